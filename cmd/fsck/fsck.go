@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/arawak/ganache/internal/media"
+	"github.com/arawak/ganache/internal/store"
+)
+
+type assetRef struct {
+	ID     int64  `json:"id"`
+	SHA256 string `json:"sha256"`
+}
+
+type missingVariant struct {
+	ID      int64  `json:"id"`
+	SHA256  string `json:"sha256"`
+	Variant string `json:"variant"`
+	Width   int    `json:"width,omitempty"`
+}
+
+// report is the JSON document written to stdout.
+type report struct {
+	Assets           int              `json:"assets"`
+	Files            int              `json:"files"`
+	MissingOriginals []assetRef       `json:"missingOriginals"`
+	MissingVariants  []missingVariant `json:"missingVariants"`
+	OrphanedFiles    []string         `json:"orphanedFiles"`
+}
+
+func (r *report) inconsistent() bool {
+	return len(r.MissingOriginals) > 0 || len(r.MissingVariants) > 0 || len(r.OrphanedFiles) > 0
+}
+
+func variantKey(variant string, width int) string {
+	if width > 0 {
+		return fmt.Sprintf("%s-w%d", variant, width)
+	}
+	return variant
+}
+
+// check cross-references asset rows against the files under mgr's root. Live assets
+// must have an original, content, thumb, and one thumb per configured width. Files
+// whose hash matches no row (soft-deleted rows included) are orphaned.
+func check(ctx context.Context, assets []store.AssetFile, mgr *media.Manager, thumbWidths []int) (*report, error) {
+	rep := &report{
+		Assets:           len(assets),
+		MissingOriginals: []assetRef{},
+		MissingVariants:  []missingVariant{},
+		OrphanedFiles:    []string{},
+	}
+	known := make(map[string]bool, len(assets))
+	for _, a := range assets {
+		known[a.SHA256] = true
+	}
+
+	found := make(map[string]map[string]bool)
+	err := mgr.WalkFiles(ctx, func(f media.StoredFile) error {
+		rep.Files++
+		if f.SHA256 == "" || !known[f.SHA256] {
+			rep.OrphanedFiles = append(rep.OrphanedFiles, f.Path)
+			return nil
+		}
+		if found[f.SHA256] == nil {
+			found[f.SHA256] = make(map[string]bool)
+		}
+		found[f.SHA256][variantKey(f.Variant, f.Width)] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range assets {
+		if a.Deleted {
+			continue
+		}
+		have := found[a.SHA256]
+		if !have[media.VariantOriginal] {
+			rep.MissingOriginals = append(rep.MissingOriginals, assetRef{ID: a.ID, SHA256: a.SHA256})
+		}
+		for _, variant := range []string{media.VariantContent, media.VariantThumb} {
+			if !have[variant] {
+				rep.MissingVariants = append(rep.MissingVariants, missingVariant{ID: a.ID, SHA256: a.SHA256, Variant: variant})
+			}
+		}
+		for _, width := range thumbWidths {
+			if !have[variantKey(media.VariantThumb, width)] {
+				rep.MissingVariants = append(rep.MissingVariants, missingVariant{ID: a.ID, SHA256: a.SHA256, Variant: media.VariantThumb, Width: width})
+			}
+		}
+	}
+	return rep, nil
+}
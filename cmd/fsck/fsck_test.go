@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arawak/ganache/internal/media"
+	"github.com/arawak/ganache/internal/store"
+)
+
+func TestCheck(t *testing.T) {
+	root := t.TempDir()
+	mgr := media.NewManager(root, media.Options{})
+	complete := strings.Repeat("a", 64)
+	broken := strings.Repeat("b", 64)
+	deleted := strings.Repeat("c", 64)
+	stray := strings.Repeat("d", 64)
+
+	touch := func(path string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	for _, sha := range []string{complete, deleted, stray} {
+		touch(mgr.PathForVariant(sha, media.VariantOriginal, ".jpg"))
+		touch(mgr.PathForVariant(sha, media.VariantContent, ""))
+		touch(mgr.PathForVariant(sha, media.VariantThumb, ""))
+	}
+	touch(mgr.PathForThumbWidth(complete, 200))
+	touch(mgr.PathForVariant(broken, media.VariantThumb, ""))
+	touch(filepath.Join(root, "original", "zz", "notes.txt"))
+
+	assets := []store.AssetFile{
+		{ID: 1, SHA256: complete},
+		{ID: 2, SHA256: broken},
+		{ID: 3, SHA256: deleted, Deleted: true},
+	}
+	rep, err := check(context.Background(), assets, mgr, []int{200})
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if !rep.inconsistent() {
+		t.Fatalf("expected inconsistencies")
+	}
+	if rep.Assets != 3 || rep.Files != 12 {
+		t.Fatalf("unexpected counts: %d assets, %d files", rep.Assets, rep.Files)
+	}
+	if len(rep.MissingOriginals) != 1 || rep.MissingOriginals[0].ID != 2 {
+		t.Fatalf("unexpected missing originals %+v", rep.MissingOriginals)
+	}
+	if len(rep.MissingVariants) != 2 {
+		t.Fatalf("unexpected missing variants %+v", rep.MissingVariants)
+	}
+	for _, mv := range rep.MissingVariants {
+		if mv.ID != 2 || (mv.Variant == media.VariantThumb && mv.Width != 200) {
+			t.Fatalf("unexpected missing variant %+v", mv)
+		}
+	}
+	if len(rep.OrphanedFiles) != 4 {
+		t.Fatalf("expected stray asset files and notes.txt to be orphaned, got %v", rep.OrphanedFiles)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/arawak/ganache/internal/config"
+	"github.com/arawak/ganache/internal/media"
+	"github.com/arawak/ganache/internal/store"
+)
+
+// Exit codes: 0 when storage and database agree, 1 when inconsistencies were
+// found, 2 when the check itself could not run.
+const (
+	exitInconsistent = 1
+	exitError        = 2
+)
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "config error:", err)
+		os.Exit(exitError)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	db, err := sqlx.Open("mysql", cfg.DBDSN)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to open db:", err)
+		os.Exit(exitError)
+	}
+	defer db.Close()
+
+	assets, err := store.New(db).ListAssetFiles(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to list assets:", err)
+		os.Exit(exitError)
+	}
+	mgr := media.NewManager(cfg.StorageRoot, media.Options{ThumbWidths: cfg.ThumbWidths, ExtAliases: cfg.ExtAliases})
+	rep, err := check(ctx, assets, mgr, cfg.ThumbWidths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to walk storage:", err)
+		os.Exit(exitError)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(rep); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to write report:", err)
+		os.Exit(exitError)
+	}
+	if rep.inconsistent() {
+		os.Exit(exitInconsistent)
+	}
+}
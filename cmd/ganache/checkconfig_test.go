@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arawak/ganache/internal/config"
+)
+
+func TestRunConfigCheck(t *testing.T) {
+	dir := t.TempDir()
+	keys := filepath.Join(dir, "keys.yaml")
+	if err := os.WriteFile(keys, []byte("- id: ci\n  key: secret\n  permissions: [can_search]\n"), 0o600); err != nil {
+		t.Fatalf("write keys: %v", err)
+	}
+	good := &config.Config{
+		DBDSN:       "ganache:ganache@tcp(db:3306)/ganache?parseTime=true",
+		StorageRoot: filepath.Join(dir, "storage"),
+		AuthMode:    config.AuthAPIKey,
+		APIKeysFile: keys,
+	}
+	var out strings.Builder
+	if code := runConfigCheck(&out, good, nil); code != 0 {
+		t.Fatalf("expected a passing check, got %d:\n%s", code, out.String())
+	}
+
+	bad := *good
+	bad.DBDSN = "ganache@tcp(db:3306)/ganache"
+	bad.APIKeysFile = filepath.Join(dir, "missing.yaml")
+	out.Reset()
+	if code := runConfigCheck(&out, &bad, nil); code != 1 {
+		t.Fatalf("expected a failing check, got %d:\n%s", code, out.String())
+	}
+	for _, want := range []string{"FAIL  GANACHE_DB_DSN: parseTime=true is required", "FAIL  GANACHE_API_KEYS_FILE", "ok    GANACHE_STORAGE_ROOT writable", "2 of 4 checks failed"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected %q in report:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	if code := runConfigCheck(&out, nil, errors.New("GANACHE_DB_DSN is required")); code != 1 || !strings.Contains(out.String(), "FAIL  environment: GANACHE_DB_DSN is required") {
+		t.Fatalf("expected load errors to fail the check, got %d:\n%s", code, out.String())
+	}
+}
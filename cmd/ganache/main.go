@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
@@ -13,6 +15,7 @@ import (
 	"github.com/jmoiron/sqlx"
 
 	"github.com/arawak/ganache/internal/config"
+	"github.com/arawak/ganache/internal/events"
 	"github.com/arawak/ganache/internal/httpapi"
 	"github.com/arawak/ganache/internal/media"
 	"github.com/arawak/ganache/internal/store"
@@ -22,15 +25,26 @@ import (
 var version = "dev"
 
 func main() {
+	checkOnly := flag.Bool("check-config", false, "validate the configuration and the files and services it names, then exit without starting the server")
+	flag.Parse()
+
 	cfg, err := config.Load()
+	if *checkOnly {
+		os.Exit(runConfigCheck(os.Stdout, cfg, err))
+	}
 	if err != nil {
 		panic(err)
 	}
+	cfg.Version = version
+	store.SetAccentFolding(cfg.TagFoldAccents)
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil)).With("version", version)
+	if cfg.ReadOnly {
+		logger.Warn("read-only mode: the API refuses every change with 405 (GANACHE_READ_ONLY=true)")
+	}
 
 	var apiKeys *httpapi.APIKeyStore
-	if cfg.AuthMode == config.AuthAPIKey {
+	if cfg.AuthMode.Has(config.AuthAPIKey) {
 		apiKeys, err = httpapi.LoadAPIKeys(cfg.APIKeysFile)
 		if err != nil {
 			logger.Error("failed to load api keys", "error", err)
@@ -38,7 +52,29 @@ func main() {
 		}
 	}
 
-	db, err := sqlx.Open("mysql", cfg.DBDSN)
+	var routePerms httpapi.RoutePermissions
+	if cfg.RoutePermsFile != "" {
+		routePerms, err = httpapi.LoadRoutePermissions(cfg.RoutePermsFile)
+		if err != nil {
+			logger.Error("failed to load route permissions", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if cfg.PlaceholderImage != "" {
+		if _, err := os.Stat(cfg.PlaceholderImage); err != nil {
+			logger.Error("failed to read placeholder image", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// Migrations keep the plain DSN: schema changes may run longer than any query.
+	poolDSN, err := store.StatementTimeoutDSN(cfg.DBDSN, cfg.DBStatementTimeout)
+	if err != nil {
+		logger.Error("invalid GANACHE_DB_DSN", "error", err)
+		os.Exit(1)
+	}
+	db, err := sqlx.Open("mysql", poolDSN)
 	if err != nil {
 		logger.Error("failed to open db", "error", err)
 		os.Exit(1)
@@ -47,14 +83,122 @@ func main() {
 	db.SetMaxIdleConns(5)
 	db.SetConnMaxLifetime(30 * time.Minute)
 
+	if err := waitForDB(db, cfg.DBWaitTimeout, logger); err != nil {
+		logger.Error("database not reachable", "error", err, "timeout", cfg.DBWaitTimeout.String())
+		os.Exit(1)
+	}
+
 	if err := migrations.Up(cfg.DBDSN); err != nil {
 		logger.Error("failed to run migrations", "error", err)
 		os.Exit(1)
 	}
 
-	storeSvc := store.New(db)
-	mediaMgr := media.NewManager(cfg.StorageRoot)
-	router := httpapi.NewRouter(cfg, storeSvc, mediaMgr, apiKeys, logger)
+	var replica *sqlx.DB
+	if cfg.DBReplicaDSN != "" {
+		replicaDSN, err := store.StatementTimeoutDSN(cfg.DBReplicaDSN, cfg.DBStatementTimeout)
+		if err != nil {
+			logger.Error("invalid GANACHE_DB_REPLICA_DSN", "error", err)
+			os.Exit(1)
+		}
+		replica, err = sqlx.Open("mysql", replicaDSN)
+		if err != nil {
+			logger.Error("failed to open replica db", "error", err)
+			os.Exit(1)
+		}
+		replica.SetMaxOpenConns(10)
+		replica.SetMaxIdleConns(5)
+		replica.SetConnMaxLifetime(30 * time.Minute)
+	}
+
+	var blockedTags *store.TagBlocklist
+	if cfg.BlockedTagsFile != "" {
+		blockedTags, err = store.LoadTagBlocklist(cfg.BlockedTagsFile)
+		if err != nil {
+			logger.Error("failed to load blocked tags", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	storeSvc := store.NewWithOptions(db, store.Options{
+		Replica:          replica,
+		BlockedTags:      blockedTags,
+		BreakerThreshold: cfg.DBBreakerThreshold,
+		BreakerCooldown:  cfg.DBBreakerCooldown,
+		TagCacheTTL:      cfg.TagCacheTTL,
+		RelevanceWeights: store.RelevanceWeights{
+			Title:   cfg.RelevanceWeights["title"],
+			Tags:    cfg.RelevanceWeights["tags"],
+			Caption: cfg.RelevanceWeights["caption"],
+		},
+		RelevanceSortedOnly: cfg.RelevanceSortedOnly,
+	})
+	mediaOpts := media.Options{
+		ContentMaxWidth:    cfg.ContentMaxWidth,
+		ThumbMaxWidth:      cfg.ThumbMaxWidth,
+		ThumbWidths:        cfg.ThumbWidths,
+		ExtAliases:         cfg.ExtAliases,
+		FormatMaxBytes:     cfg.FormatMaxBytes,
+		FormatMaxPixels:    cfg.FormatMaxPixels,
+		MaxWidth:           cfg.MaxWidth,
+		MaxHeight:          cfg.MaxHeight,
+		AsyncVariants:      cfg.AsyncVariants,
+		VariantQueueSize:   cfg.VariantQueueSize,
+		PreviewMaxWidth:    cfg.PreviewMaxWidth,
+		HashAlgo:           string(cfg.HashAlgo),
+		CompressOriginals:  cfg.CompressOriginals,
+		RejectTransparency: cfg.RejectTransparency,
+		Background:         cfg.TransparencyBackground,
+		VariantsDone: func(sha string, err error) {
+			state := store.VariantsReady
+			if err != nil {
+				state = store.VariantsFailed
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := storeSvc.SetVariantState(ctx, sha, state); err != nil {
+				logger.Warn("failed to record variant state", "sha256", sha, "state", state, "error", err)
+			}
+		},
+	}
+	if cfg.ClamAVAddr != "" {
+		mediaOpts.Scanner = media.NewClamAV(cfg.ClamAVAddr, cfg.ClamAVTimeout)
+		logger.Info("virus scanning enabled", "clamav", cfg.ClamAVAddr)
+	}
+	mediaMgr := media.NewManager(cfg.StorageRoot, mediaOpts)
+	maintenance := httpapi.NewMaintenance(cfg.MaintenanceMode, logger)
+	bus := events.NewBus()
+	router := httpapi.NewRouter(cfg, storeSvc, mediaMgr, apiKeys, routePerms, maintenance, bus, logger)
+
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	publish := func(_ context.Context, e events.Event) error {
+		bus.Publish(e)
+		return nil
+	}
+	storeSvc.StartOutbox(bgCtx, cfg.InstanceID, publish, func(err error) {
+		logger.Warn("event outbox failed", "error", err)
+	})
+	go func() {
+		if err := mediaMgr.ScanDerivatives(bgCtx); err != nil && bgCtx.Err() == nil {
+			logger.Warn("failed to measure derivative cache", "error", err)
+		}
+	}()
+
+	storeSvc.StartReprocessWorker(bgCtx, cfg.ReprocessDelay, func(ctx context.Context, a store.AssetFile) error {
+		if err := mediaMgr.RegenerateVariants(a.SHA256); err != nil {
+			return fmt.Errorf("asset %d: %w", a.ID, err)
+		}
+		// Clears a failed state left by the original generation.
+		return storeSvc.SetVariantState(ctx, a.SHA256, store.VariantsReady)
+	}, func(err error) {
+		logger.Warn("variant reprocessing failed", "error", err)
+	})
+
+	if cfg.AsyncVariants {
+		mediaMgr.StartVariantWorkers(bgCtx, cfg.VariantWorkers, func(sha string, err error) {
+			logger.Warn("background variant generation failed", "sha256", sha, "error", err)
+		})
+	}
 
 	srv := &http.Server{Addr: cfg.Bind, Handler: router}
 	go func() {
@@ -65,6 +209,14 @@ func main() {
 		}
 	}()
 
+	toggle := make(chan os.Signal, 1)
+	notifyMaintenanceToggle(toggle)
+	go func() {
+		for range toggle {
+			maintenance.Toggle()
+		}
+	}()
+
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 	<-sig
@@ -80,4 +232,43 @@ func main() {
 	if err := db.Close(); err != nil {
 		logger.Error("database close error", "error", err)
 	}
+	if replica != nil {
+		if err := replica.Close(); err != nil {
+			logger.Error("replica database close error", "error", err)
+		}
+	}
+}
+
+// waitForDB pings the database with exponential backoff until it responds or
+// timeout elapses. A zero timeout performs a single ping.
+func waitForDB(db *sqlx.DB, timeout time.Duration, logger *slog.Logger) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	delay := 250 * time.Millisecond
+	for {
+		pingCtx, pingCancel := context.WithTimeout(ctx, 2*time.Second)
+		err := db.PingContext(pingCtx)
+		pingCancel()
+		if err == nil {
+			return nil
+		}
+		if timeout <= 0 {
+			return err
+		}
+		logger.Info("waiting for database", "error", err, "retryIn", delay.String())
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > 5*time.Second {
+			delay = 5 * time.Second
+		}
+	}
 }
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyMaintenanceToggle delivers SIGUSR1, which flips maintenance mode, to c.
+func notifyMaintenanceToggle(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
@@ -0,0 +1,7 @@
+package main
+
+import "os"
+
+// notifyMaintenanceToggle does nothing on Windows, which has no SIGUSR1; use
+// GANACHE_MAINTENANCE_MODE there.
+func notifyMaintenanceToggle(chan<- os.Signal) {}
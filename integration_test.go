@@ -3,21 +3,31 @@
 package ganache
 
 import (
+	"archive/zip"
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
 	"image/png"
 	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/arawak/ganache/internal/config"
+	"github.com/arawak/ganache/internal/events"
 	"github.com/arawak/ganache/internal/httpapi"
 	"github.com/arawak/ganache/internal/media"
 	"github.com/arawak/ganache/internal/store"
@@ -82,6 +92,14 @@ func TestEndToEnd(t *testing.T) {
 		StorageRoot:        root,
 		MaxUploadBytes:     config.DefaultMaxUploadBytes,
 		MaxPixels:          config.DefaultMaxPixels,
+		DefaultPageSize:    config.DefaultPageSize,
+		MaxPageSize:        config.DefaultMaxPageSize,
+		MaxSearchTags:      config.DefaultMaxSearchTags,
+		MaxSearchQueryLen:  config.DefaultMaxSearchQueryLen,
+		RequestTimeout:     config.DefaultRequestTimeout,
+		QueryTimeout:       config.DefaultQueryTimeout,
+		UploadTimeout:      config.DefaultUploadTimeout,
+		LongPollMaxTimeout: config.DefaultLongPollMaxTimeout,
 		PublicMedia:        true,
 		AuthMode:           config.AuthNone,
 		CORSAllowedOrigins: nil,
@@ -89,19 +107,295 @@ func TestEndToEnd(t *testing.T) {
 		OpenAPIPath:        "/openapi.yaml",
 	}
 	st := store.New(db)
-	mediaMgr := media.NewManager(root)
-	ts := httptest.NewServer(httpapi.NewRouter(cfg, st, mediaMgr, nil))
+	mediaMgr := media.NewManager(root, media.Options{})
+	ts := httptest.NewServer(httpapi.NewRouter(cfg, st, mediaMgr, nil, nil, nil, nil, nil))
 	t.Cleanup(ts.Close)
 
 	assetID := uploadAndValidate(t, ts.URL+"/api/assets")
+	waitForAssets(t, ts.URL+"/api/assets/wait", assetID)
 	getAsset(t, ts.URL+"/api/assets/", assetID)
 	patchAsset(t, ts.URL+"/api/assets/", assetID)
 	searchAsset(t, ts.URL+"/api/assets", assetID)
+	searchUnknownTag(t, ts.URL+"/api/assets")
 	mediaURL := fmt.Sprintf("%s/media/%d/thumb", ts.URL, assetID)
 	validateMedia(t, mediaURL)
 	deleteAsset(t, ts.URL+"/api/assets/", assetID)
 	ensureDeleted(t, ts.URL+"/api/assets", assetID)
+	bulkDelete(t, ts.URL+"/api/assets/delete", []int64{assetID, assetID, assetID + 1000}, map[int64]httpapi.BulkDeleteResultStatus{
+		assetID:        httpapi.AlreadyDeleted,
+		assetID + 1000: httpapi.NotFound,
+	})
 	readyz(t, ts.URL+"/readyz")
+	rejectedUpload(t, ts.URL+"/api/assets", mediaMgr)
+	stablePaging(t, ctx, st, db)
+	missingMedia(t, ctx, st, ts.URL)
+	reprocessAll(t, ctx, st, mediaMgr, ts.URL+"/api/admin/reprocess-all")
+	reprocessLeases(t, ctx, db)
+	waitCursor(t, ctx, st, db)
+	outboxDelivery(t, ctx, db)
+	privateAssets(t, ctx, cfg, st, mediaMgr)
+}
+
+// rejectedUpload checks that an upload whose file is stored but whose fields fail
+// validation leaves no files behind.
+func rejectedUpload(t *testing.T, url string, mediaMgr *media.Manager) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for i := range img.Pix {
+		img.Pix[i] = 17
+	}
+	var file bytes.Buffer
+	if err := png.Encode(&file, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, _ := mw.CreateFormFile("file", "rejected.png")
+	_, _ = fw.Write(file.Bytes())
+	_ = mw.WriteField("title", "Rejected")
+	_ = mw.WriteField("visibility", "secret")
+	mw.Close()
+
+	resp, err := http.Post(url, mw.FormDataContentType(), &buf)
+	if err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for an invalid visibility, got %d body %s", resp.StatusCode, body)
+	}
+	sha := fmt.Sprintf("%x", sha256.Sum256(file.Bytes()))
+	for _, path := range []string{
+		mediaMgr.PathForVariant(sha, media.VariantOriginal, ".png"),
+		mediaMgr.PathForVariant(sha, media.VariantContent, ".webp"),
+		mediaMgr.PathForVariant(sha, media.VariantThumb, ".webp"),
+	} {
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected %s to be removed after the rejected upload, got %v", path, err)
+		}
+	}
+}
+
+// reprocessAll runs a variant regeneration sweep to completion. The assets left by
+// the earlier steps have no stored files, so they are counted as errors.
+func reprocessAll(t *testing.T, ctx context.Context, st *store.Store, mediaMgr *media.Manager, url string) {
+	workerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	st.StartReprocessWorker(workerCtx, 0, func(_ context.Context, a store.AssetFile) error {
+		return mediaMgr.RegenerateVariants(a.SHA256)
+	}, nil)
+
+	sweep := func(method string, wantStatus int) httpapi.ReprocessSweep {
+		req, _ := http.NewRequest(method, url, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s reprocess-all: %v", method, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != wantStatus {
+			body, _ := io.ReadAll(resp.Body)
+			t.Fatalf("%s reprocess-all: expected %d got %d: %s", method, wantStatus, resp.StatusCode, body)
+		}
+		var out httpapi.ReprocessSweep
+		if wantStatus < 300 {
+			if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+				t.Fatalf("decode sweep: %v", err)
+			}
+		}
+		return out
+	}
+
+	sweep(http.MethodGet, http.StatusNotFound)
+	started := sweep(http.MethodPost, http.StatusAccepted)
+	if started.State != httpapi.Running || started.Total == 0 {
+		t.Fatalf("expected a running sweep over the stored assets, got %+v", started)
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		got := sweep(http.MethodGet, http.StatusOK)
+		if got.State == httpapi.Done {
+			if got.Id != started.Id || got.Processed != started.Total || got.Errors != got.Processed || got.LastError == nil || got.FinishedAt == nil {
+				t.Fatalf("unexpected finished sweep %+v", got)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sweep did not finish: %+v", got)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if next := sweep(http.MethodPost, http.StatusAccepted); next.Id == started.Id {
+		t.Fatalf("expected a new sweep once the last one finished")
+	}
+}
+
+// reprocessLeases checks that the batches of a sweep are leased rather than locked
+// through regeneration: while one instance is regenerating a batch, another neither
+// claims it nor blocks a second start, assets created mid-sweep do not push
+// progress past the total, and a lease left by a worker that died is taken over
+// once it expires.
+func reprocessLeases(t *testing.T, ctx context.Context, db *sqlx.DB) {
+	if _, err := db.ExecContext(ctx, "UPDATE reprocess_sweep SET state = 'done' WHERE state = 'running'"); err != nil {
+		t.Fatalf("finish earlier sweeps: %v", err)
+	}
+	st := store.New(db)
+	waitDone := func(id int64) *store.ReprocessSweep {
+		t.Helper()
+		deadline := time.Now().Add(30 * time.Second)
+		for {
+			got, err := st.LatestReprocess(ctx)
+			if err != nil {
+				t.Fatalf("latest sweep: %v", err)
+			}
+			if got.ID == id && got.State == store.ReprocessDone {
+				return got
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("sweep did not finish: %+v", got)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	workerCtx, cancel := context.WithCancel(ctx)
+	var calls atomic.Int64
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	regenerate := func(context.Context, store.AssetFile) error {
+		calls.Add(1)
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		return nil
+	}
+	sweep, err := st.StartReprocess(ctx, "test")
+	if err != nil {
+		t.Fatalf("start sweep: %v", err)
+	}
+	// Workers of other instances pick the sweep up when they start.
+	for range 2 {
+		store.New(db).StartReprocessWorker(workerCtx, 0, regenerate, nil)
+	}
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("no worker claimed the sweep")
+	}
+	quick, cancelQuick := context.WithTimeout(ctx, 2*time.Second)
+	running, err := st.StartReprocess(quick, "test")
+	cancelQuick()
+	if !errors.Is(err, store.ErrReprocessRunning) || running == nil || running.ID != sweep.ID {
+		t.Fatalf("expected the running sweep to be reported without waiting on its batch, got %+v, %v", running, err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("expected the leased batch to be left to its worker, got %d regenerations", n)
+	}
+	for range 3 {
+		if _, err := st.CreateAsset(ctx, store.AssetCreate{Title: "mid-sweep", Width: 1, Height: 1, Bytes: 1, Mime: "image/png", SHA256: fmt.Sprintf("%064x", time.Now().UnixNano())}); err != nil {
+			t.Fatalf("create asset: %v", err)
+		}
+	}
+	close(release)
+	done := waitDone(sweep.ID)
+	if done.Processed != done.Total || calls.Load() <= int64(done.Total) {
+		t.Fatalf("expected progress clamped to the total after visiting the new assets, got %+v after %d regenerations", done, calls.Load())
+	}
+	cancel()
+
+	// A worker that died mid-batch leaves its lease behind.
+	sweep, err = st.StartReprocess(ctx, "test")
+	if err != nil {
+		t.Fatalf("start sweep: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE reprocess_sweep SET lease_token = 'dead', lease_until = NOW(6) - INTERVAL 1 SECOND WHERE id = ?", sweep.ID); err != nil {
+		t.Fatalf("leave lease: %v", err)
+	}
+	workerCtx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	store.New(db).StartReprocessWorker(workerCtx, 0, func(context.Context, store.AssetFile) error { return nil }, nil)
+	if done := waitDone(sweep.ID); done.Processed != done.Total {
+		t.Fatalf("expected the expired lease to be taken over, got %+v", done)
+	}
+}
+
+// missingMedia checks that an asset whose file is absent from storage is reported
+// as gone rather than as an unknown id.
+func missingMedia(t *testing.T, ctx context.Context, st *store.Store, baseURL string) {
+	a, err := st.CreateAsset(ctx, store.AssetCreate{
+		Title:  "orphan",
+		Width:  1,
+		Height: 1,
+		Bytes:  1,
+		Mime:   "image/png",
+		SHA256: fmt.Sprintf("%064x", 0xdead),
+	})
+	if err != nil {
+		t.Fatalf("create asset: %v", err)
+	}
+	for id, want := range map[int64]int{a.ID: http.StatusGone, a.ID + 1000: http.StatusNotFound} {
+		for _, path := range []string{"/media/%d/content", "/api/assets/%d/download"} {
+			path = fmt.Sprintf(path, id)
+			resp, err := http.Get(baseURL + path)
+			if err != nil {
+				t.Fatalf("media request: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != want {
+				t.Fatalf("%s: expected %d got %d", path, want, resp.StatusCode)
+			}
+		}
+	}
+}
+
+// stablePaging creates assets that share a timestamp and relevance score and checks
+// that paging through them visits each exactly once for every sort order.
+func stablePaging(t *testing.T, ctx context.Context, st *store.Store, db *sqlx.DB) {
+	const n = 7
+	ids := make([]any, 0, n)
+	for i := 0; i < n; i++ {
+		a, err := st.CreateAsset(ctx, store.AssetCreate{
+			Title:  "bulkimport",
+			Width:  1,
+			Height: 1,
+			Bytes:  1,
+			Mime:   "image/png",
+			SHA256: fmt.Sprintf("%064d", i+1),
+		})
+		if err != nil {
+			t.Fatalf("create asset %d: %v", i, err)
+		}
+		ids = append(ids, a.ID)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", n), ",")
+	if _, err := db.ExecContext(ctx, "UPDATE asset SET created_at = '2024-01-01 00:00:00' WHERE id IN ("+placeholders+")", ids...); err != nil {
+		t.Fatalf("pin timestamps: %v", err)
+	}
+
+	for _, sort := range []string{"newest", "oldest", "relevance"} {
+		seen := map[int64]int{}
+		for page := 1; page <= (n+1)/2; page++ {
+			assets, total, err := st.SearchAssets(ctx, store.SearchParams{Query: "bulkimport", Sort: sort, Page: page, PageSize: 2})
+			if err != nil {
+				t.Fatalf("%s page %d: %v", sort, page, err)
+			}
+			if total != n {
+				t.Fatalf("%s: expected total %d got %d", sort, n, total)
+			}
+			for _, a := range assets {
+				seen[a.ID]++
+			}
+		}
+		if len(seen) != n {
+			t.Fatalf("%s: expected %d distinct assets across pages, got %v", sort, n, seen)
+		}
+		for id, count := range seen {
+			if count != 1 {
+				t.Fatalf("%s: asset %d appeared %d times", sort, id, count)
+			}
+		}
+	}
 }
 
 func uploadAndValidate(t *testing.T, url string) int64 {
@@ -153,6 +447,39 @@ func uploadAndValidate(t *testing.T, url string) int64 {
 	return asset.Id
 }
 
+// waitForAssets checks that a long-poll from the start answers at once with the first
+// asset, id, and that one from the cursor it returns times out without items.
+func waitForAssets(t *testing.T, url string, id int64) {
+	wait := func(query string) httpapi.AssetWaitResponse {
+		resp, err := http.Get(url + query)
+		if err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			t.Fatalf("wait status %d body %s", resp.StatusCode, string(body))
+		}
+		var res httpapi.AssetWaitResponse
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			t.Fatalf("decode wait: %v", err)
+		}
+		return res
+	}
+
+	first := wait("?since=0&timeout=10s")
+	if len(first.Items) != 1 || first.Items[0].Id != id || first.Cursor == 0 {
+		t.Fatalf("expected the new asset, got %+v", first)
+	}
+	start := time.Now()
+	if res := wait(fmt.Sprintf("?since=%d&timeout=200ms", first.Cursor)); len(res.Items) != 0 || res.Cursor != first.Cursor {
+		t.Fatalf("expected an empty timed-out wait, got %+v", res)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("expected the wait to block for its timeout, returned after %s", elapsed)
+	}
+}
+
 func getAsset(t *testing.T, base string, id int64) {
 	resp, err := http.Get(fmt.Sprintf("%s%d", base, id))
 	if err != nil {
@@ -200,6 +527,22 @@ func searchAsset(t *testing.T, url string, id int64) {
 	}
 }
 
+// searchUnknownTag checks that a search emptied by a tag nobody uses says which tag.
+func searchUnknownTag(t *testing.T, url string) {
+	resp, err := http.Get(url + "?tag=tagtwo&tag=No-Such-Tag")
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	defer resp.Body.Close()
+	var res httpapi.AssetSearchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatalf("decode search: %v", err)
+	}
+	if res.Total != 0 || res.UnknownTags == nil || len(*res.UnknownTags) != 1 || (*res.UnknownTags)[0] != "no-such-tag" {
+		t.Fatalf("expected no-such-tag to be reported unknown: %+v", res)
+	}
+}
+
 func validateMedia(t *testing.T, url string) {
 	resp, err := http.Get(url)
 	if err != nil {
@@ -245,6 +588,29 @@ func deleteAsset(t *testing.T, base string, id int64) {
 	}
 }
 
+func bulkDelete(t *testing.T, url string, ids []int64, want map[int64]httpapi.BulkDeleteResultStatus) {
+	body, _ := json.Marshal(httpapi.BulkDeleteRequest{Ids: ids})
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("bulk delete: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		t.Fatalf("bulk delete status %d body %s", resp.StatusCode, string(b))
+	}
+	var res httpapi.BulkDeleteResponse
+	_ = json.NewDecoder(resp.Body).Decode(&res)
+	if len(res.Results) != len(want) {
+		t.Fatalf("expected %d results, got %+v", len(want), res.Results)
+	}
+	for _, r := range res.Results {
+		if want[r.Id] != r.Status {
+			t.Fatalf("id %d: expected %s got %s", r.Id, want[r.Id], r.Status)
+		}
+	}
+}
+
 func ensureDeleted(t *testing.T, url string, id int64) {
 	resp, err := http.Get(url)
 	if err != nil {
@@ -273,3 +639,497 @@ func readyz(t *testing.T, url string) {
 		t.Fatalf("readyz status %d body %s", resp.StatusCode, string(body))
 	}
 }
+
+// waitCursor checks that an asset insert that rolls back, leaving a gap in the asset
+// ids, does not hold AssetsAfter's cursor back: the next asset comes back at once.
+func waitCursor(t *testing.T, ctx context.Context, st *store.Store, db *sqlx.DB) {
+	create := func(n int) int64 {
+		a, err := st.CreateAsset(ctx, store.AssetCreate{Title: "cursor", Width: 1, Height: 1, Bytes: 1, Mime: "image/png", SHA256: fmt.Sprintf("c%063d", n)})
+		if err != nil {
+			t.Fatalf("create asset: %v", err)
+		}
+		return a.ID
+	}
+	create(1)
+	since, err := st.LatestAssetID(ctx)
+	if err != nil {
+		t.Fatalf("latest asset id: %v", err)
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO asset (caption, usage_notes, width, height, bytes, mime, original_filename, sha256, tag_text) VALUES ('', '', 1, 1, 1, 'image/png', 'gone.png', ?, '')", fmt.Sprintf("c%063d", 2)); err != nil {
+		t.Fatalf("insert asset: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE event_outbox_seq SET last_id = last_id + 1 WHERE id = 1"); err != nil {
+		t.Fatalf("take event id: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	next := create(3)
+
+	started := time.Now()
+	assets, cursor, err := st.AssetsAfter(ctx, since, nil, 100)
+	if err != nil || len(assets) != 1 || assets[0].ID != next || cursor != since+1 {
+		t.Fatalf("expected the asset after the rolled-back one, got %+v, cursor %d (since %d), %v", assets, cursor, since, err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("expected the asset at once, took %s", elapsed)
+	}
+	if latest, err := st.LatestAssetID(ctx); err != nil || latest != cursor {
+		t.Fatalf("expected the latest cursor to be %d, got %d, %v", cursor, latest, err)
+	}
+}
+
+// outboxDelivery runs two outbox workers, standing for two instances, and checks that
+// both deliver every event, that a failed delivery is retried, that a worker stopped
+// and started again under the same name delivers what was committed meanwhile, and
+// that old delivered events are pruned.
+func outboxDelivery(t *testing.T, ctx context.Context, db *sqlx.DB) {
+	if _, err := db.ExecContext(ctx, "INSERT INTO event_outbox (id, type, asset_id, created_at, delivered_at) VALUES (0, 'asset.updated', 1, NOW(6) - INTERVAL 2 DAY, NOW(6) - INTERVAL 2 DAY)"); err != nil {
+		t.Fatalf("insert old event: %v", err)
+	}
+
+	st := store.New(db)
+	names := [2]string{fmt.Sprintf("instance-a-%d", time.Now().UnixNano()), fmt.Sprintf("instance-b-%d", time.Now().UnixNano())}
+	var received [2]chan events.Event
+	var failOnce atomic.Bool
+	failOnce.Store(true)
+	start := func(i int) context.CancelFunc {
+		workerCtx, cancel := context.WithCancel(ctx)
+		store.New(db).StartOutbox(workerCtx, names[i], func(ctx context.Context, e events.Event) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if i == 0 && failOnce.CompareAndSwap(true, false) {
+				return errors.New("subscriber unavailable")
+			}
+			select {
+			case received[i] <- e:
+			default:
+			}
+			return nil
+		}, func(err error) { t.Logf("outbox %s: %v", names[i], err) })
+		return cancel
+	}
+	next := func(i int) events.Event {
+		select {
+		case e := <-received[i]:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatalf("worker %d delivered nothing", i)
+			return events.Event{}
+		}
+	}
+	create := func(n int) *store.Asset {
+		a, err := st.CreateAsset(ctx, store.AssetCreate{Title: "outbox", Width: 1, Height: 1, Bytes: 1, Mime: "image/png", SHA256: fmt.Sprintf("e%063d", n)})
+		if err != nil {
+			t.Fatalf("create asset: %v", err)
+		}
+		return a
+	}
+
+	var cancels [2]context.CancelFunc
+	for i := range received {
+		received[i] = make(chan events.Event, 16)
+		cancels[i] = start(i)
+	}
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var registered int
+		if err := db.GetContext(ctx, &registered, "SELECT COUNT(*) FROM event_consumer WHERE name IN (?, ?)", names[0], names[1]); err != nil {
+			t.Fatalf("count consumers: %v", err)
+		}
+		if registered == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected both workers to register, got %d", registered)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	first := create(1)
+	for i := range received {
+		if e := next(i); e.Type != events.AssetCreated || e.AssetID != first.ID {
+			t.Fatalf("worker %d: expected the asset.created event, got %+v", i, e)
+		}
+	}
+	var attempts int
+	if err := db.GetContext(ctx, &attempts, "SELECT attempts FROM event_outbox WHERE type = 'asset.created' AND asset_id = ?", first.ID); err != nil || attempts != 1 {
+		t.Fatalf("expected the failed delivery to be recorded, got %d attempts, %v", attempts, err)
+	}
+
+	cancels[1]()
+	time.Sleep(200 * time.Millisecond)
+	second := create(2)
+	if e := next(0); e.Type != events.AssetCreated || e.AssetID != second.ID {
+		t.Fatalf("worker 0: expected the second asset.created event, got %+v", e)
+	}
+	select {
+	case e := <-received[1]:
+		t.Fatalf("stopped worker delivered %+v", e)
+	case <-time.After(1500 * time.Millisecond):
+	}
+	cancels[1] = start(1)
+	if e := next(1); e.Type != events.AssetCreated || e.AssetID != second.ID {
+		t.Fatalf("restarted worker: expected the event committed while it was stopped, got %+v", e)
+	}
+
+	var left int
+	if err := db.GetContext(ctx, &left, "SELECT COUNT(*) FROM event_outbox WHERE id = 0"); err != nil || left != 0 {
+		t.Fatalf("expected the old event to be pruned, got %d, %v", left, err)
+	}
+}
+
+// privateAssets checks that a private asset is invisible to a key outside its
+// allowed principals on every route that changes an asset, not only on reads.
+func privateAssets(t *testing.T, ctx context.Context, cfg *config.Config, st *store.Store, mediaMgr *media.Manager) {
+	keyFile := filepath.Join(t.TempDir(), "api-keys.yaml")
+	perms := "[can_search, can_upload, can_update, can_delete]"
+	keys := fmt.Sprintf("- id: owner\n  key: owner-key\n  permissions: %s\n- id: other\n  key: other-key\n  permissions: %s\n", perms, perms)
+	if err := os.WriteFile(keyFile, []byte(keys), 0o600); err != nil {
+		t.Fatalf("write api keys: %v", err)
+	}
+	apiKeys, err := httpapi.LoadAPIKeys(keyFile)
+	if err != nil {
+		t.Fatalf("load api keys: %v", err)
+	}
+	keyCfg := *cfg
+	keyCfg.AuthMode = config.AuthAPIKey
+	bus := events.NewBus()
+	ts := httptest.NewServer(httpapi.NewRouter(&keyCfg, st, mediaMgr, apiKeys, nil, nil, bus, nil))
+	t.Cleanup(ts.Close)
+
+	do := func(key, method, path, contentType string, body []byte) (int, []byte) {
+		req, _ := http.NewRequest(method, ts.URL+path, bytes.NewReader(body))
+		req.Header.Set("X-Api-Key", key)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, b
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for i := range img.Pix {
+		img.Pix[i] = 90
+	}
+	var file bytes.Buffer
+	if err := png.Encode(&file, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, _ := mw.CreateFormFile("file", "private.png")
+	_, _ = fw.Write(file.Bytes())
+	_ = mw.WriteField("title", "Private")
+	_ = mw.WriteField("visibility", "private")
+	_ = mw.WriteField("allowedPrincipals", "owner")
+	mw.Close()
+	upload := buf.Bytes()
+
+	status, body := do("owner-key", http.MethodPost, "/api/assets", mw.FormDataContentType(), upload)
+	if status != http.StatusCreated {
+		t.Fatalf("private upload status %d body %s", status, body)
+	}
+	var asset httpapi.Asset
+	_ = json.Unmarshal(body, &asset)
+	path := fmt.Sprintf("/api/assets/%d", asset.Id)
+	assetFacets(t, do)
+	downloadAsset(t, ts.URL, asset.Id, file.Bytes())
+	streamVisibility(t, ctx, ts.URL, bus, st, asset.Id)
+
+	hidden := []struct {
+		name, method, path, contentType string
+		body                            []byte
+	}{
+		{"get", http.MethodGet, path, "", nil},
+		{"patch", http.MethodPatch, path, "application/json", []byte(`{"title":"Taken"}`)},
+		{"delete", http.MethodDelete, path, "", nil},
+	}
+	for _, tc := range hidden {
+		if status, body := do("other-key", tc.method, tc.path, tc.contentType, tc.body); status != http.StatusNotFound {
+			t.Fatalf("%s: expected 404 for an asset the caller cannot view, got %d body %s", tc.name, status, body)
+		}
+	}
+
+	bulk, _ := json.Marshal(httpapi.BulkDeleteRequest{Ids: []int64{asset.Id}})
+	status, body = do("other-key", http.MethodPost, "/api/assets/delete", "application/json", bulk)
+	var res httpapi.BulkDeleteResponse
+	_ = json.Unmarshal(body, &res)
+	if status != http.StatusOK || len(res.Results) != 1 || res.Results[0].Status != httpapi.NotFound {
+		t.Fatalf("expected bulk delete to report not_found, got %d body %s", status, body)
+	}
+
+	status, body = do("other-key", http.MethodPost, "/api/assets", mw.FormDataContentType(), upload)
+	if status != http.StatusConflict || bytes.Contains(body, []byte(`"id"`)) {
+		t.Fatalf("expected a bare 409 for a duplicate the caller cannot view, got %d body %s", status, body)
+	}
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/assets", nil)
+	req.Header.Set("X-Api-Key", "other-key")
+	req.Header.Set("If-None-Match", fmt.Sprintf(`"%x"`, sha256.Sum256(file.Bytes())))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("if-none-match upload: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict || bytes.Contains(body, []byte(`"id"`)) {
+		t.Fatalf("expected If-None-Match to give a bare 409 for an asset the caller cannot view, got %d body %s", resp.StatusCode, body)
+	}
+	ref, _ := json.Marshal(httpapi.AssetReference{Sha256: fmt.Sprintf("%x", sha256.Sum256(file.Bytes()))})
+	status, body = do("other-key", http.MethodPost, "/api/assets/reference", "application/json", ref)
+	if status != http.StatusConflict || bytes.Contains(body, []byte(`"id"`)) {
+		t.Fatalf("expected a reference to give a bare 409 for an asset the caller cannot view, got %d body %s", status, body)
+	}
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	zf, _ := zw.Create("private.png")
+	_, _ = zf.Write(file.Bytes())
+	_ = zw.Close()
+	status, body = do("other-key", http.MethodPost, "/api/assets/import", "application/zip", archive.Bytes())
+	var imported httpapi.ImportResponse
+	_ = json.Unmarshal(body, &imported)
+	if status != http.StatusOK || len(imported.Results) != 1 || imported.Results[0].Status != httpapi.Duplicate || imported.Results[0].AssetId != nil {
+		t.Fatalf("expected an import to report a duplicate the caller cannot view without its id, got %d body %s", status, body)
+	}
+
+	if status, body := do("owner-key", http.MethodGet, path, "", nil); status != http.StatusOK {
+		t.Fatalf("expected the owner to still see the asset, got %d body %s", status, body)
+	}
+	versionVisibility(t, ctx, st, do, asset.Id)
+}
+
+// streamVisibility checks that the event stream leaves out a private asset's events
+// for a key outside its access list while still sending them to the owner.
+func streamVisibility(t *testing.T, ctx context.Context, baseURL string, bus *events.Bus, st *store.Store, privateID int64) {
+	public, err := st.CreateAsset(ctx, store.AssetCreate{Title: "public", Width: 1, Height: 1, Bytes: 1, Mime: "image/png", SHA256: fmt.Sprintf("f%063d", 1)})
+	if err != nil {
+		t.Fatalf("create public asset: %v", err)
+	}
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream := func(key string) <-chan events.Event {
+		req, _ := http.NewRequestWithContext(streamCtx, http.MethodGet, baseURL+"/api/events", nil)
+		req.Header.Set("X-Api-Key", key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("stream: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			t.Fatalf("stream status %d", resp.StatusCode)
+		}
+		// The server subscribes before writing headers, so events published from
+		// here on reach this stream.
+		ch := make(chan events.Event, 16)
+		go func() {
+			defer resp.Body.Close()
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+				if !ok {
+					continue
+				}
+				var e events.Event
+				if json.Unmarshal([]byte(data), &e) == nil {
+					ch <- e
+				}
+			}
+		}()
+		return ch
+	}
+	next := func(name string, ch <-chan events.Event) events.Event {
+		select {
+		case e := <-ch:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s stream received nothing", name)
+			return events.Event{}
+		}
+	}
+
+	owner, other := stream("owner-key"), stream("other-key")
+	bus.Publish(events.Event{ID: 1, Type: events.AssetCreated, AssetID: privateID})
+	bus.Publish(events.Event{ID: 2, Type: events.AssetCreated, AssetID: public.ID})
+	if e := next("owner", owner); e.AssetID != privateID {
+		t.Fatalf("expected the owner to receive the private asset's event first, got %+v", e)
+	}
+	if e := next("owner", owner); e.AssetID != public.ID {
+		t.Fatalf("expected the owner to receive the public asset's event, got %+v", e)
+	}
+	if e := next("other", other); e.AssetID != public.ID {
+		t.Fatalf("expected the other key to receive only the public asset's event, got %+v", e)
+	}
+}
+
+// downloadAsset fetches the private PNG privateAssets uploaded as an attachment,
+// whole and by range, and checks that a key outside its access list gets 404.
+func downloadAsset(t *testing.T, baseURL string, id int64, original []byte) {
+	get := func(key, rangeHeader string) (*http.Response, []byte) {
+		req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/assets/%d/download", baseURL, id), nil)
+		req.Header.Set("X-Api-Key", key)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("download: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	resp, body := get("owner-key", "")
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, original) {
+		t.Fatalf("expected the original bytes, got %d with %d bytes", resp.StatusCode, len(body))
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "image/png" {
+		t.Fatalf("expected image/png, got %q", ct)
+	}
+	disposition, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+	if err != nil || disposition != "attachment" || params["filename"] != "private.png" {
+		t.Fatalf("unexpected Content-Disposition %q", resp.Header.Get("Content-Disposition"))
+	}
+	if cc := resp.Header.Get("Cache-Control"); !strings.HasPrefix(cc, "private") {
+		t.Fatalf("expected a private Cache-Control, got %q", cc)
+	}
+
+	resp, body = get("owner-key", "bytes=0-7")
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, original[:8]) {
+		t.Fatalf("expected the first 8 bytes with 206, got %d body %x", resp.StatusCode, body)
+	}
+	if cr, want := resp.Header.Get("Content-Range"), fmt.Sprintf("bytes 0-7/%d", len(original)); cr != want {
+		t.Fatalf("expected Content-Range %q, got %q", want, cr)
+	}
+	if resp, _ = get("owner-key", fmt.Sprintf("bytes=%d-", len(original)+10)); resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("expected 416 for a range past the end, got %d", resp.StatusCode)
+	}
+
+	if resp, body = get("other-key", ""); resp.StatusCode != http.StatusNotFound || bytes.Equal(body, original) {
+		t.Fatalf("expected 404 for an asset the caller cannot view, got %d", resp.StatusCode)
+	}
+}
+
+// assetFacets checks that facet counts add up to the matching total, that a bad
+// field or interval is rejected, and that an asset the caller cannot view is not
+// counted. privateAssets has just uploaded one PNG visible only to the owner key.
+func assetFacets(t *testing.T, do func(key, method, path, contentType string, body []byte) (int, []byte)) {
+	facets := func(key, query string) httpapi.AssetFacetsResponse {
+		status, body := do(key, http.MethodGet, "/api/assets/facets?"+query, "", nil)
+		if status != http.StatusOK {
+			t.Fatalf("facets %s: status %d body %s", query, status, body)
+		}
+		var resp httpapi.AssetFacetsResponse
+		_ = json.Unmarshal(body, &resp)
+		return resp
+	}
+	count := func(buckets []httpapi.FacetBucket, key string) (sum int) {
+		for _, b := range buckets {
+			if key == "" || b.Key == key {
+				sum += b.Count
+			}
+		}
+		return sum
+	}
+
+	status, body := do("owner-key", http.MethodGet, "/api/assets/count", "", nil)
+	var total httpapi.AssetCountResponse
+	_ = json.Unmarshal(body, &total)
+	if status != http.StatusOK || total.Total == 0 {
+		t.Fatalf("count: status %d body %s", status, body)
+	}
+	for _, interval := range []string{"year", "month", "day"} {
+		resp := facets("owner-key", "field=created_at&interval="+interval)
+		if resp.Interval == nil || string(*resp.Interval) != interval {
+			t.Fatalf("expected interval %s, got %+v", interval, resp)
+		}
+		if sum := count(resp.Buckets, ""); sum != total.Total {
+			t.Fatalf("%s buckets add up to %d, expected the %d matching assets", interval, sum, total.Total)
+		}
+		for i := 1; i < len(resp.Buckets); i++ {
+			if resp.Buckets[i-1].Key >= resp.Buckets[i].Key {
+				t.Fatalf("expected %s buckets oldest first, got %+v", interval, resp.Buckets)
+			}
+		}
+	}
+
+	owner := facets("owner-key", "field=mime")
+	if owner.Interval != nil || count(owner.Buckets, "") != total.Total {
+		t.Fatalf("unexpected mime facets %+v for %d assets", owner, total.Total)
+	}
+	for i := 1; i < len(owner.Buckets); i++ {
+		if owner.Buckets[i-1].Count < owner.Buckets[i].Count {
+			t.Fatalf("expected mime buckets most common first, got %+v", owner.Buckets)
+		}
+	}
+	other := facets("other-key", "field=mime")
+	if got, want := count(other.Buckets, "image/png"), count(owner.Buckets, "image/png")-1; got != want {
+		t.Fatalf("expected the private PNG to be left out for another key, got %d image/png, expected %d", got, want)
+	}
+	if filtered := facets("owner-key", "field=mime&mime=image/png"); len(filtered.Buckets) != 1 || filtered.Buckets[0].Key != "image/png" {
+		t.Fatalf("expected the mime filter to leave one bucket, got %+v", filtered.Buckets)
+	}
+
+	for _, query := range []string{"field=title", "field=created_at&interval=week"} {
+		if status, body := do("owner-key", http.MethodGet, "/api/assets/facets?"+query, "", nil); status != http.StatusBadRequest {
+			t.Fatalf("facets %s: expected 400, got %d body %s", query, status, body)
+		}
+	}
+}
+
+// versionVisibility edits a private asset into a public one and checks that each
+// version keeps the access list it had, that edits changing nothing record no version,
+// and that a key outside the old access list sees only the public versions.
+func versionVisibility(t *testing.T, ctx context.Context, st *store.Store, do func(key, method, path, contentType string, body []byte) (int, []byte), id int64) {
+	path := fmt.Sprintf("/api/assets/%d", id)
+	for _, patch := range []string{`{"title":"Renamed"}`, `{"title":"Renamed"}`, `{"visibility":"public"}`, `{"title":"Public"}`} {
+		if status, body := do("owner-key", http.MethodPatch, path, "application/json", []byte(patch)); status != http.StatusOK {
+			t.Fatalf("patch %s: status %d body %s", patch, status, body)
+		}
+	}
+
+	versions, err := st.ListVersions(ctx, id)
+	if err != nil {
+		t.Fatalf("list versions: %v", err)
+	}
+	if len(versions) != 3 {
+		t.Fatalf("expected the repeated title to record no version, got %d versions", len(versions))
+	}
+	if v := versions[2]; v.Version != 1 || v.Visibility != store.VisibilityPrivate || len(v.AllowedPrincipals) != 1 || v.AllowedPrincipals[0] != "owner" {
+		t.Fatalf("expected version 1 to keep its access list, got %+v", v)
+	}
+	if v := versions[0]; v.Version != 3 || v.Visibility != store.VisibilityPublic || v.Title != "Renamed" {
+		t.Fatalf("unexpected version 3 %+v", v)
+	}
+
+	status, body := do("other-key", http.MethodGet, path+"/versions", "", nil)
+	var list httpapi.AssetVersionList
+	_ = json.Unmarshal(body, &list)
+	if status != http.StatusOK || len(list.Items) != 1 || list.Items[0].Version != 3 {
+		t.Fatalf("expected only the public version, got %d body %s", status, body)
+	}
+	if status, body := do("other-key", http.MethodGet, path+"/versions/1", "", nil); status != http.StatusNotFound {
+		t.Fatalf("expected 404 for a private version, got %d body %s", status, body)
+	}
+	status, body = do("owner-key", http.MethodGet, path+"/versions", "", nil)
+	_ = json.Unmarshal(body, &list)
+	if status != http.StatusOK || len(list.Items) != 3 {
+		t.Fatalf("expected the owner to see every version, got %d body %s", status, body)
+	}
+}
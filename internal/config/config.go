@@ -2,24 +2,113 @@ package config
 
 import (
 	"fmt"
+	"image/color"
+	"math"
+	"net/netip"
 	"os"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 const (
-	DefaultBind                  = ":8080"
-	DefaultStorageRoot           = "/srv/ganache"
-	DefaultMaxUploadBytes  int64 = 20 * 1024 * 1024
-	DefaultMaxPixels             = 50_000_000
-	DefaultContentMaxWidth       = 1600
-	DefaultThumbMaxWidth         = 400
+	DefaultBind                     = ":8080"
+	DefaultServiceName              = "ganache"
+	DefaultStorageRoot              = "/srv/ganache"
+	DefaultMaxUploadBytes     int64 = 20 * 1024 * 1024
+	DefaultMultipartMemory    int64 = 10 * 1024 * 1024
+	DefaultUploadSlackBytes   int64 = 64 * 1024
+	DefaultMaxImportBytes     int64 = 1024 * 1024 * 1024
+	DefaultMaxPixels                = 50_000_000
+	DefaultFilenameMaxLen           = 255
+	DefaultContentMaxWidth          = 1600
+	DefaultThumbMaxWidth            = 400
+	MaxPreviewWidth                 = 64
+	DefaultDBWaitTimeout            = 30 * time.Second
+	DefaultDBBreakerThreshold       = 5
+	DefaultDBBreakerCooldown        = 10 * time.Second
+	DefaultTagCacheTTL              = 10 * time.Second
+	DefaultRequestTimeout           = 60 * time.Second
+	DefaultQueryTimeout             = 15 * time.Second
+	DefaultUploadTimeout            = 10 * time.Minute
+	DefaultLongPollMaxTimeout       = 60 * time.Second
+	DefaultPageSize                 = 30
+	DefaultMaxPageSize              = 200
+	DefaultMaxSearchTags            = 20
+	DefaultMaxSearchQueryLen        = 500
+	DefaultExtAliases               = "jfif=jpeg,jpe=jpeg,pjpeg=jpeg"
+	DefaultVariantWorkers           = 2
+	DefaultVariantQueueSize         = 1000
+	DefaultReprocessDelay           = 100 * time.Millisecond
+	DefaultClamAVTimeout            = 30 * time.Second
+	DefaultRelevanceWeights         = "title=3,tags=2,caption=1"
 )
 
+// AuthMode names one authentication mechanism, or a comma-separated list of them
+// tried in order, e.g. "apikey,oidc". AuthNone cannot be combined with others.
 type AuthMode string
 
+// Modes returns the mechanisms m lists, in the order they are tried.
+func (m AuthMode) Modes() []AuthMode {
+	var modes []AuthMode
+	for _, part := range splitAndTrim(string(m)) {
+		modes = append(modes, AuthMode(part))
+	}
+	return modes
+}
+
+// Has reports whether m lists mode.
+func (m AuthMode) Has(mode AuthMode) bool {
+	return slices.Contains(m.Modes(), mode)
+}
+
+// DuplicateResponse selects how an upload whose hash matches an existing asset is answered.
+type DuplicateResponse string
+
+const (
+	DuplicateConflict DuplicateResponse = "conflict"
+	DuplicateOK       DuplicateResponse = "ok"
+)
+
+// ErrorFormat selects the body of error responses.
+type ErrorFormat string
+
+const (
+	// ErrorFormatJSON is the native {code, message, details} shape.
+	ErrorFormatJSON ErrorFormat = "json"
+	// ErrorFormatProblem is RFC 7807 application/problem+json.
+	ErrorFormatProblem ErrorFormat = "problem"
+)
+
+// EmptyQueryBehavior selects what a search without any filter returns.
+type EmptyQueryBehavior string
+
+const (
+	EmptyQueryAll  EmptyQueryBehavior = "all"
+	EmptyQueryNone EmptyQueryBehavior = "none"
+)
+
+// MissingMediaResponse selects how a media request is answered when the asset
+// exists but its file is missing from storage.
+type MissingMediaResponse string
+
+const (
+	MissingMediaGone     MissingMediaResponse = "gone"
+	MissingMediaNotFound MissingMediaResponse = "not_found"
+)
+
+// HashAlgo selects the algorithm new uploads are content-addressed by.
+type HashAlgo string
+
+const (
+	HashSHA256 HashAlgo = "sha256"
+	HashSHA512 HashAlgo = "sha512"
+)
+
 const (
 	AuthNone   AuthMode = "none"
 	AuthAPIKey AuthMode = "apikey"
@@ -29,18 +118,91 @@ const (
 type Config struct {
 	Bind               string
 	DBDSN              string
+	DBReplicaDSN       string
+	DBWaitTimeout      time.Duration
+	DBStatementTimeout time.Duration
+	DBBreakerThreshold int
+	DBBreakerCooldown  time.Duration
 	StorageRoot        string
 	MaxUploadBytes     int64
+	MaxImportBytes     int64
+	MultipartMemory    int64
+	UploadSlackBytes   int64
+	FilenameMaxLen     int
 	MaxPixels          int
+	MaxWidth           int
+	MaxHeight          int
+	FormatMaxBytes     map[string]int64
+	FormatMaxPixels    map[string]int
 	ContentMaxWidth    int
 	ThumbMaxWidth      int
+	ThumbWidths        []int
+	PreviewMaxWidth    int
+	AsyncVariants      bool
+	HashAlgo           HashAlgo
+	CompressOriginals  bool
+	RejectTransparency bool
+	VariantWorkers     int
+	VariantQueueSize   int
+	ReprocessDelay     time.Duration
+	ClamAVAddr         string
+	ClamAVTimeout      time.Duration
+	ExtAliases         map[string]string
+	UploadFieldMap     map[string]string
+	RequireTitle       bool
+	RequireCredit      bool
+	TagFoldAccents     bool
+	TagCacheTTL        time.Duration
+	RelevanceWeights   map[string]float64
+	BlockedTagsFile    string
+	PlaceholderImage   string
+	DefaultPageSize    int
+	MaxPageSize        int
+	StrictPagination   bool
+	MaxSearchTags      int
+	MaxSearchQueryLen  int
 	PublicMedia        bool
+	OriginalAuth       bool
+	// RelevanceSortedOnly skips computing relevance scores for searches not sorted by
+	// relevance.
+	RelevanceSortedOnly bool
+	// MediaAuthVariants lists the /media variants that require can_search. Load
+	// derives it from PublicMedia and OriginalAuth unless GANACHE_MEDIA_AUTH_VARIANTS
+	// is set.
+	MediaAuthVariants  []string
+	PublicBaseURL      string
 	AuthMode           AuthMode
+	DuplicateResponse  DuplicateResponse
+	MissingMedia       MissingMediaResponse
+	EmptyQuery         EmptyQueryBehavior
+	ErrorFormat        ErrorFormat
+	BigIntAsString     bool
 	APIKeysFile        string
+	RoutePermsFile     string
 	CORSAllowedOrigins []string
+	AdminIPAllowlist   []netip.Prefix
+	TrustedProxies     []netip.Prefix
+	SecureHeaders      bool
+	ServerTiming       bool
+	MaintenanceMode    bool
+	ReadOnly           bool
 	LogLevel           string
+	RequestTimeout     time.Duration
+	QueryTimeout       time.Duration
+	UploadTimeout      time.Duration
+	LongPollMaxTimeout time.Duration
 	SwaggerUIPath      string
 	OpenAPIPath        string
+	ServiceName        string
+	// InstanceID names this instance's position in the event outbox, so a restart
+	// resumes where it stopped. It defaults to the hostname.
+	InstanceID string
+	// TransparencyBackground is the color transparent images are flattened against
+	// for derivatives, parsed from a "#rrggbb" GANACHE_TRANSPARENCY_BACKGROUND. Nil
+	// leaves derivatives with their alpha channel.
+	TransparencyBackground color.Color
+	// Version is set by the binary at startup rather than loaded from the environment.
+	Version string
 }
 
 func Load() (*Config, error) {
@@ -48,31 +210,243 @@ func Load() (*Config, error) {
 
 	cfg := &Config{
 		Bind:               getenv("GANACHE_BIND", DefaultBind),
+		DBWaitTimeout:      getDuration("GANACHE_DB_WAIT_TIMEOUT", DefaultDBWaitTimeout),
+		DBStatementTimeout: getDuration("GANACHE_DB_STATEMENT_TIMEOUT", 0),
+		DBBreakerThreshold: getInt("GANACHE_DB_BREAKER_THRESHOLD", DefaultDBBreakerThreshold),
+		DBBreakerCooldown:  getDuration("GANACHE_DB_BREAKER_COOLDOWN", DefaultDBBreakerCooldown),
 		StorageRoot:        getenv("GANACHE_STORAGE_ROOT", DefaultStorageRoot),
 		MaxUploadBytes:     getInt64("GANACHE_MAX_UPLOAD_BYTES", DefaultMaxUploadBytes),
+		MaxImportBytes:     getInt64("GANACHE_MAX_IMPORT_BYTES", DefaultMaxImportBytes),
+		MultipartMemory:    getInt64("GANACHE_MULTIPART_MEMORY", DefaultMultipartMemory),
+		UploadSlackBytes:   getInt64("GANACHE_UPLOAD_SLACK_BYTES", DefaultUploadSlackBytes),
+		FilenameMaxLen:     getInt("GANACHE_FILENAME_MAX_LENGTH", DefaultFilenameMaxLen),
 		MaxPixels:          getInt("GANACHE_MAX_PIXELS", DefaultMaxPixels),
+		MaxWidth:           getInt("GANACHE_MAX_WIDTH", 0),
+		MaxHeight:          getInt("GANACHE_MAX_HEIGHT", 0),
 		ContentMaxWidth:    getInt("GANACHE_CONTENT_MAX_WIDTH", DefaultContentMaxWidth),
 		ThumbMaxWidth:      getInt("GANACHE_THUMB_MAX_WIDTH", DefaultThumbMaxWidth),
+		PreviewMaxWidth:    getInt("GANACHE_PREVIEW_MAX_WIDTH", 0),
+		AsyncVariants:      getBool("GANACHE_ASYNC_VARIANTS", false),
+		HashAlgo:           HashAlgo(strings.ToLower(getenv("GANACHE_HASH_ALGO", string(HashSHA256)))),
+		CompressOriginals:  getBool("GANACHE_COMPRESS_ORIGINALS", false),
+		RejectTransparency: getBool("GANACHE_REJECT_TRANSPARENCY", false),
+		VariantWorkers:     getInt("GANACHE_VARIANT_WORKERS", DefaultVariantWorkers),
+		VariantQueueSize:   getInt("GANACHE_VARIANT_QUEUE_SIZE", DefaultVariantQueueSize),
+		ReprocessDelay:     getDuration("GANACHE_REPROCESS_DELAY", DefaultReprocessDelay),
+		ClamAVAddr:         strings.TrimSpace(os.Getenv("GANACHE_CLAMAV_ADDR")),
+		ClamAVTimeout:      getDuration("GANACHE_CLAMAV_TIMEOUT", DefaultClamAVTimeout),
+		DefaultPageSize:    getInt("GANACHE_DEFAULT_PAGE_SIZE", DefaultPageSize),
+		MaxPageSize:        getInt("GANACHE_MAX_PAGE_SIZE", DefaultMaxPageSize),
+		StrictPagination:   getBool("GANACHE_STRICT_PAGINATION", false),
+		MaxSearchTags:      getInt("GANACHE_MAX_SEARCH_TAGS", DefaultMaxSearchTags),
+		MaxSearchQueryLen:  getInt("GANACHE_MAX_SEARCH_QUERY_LENGTH", DefaultMaxSearchQueryLen),
 		PublicMedia:        getBool("GANACHE_PUBLIC_MEDIA", true),
+		OriginalAuth:       getBool("GANACHE_ORIGINAL_REQUIRES_AUTH", false),
+		TagFoldAccents:     getBool("GANACHE_TAG_FOLD_ACCENTS", false),
+		TagCacheTTL:        getDuration("GANACHE_TAG_CACHE_TTL", DefaultTagCacheTTL),
+		RequireTitle:       getBool("GANACHE_REQUIRE_TITLE", false),
+		RequireCredit:      getBool("GANACHE_REQUIRE_CREDIT", false),
+		BlockedTagsFile:    os.Getenv("GANACHE_BLOCKED_TAGS_FILE"),
+		PlaceholderImage:   os.Getenv("GANACHE_PLACEHOLDER_IMAGE"),
+		RoutePermsFile:     os.Getenv("GANACHE_ROUTE_PERMISSIONS_FILE"),
+		PublicBaseURL:      strings.TrimRight(strings.TrimSpace(os.Getenv("GANACHE_PUBLIC_BASE_URL")), "/"),
 		AuthMode:           AuthMode(getenv("GANACHE_AUTH_MODE", string(AuthAPIKey))),
+		DuplicateResponse:  DuplicateResponse(getenv("GANACHE_DUPLICATE_RESPONSE", string(DuplicateConflict))),
+		MissingMedia:       MissingMediaResponse(getenv("GANACHE_MISSING_MEDIA_RESPONSE", string(MissingMediaGone))),
+		EmptyQuery:         EmptyQueryBehavior(getenv("GANACHE_EMPTY_QUERY_BEHAVIOR", string(EmptyQueryAll))),
+		ErrorFormat:        ErrorFormat(getenv("GANACHE_ERROR_FORMAT", string(ErrorFormatJSON))),
+		BigIntAsString:     getBool("GANACHE_BIGINT_AS_STRING", false),
 		CORSAllowedOrigins: splitAndTrim(os.Getenv("GANACHE_CORS_ALLOWED_ORIGINS")),
+		SecureHeaders:      getBool("GANACHE_SECURE_HEADERS", false),
+		ServerTiming:       getBool("GANACHE_SERVER_TIMING", false),
+		MaintenanceMode:    getBool("GANACHE_MAINTENANCE_MODE", false),
+		ReadOnly:           getBool("GANACHE_READ_ONLY", false),
 		LogLevel:           os.Getenv("GANACHE_LOG_LEVEL"),
+		RequestTimeout:     getDuration("GANACHE_REQUEST_TIMEOUT", DefaultRequestTimeout),
+		QueryTimeout:       getDuration("GANACHE_QUERY_TIMEOUT", DefaultQueryTimeout),
+		UploadTimeout:      getDuration("GANACHE_UPLOAD_TIMEOUT", DefaultUploadTimeout),
+		LongPollMaxTimeout: getDuration("GANACHE_LONG_POLL_MAX_TIMEOUT", DefaultLongPollMaxTimeout),
 		SwaggerUIPath:      "/swagger",
 		OpenAPIPath:        "/openapi.yaml",
+		ServiceName:        getenv("GANACHE_SERVICE_NAME", DefaultServiceName),
+		InstanceID:         strings.TrimSpace(os.Getenv("GANACHE_INSTANCE_ID")),
+	}
+	if cfg.InstanceID == "" {
+		host, err := os.Hostname()
+		if err != nil || host == "" {
+			host = DefaultServiceName
+		}
+		cfg.InstanceID = host
+	}
+
+	widths, err := parseWidths(os.Getenv("GANACHE_THUMB_WIDTHS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GANACHE_THUMB_WIDTHS: %w", err)
+	}
+	cfg.ThumbWidths = widths
+
+	aliases, err := parseExtAliases(getenv("GANACHE_EXT_ALIASES", DefaultExtAliases))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GANACHE_EXT_ALIASES: %w", err)
+	}
+	cfg.ExtAliases = aliases
+
+	formatBytes, err := parseFormatLimits(os.Getenv("GANACHE_FORMAT_MAX_UPLOAD_BYTES"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GANACHE_FORMAT_MAX_UPLOAD_BYTES: %w", err)
+	}
+	cfg.FormatMaxBytes = formatBytes
+
+	formatPixels, err := parseFormatLimits(os.Getenv("GANACHE_FORMAT_MAX_PIXELS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GANACHE_FORMAT_MAX_PIXELS: %w", err)
+	}
+	cfg.FormatMaxPixels = make(map[string]int, len(formatPixels))
+	for format, n := range formatPixels {
+		cfg.FormatMaxPixels[format] = int(n)
+	}
+
+	weights, err := parseRelevanceWeights(getenv("GANACHE_RELEVANCE_WEIGHTS", DefaultRelevanceWeights))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GANACHE_RELEVANCE_WEIGHTS: %w", err)
+	}
+	cfg.RelevanceWeights = weights
+	cfg.RelevanceSortedOnly = getBool("GANACHE_RELEVANCE_SORTED_ONLY", false)
+
+	authVariants, err := parseMediaAuthVariants(os.Getenv("GANACHE_MEDIA_AUTH_VARIANTS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GANACHE_MEDIA_AUTH_VARIANTS: %w", err)
+	}
+	switch {
+	case authVariants != nil:
+		cfg.MediaAuthVariants = authVariants
+	case !cfg.PublicMedia:
+		cfg.MediaAuthVariants = MediaVariants
+	case cfg.OriginalAuth:
+		cfg.MediaAuthVariants = []string{"original"}
+	}
+
+	fieldMap, err := parseUploadFieldMap(os.Getenv("GANACHE_UPLOAD_FIELD_MAP"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GANACHE_UPLOAD_FIELD_MAP: %w", err)
+	}
+	cfg.UploadFieldMap = fieldMap
+
+	adminIPs, err := parseIPAllowlist(os.Getenv("GANACHE_ADMIN_IP_ALLOWLIST"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GANACHE_ADMIN_IP_ALLOWLIST: %w", err)
+	}
+	cfg.AdminIPAllowlist = adminIPs
+
+	proxies, err := parseIPAllowlist(os.Getenv("GANACHE_TRUSTED_PROXIES"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GANACHE_TRUSTED_PROXIES: %w", err)
+	}
+	cfg.TrustedProxies = proxies
+
+	if v := strings.TrimSpace(os.Getenv("GANACHE_TRANSPARENCY_BACKGROUND")); v != "" {
+		bg, err := parseHexColor(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GANACHE_TRANSPARENCY_BACKGROUND: %w", err)
+		}
+		if cfg.RejectTransparency {
+			return nil, fmt.Errorf("GANACHE_TRANSPARENCY_BACKGROUND cannot be combined with GANACHE_REJECT_TRANSPARENCY")
+		}
+		cfg.TransparencyBackground = bg
 	}
 
+	cfg.DBReplicaDSN = os.Getenv("GANACHE_DB_REPLICA_DSN")
 	cfg.DBDSN = os.Getenv("GANACHE_DB_DSN")
 	if cfg.DBDSN == "" {
 		return nil, fmt.Errorf("GANACHE_DB_DSN is required")
 	}
 
-	switch cfg.AuthMode {
-	case AuthNone, AuthAPIKey, AuthOIDC:
+	if cfg.VariantWorkers < 1 || cfg.VariantQueueSize < 1 {
+		return nil, fmt.Errorf("GANACHE_VARIANT_WORKERS and GANACHE_VARIANT_QUEUE_SIZE must be positive")
+	}
+
+	if cfg.DBStatementTimeout < 0 {
+		return nil, fmt.Errorf("GANACHE_DB_STATEMENT_TIMEOUT must not be negative")
+	}
+
+	if cfg.ReprocessDelay < 0 {
+		return nil, fmt.Errorf("GANACHE_REPROCESS_DELAY must not be negative")
+	}
+
+	if cfg.LongPollMaxTimeout <= 0 {
+		return nil, fmt.Errorf("GANACHE_LONG_POLL_MAX_TIMEOUT must be positive")
+	}
+
+	if cfg.FilenameMaxLen < 16 || cfg.FilenameMaxLen > DefaultFilenameMaxLen {
+		return nil, fmt.Errorf("GANACHE_FILENAME_MAX_LENGTH must be between 16 and %d", DefaultFilenameMaxLen)
+	}
+
+	if cfg.MaxWidth < 0 || cfg.MaxHeight < 0 {
+		return nil, fmt.Errorf("GANACHE_MAX_WIDTH and GANACHE_MAX_HEIGHT must not be negative")
+	}
+
+	// Previews are returned inline with every asset and stored in the asset row's TEXT
+	// column.
+	if cfg.PreviewMaxWidth < 0 || cfg.PreviewMaxWidth > MaxPreviewWidth {
+		return nil, fmt.Errorf("GANACHE_PREVIEW_MAX_WIDTH must be between 0 and %d", MaxPreviewWidth)
+	}
+
+	if cfg.MultipartMemory <= 0 {
+		return nil, fmt.Errorf("GANACHE_MULTIPART_MEMORY must be positive")
+	}
+
+	if cfg.UploadSlackBytes <= 0 {
+		return nil, fmt.Errorf("GANACHE_UPLOAD_SLACK_BYTES must be positive")
+	}
+
+	if cfg.DefaultPageSize < 1 || cfg.MaxPageSize < 1 {
+		return nil, fmt.Errorf("GANACHE_DEFAULT_PAGE_SIZE and GANACHE_MAX_PAGE_SIZE must be positive")
+	}
+	if cfg.DefaultPageSize > cfg.MaxPageSize {
+		return nil, fmt.Errorf("GANACHE_DEFAULT_PAGE_SIZE (%d) must not exceed GANACHE_MAX_PAGE_SIZE (%d)", cfg.DefaultPageSize, cfg.MaxPageSize)
+	}
+
+	if cfg.MaxSearchTags < 1 || cfg.MaxSearchQueryLen < 1 {
+		return nil, fmt.Errorf("GANACHE_MAX_SEARCH_TAGS and GANACHE_MAX_SEARCH_QUERY_LENGTH must be positive")
+	}
+
+	switch cfg.DuplicateResponse {
+	case DuplicateConflict, DuplicateOK:
 	default:
-		return nil, fmt.Errorf("invalid GANACHE_AUTH_MODE: %s", cfg.AuthMode)
+		return nil, fmt.Errorf("invalid GANACHE_DUPLICATE_RESPONSE: %s", cfg.DuplicateResponse)
 	}
 
-	if cfg.AuthMode == AuthAPIKey {
+	switch cfg.HashAlgo {
+	case HashSHA256, HashSHA512:
+	default:
+		return nil, fmt.Errorf("invalid GANACHE_HASH_ALGO: %s (supported: sha256, sha512)", cfg.HashAlgo)
+	}
+
+	switch cfg.MissingMedia {
+	case MissingMediaGone, MissingMediaNotFound:
+	default:
+		return nil, fmt.Errorf("invalid GANACHE_MISSING_MEDIA_RESPONSE: %s", cfg.MissingMedia)
+	}
+
+	switch cfg.EmptyQuery {
+	case EmptyQueryAll, EmptyQueryNone:
+	default:
+		return nil, fmt.Errorf("invalid GANACHE_EMPTY_QUERY_BEHAVIOR: %s", cfg.EmptyQuery)
+	}
+
+	switch cfg.ErrorFormat {
+	case ErrorFormatJSON, ErrorFormatProblem:
+	default:
+		return nil, fmt.Errorf("invalid GANACHE_ERROR_FORMAT: %s", cfg.ErrorFormat)
+	}
+
+	authMode, err := parseAuthMode(string(cfg.AuthMode))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GANACHE_AUTH_MODE: %w", err)
+	}
+	cfg.AuthMode = authMode
+
+	if cfg.AuthMode.Has(AuthAPIKey) {
 		cfg.APIKeysFile = getenv("GANACHE_API_KEYS_FILE", "api-keys.yaml")
 		if cfg.APIKeysFile == "" {
 			return nil, fmt.Errorf("GANACHE_API_KEYS_FILE is required when GANACHE_AUTH_MODE=apikey")
@@ -109,6 +483,16 @@ func getInt64(key string, def int64) int64 {
 	return def
 }
 
+func getDuration(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+	}
+	return def
+}
+
 func getBool(key string, def bool) bool {
 	if v := os.Getenv(key); v != "" {
 		v = strings.ToLower(strings.TrimSpace(v))
@@ -117,6 +501,213 @@ func getBool(key string, def bool) bool {
 	return def
 }
 
+func parseWidths(input string) ([]int, error) {
+	var out []int
+	seen := make(map[int]bool)
+	for _, p := range splitAndTrim(input) {
+		w, err := strconv.Atoi(p)
+		if err != nil || w <= 0 {
+			return nil, fmt.Errorf("%q is not a positive integer", p)
+		}
+		if !seen[w] {
+			seen[w] = true
+			out = append(out, w)
+		}
+	}
+	sort.Ints(out)
+	return out, nil
+}
+
+// parseHexColor reads an opaque "#rrggbb" color; the leading "#" is optional.
+func parseHexColor(input string) (color.Color, error) {
+	s := strings.TrimPrefix(input, "#")
+	if len(s) != 6 {
+		return nil, fmt.Errorf("%q is not of the form #rrggbb", input)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not of the form #rrggbb", input)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
+// parseExtAliases reads "alias=canonical" pairs such as "jfif=jpeg,jpe=jpeg" into a map
+// keyed by lowercase extension, with leading dots stripped from both sides.
+func parseExtAliases(input string) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, p := range splitAndTrim(input) {
+		from, to, ok := strings.Cut(p, "=")
+		from = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(from)), ".")
+		to = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(to)), ".")
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("%q is not of the form alias=extension", p)
+		}
+		out[from] = to
+	}
+	return out, nil
+}
+
+// imageFormats lists the decoder format names per-format limits may be keyed by.
+var imageFormats = []string{"gif", "jpeg", "png", "webp"}
+
+// parseFormatLimits reads "format=limit" pairs such as "png=20000000,gif=10000000".
+// Formats are matched case-insensitively and "jpg" is accepted for "jpeg".
+func parseFormatLimits(input string) (map[string]int64, error) {
+	out := make(map[string]int64)
+	for _, p := range splitAndTrim(input) {
+		format, value, ok := strings.Cut(p, "=")
+		format = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(format)), ".")
+		if format == "jpg" {
+			format = "jpeg"
+		}
+		if !ok || !slices.Contains(imageFormats, format) {
+			return nil, fmt.Errorf("%q is not of the form format=limit with format one of %s", p, strings.Join(imageFormats, ", "))
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("limit for %s must be a positive integer", format)
+		}
+		out[format] = n
+	}
+	return out, nil
+}
+
+// RelevanceColumns are the searchable columns GANACHE_RELEVANCE_WEIGHTS can weigh.
+var RelevanceColumns = []string{"title", "tags", "caption"}
+
+// parseRelevanceWeights reads "column=weight" pairs such as "title=3,tags=2,caption=1".
+// Omitted columns get weight zero and do not count towards relevance; at least one
+// weight must be positive.
+func parseRelevanceWeights(input string) (map[string]float64, error) {
+	out := make(map[string]float64)
+	for _, p := range splitAndTrim(input) {
+		column, value, ok := strings.Cut(p, "=")
+		column = strings.ToLower(strings.TrimSpace(column))
+		if !ok || !slices.Contains(RelevanceColumns, column) {
+			return nil, fmt.Errorf("%q is not of the form column=weight with column one of %s", p, strings.Join(RelevanceColumns, ", "))
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || w < 0 || math.IsInf(w, 0) || math.IsNaN(w) {
+			return nil, fmt.Errorf("weight for %s must be a non-negative number", column)
+		}
+		out[column] = w
+	}
+	for _, w := range out {
+		if w > 0 {
+			return out, nil
+		}
+	}
+	return nil, fmt.Errorf("at least one column needs a positive weight")
+}
+
+// MediaVariants lists the variants served under /media.
+var MediaVariants = []string{"thumb", "content", "original"}
+
+// parseMediaAuthVariants parses a comma-separated subset of MediaVariants. "none"
+// yields an empty, non-nil list; an unset value yields nil.
+func parseMediaAuthVariants(input string) ([]string, error) {
+	if strings.TrimSpace(input) == "" {
+		return nil, nil
+	}
+	if strings.EqualFold(strings.TrimSpace(input), "none") {
+		return []string{}, nil
+	}
+	var out []string
+	for _, v := range splitAndTrim(strings.ToLower(input)) {
+		if !slices.Contains(MediaVariants, v) {
+			return nil, fmt.Errorf("unknown variant %q", v)
+		}
+		if !slices.Contains(out, v) {
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
+
+// MediaRequiresAuth reports whether /media requests for variant need can_search.
+func (c *Config) MediaRequiresAuth(variant string) bool {
+	return slices.Contains(c.MediaAuthVariants, variant)
+}
+
+// UploadByteCeiling is the largest upload any format may be, used to bound the
+// request body before the format is known.
+func (c *Config) UploadByteCeiling() int64 {
+	ceiling := c.MaxUploadBytes
+	for _, n := range c.FormatMaxBytes {
+		ceiling = max(ceiling, n)
+	}
+	return ceiling
+}
+
+// UploadFields lists the canonical multipart field names accepted by the upload endpoint.
+var UploadFields = []string{"file", "title", "caption", "credit", "source", "usageNotes", "tags", "sha256", "visibility", "allowedPrincipals"}
+
+// parseUploadFieldMap reads "alias=canonical" pairs such as "headline=title,byline=credit"
+// into a map keyed by alias. Field names are case-sensitive, as in multipart forms.
+func parseUploadFieldMap(input string) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, p := range splitAndTrim(input) {
+		from, to, ok := strings.Cut(p, "=")
+		from = strings.TrimSpace(from)
+		to = strings.TrimSpace(to)
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("%q is not of the form alias=field", p)
+		}
+		if !slices.Contains(UploadFields, to) {
+			return nil, fmt.Errorf("%q is not an upload field", to)
+		}
+		out[from] = to
+	}
+	return out, nil
+}
+
+// parseAuthMode validates a comma-separated list of auth mechanisms and returns it
+// with the whitespace removed.
+func parseAuthMode(input string) (AuthMode, error) {
+	modes := AuthMode(input).Modes()
+	if len(modes) == 0 {
+		return "", fmt.Errorf("no auth mode given")
+	}
+	names := make([]string, 0, len(modes))
+	for _, mode := range modes {
+		switch mode {
+		case AuthNone, AuthAPIKey, AuthOIDC:
+		default:
+			return "", fmt.Errorf("unknown auth mode %q", mode)
+		}
+		if mode == AuthNone && len(modes) > 1 {
+			return "", fmt.Errorf("%q cannot be combined with other modes", AuthNone)
+		}
+		if slices.Contains(names, string(mode)) {
+			return "", fmt.Errorf("%q is listed twice", mode)
+		}
+		names = append(names, string(mode))
+	}
+	return AuthMode(strings.Join(names, ",")), nil
+}
+
+// parseIPAllowlist reads a comma-separated list of CIDR ranges such as
+// "10.8.0.0/16,fd00::/8". A bare address stands for itself alone.
+func parseIPAllowlist(input string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, p := range splitAndTrim(input) {
+		if !strings.Contains(p, "/") {
+			addr, err := netip.ParseAddr(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", p)
+			}
+			out = append(out, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range %q", p)
+		}
+		out = append(out, prefix.Masked())
+	}
+	return out, nil
+}
+
 func splitAndTrim(input string) []string {
 	if input == "" {
 		return nil
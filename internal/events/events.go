@@ -0,0 +1,77 @@
+// Package events provides an in-process publish/subscribe bus for asset lifecycle events.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	AssetCreated Type = "asset.created"
+	AssetUpdated Type = "asset.updated"
+	AssetDeleted Type = "asset.deleted"
+)
+
+type Event struct {
+	// ID is the event's position in the store's outbox, in commit order. Delivery is
+	// at least once and a redelivered event keeps its ID, so consumers drop events
+	// whose ID they have already seen.
+	ID      int64     `json:"id,omitempty"`
+	Type    Type      `json:"type"`
+	AssetID int64     `json:"assetId"`
+	At      time.Time `json:"at"`
+}
+
+// Bus fans events out to subscribers. Publishing never blocks: a subscriber whose
+// buffer is full is dropped and its channel closed, so a slow consumer cannot stall writers.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[chan Event]struct{})}
+}
+
+// Subscribe registers a subscriber with the given buffer size. The returned cancel
+// function unregisters it and is safe to call more than once.
+func (b *Bus) Subscribe(buffer int) (<-chan Event, func()) {
+	if buffer < 1 {
+		buffer = 1
+	}
+	ch := make(chan Event, buffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() { b.remove(ch) }
+}
+
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.At.IsZero() {
+		e.At = time.Now().UTC()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *Bus) remove(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
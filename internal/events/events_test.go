@@ -0,0 +1,39 @@
+package events
+
+import "testing"
+
+func TestBusDelivers(t *testing.T) {
+	bus := NewBus()
+	ch, cancel := bus.Subscribe(4)
+	defer cancel()
+
+	bus.Publish(Event{Type: AssetCreated, AssetID: 1})
+	got := <-ch
+	if got.Type != AssetCreated || got.AssetID != 1 || got.At.IsZero() {
+		t.Fatalf("unexpected event %+v", got)
+	}
+}
+
+func TestBusDropsSlowSubscriber(t *testing.T) {
+	bus := NewBus()
+	ch, cancel := bus.Subscribe(1)
+	defer cancel()
+
+	bus.Publish(Event{Type: AssetCreated, AssetID: 1})
+	bus.Publish(Event{Type: AssetCreated, AssetID: 2})
+
+	if _, ok := <-ch; !ok {
+		t.Fatalf("expected buffered event")
+	}
+	if _, ok := <-ch; ok {
+		t.Fatalf("expected channel to be closed after overflow")
+	}
+}
+
+func TestCancelIsIdempotent(t *testing.T) {
+	bus := NewBus()
+	_, cancel := bus.Subscribe(1)
+	cancel()
+	cancel()
+	bus.Publish(Event{Type: AssetDeleted, AssetID: 3})
+}
@@ -1,9 +1,17 @@
 package httpapi
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -12,13 +20,99 @@ type APIKey struct {
 	ID          string   `yaml:"id"`
 	Key         string   `yaml:"key"`
 	Permissions []string `yaml:"permissions"`
+	// NotBefore and ExpiresAt bound when the key is accepted; either may be unset.
+	NotBefore *time.Time `yaml:"notBefore"`
+	ExpiresAt *time.Time `yaml:"expiresAt"`
+	// file is the key file the entry was loaded from, rewritten on rotation.
+	file string
 }
 
 type APIKeyStore struct {
+	mu    sync.RWMutex
 	byKey map[string]*APIKey
 }
 
-func LoadAPIKeys(path string) (*APIKeyStore, error) {
+var (
+	ErrAPIKeyNotFound  = errors.New("api key not found")
+	ErrAPIKeyAmbiguous = errors.New("api key id is used by more than one key")
+	ErrAPIKeyExpired   = errors.New("api key has expired")
+	ErrAPIKeyNotYet    = errors.New("api key is not valid yet")
+)
+
+// LoadAPIKeys reads API keys from spec, a comma-separated list of YAML files and
+// directories. Every *.yaml and *.yml file in a directory is loaded, so each team can
+// own its own file. A key value may appear only once across all sources.
+func LoadAPIKeys(spec string) (*APIKeyStore, error) {
+	paths, err := apiKeyFiles(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &APIKeyStore{byKey: make(map[string]*APIKey)}
+	source := make(map[string]string)
+	for _, path := range paths {
+		entries, err := readAPIKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for i := range entries {
+			entry := &entries[i]
+			if prev, exists := store.byKey[entry.Key]; exists {
+				if source[entry.Key] == path {
+					return nil, fmt.Errorf("duplicate api key value for id %q in %s", entry.ID, path)
+				}
+				return nil, fmt.Errorf("duplicate api key value for id %q in %s: already used by id %q in %s", entry.ID, path, prev.ID, source[entry.Key])
+			}
+			entry.file = path
+			store.byKey[entry.Key] = entry
+			source[entry.Key] = path
+		}
+	}
+
+	if len(store.byKey) == 0 {
+		return nil, fmt.Errorf("api keys file is empty")
+	}
+	return store, nil
+}
+
+// apiKeyFiles expands spec into the key files to load, in a stable order.
+func apiKeyFiles(spec string) ([]string, error) {
+	var paths []string
+	for _, p := range strings.Split(spec, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("read api keys file: %w", err)
+		}
+		if !info.IsDir() {
+			paths = append(paths, p)
+			continue
+		}
+		var found []string
+		for _, pattern := range []string{"*.yaml", "*.yml"} {
+			matches, err := filepath.Glob(filepath.Join(p, pattern))
+			if err != nil {
+				return nil, fmt.Errorf("list api keys directory: %w", err)
+			}
+			found = append(found, matches...)
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("api keys directory %s has no *.yaml files", p)
+		}
+		sort.Strings(found)
+		paths = append(paths, found...)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no api keys file configured")
+	}
+	return paths, nil
+}
+
+// readAPIKeyFile parses and normalizes the entries of one key file.
+func readAPIKeyFile(path string) ([]APIKey, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("read api keys file: %w", err)
@@ -26,42 +120,175 @@ func LoadAPIKeys(path string) (*APIKeyStore, error) {
 
 	var entries []APIKey
 	if err := yaml.Unmarshal(data, &entries); err != nil {
-		return nil, fmt.Errorf("parse api keys file: %w", err)
+		return nil, fmt.Errorf("parse api keys file %s: %w", path, err)
 	}
 
-	if len(entries) == 0 {
-		return nil, fmt.Errorf("api keys file is empty")
-	}
-
-	store := &APIKeyStore{byKey: make(map[string]*APIKey, len(entries))}
 	for i := range entries {
-		entry := entries[i]
+		entry := &entries[i]
 		entry.ID = strings.TrimSpace(entry.ID)
 		entry.Key = strings.TrimSpace(entry.Key)
 		if entry.ID == "" {
-			return nil, fmt.Errorf("api key at index %d has empty id", i)
+			return nil, fmt.Errorf("api key at index %d in %s has empty id", i, path)
 		}
 		if entry.Key == "" {
-			return nil, fmt.Errorf("api key %q has empty key", entry.ID)
+			return nil, fmt.Errorf("api key %q in %s has empty key", entry.ID, path)
 		}
 		if len(entry.Permissions) == 0 {
-			return nil, fmt.Errorf("api key %q has no permissions", entry.ID)
+			return nil, fmt.Errorf("api key %q in %s has no permissions", entry.ID, path)
 		}
-		if _, exists := store.byKey[entry.Key]; exists {
-			return nil, fmt.Errorf("duplicate api key value for id %q", entry.ID)
+		if entry.NotBefore != nil && entry.ExpiresAt != nil && !entry.ExpiresAt.After(*entry.NotBefore) {
+			return nil, fmt.Errorf("api key %q in %s expires before it becomes valid", entry.ID, path)
 		}
-		// store pointer to normalized entry
-		entries[i] = entry
-		store.byKey[entry.Key] = &entries[i]
 	}
-
-	return store, nil
+	return entries, nil
 }
 
 func (s *APIKeyStore) Lookup(key string) (*APIKey, bool) {
 	if s == nil {
 		return nil, false
 	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	k, ok := s.byKey[key]
 	return k, ok
 }
+
+// ValidAt reports whether the key is inside its validity window at t. Keys without
+// notBefore or expiresAt are valid from or until any time.
+func (k *APIKey) ValidAt(t time.Time) error {
+	if k.NotBefore != nil && t.Before(*k.NotBefore) {
+		return ErrAPIKeyNotYet
+	}
+	if k.ExpiresAt != nil && !t.Before(*k.ExpiresAt) {
+		return ErrAPIKeyExpired
+	}
+	return nil
+}
+
+// Rotate replaces the secret of the key with the given id by a freshly generated
+// one, writes it to the key's file, and returns it. The old secret stops working as
+// soon as Rotate returns; if the file cannot be written nothing changes.
+func (s *APIKeyStore) Rotate(id string) (string, error) {
+	if s == nil {
+		return "", ErrAPIKeyNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var current *APIKey
+	for _, k := range s.byKey {
+		if k.ID != id {
+			continue
+		}
+		if current != nil {
+			return "", ErrAPIKeyAmbiguous
+		}
+		current = k
+	}
+	if current == nil {
+		return "", ErrAPIKeyNotFound
+	}
+
+	secret, err := newAPIKeySecret()
+	if err != nil {
+		return "", err
+	}
+	if err := rewriteAPIKeyFile(current.file, id, secret); err != nil {
+		return "", err
+	}
+	rotated := *current
+	rotated.Key = secret
+	delete(s.byKey, current.Key)
+	s.byKey[secret] = &rotated
+	return secret, nil
+}
+
+func newAPIKeySecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate api key: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// rewriteAPIKeyFile sets the key of entry id in path, keeping the rest of the
+// document (comments included) as it was. The new file is written beside the old
+// one and renamed over it so readers never see a partial file.
+func rewriteAPIKeyFile(path, id, secret string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read api keys file: %w", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("parse api keys file %s: %w", path, err)
+	}
+	if !setAPIKeyNode(&doc, id, secret) {
+		return fmt.Errorf("api key %q not found in %s", id, path)
+	}
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return fmt.Errorf("encode api keys file: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("encode api keys file: %w", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("stat api keys file: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".apikeys-*")
+	if err != nil {
+		return fmt.Errorf("write api keys file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(out.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write api keys file: %w", err)
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write api keys file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write api keys file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write api keys file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replace api keys file: %w", err)
+	}
+	return nil
+}
+
+func setAPIKeyNode(doc *yaml.Node, id, secret string) bool {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.SequenceNode {
+		return false
+	}
+	for _, entry := range doc.Content[0].Content {
+		if entry.Kind != yaml.MappingNode {
+			continue
+		}
+		var idNode, keyNode *yaml.Node
+		for i := 0; i+1 < len(entry.Content); i += 2 {
+			switch entry.Content[i].Value {
+			case "id":
+				idNode = entry.Content[i+1]
+			case "key":
+				keyNode = entry.Content[i+1]
+			}
+		}
+		if idNode != nil && keyNode != nil && strings.TrimSpace(idNode.Value) == id {
+			keyNode.Value = secret
+			keyNode.Tag = "!!str"
+			keyNode.Style = yaml.DoubleQuotedStyle
+			return true
+		}
+	}
+	return false
+}
@@ -1,9 +1,12 @@
 package httpapi
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadAPIKeysSuccess(t *testing.T) {
@@ -79,3 +82,164 @@ func TestLoadAPIKeysMissingFile(t *testing.T) {
 		t.Fatalf("expected error for missing file")
 	}
 }
+
+func TestLoadAPIKeysMultipleSources(t *testing.T) {
+	dir := t.TempDir()
+	teams := filepath.Join(dir, "teams")
+	if err := os.Mkdir(teams, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	files := map[string]string{
+		filepath.Join(teams, "news.yaml"):  "- {id: news, key: k-news, permissions: [can_search]}\n",
+		filepath.Join(teams, "sport.yml"):  "- {id: sport, key: k-sport, permissions: [can_upload]}\n",
+		filepath.Join(teams, "README.md"):  "not a key file\n",
+		filepath.Join(dir, "ops.yaml"):     "- {id: ops, key: k-ops, permissions: [can_admin]}\n",
+		filepath.Join(dir, "clash.yaml"):   "- {id: intruder, key: k-news, permissions: [can_admin]}\n",
+		filepath.Join(dir, "unrelated.md"): "",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+
+	store, err := LoadAPIKeys(teams + ", " + filepath.Join(dir, "ops.yaml"))
+	if err != nil {
+		t.Fatalf("expected success, got error: %v", err)
+	}
+	for _, key := range []string{"k-news", "k-sport", "k-ops"} {
+		if _, ok := store.Lookup(key); !ok {
+			t.Fatalf("expected to find %s", key)
+		}
+	}
+
+	_, err = LoadAPIKeys(teams + "," + filepath.Join(dir, "clash.yaml"))
+	if err == nil {
+		t.Fatalf("expected error for key reused across files")
+	}
+	if !strings.Contains(err.Error(), "news.yaml") || !strings.Contains(err.Error(), "clash.yaml") {
+		t.Fatalf("error should name both files, got %v", err)
+	}
+}
+
+func TestRotateAPIKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keys.yaml")
+	content := `# team keys
+- id: one
+  key: old-secret # leaked
+  permissions: [can_search]
+- id: two
+  key: other
+  permissions: [can_search]
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	store, err := LoadAPIKeys(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	secret, err := store.Rotate("one")
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if secret == "" || secret == "old-secret" {
+		t.Fatalf("unexpected secret %q", secret)
+	}
+	if _, ok := store.Lookup("old-secret"); ok {
+		t.Fatalf("old secret should stop working")
+	}
+	if k, ok := store.Lookup(secret); !ok || k.ID != "one" {
+		t.Fatalf("new secret should authenticate as one, got %v", k)
+	}
+
+	reloaded, err := LoadAPIKeys(path)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if _, ok := reloaded.Lookup(secret); !ok {
+		t.Fatalf("new secret should be persisted")
+	}
+	if _, ok := reloaded.Lookup("other"); !ok {
+		t.Fatalf("other keys should be untouched")
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "# team keys") {
+		t.Fatalf("comments should be preserved, got:\n%s", data)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Fatalf("file mode should be preserved, got %v", info.Mode())
+	}
+
+	if _, err := store.Rotate("missing"); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Fatalf("expected ErrAPIKeyNotFound, got %v", err)
+	}
+}
+
+func TestLoadAPIKeysValidityWindow(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keys.yaml")
+	yaml := `
+- id: contractor
+  key: secret1
+  permissions: [can_search]
+  notBefore: 2026-01-01T00:00:00Z
+  expiresAt: 2026-07-01T00:00:00Z
+- id: forever
+  key: secret2
+  permissions: [can_search]
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	store, err := LoadAPIKeys(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	key, _ := store.Lookup("secret1")
+	if key.NotBefore == nil || key.ExpiresAt == nil || !key.ExpiresAt.Equal(time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected validity window to be parsed, got %v %v", key.NotBefore, key.ExpiresAt)
+	}
+
+	cases := []struct {
+		name string
+		at   time.Time
+		want error
+	}{
+		{"not yet valid", time.Date(2025, 12, 31, 23, 59, 59, 0, time.UTC), ErrAPIKeyNotYet},
+		{"valid", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), nil},
+		{"expired", time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC), ErrAPIKeyExpired},
+	}
+	for _, tc := range cases {
+		if err := key.ValidAt(tc.at); !errors.Is(err, tc.want) {
+			t.Fatalf("%s: expected %v, got %v", tc.name, tc.want, err)
+		}
+	}
+
+	forever, _ := store.Lookup("secret2")
+	for _, at := range []time.Time{{}, time.Date(2999, 1, 1, 0, 0, 0, 0, time.UTC)} {
+		if err := forever.ValidAt(at); err != nil {
+			t.Fatalf("expected key without window to be valid at %v, got %v", at, err)
+		}
+	}
+}
+
+func TestLoadAPIKeysRejectsInvertedWindow(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keys.yaml")
+	yaml := `
+- id: backwards
+  key: secret
+  permissions: [can_search]
+  notBefore: 2026-07-01T00:00:00Z
+  expiresAt: 2026-01-01T00:00:00Z
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if _, err := LoadAPIKeys(path); err == nil || !strings.Contains(err.Error(), "backwards") {
+		t.Fatalf("expected inverted window error naming the key, got %v", err)
+	}
+}
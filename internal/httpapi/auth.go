@@ -13,6 +13,9 @@ const (
 	PermCanUpload = "can_upload"
 	PermCanUpdate = "can_update"
 	PermCanDelete = "can_delete"
+	PermCanAdmin  = "can_admin"
+	// PermCanViewDeleted reveals who deleted an asset and why.
+	PermCanViewDeleted = "can_view_deleted"
 )
 
 type Principal struct {
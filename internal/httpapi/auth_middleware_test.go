@@ -3,7 +3,11 @@ package httpapi
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
 
 	"github.com/arawak/ganache/internal/config"
 )
@@ -126,3 +130,151 @@ func TestRequirePermissionsIgnoredWhenAuthNone(t *testing.T) {
 		t.Fatalf("expected 200, got %d", rec.Code)
 	}
 }
+
+func TestViewerAndRequestPrincipal(t *testing.T) {
+	keys := &APIKeyStore{byKey: map[string]*APIKey{
+		"reader": {ID: "reader", Permissions: []string{PermCanSearch}},
+		"admin":  {ID: "admin", Permissions: []string{PermCanSearch, PermCanAdmin}},
+	}}
+	s := &Server{cfg: &config.Config{AuthMode: config.AuthAPIKey}, apiKeys: keys}
+
+	req := httptest.NewRequest(http.MethodGet, "/media/1/original", nil)
+	if v := s.viewer(req); v == nil || *v != "" {
+		t.Fatalf("anonymous request should only see public assets, got %v", v)
+	}
+	if _, ok := s.requestPrincipal(req); ok {
+		t.Fatalf("expected no principal without a key")
+	}
+
+	req.Header.Set("X-Api-Key", "reader")
+	principal, ok := s.requestPrincipal(req)
+	if !ok || principal.ID != "reader" {
+		t.Fatalf("expected reader principal from header, got %+v", principal)
+	}
+	ctxReq := req.WithContext(WithPrincipal(req.Context(), principal))
+	if v := s.viewer(ctxReq); v == nil || *v != "reader" {
+		t.Fatalf("expected viewer reader, got %v", v)
+	}
+
+	admin, _ := s.requestPrincipal(withKey(req, "admin"))
+	if v := s.viewer(req.WithContext(WithPrincipal(req.Context(), admin))); v != nil {
+		t.Fatalf("admins should not be filtered, got %q", *v)
+	}
+
+	open := &Server{cfg: &config.Config{AuthMode: config.AuthNone}}
+	if v := open.viewer(req); v != nil {
+		t.Fatalf("auth none should not filter, got %q", *v)
+	}
+}
+
+func withKey(r *http.Request, key string) *http.Request {
+	clone := r.Clone(r.Context())
+	clone.Header.Set("X-Api-Key", key)
+	return clone
+}
+
+func TestAuthMiddlewareValidityWindow(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	store := &APIKeyStore{byKey: map[string]*APIKey{
+		"expired": {ID: "expired", Permissions: []string{PermCanSearch}, ExpiresAt: &past},
+		"pending": {ID: "pending", Permissions: []string{PermCanSearch}, NotBefore: &future},
+		"current": {ID: "current", Permissions: []string{PermCanSearch}, NotBefore: &past, ExpiresAt: &future},
+		"forever": {ID: "forever", Permissions: []string{PermCanSearch}},
+	}}
+	s := &Server{cfg: &config.Config{AuthMode: config.AuthAPIKey}, apiKeys: store}
+	h := s.authMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	cases := []struct {
+		key     string
+		status  int
+		message string
+	}{
+		{"expired", http.StatusUnauthorized, "api key has expired"},
+		{"pending", http.StatusUnauthorized, "api key is not valid yet"},
+		{"current", http.StatusOK, ""},
+		{"forever", http.StatusOK, ""},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Api-Key", tc.key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Fatalf("%s: expected %d, got %d", tc.key, tc.status, rec.Code)
+		}
+		if tc.message != "" && !strings.Contains(rec.Body.String(), tc.message) {
+			t.Fatalf("%s: expected message %q, got %s", tc.key, tc.message, rec.Body.String())
+		}
+	}
+}
+
+func TestForVariantsGuardsOnlyOriginal(t *testing.T) {
+	store := &APIKeyStore{byKey: map[string]*APIKey{
+		"reader":   {ID: "reader", Permissions: []string{PermCanSearch}},
+		"uploader": {ID: "uploader", Permissions: []string{PermCanUpload}},
+	}}
+	s := &Server{cfg: &config.Config{AuthMode: config.AuthAPIKey}, apiKeys: store}
+	r := chi.NewRouter()
+	r.With(forVariants([]string{string(GetMediaVariantParamsVariantOriginal)}, s.authMiddleware(), s.requirePermissions(PermCanSearch))).
+		Get("/media/{id}/{variant}", func(w http.ResponseWriter, r *http.Request) {})
+
+	cases := []struct {
+		path   string
+		key    string
+		status int
+	}{
+		{"/media/1/thumb", "", http.StatusOK},
+		{"/media/1/content", "", http.StatusOK},
+		{"/media/1/original", "", http.StatusUnauthorized},
+		{"/media/1/original", "uploader", http.StatusForbidden},
+		{"/media/1/original", "reader", http.StatusOK},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.key != "" {
+			req.Header.Set("X-Api-Key", tc.key)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Fatalf("%s with key %q: expected %d, got %d", tc.path, tc.key, tc.status, rec.Code)
+		}
+	}
+}
+
+func TestAuthMiddlewareMultipleModes(t *testing.T) {
+	store := &APIKeyStore{byKey: map[string]*APIKey{
+		"secret": {ID: "svc", Permissions: []string{PermCanSearch}},
+	}}
+	s := &Server{cfg: &config.Config{AuthMode: "apikey,oidc"}, apiKeys: store}
+	h := s.authMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := PrincipalFromContext(r.Context()); !ok || p.ID != "svc" {
+			t.Errorf("expected principal svc, got %+v", p)
+		}
+	}))
+
+	cases := []struct {
+		name    string
+		headers map[string]string
+		expect  int
+	}{
+		{"api key", map[string]string{"X-Api-Key": "secret"}, http.StatusOK},
+		{"api key wins over bearer", map[string]string{"X-Api-Key": "secret", "Authorization": "Bearer token"}, http.StatusOK},
+		{"nothing sent", nil, http.StatusUnauthorized},
+		{"bad api key", map[string]string{"X-Api-Key": "bad"}, http.StatusUnauthorized},
+		// OIDC is not implemented, so a bearer token reaches it and gets 501.
+		{"bearer", map[string]string{"Authorization": "Bearer token"}, http.StatusNotImplemented},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range c.headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != c.expect {
+			t.Fatalf("%s: expected %d, got %d: %s", c.name, c.expect, rec.Code, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package httpapi
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/arawak/ganache/internal/media"
+	"github.com/arawak/ganache/internal/store"
+)
+
+// maxImportEntries caps the files in one archive so a crafted ZIP cannot keep a
+// request busy indefinitely.
+const maxImportEntries = 10000
+
+// maxImportManifestBytes caps the manifest, which is parsed in memory, so a small
+// entry that inflates to gigabytes cannot exhaust it.
+const maxImportManifestBytes = 16 << 20
+
+// importManifestNames are the manifest files looked for at the root of an archive.
+var importManifestNames = []string{"manifest.json", "manifest.csv"}
+
+// importMetadata is one manifest row: the metadata for the archive entry at File.
+type importMetadata struct {
+	File       string   `json:"file"`
+	Title      string   `json:"title"`
+	Caption    string   `json:"caption"`
+	Credit     string   `json:"credit"`
+	Source     string   `json:"source"`
+	UsageNotes string   `json:"usageNotes"`
+	Tags       []string `json:"tags"`
+}
+
+// ImportAssets creates an asset for every image in a ZIP archive, taking metadata
+// from its manifest. The archive is spooled to a temp file and its entries are
+// streamed through media.Save one at a time, so memory use does not grow with the
+// archive. Entries that fail are reported individually instead of failing the import.
+// An import the request timeout cuts short reports the entries it got to, marked
+// incomplete.
+func (s *Server) ImportAssets(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxImportBytes)
+	tmp, err := os.CreateTemp("", "ganache-import-*.zip")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal", "failed to buffer archive", map[string]any{"error": err.Error()})
+		return
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	size, err := io.Copy(tmp, r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("archive exceeds maximum size of %d bytes", s.cfg.MaxImportBytes), nil)
+			return
+		}
+		writeError(w, http.StatusBadRequest, "bad_request", "failed to read archive", map[string]any{"error": err.Error()})
+		return
+	}
+
+	archive, err := zip.NewReader(tmp, size)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid zip archive", map[string]any{"error": err.Error()})
+		return
+	}
+	if len(archive.File) > maxImportEntries {
+		writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("archive has more than %d entries", maxImportEntries), nil)
+		return
+	}
+	manifestName, manifest, err := readImportManifest(archive)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error(), map[string]any{"manifest": manifestName})
+		return
+	}
+
+	resp := ImportResponse{Results: make([]ImportResult, 0, len(archive.File))}
+	seen := make(map[string]bool, len(archive.File))
+	viewer := s.viewer(r)
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() || f.Name == manifestName {
+			continue
+		}
+		if r.Context().Err() != nil {
+			// The assets created so far stay; the caller needs to know which they are
+			// to resume with the rest.
+			incomplete := true
+			resp.Incomplete = &incomplete
+			writeJSON(w, http.StatusOK, resp)
+			return
+		}
+		seen[f.Name] = true
+		resp.Results = append(resp.Results, s.importFile(r.Context(), f, manifest[f.Name], viewer))
+	}
+
+	var missing []string
+	for name := range manifest {
+		if !seen[name] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		resp.Results = append(resp.Results, importFailure(name, Missing, "listed in the manifest but not in the archive"))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// importFile stores one archive entry and creates its asset. Entries that are not
+// images are skipped; anything else that goes wrong is reported as failed. A
+// duplicate of an asset viewer cannot see is reported without its id.
+func (s *Server) importFile(ctx context.Context, f *zip.File, meta importMetadata, viewer *string) ImportResult {
+	if msg := fieldLengthError(meta.Title, meta.Credit, meta.Source, meta.Tags); msg != "" {
+		return importFailure(f.Name, Failed, msg)
+	}
+	if missing := s.missingRequiredFields(meta.Title, meta.Credit); len(missing) > 0 {
+		return importFailure(f.Name, Failed, strings.Join(missing, " and ")+" required")
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return importFailure(f.Name, Failed, err.Error())
+	}
+	defer rc.Close()
+
+	filename, displayName := sanitizeFilename(path.Base(f.Name), s.cfg.FilenameMaxLen)
+	save, discard, err := s.media.Save(ctx, rc, filename, s.cfg.MaxUploadBytes, s.cfg.MaxPixels, "")
+	if err != nil {
+		if errors.Is(err, media.ErrInvalidImage) || errors.Is(err, media.ErrEmptyUpload) {
+			return importFailure(f.Name, Skipped, "not an image")
+		}
+		return importFailure(f.Name, Failed, err.Error())
+	}
+
+	if filename == "" {
+		filename = save.SHA256 + save.Ext
+	}
+	asset, err := s.store.CreateAsset(ctx, store.AssetCreate{
+		Title:            meta.Title,
+		Caption:          meta.Caption,
+		Credit:           meta.Credit,
+		Source:           meta.Source,
+		UsageNotes:       meta.UsageNotes,
+		Tags:             meta.Tags,
+		Width:            save.Width,
+		Height:           save.Height,
+		Bytes:            save.Bytes,
+		Mime:             save.Mime,
+		OriginalFilename: filename,
+		DisplayFilename:  displayName,
+		SHA256:           save.SHA256,
+		HashAlgo:         save.HashAlgo,
+		Preview:          save.Preview,
+		HasAlpha:         save.HasAlpha,
+		ColorSpace:       save.ColorSpace,
+		VariantState:     savedVariantState(save),
+	})
+	if err != nil {
+		s.discardSave(ctx, save.SHA256, discard)
+		if errors.Is(err, store.ErrDuplicate) && asset != nil {
+			if viewer != nil && !asset.CanView(*viewer) {
+				return ImportResult{File: f.Name, Status: Duplicate}
+			}
+			return ImportResult{File: f.Name, Status: Duplicate, AssetId: &asset.ID}
+		}
+		return importFailure(f.Name, Failed, err.Error())
+	}
+	s.settleVariantState(ctx, asset)
+	return ImportResult{File: f.Name, Status: Created, AssetId: &asset.ID}
+}
+
+func importFailure(file string, status ImportResultStatus, msg string) ImportResult {
+	return ImportResult{File: file, Status: status, Error: &msg}
+}
+
+// readImportManifest finds and parses the archive's manifest, keyed by entry path.
+// An archive without one imports every image with empty metadata.
+func readImportManifest(archive *zip.Reader) (string, map[string]importMetadata, error) {
+	for _, name := range importManifestNames {
+		for _, f := range archive.File {
+			if f.Name != name {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return name, nil, fmt.Errorf("open %s: %w", name, err)
+			}
+			defer rc.Close()
+			data, err := io.ReadAll(io.LimitReader(rc, maxImportManifestBytes+1))
+			if err != nil {
+				return name, nil, fmt.Errorf("read %s: %w", name, err)
+			}
+			if len(data) > maxImportManifestBytes {
+				return name, nil, fmt.Errorf("%s exceeds maximum size of %d bytes", name, maxImportManifestBytes)
+			}
+			var rows []importMetadata
+			if path.Ext(name) == ".csv" {
+				rows, err = parseCSVManifest(bytes.NewReader(data))
+			} else {
+				rows, err = parseJSONManifest(bytes.NewReader(data))
+			}
+			if err != nil {
+				return name, nil, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			manifest := make(map[string]importMetadata, len(rows))
+			for i, row := range rows {
+				row.File = strings.TrimPrefix(strings.TrimSpace(row.File), "./")
+				if row.File == "" {
+					return name, nil, fmt.Errorf("invalid %s: entry %d has no file", name, i+1)
+				}
+				if _, dup := manifest[row.File]; dup {
+					return name, nil, fmt.Errorf("invalid %s: file %q is listed twice", name, row.File)
+				}
+				manifest[row.File] = row
+			}
+			return name, manifest, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// parseJSONManifest reads an array of objects with the importMetadata fields.
+func parseJSONManifest(r io.Reader) ([]importMetadata, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var rows []importMetadata
+	if err := dec.Decode(&rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// parseCSVManifest reads a CSV whose header names the importMetadata fields; file is
+// required and tags are separated by semicolons.
+func parseCSVManifest(r io.Reader) ([]importMetadata, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "file", "title", "caption", "credit", "source", "usageNotes", "tags":
+		default:
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["file"]; !ok {
+		return nil, fmt.Errorf("missing file column")
+	}
+
+	var rows []importMetadata
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		get := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		var tags []string
+		if raw := get("tags"); raw != "" {
+			tags = splitTags(raw)
+		}
+		rows = append(rows, importMetadata{
+			File:       get("file"),
+			Title:      get("title"),
+			Caption:    get("caption"),
+			Credit:     get("credit"),
+			Source:     get("source"),
+			UsageNotes: get("usageNotes"),
+			Tags:       tags,
+		})
+	}
+}
+
+func splitTags(raw string) []string {
+	var tags []string
+	for _, tag := range strings.Split(raw, ";") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
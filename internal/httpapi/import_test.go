@@ -0,0 +1,130 @@
+package httpapi
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/arawak/ganache/internal/config"
+	"github.com/arawak/ganache/internal/media"
+)
+
+func TestParseImportManifests(t *testing.T) {
+	jsonRows, err := parseJSONManifest(strings.NewReader(`[{"file": "a.jpg", "title": "A", "tags": ["x", "y"]}]`))
+	if err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	csvRows, err := parseCSVManifest(strings.NewReader("file,title,tags\na.jpg,A, x ; y ;\n"))
+	if err != nil {
+		t.Fatalf("csv: %v", err)
+	}
+	for name, rows := range map[string][]importMetadata{"json": jsonRows, "csv": csvRows} {
+		if len(rows) != 1 || rows[0].File != "a.jpg" || rows[0].Title != "A" || strings.Join(rows[0].Tags, ",") != "x,y" {
+			t.Fatalf("%s: unexpected rows %+v", name, rows)
+		}
+	}
+
+	if _, err := parseJSONManifest(strings.NewReader(`[{"file": "a.jpg", "titel": "A"}]`)); err == nil {
+		t.Fatalf("expected unknown json field to be rejected")
+	}
+	if _, err := parseCSVManifest(strings.NewReader("title\nA\n")); err == nil {
+		t.Fatalf("expected csv without a file column to be rejected")
+	}
+	if _, err := parseCSVManifest(strings.NewReader("file,titel\na.jpg,A\n")); err == nil {
+		t.Fatalf("expected unknown csv column to be rejected")
+	}
+}
+
+// importArchive zips files, keyed by entry path.
+func importArchive(t *testing.T, files map[string]string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range files {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		if _, err := f.Write([]byte(body)); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+	return &buf
+}
+
+func TestImportAssetsReportsSkippedAndMissing(t *testing.T) {
+	buf := importArchive(t, map[string]string{
+		"manifest.json": `[{"file": "notes.txt", "title": "Notes"}, {"file": "gone.jpg"}]`,
+		"notes.txt":     "not an image",
+	})
+
+	s := &Server{
+		cfg:   &config.Config{MaxImportBytes: 1 << 20, MaxUploadBytes: 1 << 20, MaxPixels: 1000},
+		media: media.NewManager(t.TempDir(), media.Options{}),
+	}
+	rec := httptest.NewRecorder()
+	s.ImportAssets(rec, httptest.NewRequest(http.MethodPost, "/api/assets/import", buf))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp ImportResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp.Results) != 2 {
+		t.Fatalf("expected 2 results, got %+v", resp.Results)
+	}
+	if r := resp.Results[0]; r.File != "notes.txt" || r.Status != Skipped {
+		t.Fatalf("expected notes.txt to be skipped, got %+v", r)
+	}
+	if r := resp.Results[1]; r.File != "gone.jpg" || r.Status != Missing {
+		t.Fatalf("expected gone.jpg to be missing, got %+v", r)
+	}
+}
+
+func TestImportAssetsRejectsInvalidArchive(t *testing.T) {
+	s := &Server{cfg: &config.Config{MaxImportBytes: 1 << 20}}
+	rec := httptest.NewRecorder()
+	s.ImportAssets(rec, httptest.NewRequest(http.MethodPost, "/api/assets/import", strings.NewReader("not a zip")))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+
+	// A manifest that inflates past the cap is refused before it is parsed.
+	rec = httptest.NewRecorder()
+	huge := importArchive(t, map[string]string{"manifest.json": "[" + strings.Repeat(" ", maxImportManifestBytes) + "]"})
+	s.ImportAssets(rec, httptest.NewRequest(http.MethodPost, "/api/assets/import", huge))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "exceeds maximum size") {
+		t.Fatalf("expected 400 for an oversized manifest, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestImportAssetsReportsPartialResultsOnTimeout(t *testing.T) {
+	buf := importArchive(t, map[string]string{
+		"manifest.json": `[{"file": "gone.jpg"}]`,
+		"notes.txt":     "not an image",
+	})
+	s := &Server{
+		cfg:   &config.Config{MaxImportBytes: 1 << 20, MaxUploadBytes: 1 << 20, MaxPixels: 1000},
+		media: media.NewManager(t.TempDir(), media.Options{}),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := httptest.NewRecorder()
+	s.ImportAssets(rec, httptest.NewRequest(http.MethodPost, "/api/assets/import", buf).WithContext(ctx))
+	var resp ImportResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if rec.Code != http.StatusOK || resp.Incomplete == nil || !*resp.Incomplete || len(resp.Results) != 0 {
+		t.Fatalf("expected an incomplete report without missing rows, got %d %+v", rec.Code, resp)
+	}
+}
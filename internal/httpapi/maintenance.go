@@ -0,0 +1,93 @@
+package httpapi
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maintenanceRetryAfter is advertised to clients whose writes are refused.
+const maintenanceRetryAfter = 60 * time.Second
+
+// Maintenance is the read-only switch: while it is on, mutating API requests get 503
+// and reads keep working. It is safe to flip from a signal handler.
+type Maintenance struct {
+	on     atomic.Bool
+	logger *slog.Logger
+}
+
+func NewMaintenance(enabled bool, logger *slog.Logger) *Maintenance {
+	m := &Maintenance{logger: logger}
+	m.on.Store(enabled)
+	if enabled && logger != nil {
+		logger.Warn("maintenance mode entered; writes are rejected")
+	}
+	return m
+}
+
+func (m *Maintenance) Enabled() bool {
+	return m != nil && m.on.Load()
+}
+
+// Set switches maintenance mode and logs the transition.
+func (m *Maintenance) Set(enabled bool) {
+	if m.on.Swap(enabled) == enabled || m.logger == nil {
+		return
+	}
+	if enabled {
+		m.logger.Warn("maintenance mode entered; writes are rejected")
+	} else {
+		m.logger.Info("maintenance mode exited; writes are accepted")
+	}
+}
+
+// Toggle flips maintenance mode.
+func (m *Maintenance) Toggle() {
+	m.Set(!m.Enabled())
+}
+
+// middleware rejects writes under /api/ while maintenance mode is on. Admin routes
+// stay available so keys can still be rotated during maintenance, and so do the
+// readOnlySafe routes, which only read.
+func (m *Maintenance) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.Enabled() && isWrite(r.Method) && strings.HasPrefix(r.URL.Path, "/api/") && !strings.HasPrefix(r.URL.Path, "/api/admin/") && !readOnlySafe[r.Method+" "+r.URL.Path] {
+			w.Header().Set("Retry-After", strconv.Itoa(int(maintenanceRetryAfter.Seconds())))
+			writeError(w, http.StatusServiceUnavailable, "maintenance", "service is in maintenance mode; writes are temporarily disabled", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readOnlySafe lists routes that use a write method without changing anything, so
+// neither GANACHE_READ_ONLY nor maintenance mode refuses them.
+var readOnlySafe = map[string]bool{
+	"POST /api/tags/normalize":   true,
+	"POST /api/assets/batch-get": true,
+}
+
+// refusedReadOnly reports whether GANACHE_READ_ONLY shuts route, given as
+// "METHOD /pattern", off.
+func (s *Server) refusedReadOnly(route string) bool {
+	method, _, _ := strings.Cut(route, " ")
+	return s.cfg.ReadOnly && isWrite(method) && !readOnlySafe[route]
+}
+
+// serveReadOnly stands in for every refused route: read-only is a permanent posture,
+// so unlike maintenance mode it answers 405 without Retry-After, before any auth.
+func (s *Server) serveReadOnly(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", strings.Join(s.allowedMethods(r), ", "))
+	writeError(w, http.StatusMethodNotAllowed, "read_only", "this deployment is read-only; changes are not accepted", nil)
+}
+
+func isWrite(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
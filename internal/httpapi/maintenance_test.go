@@ -0,0 +1,52 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMaintenanceRejectsWrites(t *testing.T) {
+	m := NewMaintenance(false, nil)
+	h := m.middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	if rec := serve(http.MethodPost, "/api/assets"); rec.Code != http.StatusOK {
+		t.Fatalf("expected writes to pass outside maintenance, got %d", rec.Code)
+	}
+
+	m.Toggle()
+	for _, tc := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodPost, "/api/assets", http.StatusServiceUnavailable},
+		{http.MethodPatch, "/api/assets/1", http.StatusServiceUnavailable},
+		{http.MethodDelete, "/api/assets/1", http.StatusServiceUnavailable},
+		{http.MethodPut, "/api/assets/1/immutable", http.StatusServiceUnavailable},
+		{http.MethodGet, "/api/assets", http.StatusOK},
+		{http.MethodGet, "/media/1/thumb", http.StatusOK},
+		{http.MethodPost, "/api/admin/keys/k/rotate", http.StatusOK},
+		{http.MethodPost, "/api/tags/normalize", http.StatusOK},
+		{http.MethodPost, "/api/assets/batch-get", http.StatusOK},
+	} {
+		rec := serve(tc.method, tc.path)
+		if rec.Code != tc.want {
+			t.Fatalf("%s %s: expected %d, got %d", tc.method, tc.path, tc.want, rec.Code)
+		}
+		if tc.want == http.StatusServiceUnavailable && rec.Header().Get("Retry-After") != "60" {
+			t.Fatalf("%s %s: expected Retry-After 60, got %q", tc.method, tc.path, rec.Header().Get("Retry-After"))
+		}
+	}
+
+	m.Toggle()
+	if rec := serve(http.MethodDelete, "/api/assets/1"); rec.Code != http.StatusOK {
+		t.Fatalf("expected writes to pass after leaving maintenance, got %d", rec.Code)
+	}
+}
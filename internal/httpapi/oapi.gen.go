@@ -18,11 +18,28 @@ const (
 	BearerAuthScopes = "bearerAuth.Scopes"
 )
 
+// Defines values for BulkDeleteResultStatus.
+const (
+	AlreadyDeleted BulkDeleteResultStatus = "already_deleted"
+	Deleted        BulkDeleteResultStatus = "deleted"
+	Immutable      BulkDeleteResultStatus = "immutable"
+	NotFound       BulkDeleteResultStatus = "not_found"
+)
+
 // Defines values for HealthStatus.
 const (
 	Ok HealthStatus = "ok"
 )
 
+// Defines values for ImportResultStatus.
+const (
+	Created   ImportResultStatus = "created"
+	Duplicate ImportResultStatus = "duplicate"
+	Failed    ImportResultStatus = "failed"
+	Missing   ImportResultStatus = "missing"
+	Skipped   ImportResultStatus = "skipped"
+)
+
 // Defines values for MediaVariant.
 const (
 	MediaVariantContent  MediaVariant = "content"
@@ -30,6 +47,12 @@ const (
 	MediaVariantThumb    MediaVariant = "thumb"
 )
 
+// Defines values for ReprocessSweepState.
+const (
+	Done    ReprocessSweepState = "done"
+	Running ReprocessSweepState = "running"
+)
+
 // Defines values for Sort.
 const (
 	SortNewest    Sort = "newest"
@@ -37,6 +60,52 @@ const (
 	SortRelevance Sort = "relevance"
 )
 
+// Defines values for VariantState.
+const (
+	VariantStateMissing VariantState = "missing"
+	VariantStatePending VariantState = "pending"
+	VariantStateReady   VariantState = "ready"
+)
+
+// Defines values for Visibility.
+const (
+	Private Visibility = "private"
+	Public  Visibility = "public"
+)
+
+// Defines values for AssetFacetsResponseField.
+const (
+	AssetFacetsResponseFieldCreatedAt AssetFacetsResponseField = "created_at"
+	AssetFacetsResponseFieldMime      AssetFacetsResponseField = "mime"
+)
+
+// Defines values for AssetFacetsResponseInterval.
+const (
+	AssetFacetsResponseIntervalDay   AssetFacetsResponseInterval = "day"
+	AssetFacetsResponseIntervalMonth AssetFacetsResponseInterval = "month"
+	AssetFacetsResponseIntervalYear  AssetFacetsResponseInterval = "year"
+)
+
+// Defines values for AssetOrientation.
+const (
+	Landscape AssetOrientation = "landscape"
+	Portrait  AssetOrientation = "portrait"
+	Square    AssetOrientation = "square"
+)
+
+// Defines values for GetAssetFacetsParamsField.
+const (
+	GetAssetFacetsParamsFieldCreatedAt GetAssetFacetsParamsField = "created_at"
+	GetAssetFacetsParamsFieldMime      GetAssetFacetsParamsField = "mime"
+)
+
+// Defines values for GetAssetFacetsParamsInterval.
+const (
+	GetAssetFacetsParamsIntervalDay   GetAssetFacetsParamsInterval = "day"
+	GetAssetFacetsParamsIntervalMonth GetAssetFacetsParamsInterval = "month"
+	GetAssetFacetsParamsIntervalYear  GetAssetFacetsParamsInterval = "year"
+)
+
 // Defines values for SearchAssetsParamsSort.
 const (
 	SearchAssetsParamsSortNewest    SearchAssetsParamsSort = "newest"
@@ -44,6 +113,12 @@ const (
 	SearchAssetsParamsSortRelevance SearchAssetsParamsSort = "relevance"
 )
 
+// Defines values for UploadAssetParamsOnDuplicate.
+const (
+	UploadAssetParamsOnDuplicateConflict UploadAssetParamsOnDuplicate = "conflict"
+	UploadAssetParamsOnDuplicateOk       UploadAssetParamsOnDuplicate = "ok"
+)
+
 // Defines values for GetMediaVariantParamsVariant.
 const (
 	GetMediaVariantParamsVariantContent  GetMediaVariantParamsVariant = "content"
@@ -51,27 +126,131 @@ const (
 	GetMediaVariantParamsVariantThumb    GetMediaVariantParamsVariant = "thumb"
 )
 
+// ApiKeyRotation A freshly generated API key secret. It is returned only once.
+type ApiKeyRotation struct {
+	Id  string `json:"id"`
+	Key string `json:"key"`
+}
+
 // Asset defines model for Asset.
 type Asset struct {
-	Bytes            int64      `json:"bytes"`
-	Caption          string     `json:"caption"`
-	CreatedAt        time.Time  `json:"createdAt"`
-	Credit           string     `json:"credit"`
-	DeletedAt        *time.Time `json:"deletedAt"`
-	Height           int        `json:"height"`
-	Id               int64      `json:"id"`
-	Mime             string     `json:"mime"`
-	OriginalFilename *string    `json:"originalFilename,omitempty"`
-
-	// Sha256 Hex-encoded SHA-256 of the original bytes (optional to expose).
-	Sha256     *string          `json:"sha256,omitempty"`
-	Source     string           `json:"source"`
-	Tags       []string         `json:"tags"`
-	Title      string           `json:"title"`
-	UpdatedAt  time.Time        `json:"updatedAt"`
-	UsageNotes string           `json:"usageNotes"`
-	Variants   AssetVariantUrls `json:"variants"`
-	Width      int              `json:"width"`
+	// AllowedPrincipals Principals allowed to see a private asset. Omitted for public assets.
+	AllowedPrincipals *[]string `json:"allowedPrincipals,omitempty"`
+
+	// AspectRatio width divided by height, rounded to 4 decimal places, e.g. 1.5 for 3:2 landscape. Both are the dimensions of the image as displayed, i.e. after the original's EXIF orientation, which is also how the variants are served.
+	AspectRatio float64 `json:"aspectRatio"`
+
+	// Bytes Size of the original. An integer by default; a decimal string, e.g. "1048576", when GANACHE_BIGINT_AS_STRING is set.
+	Bytes   int64  `json:"bytes"`
+	Caption string `json:"caption"`
+
+	// ColorSpace Color space named by the original's embedded ICC profile. Variants are converted from it to sRGB; the original keeps its profile. Absent when the original has no profile, in which case it is treated as sRGB, and for assets stored before this was recorded.
+	ColorSpace *string    `json:"colorSpace,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	Credit     string     `json:"credit"`
+	DeletedAt  *time.Time `json:"deletedAt"`
+
+	// DeletedBy Principal that soft-deleted the asset. Only shown to principals with can_view_deleted or can_admin.
+	DeletedBy *string `json:"deletedBy,omitempty"`
+
+	// DeletionReason Reason given when the asset was soft-deleted. Only shown to principals with can_view_deleted or can_admin.
+	DeletionReason *string `json:"deletionReason,omitempty"`
+
+	// DisplayFilename The uploaded filename for display, without directories, control or bidi characters. originalFilename is the stricter form used in headers.
+	DisplayFilename *string `json:"displayFilename,omitempty"`
+
+	// HasAlpha Whether the original has transparent pixels. Absent for assets stored before this was recorded.
+	HasAlpha *bool `json:"hasAlpha,omitempty"`
+
+	// HashAlgo Algorithm the sha256 field was computed with: sha256, or sha512 for assets uploaded with GANACHE_HASH_ALGO=sha512.
+	HashAlgo *string `json:"hashAlgo,omitempty"`
+	Height   int     `json:"height"`
+	Id       int64   `json:"id"`
+
+	// Immutable Frozen assets reject metadata edits and deletes until an admin clears the flag.
+	Immutable bool   `json:"immutable"`
+	Mime      string `json:"mime"`
+
+	// Orientation Whether width is smaller than, larger than, or equal to height.
+	Orientation AssetOrientation `json:"orientation"`
+
+	// OriginalFilename The uploaded filename made safe for storage and headers: no directories, control characters, quotes, or leading dots, and at most GANACHE_FILENAME_MAX_LENGTH bytes.
+	OriginalFilename *string `json:"originalFilename,omitempty"`
+
+	// Preview Tiny WebP placeholder as a data URI, for showing before the thumb loads. At most GANACHE_PREVIEW_MAX_WIDTH pixels wide and tall; omitted unless it was set when the asset was created.
+	Preview *string `json:"preview,omitempty"`
+
+	// Relevance Full-text match score of the search query `q`, higher is a closer match. Null when no text query was given, outside search results, and for sorts other than relevance when GANACHE_RELEVANCE_SORTED_ONLY is set.
+	Relevance *float64 `json:"relevance"`
+
+	// Sha256 Hex-encoded content hash of the original bytes under hashAlgo; the name predates other algorithms.
+	Sha256     *string   `json:"sha256,omitempty"`
+	Source     string    `json:"source"`
+	Tags       []string  `json:"tags"`
+	Title      string    `json:"title"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	UsageNotes string    `json:"usageNotes"`
+
+	// VariantStatus Whether each variant's file is available.
+	VariantStatus AssetVariantStatus `json:"variantStatus"`
+
+	// Variants Always present except in search results requested with includeVariants=false.
+	Variants *AssetVariantUrls `json:"variants,omitempty"`
+
+	// VariantsReady False while derivatives are still queued for background generation; requesting a variant in the meantime generates it on demand.
+	VariantsReady bool       `json:"variantsReady"`
+	Visibility    Visibility `json:"visibility"`
+	Width         int        `json:"width"`
+}
+
+// AssetOrientation Whether width is smaller than, larger than, or equal to height.
+type AssetOrientation string
+
+// AssetCountResponse defines model for AssetCountResponse.
+type AssetCountResponse struct {
+	Total int `json:"total"`
+}
+
+// AssetFacetsResponse defines model for AssetFacetsResponse.
+type AssetFacetsResponse struct {
+	// Buckets Oldest first for created_at; most common first for mime.
+	Buckets []FacetBucket            `json:"buckets"`
+	Field   AssetFacetsResponseField `json:"field"`
+
+	// Interval Present for field=created_at.
+	Interval *AssetFacetsResponseInterval `json:"interval,omitempty"`
+}
+
+// AssetFacetsResponseField defines model for AssetFacetsResponse.Field.
+type AssetFacetsResponseField string
+
+// AssetFacetsResponseInterval Present for field=created_at.
+type AssetFacetsResponseInterval string
+
+// AssetExif EXIF tags of the original keyed by tag name; empty when the file carries none.
+type AssetExif map[string]interface{}
+
+// AssetImmutability defines model for AssetImmutability.
+type AssetImmutability struct {
+	Immutable bool `json:"immutable"`
+}
+
+// AssetReference defines model for AssetReference.
+type AssetReference struct {
+	AllowedPrincipals *[]string `json:"allowedPrincipals,omitempty"`
+	Caption           *string   `json:"caption,omitempty"`
+	Credit            *string   `json:"credit,omitempty"`
+
+	// OriginalFilename Defaults to the SHA-256 with the stored file's extension.
+	OriginalFilename *string `json:"originalFilename,omitempty"`
+
+	// Sha256 Hex-encoded content hash (64 characters for SHA-256, 128 for SHA-512) of content already stored by an earlier upload.
+	Sha256     string      `json:"sha256"`
+	Source     *string     `json:"source,omitempty"`
+	Tags       *[]string   `json:"tags,omitempty"`
+	Title      *string     `json:"title,omitempty"`
+	UsageNotes *string     `json:"usageNotes,omitempty"`
+	Visibility *Visibility `json:"visibility,omitempty"`
 }
 
 // AssetSearchResponse defines model for AssetSearchResponse.
@@ -80,16 +259,42 @@ type AssetSearchResponse struct {
 	Page     int     `json:"page"`
 	PageSize int     `json:"pageSize"`
 	Total    int     `json:"total"`
+
+	// UnknownTags Requested tags (normalized) that are not in the tag catalog. Present only when the search returned nothing because of them.
+	UnknownTags *[]string `json:"unknownTags,omitempty"`
 }
 
-// AssetUpdate defines model for AssetUpdate.
+// AssetUpdate JSON Merge Patch (RFC 7386): omitted fields are unchanged, `null` clears a field, and any other value replaces it.
 type AssetUpdate struct {
-	Caption    *string   `json:"caption,omitempty"`
-	Credit     *string   `json:"credit,omitempty"`
-	Source     *string   `json:"source,omitempty"`
-	Tags       *[]string `json:"tags,omitempty"`
-	Title      *string   `json:"title,omitempty"`
-	UsageNotes *string   `json:"usageNotes,omitempty"`
+	// AddTags Tags to add to the current set. Cannot be combined with `tags`.
+	AddTags *[]string `json:"addTags,omitempty"`
+
+	// AllowedPrincipals Replaces the access list of a private asset.
+	AllowedPrincipals *[]string `json:"allowedPrincipals,omitempty"`
+	Caption           *string   `json:"caption"`
+	Credit            *string   `json:"credit"`
+
+	// RemoveTags Tags to remove from the current set. Cannot be combined with `tags`.
+	RemoveTags *[]string `json:"removeTags,omitempty"`
+	Source     *string   `json:"source"`
+
+	// Tags Replaces the full tag set; `null` or `[]` removes all tags.
+	Tags       *[]string   `json:"tags"`
+	Title      *string     `json:"title"`
+	UsageNotes *string     `json:"usageNotes"`
+	Visibility *Visibility `json:"visibility,omitempty"`
+}
+
+// AssetVariantStatus Whether each variant's file is available.
+type AssetVariantStatus struct {
+	// Content ready once generated; pending while queued for background generation; missing when generation failed. Requesting a pending or missing variant retries it on demand.
+	Content VariantState `json:"content"`
+
+	// Original ready once generated; pending while queued for background generation; missing when generation failed. Requesting a pending or missing variant retries it on demand.
+	Original VariantState `json:"original"`
+
+	// Thumb ready once generated; pending while queued for background generation; missing when generation failed. Requesting a pending or missing variant retries it on demand.
+	Thumb VariantState `json:"thumb"`
 }
 
 // AssetVariantUrls defines model for AssetVariantUrls.
@@ -97,13 +302,128 @@ type AssetVariantUrls struct {
 	Content  string `json:"content"`
 	Original string `json:"original"`
 	Thumb    string `json:"thumb"`
+
+	// Thumbs Additional thumbnail widths configured via GANACHE_THUMB_WIDTHS, ordered by width, suitable for building a srcset.
+	Thumbs *[]ThumbnailUrl `json:"thumbs,omitempty"`
+}
+
+// AssetVersion An earlier state of an asset's metadata, kept when an edit replaced it.
+type AssetVersion struct {
+	// AllowedPrincipals Principals allowed to see the asset in this version, when it was private. Omitted for public versions.
+	AllowedPrincipals *[]string `json:"allowedPrincipals,omitempty"`
+	Caption           string    `json:"caption"`
+	Credit            string    `json:"credit"`
+
+	// ReplacedAt When the edit that replaced this version was made.
+	ReplacedAt time.Time `json:"replacedAt"`
+
+	// ReplacedBy Principal that made the replacing edit, when known.
+	ReplacedBy *string `json:"replacedBy,omitempty"`
+
+	// Sha256 Content hash of the original this version described.
+	Sha256     string   `json:"sha256"`
+	Source     string   `json:"source"`
+	Tags       []string `json:"tags"`
+	Title      string   `json:"title"`
+	UsageNotes string   `json:"usageNotes"`
+
+	// ValidFrom When this version was last written.
+	ValidFrom time.Time `json:"validFrom"`
+
+	// Version Numbered from 1 per asset in the order versions were replaced.
+	Version    int        `json:"version"`
+	Visibility Visibility `json:"visibility"`
+}
+
+// AssetVersionList defines model for AssetVersionList.
+type AssetVersionList struct {
+	// Items Newest first.
+	Items []AssetVersion `json:"items"`
 }
 
+// AssetWaitResponse defines model for AssetWaitResponse.
+type AssetWaitResponse struct {
+	// Cursor Pass as `since` on the next call. A position in the event outbox, in commit order, at or past the last item's creation; it can move on without items, past assets the caller may not view.
+	Cursor int64 `json:"cursor"`
+
+	// Items New assets in the order they were created, oldest first; empty when the wait timed out.
+	Items []Asset `json:"items"`
+}
+
+// BatchGetRequest defines model for BatchGetRequest.
+type BatchGetRequest struct {
+	Ids []int64 `json:"ids"`
+}
+
+// BatchGetResponse defines model for BatchGetResponse.
+type BatchGetResponse struct {
+	// Items The found assets in request order, each once.
+	Items []Asset `json:"items"`
+
+	// Missing Requested ids that are not returned because the asset does not exist, is deleted, or is not visible to the caller, in request order.
+	Missing []int64 `json:"missing"`
+}
+
+// BulkDeleteRequest defines model for BulkDeleteRequest.
+type BulkDeleteRequest struct {
+	Ids []int64 `json:"ids"`
+
+	// Reason Recorded as the deletion reason on every asset this request deletes.
+	Reason *string `json:"reason,omitempty"`
+}
+
+// BulkDeleteResponse defines model for BulkDeleteResponse.
+type BulkDeleteResponse struct {
+	Results []BulkDeleteResult `json:"results"`
+}
+
+// BulkDeleteResult defines model for BulkDeleteResult.
+type BulkDeleteResult struct {
+	Id     int64                  `json:"id"`
+	Status BulkDeleteResultStatus `json:"status"`
+}
+
+// BulkDeleteResultStatus defines model for BulkDeleteResult.Status.
+type BulkDeleteResultStatus string
+
 // Error defines model for Error.
 type Error struct {
 	Code    string                  `json:"code"`
 	Details *map[string]interface{} `json:"details,omitempty"`
 	Message string                  `json:"message"`
+
+	// RequestId The request's X-Request-ID, for correlating with server logs.
+	RequestId *string `json:"requestId,omitempty"`
+}
+
+// Problem RFC 7807 problem details, sent as application/problem+json instead of Error when GANACHE_ERROR_FORMAT=problem. code and details carry the same values as in Error.
+type Problem struct {
+	Code string `json:"code"`
+
+	// Detail The Error message.
+	Detail  string                  `json:"detail"`
+	Details *map[string]interface{} `json:"details,omitempty"`
+
+	// Instance Path of the request that failed.
+	Instance string `json:"instance"`
+
+	// RequestId The request's X-Request-ID, for correlating with server logs.
+	RequestId *string `json:"requestId,omitempty"`
+	Status    int     `json:"status"`
+
+	// Title Reason phrase of the HTTP status.
+	Title string `json:"title"`
+
+	// Type `urn:ganache:error:<code>`
+	Type string `json:"type"`
+}
+
+// FacetBucket defines model for FacetBucket.
+type FacetBucket struct {
+	Count int `json:"count"`
+
+	// Key `2024`, `2024-05` or `2024-05-17` for created_at; the MIME type for mime.
+	Key string `json:"key"`
 }
 
 // Health defines model for Health.
@@ -114,6 +434,75 @@ type Health struct {
 // HealthStatus defines model for Health.Status.
 type HealthStatus string
 
+// ImportResponse defines model for ImportResponse.
+type ImportResponse struct {
+	// Incomplete Set when the request timed out before every entry was imported. Results then lists only the entries handled, whose assets stay created, and no manifest rows are reported missing.
+	Incomplete *bool          `json:"incomplete,omitempty"`
+	Results    []ImportResult `json:"results"`
+}
+
+// ImportResult defines model for ImportResult.
+type ImportResult struct {
+	// AssetId Set for created entries, and for duplicates of an asset the caller can view.
+	AssetId *int64 `json:"assetId,omitempty"`
+
+	// Error Why the entry was skipped or failed.
+	Error *string `json:"error,omitempty"`
+
+	// File Path of the entry inside the archive, or of the manifest row for missing files.
+	File   string             `json:"file"`
+	Status ImportResultStatus `json:"status"`
+}
+
+// ImportResultStatus defines model for ImportResult.Status.
+type ImportResultStatus string
+
+// RelatedTag defines model for RelatedTag.
+type RelatedTag struct {
+	// Count Number of assets carrying both this tag and the requested one.
+	Count int    `json:"count"`
+	Name  string `json:"name"`
+}
+
+// RelatedTagsResponse defines model for RelatedTagsResponse.
+type RelatedTagsResponse struct {
+	Items    []RelatedTag `json:"items"`
+	Page     int          `json:"page"`
+	PageSize int          `json:"pageSize"`
+
+	// Tag The requested tag after normalization.
+	Tag   string `json:"tag"`
+	Total int    `json:"total"`
+}
+
+// ReprocessSweep Progress of a background sweep regenerating the variants of every asset that was not deleted when it started.
+type ReprocessSweep struct {
+	// Errors Assets whose variants could not be regenerated.
+	Errors     int        `json:"errors"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Id         int64      `json:"id"`
+
+	// LastError The most recent failure, if any.
+	LastError *string `json:"lastError,omitempty"`
+
+	// Processed Assets visited so far, failed ones included. Assets uploaded during the sweep are visited too, so this can end above total; deleted ones are skipped.
+	Processed int       `json:"processed"`
+	StartedAt time.Time `json:"startedAt"`
+
+	// StartedBy Principal that started the sweep.
+	StartedBy *string             `json:"startedBy,omitempty"`
+	State     ReprocessSweepState `json:"state"`
+
+	// Total Assets not deleted when the sweep started.
+	Total int `json:"total"`
+
+	// UpdatedAt When progress was last recorded.
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// ReprocessSweepState defines model for ReprocessSweep.State.
+type ReprocessSweepState string
+
 // Tag defines model for Tag.
 type Tag struct {
 	Name string `json:"name"`
@@ -127,15 +516,64 @@ type TagListResponse struct {
 	Total    int   `json:"total"`
 }
 
+// TagNormalization defines model for TagNormalization.
+type TagNormalization struct {
+	Input string `json:"input"`
+
+	// Normalized Empty when the input is blank and would be dropped.
+	Normalized string `json:"normalized"`
+}
+
+// TagNormalizeRequest defines model for TagNormalizeRequest.
+type TagNormalizeRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// TagNormalizeResponse defines model for TagNormalizeResponse.
+type TagNormalizeResponse struct {
+	// Results One entry per input tag, in request order.
+	Results []TagNormalization `json:"results"`
+
+	// Tags The tag set that would be stored, deduplicated and sorted.
+	Tags []string `json:"tags"`
+}
+
+// ThumbnailUrl defines model for ThumbnailUrl.
+type ThumbnailUrl struct {
+	Url   string `json:"url"`
+	Width int    `json:"width"`
+}
+
+// VariantState ready once generated; pending while queued for background generation; missing when generation failed. Requesting a pending or missing variant retries it on demand.
+type VariantState string
+
+// Visibility Private assets are only visible to principals on their access list (and to `can_admin`).
+type Visibility string
+
 // AssetId defines model for AssetId.
 type AssetId = int64
 
+// CreatedAfter defines model for CreatedAfter.
+type CreatedAfter = time.Time
+
+// CreatedBefore defines model for CreatedBefore.
+type CreatedBefore = time.Time
+
 // IncludeDeleted defines model for IncludeDeleted.
 type IncludeDeleted = bool
 
+// IncludeVariants defines model for IncludeVariants.
+type IncludeVariants = bool
+
+// Lang defines model for Lang.
+type Lang = string
+
 // MediaVariant defines model for MediaVariant.
 type MediaVariant string
 
+// Mime defines model for Mime.
+type Mime = string
+
 // Page defines model for Page.
 type Page = int
 
@@ -153,41 +591,178 @@ type TagFilter = []string
 
 // SearchAssetsParams defines parameters for SearchAssets.
 type SearchAssetsParams struct {
-	// Q Full-text query (searched across title, caption, and tags).
+	// Q Full-text query (searched across title, caption, and tags). Longer than GANACHE_MAX_SEARCH_QUERY_LENGTH (500 unless configured) is rejected with 400.
 	Q *Query `form:"q,omitempty" json:"q,omitempty"`
 
-	// Tag Filter by tag name. Repeatable to require multiple tags.
-	Tag      *TagFilter `form:"tag,omitempty" json:"tag,omitempty"`
-	Page     *Page      `form:"page,omitempty" json:"page,omitempty"`
-	PageSize *PageSize  `form:"pageSize,omitempty" json:"pageSize,omitempty"`
+	// Lang Stem the query in this language so it also matches other forms of its words, e.g. `photographie` finds `photographe` with `fr`. One of de, en, es, fr; anything else is rejected with 400. Omit to match the words as given.
+	Lang *Lang `form:"lang,omitempty" json:"lang,omitempty"`
+
+	// Tag Filter by tag name. Repeatable to require multiple tags, up to GANACHE_MAX_SEARCH_TAGS (20 unless configured); more is rejected with 400. `namespace:*`, e.g. `location:*`, matches assets with any tag in that namespace.
+	Tag  *TagFilter `form:"tag,omitempty" json:"tag,omitempty"`
+	Page *Page      `form:"page,omitempty" json:"page,omitempty"`
+
+	// PageSize Results per page. Defaults to GANACHE_DEFAULT_PAGE_SIZE and is clamped to GANACHE_MAX_PAGE_SIZE (30 and 200 unless configured), or rejected with 400 when out of range if GANACHE_STRICT_PAGINATION is set.
+	PageSize *PageSize `form:"pageSize,omitempty" json:"pageSize,omitempty"`
+
+	// Mime Filter by exact MIME type (e.g. image/jpeg).
+	Mime *Mime `form:"mime,omitempty" json:"mime,omitempty"`
+
+	// CreatedAfter Only include assets created at or after this instant.
+	CreatedAfter *CreatedAfter `form:"createdAfter,omitempty" json:"createdAfter,omitempty"`
+
+	// CreatedBefore Only include assets created before this instant.
+	CreatedBefore *CreatedBefore `form:"createdBefore,omitempty" json:"createdBefore,omitempty"`
 
 	// Sort Sort order for search results.
 	Sort *SearchAssetsParamsSort `form:"sort,omitempty" json:"sort,omitempty"`
 
 	// IncludeDeleted Include soft-deleted assets in results (admin use).
 	IncludeDeleted *IncludeDeleted `form:"includeDeleted,omitempty" json:"includeDeleted,omitempty"`
+
+	// IncludeVariants When false, omit variant URLs, sha256, hashAlgo, originalFilename, and displayFilename from each item to shrink the response.
+	IncludeVariants *IncludeVariants `form:"includeVariants,omitempty" json:"includeVariants,omitempty"`
 }
 
 // SearchAssetsParamsSort defines parameters for SearchAssets.
 type SearchAssetsParamsSort string
 
+// CountAssetsParams defines parameters for CountAssets.
+type CountAssetsParams struct {
+	// Q Full-text query (searched across title, caption, and tags). Longer than GANACHE_MAX_SEARCH_QUERY_LENGTH (500 unless configured) is rejected with 400.
+	Q *Query `form:"q,omitempty" json:"q,omitempty"`
+
+	// Lang Stem the query in this language so it also matches other forms of its words, e.g. `photographie` finds `photographe` with `fr`. One of de, en, es, fr; anything else is rejected with 400. Omit to match the words as given.
+	Lang *Lang `form:"lang,omitempty" json:"lang,omitempty"`
+
+	// Tag Filter by tag name. Repeatable to require multiple tags, up to GANACHE_MAX_SEARCH_TAGS (20 unless configured); more is rejected with 400. `namespace:*`, e.g. `location:*`, matches assets with any tag in that namespace.
+	Tag *TagFilter `form:"tag,omitempty" json:"tag,omitempty"`
+
+	// Mime Filter by exact MIME type (e.g. image/jpeg).
+	Mime *Mime `form:"mime,omitempty" json:"mime,omitempty"`
+
+	// CreatedAfter Only include assets created at or after this instant.
+	CreatedAfter *CreatedAfter `form:"createdAfter,omitempty" json:"createdAfter,omitempty"`
+
+	// CreatedBefore Only include assets created before this instant.
+	CreatedBefore *CreatedBefore `form:"createdBefore,omitempty" json:"createdBefore,omitempty"`
+
+	// IncludeDeleted Include soft-deleted assets in results (admin use).
+	IncludeDeleted *IncludeDeleted `form:"includeDeleted,omitempty" json:"includeDeleted,omitempty"`
+}
+
+// GetAssetFacetsParams defines parameters for GetAssetFacets.
+type GetAssetFacetsParams struct {
+	Field GetAssetFacetsParamsField `form:"field" json:"field"`
+
+	// Interval Bucket size for field=created_at; ignored for mime.
+	Interval *GetAssetFacetsParamsInterval `form:"interval,omitempty" json:"interval,omitempty"`
+
+	// Q Full-text query (searched across title, caption, and tags). Longer than GANACHE_MAX_SEARCH_QUERY_LENGTH (500 unless configured) is rejected with 400.
+	Q *Query `form:"q,omitempty" json:"q,omitempty"`
+
+	// Lang Stem the query in this language so it also matches other forms of its words, e.g. `photographie` finds `photographe` with `fr`. One of de, en, es, fr; anything else is rejected with 400. Omit to match the words as given.
+	Lang *Lang `form:"lang,omitempty" json:"lang,omitempty"`
+
+	// Tag Filter by tag name. Repeatable to require multiple tags, up to GANACHE_MAX_SEARCH_TAGS (20 unless configured); more is rejected with 400. `namespace:*`, e.g. `location:*`, matches assets with any tag in that namespace.
+	Tag *TagFilter `form:"tag,omitempty" json:"tag,omitempty"`
+
+	// Mime Filter by exact MIME type (e.g. image/jpeg).
+	Mime *Mime `form:"mime,omitempty" json:"mime,omitempty"`
+
+	// CreatedAfter Only include assets created at or after this instant.
+	CreatedAfter *CreatedAfter `form:"createdAfter,omitempty" json:"createdAfter,omitempty"`
+
+	// CreatedBefore Only include assets created before this instant.
+	CreatedBefore *CreatedBefore `form:"createdBefore,omitempty" json:"createdBefore,omitempty"`
+
+	// IncludeDeleted Include soft-deleted assets in results (admin use).
+	IncludeDeleted *IncludeDeleted `form:"includeDeleted,omitempty" json:"includeDeleted,omitempty"`
+}
+
+// GetAssetFacetsParamsField defines parameters for GetAssetFacets.
+type GetAssetFacetsParamsField string
+
+// GetAssetFacetsParamsInterval defines parameters for GetAssetFacets.
+type GetAssetFacetsParamsInterval string
+
 // UploadAssetMultipartBody defines parameters for UploadAsset.
 type UploadAssetMultipartBody struct {
-	Caption    *string            `json:"caption,omitempty"`
-	Credit     *string            `json:"credit,omitempty"`
-	File       openapi_types.File `json:"file"`
-	Source     *string            `json:"source,omitempty"`
-	Tags       *[]string          `json:"tags,omitempty"`
-	Title      *string            `json:"title,omitempty"`
-	UsageNotes *string            `json:"usageNotes,omitempty"`
+	// AllowedPrincipals Principal ids allowed to see the asset when visibility is private.
+	AllowedPrincipals *[]string          `json:"allowedPrincipals,omitempty"`
+	Caption           *string            `json:"caption,omitempty"`
+	Credit            *string            `json:"credit,omitempty"`
+	File              openapi_types.File `json:"file"`
+
+	// Sha256 Expected hex-encoded SHA-256 of the file (alternative to the X-Content-SHA256 header).
+	Sha256     *string     `json:"sha256,omitempty"`
+	Source     *string     `json:"source,omitempty"`
+	Tags       *[]string   `json:"tags,omitempty"`
+	Title      *string     `json:"title,omitempty"`
+	UsageNotes *string     `json:"usageNotes,omitempty"`
+	Visibility *Visibility `json:"visibility,omitempty"`
+}
+
+// UploadAssetParams defines parameters for UploadAsset.
+type UploadAssetParams struct {
+	// ImportMetadata Read embedded IPTC/XMP metadata and use it to fill title, caption, and credit when those form fields are empty; embedded keywords are merged into tags.
+	ImportMetadata *bool `form:"importMetadata,omitempty" json:"importMetadata,omitempty"`
+
+	// OnDuplicate How to answer when the file's SHA-256 matches an existing asset: `conflict` returns 409, `ok` returns 200. Both carry the existing asset. Defaults to GANACHE_DUPLICATE_RESPONSE.
+	OnDuplicate *UploadAssetParamsOnDuplicate `form:"onDuplicate,omitempty" json:"onDuplicate,omitempty"`
+
+	// XContentSHA256 Expected hex-encoded SHA-256 of the file. When supplied (here or via the `sha256` form field) the upload is rejected with 422 if the computed hash differs.
+	XContentSHA256 *string `json:"X-Content-SHA256,omitempty"`
+
+	// IfNoneMatch Quoted hex-encoded content hash of the file (SHA-256, or SHA-512 when GANACHE_HASH_ALGO=sha512), e.g. `"9f86d0..."`. When an asset with that hash already exists it is returned as a duplicate (see onDuplicate) before the body is read; otherwise the upload proceeds normally.
+	IfNoneMatch *string `json:"If-None-Match,omitempty"`
+}
+
+// UploadAssetParamsOnDuplicate defines parameters for UploadAsset.
+type UploadAssetParamsOnDuplicate string
+
+// WaitForAssetsParams defines parameters for WaitForAssets.
+type WaitForAssetsParams struct {
+	// Since Cursor from a previous response (a position in the event outbox, not an asset id). Omit on the first call to wait for assets created from now on.
+	Since *int64 `form:"since,omitempty" json:"since,omitempty"`
+
+	// Timeout How long to wait as a Go duration, e.g. `30s` (the default). Capped at GANACHE_LONG_POLL_MAX_TIMEOUT (60s unless configured).
+	Timeout *string `form:"timeout,omitempty" json:"timeout,omitempty"`
+}
+
+// DeleteAssetParams defines parameters for DeleteAsset.
+type DeleteAssetParams struct {
+	// Reason Why the asset is being deleted; stored alongside the deleting principal.
+	Reason *string `form:"reason,omitempty" json:"reason,omitempty"`
 }
 
 // ListTagsParams defines parameters for ListTags.
 type ListTagsParams struct {
-	// Prefix Prefix filter for tag autocomplete.
-	Prefix   *string `form:"prefix,omitempty" json:"prefix,omitempty"`
-	Page     *Page   `form:"page,omitempty" json:"page,omitempty"`
-	PageSize *int    `form:"pageSize,omitempty" json:"pageSize,omitempty"`
+	// Prefix Prefix filter for tag autocomplete. With namespace, it matches the part after the colon.
+	Prefix *string `form:"prefix,omitempty" json:"prefix,omitempty"`
+
+	// Namespace Only list tags in this namespace, the part before the colon of tags like `location:paris`.
+	Namespace *string `form:"namespace,omitempty" json:"namespace,omitempty"`
+	Page      *Page   `form:"page,omitempty" json:"page,omitempty"`
+
+	// PageSize Results per page. Defaults to GANACHE_DEFAULT_PAGE_SIZE and is clamped to GANACHE_MAX_PAGE_SIZE (30 and 200 unless configured), or rejected with 400 when out of range if GANACHE_STRICT_PAGINATION is set.
+	PageSize *PageSize `form:"pageSize,omitempty" json:"pageSize,omitempty"`
+}
+
+// ListRelatedTagsParams defines parameters for ListRelatedTags.
+type ListRelatedTagsParams struct {
+	Page *Page `form:"page,omitempty" json:"page,omitempty"`
+
+	// PageSize Results per page. Defaults to GANACHE_DEFAULT_PAGE_SIZE and is clamped to GANACHE_MAX_PAGE_SIZE (30 and 200 unless configured), or rejected with 400 when out of range if GANACHE_STRICT_PAGINATION is set.
+	PageSize *PageSize `form:"pageSize,omitempty" json:"pageSize,omitempty"`
+}
+
+// GetMediaVariantParams defines parameters for GetMediaVariant.
+type GetMediaVariantParams struct {
+	// W Thumbnail width; only valid for the thumb variant and one of the configured widths.
+	W *int `form:"w,omitempty" json:"w,omitempty"`
+
+	// Download When true, respond with `Content-Disposition: attachment` using the asset's original filename so browsers save the file instead of displaying it.
+	Download *bool `form:"download,omitempty" json:"download,omitempty"`
 }
 
 // GetMediaVariantParamsVariant defines parameters for GetMediaVariant.
@@ -196,35 +771,107 @@ type GetMediaVariantParamsVariant string
 // UploadAssetMultipartRequestBody defines body for UploadAsset for multipart/form-data ContentType.
 type UploadAssetMultipartRequestBody UploadAssetMultipartBody
 
+// BatchGetAssetsJSONRequestBody defines body for BatchGetAssets for application/json ContentType.
+type BatchGetAssetsJSONRequestBody = BatchGetRequest
+
+// BulkDeleteAssetsJSONRequestBody defines body for BulkDeleteAssets for application/json ContentType.
+type BulkDeleteAssetsJSONRequestBody = BulkDeleteRequest
+
+// ReferenceAssetJSONRequestBody defines body for ReferenceAsset for application/json ContentType.
+type ReferenceAssetJSONRequestBody = AssetReference
+
 // UpdateAssetJSONRequestBody defines body for UpdateAsset for application/json ContentType.
 type UpdateAssetJSONRequestBody = AssetUpdate
 
+// UpdateAssetApplicationMergePatchPlusJSONRequestBody defines body for UpdateAsset for application/merge-patch+json ContentType.
+type UpdateAssetApplicationMergePatchPlusJSONRequestBody = AssetUpdate
+
+// SetAssetImmutableJSONRequestBody defines body for SetAssetImmutable for application/json ContentType.
+type SetAssetImmutableJSONRequestBody = AssetImmutability
+
+// NormalizeTagsJSONRequestBody defines body for NormalizeTags for application/json ContentType.
+type NormalizeTagsJSONRequestBody = TagNormalizeRequest
+
 // ServerInterface represents all server handlers.
 type ServerInterface interface {
+	// Rotate an API key's secret
+	// (POST /api/admin/keys/{id}/rotate)
+	RotateApiKey(w http.ResponseWriter, r *http.Request, id string)
+	// Get the progress of the variant regeneration sweep
+	// (GET /api/admin/reprocess-all)
+	GetReprocessSweep(w http.ResponseWriter, r *http.Request)
+	// Regenerate the variants of every asset
+	// (POST /api/admin/reprocess-all)
+	StartReprocessSweep(w http.ResponseWriter, r *http.Request)
 	// Search and browse assets
 	// (GET /api/assets)
 	SearchAssets(w http.ResponseWriter, r *http.Request, params SearchAssetsParams)
 	// Upload a new asset
 	// (POST /api/assets)
-	UploadAsset(w http.ResponseWriter, r *http.Request)
+	UploadAsset(w http.ResponseWriter, r *http.Request, params UploadAssetParams)
+	// Get several assets by id
+	// (POST /api/assets/batch-get)
+	BatchGetAssets(w http.ResponseWriter, r *http.Request)
+	// Count assets matching search filters
+	// (GET /api/assets/count)
+	CountAssets(w http.ResponseWriter, r *http.Request, params CountAssetsParams)
+	// Soft delete several assets at once
+	// (POST /api/assets/delete)
+	BulkDeleteAssets(w http.ResponseWriter, r *http.Request)
+	// Count matching assets per date bucket or MIME type
+	// (GET /api/assets/facets)
+	GetAssetFacets(w http.ResponseWriter, r *http.Request, params GetAssetFacetsParams)
+	// Import a ZIP of images with a metadata manifest
+	// (POST /api/assets/import)
+	ImportAssets(w http.ResponseWriter, r *http.Request)
+	// Create an asset for content that is already stored
+	// (POST /api/assets/reference)
+	ReferenceAsset(w http.ResponseWriter, r *http.Request)
+	// Wait for new assets
+	// (GET /api/assets/wait)
+	WaitForAssets(w http.ResponseWriter, r *http.Request, params WaitForAssetsParams)
 	// Soft delete an asset
 	// (DELETE /api/assets/{id})
-	DeleteAsset(w http.ResponseWriter, r *http.Request, id AssetId)
+	DeleteAsset(w http.ResponseWriter, r *http.Request, id AssetId, params DeleteAssetParams)
 	// Get an asset by id
 	// (GET /api/assets/{id})
 	GetAsset(w http.ResponseWriter, r *http.Request, id AssetId)
 	// Update asset metadata
 	// (PATCH /api/assets/{id})
 	UpdateAsset(w http.ResponseWriter, r *http.Request, id AssetId)
+	// Download an asset's original
+	// (GET /api/assets/{id}/download)
+	DownloadAsset(w http.ResponseWriter, r *http.Request, id AssetId)
+	// Get EXIF metadata of an asset's original
+	// (GET /api/assets/{id}/exif)
+	GetAssetExif(w http.ResponseWriter, r *http.Request, id AssetId)
+	// List an asset's earlier versions
+	// (GET /api/assets/{id}/versions)
+	ListAssetVersions(w http.ResponseWriter, r *http.Request, id AssetId)
+	// Get one earlier version of an asset
+	// (GET /api/assets/{id}/versions/{version})
+	GetAssetVersion(w http.ResponseWriter, r *http.Request, id AssetId, version int)
+	// Freeze or unfreeze an asset
+	// (PUT /api/assets/{id}/immutable)
+	SetAssetImmutable(w http.ResponseWriter, r *http.Request, id AssetId)
+	// Stream asset lifecycle events
+	// (GET /api/events)
+	StreamEvents(w http.ResponseWriter, r *http.Request)
 	// List tags (optionally by prefix)
 	// (GET /api/tags)
 	ListTags(w http.ResponseWriter, r *http.Request, params ListTagsParams)
+	// Preview how tags normalize
+	// (POST /api/tags/normalize)
+	NormalizeTags(w http.ResponseWriter, r *http.Request)
+	// List tags that co-occur with a tag
+	// (GET /api/tags/{name}/related)
+	ListRelatedTags(w http.ResponseWriter, r *http.Request, name string, params ListRelatedTagsParams)
 	// Liveness check
 	// (GET /healthz)
 	GetHealthz(w http.ResponseWriter, r *http.Request)
 	// Serve image bytes for an asset variant
 	// (GET /media/{id}/{variant})
-	GetMediaVariant(w http.ResponseWriter, r *http.Request, id AssetId, variant GetMediaVariantParamsVariant)
+	GetMediaVariant(w http.ResponseWriter, r *http.Request, id AssetId, variant GetMediaVariantParamsVariant, params GetMediaVariantParams)
 	// Readiness check
 	// (GET /readyz)
 	GetReadyz(w http.ResponseWriter, r *http.Request)
@@ -234,6 +881,24 @@ type ServerInterface interface {
 
 type Unimplemented struct{}
 
+// Rotate an API key's secret
+// (POST /api/admin/keys/{id}/rotate)
+func (_ Unimplemented) RotateApiKey(w http.ResponseWriter, r *http.Request, id string) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Get the progress of the variant regeneration sweep
+// (GET /api/admin/reprocess-all)
+func (_ Unimplemented) GetReprocessSweep(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Regenerate the variants of every asset
+// (POST /api/admin/reprocess-all)
+func (_ Unimplemented) StartReprocessSweep(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
 // Search and browse assets
 // (GET /api/assets)
 func (_ Unimplemented) SearchAssets(w http.ResponseWriter, r *http.Request, params SearchAssetsParams) {
@@ -242,13 +907,55 @@ func (_ Unimplemented) SearchAssets(w http.ResponseWriter, r *http.Request, para
 
 // Upload a new asset
 // (POST /api/assets)
-func (_ Unimplemented) UploadAsset(w http.ResponseWriter, r *http.Request) {
+func (_ Unimplemented) UploadAsset(w http.ResponseWriter, r *http.Request, params UploadAssetParams) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Get several assets by id
+// (POST /api/assets/batch-get)
+func (_ Unimplemented) BatchGetAssets(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Count assets matching search filters
+// (GET /api/assets/count)
+func (_ Unimplemented) CountAssets(w http.ResponseWriter, r *http.Request, params CountAssetsParams) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Soft delete several assets at once
+// (POST /api/assets/delete)
+func (_ Unimplemented) BulkDeleteAssets(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Count matching assets per date bucket or MIME type
+// (GET /api/assets/facets)
+func (_ Unimplemented) GetAssetFacets(w http.ResponseWriter, r *http.Request, params GetAssetFacetsParams) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Import a ZIP of images with a metadata manifest
+// (POST /api/assets/import)
+func (_ Unimplemented) ImportAssets(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Create an asset for content that is already stored
+// (POST /api/assets/reference)
+func (_ Unimplemented) ReferenceAsset(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Wait for new assets
+// (GET /api/assets/wait)
+func (_ Unimplemented) WaitForAssets(w http.ResponseWriter, r *http.Request, params WaitForAssetsParams) {
 	w.WriteHeader(http.StatusNotImplemented)
 }
 
 // Soft delete an asset
 // (DELETE /api/assets/{id})
-func (_ Unimplemented) DeleteAsset(w http.ResponseWriter, r *http.Request, id AssetId) {
+func (_ Unimplemented) DeleteAsset(w http.ResponseWriter, r *http.Request, id AssetId, params DeleteAssetParams) {
 	w.WriteHeader(http.StatusNotImplemented)
 }
 
@@ -264,12 +971,60 @@ func (_ Unimplemented) UpdateAsset(w http.ResponseWriter, r *http.Request, id As
 	w.WriteHeader(http.StatusNotImplemented)
 }
 
+// Download an asset's original
+// (GET /api/assets/{id}/download)
+func (_ Unimplemented) DownloadAsset(w http.ResponseWriter, r *http.Request, id AssetId) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Get EXIF metadata of an asset's original
+// (GET /api/assets/{id}/exif)
+func (_ Unimplemented) GetAssetExif(w http.ResponseWriter, r *http.Request, id AssetId) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// List an asset's earlier versions
+// (GET /api/assets/{id}/versions)
+func (_ Unimplemented) ListAssetVersions(w http.ResponseWriter, r *http.Request, id AssetId) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Get one earlier version of an asset
+// (GET /api/assets/{id}/versions/{version})
+func (_ Unimplemented) GetAssetVersion(w http.ResponseWriter, r *http.Request, id AssetId, version int) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Freeze or unfreeze an asset
+// (PUT /api/assets/{id}/immutable)
+func (_ Unimplemented) SetAssetImmutable(w http.ResponseWriter, r *http.Request, id AssetId) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// Stream asset lifecycle events
+// (GET /api/events)
+func (_ Unimplemented) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
 // List tags (optionally by prefix)
 // (GET /api/tags)
 func (_ Unimplemented) ListTags(w http.ResponseWriter, r *http.Request, params ListTagsParams) {
 	w.WriteHeader(http.StatusNotImplemented)
 }
 
+// Preview how tags normalize
+// (POST /api/tags/normalize)
+func (_ Unimplemented) NormalizeTags(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// List tags that co-occur with a tag
+// (GET /api/tags/{name}/related)
+func (_ Unimplemented) ListRelatedTags(w http.ResponseWriter, r *http.Request, name string, params ListRelatedTagsParams) {
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
 // Liveness check
 // (GET /healthz)
 func (_ Unimplemented) GetHealthz(w http.ResponseWriter, r *http.Request) {
@@ -278,7 +1033,7 @@ func (_ Unimplemented) GetHealthz(w http.ResponseWriter, r *http.Request) {
 
 // Serve image bytes for an asset variant
 // (GET /media/{id}/{variant})
-func (_ Unimplemented) GetMediaVariant(w http.ResponseWriter, r *http.Request, id AssetId, variant GetMediaVariantParamsVariant) {
+func (_ Unimplemented) GetMediaVariant(w http.ResponseWriter, r *http.Request, id AssetId, variant GetMediaVariantParamsVariant, params GetMediaVariantParams) {
 	w.WriteHeader(http.StatusNotImplemented)
 }
 
@@ -297,10 +1052,590 @@ type ServerInterfaceWrapper struct {
 
 type MiddlewareFunc func(http.Handler) http.Handler
 
+// RotateApiKey operation middleware
+func (siw *ServerInterfaceWrapper) RotateApiKey(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "id", chi.URLParam(r, "id"), &id, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "id", Err: err})
+		return
+	}
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.RotateApiKey(w, r, id)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetReprocessSweep operation middleware
+func (siw *ServerInterfaceWrapper) GetReprocessSweep(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetReprocessSweep(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// StartReprocessSweep operation middleware
+func (siw *ServerInterfaceWrapper) StartReprocessSweep(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.StartReprocessSweep(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
 // SearchAssets operation middleware
 func (siw *ServerInterfaceWrapper) SearchAssets(w http.ResponseWriter, r *http.Request) {
 
-	var err error
+	var err error
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params SearchAssetsParams
+
+	// ------------- Optional query parameter "q" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "q", r.URL.Query(), &params.Q)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "q", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "lang" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "lang", r.URL.Query(), &params.Lang)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "lang", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "tag" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "tag", r.URL.Query(), &params.Tag)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "tag", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "page" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "page", r.URL.Query(), &params.Page)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "page", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "pageSize" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "pageSize", r.URL.Query(), &params.PageSize)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "pageSize", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "mime" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "mime", r.URL.Query(), &params.Mime)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "mime", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "createdAfter" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "createdAfter", r.URL.Query(), &params.CreatedAfter)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "createdAfter", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "createdBefore" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "createdBefore", r.URL.Query(), &params.CreatedBefore)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "createdBefore", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "sort" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "sort", r.URL.Query(), &params.Sort)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "sort", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "includeDeleted" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "includeDeleted", r.URL.Query(), &params.IncludeDeleted)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "includeDeleted", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "includeVariants" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "includeVariants", r.URL.Query(), &params.IncludeVariants)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "includeVariants", Err: err})
+		return
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.SearchAssets(w, r, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// UploadAsset operation middleware
+func (siw *ServerInterfaceWrapper) UploadAsset(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params UploadAssetParams
+
+	// ------------- Optional query parameter "importMetadata" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "importMetadata", r.URL.Query(), &params.ImportMetadata)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "importMetadata", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "onDuplicate" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "onDuplicate", r.URL.Query(), &params.OnDuplicate)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "onDuplicate", Err: err})
+		return
+	}
+
+	headers := r.Header
+
+	// ------------- Optional header parameter "X-Content-SHA256" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("X-Content-SHA256")]; found {
+		var XContentSHA256 string
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "X-Content-SHA256", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithOptions("simple", "X-Content-SHA256", valueList[0], &XContentSHA256, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationHeader, Explode: false, Required: false})
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "X-Content-SHA256", Err: err})
+			return
+		}
+
+		params.XContentSHA256 = &XContentSHA256
+
+	}
+
+	// ------------- Optional header parameter "If-None-Match" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("If-None-Match")]; found {
+		var IfNoneMatch string
+		n := len(valueList)
+		if n != 1 {
+			siw.ErrorHandlerFunc(w, r, &TooManyValuesForParamError{ParamName: "If-None-Match", Count: n})
+			return
+		}
+
+		err = runtime.BindStyledParameterWithOptions("simple", "If-None-Match", valueList[0], &IfNoneMatch, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationHeader, Explode: false, Required: false})
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "If-None-Match", Err: err})
+			return
+		}
+
+		params.IfNoneMatch = &IfNoneMatch
+
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.UploadAsset(w, r, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// BatchGetAssets operation middleware
+func (siw *ServerInterfaceWrapper) BatchGetAssets(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.BatchGetAssets(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// CountAssets operation middleware
+func (siw *ServerInterfaceWrapper) CountAssets(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params CountAssetsParams
+
+	// ------------- Optional query parameter "q" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "q", r.URL.Query(), &params.Q)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "q", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "lang" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "lang", r.URL.Query(), &params.Lang)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "lang", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "tag" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "tag", r.URL.Query(), &params.Tag)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "tag", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "mime" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "mime", r.URL.Query(), &params.Mime)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "mime", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "createdAfter" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "createdAfter", r.URL.Query(), &params.CreatedAfter)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "createdAfter", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "createdBefore" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "createdBefore", r.URL.Query(), &params.CreatedBefore)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "createdBefore", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "includeDeleted" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "includeDeleted", r.URL.Query(), &params.IncludeDeleted)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "includeDeleted", Err: err})
+		return
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.CountAssets(w, r, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// BulkDeleteAssets operation middleware
+func (siw *ServerInterfaceWrapper) BulkDeleteAssets(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.BulkDeleteAssets(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// GetAssetFacets operation middleware
+func (siw *ServerInterfaceWrapper) GetAssetFacets(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetAssetFacetsParams
+
+	// ------------- Required query parameter "field" -------------
+
+	if paramValue := r.URL.Query().Get("field"); paramValue != "" {
+
+	} else {
+		siw.ErrorHandlerFunc(w, r, &RequiredParamError{ParamName: "field"})
+		return
+	}
+
+	err = runtime.BindQueryParameter("form", true, true, "field", r.URL.Query(), &params.Field)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "field", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "interval" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "interval", r.URL.Query(), &params.Interval)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "interval", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "q" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "q", r.URL.Query(), &params.Q)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "q", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "lang" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "lang", r.URL.Query(), &params.Lang)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "lang", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "tag" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "tag", r.URL.Query(), &params.Tag)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "tag", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "mime" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "mime", r.URL.Query(), &params.Mime)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "mime", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "createdAfter" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "createdAfter", r.URL.Query(), &params.CreatedAfter)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "createdAfter", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "createdBefore" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "createdBefore", r.URL.Query(), &params.CreatedBefore)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "createdBefore", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "includeDeleted" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "includeDeleted", r.URL.Query(), &params.IncludeDeleted)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "includeDeleted", Err: err})
+		return
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetAssetFacets(w, r, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// ImportAssets operation middleware
+func (siw *ServerInterfaceWrapper) ImportAssets(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ImportAssets(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// ReferenceAsset operation middleware
+func (siw *ServerInterfaceWrapper) ReferenceAsset(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ReferenceAsset(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// WaitForAssets operation middleware
+func (siw *ServerInterfaceWrapper) WaitForAssets(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params WaitForAssetsParams
+
+	// ------------- Optional query parameter "since" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "since", r.URL.Query(), &params.Since)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "since", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "timeout" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "timeout", r.URL.Query(), &params.Timeout)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "timeout", Err: err})
+		return
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.WaitForAssets(w, r, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// DeleteAsset operation middleware
+func (siw *ServerInterfaceWrapper) DeleteAsset(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id AssetId
+
+	err = runtime.BindStyledParameterWithOptions("simple", "id", chi.URLParam(r, "id"), &id, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "id", Err: err})
+		return
+	}
 
 	ctx := r.Context()
 
@@ -309,58 +1644,111 @@ func (siw *ServerInterfaceWrapper) SearchAssets(w http.ResponseWriter, r *http.R
 	r = r.WithContext(ctx)
 
 	// Parameter object where we will unmarshal all parameters from the context
-	var params SearchAssetsParams
+	var params DeleteAssetParams
 
-	// ------------- Optional query parameter "q" -------------
+	// ------------- Optional query parameter "reason" -------------
 
-	err = runtime.BindQueryParameter("form", true, false, "q", r.URL.Query(), &params.Q)
+	err = runtime.BindQueryParameter("form", true, false, "reason", r.URL.Query(), &params.Reason)
 	if err != nil {
-		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "q", Err: err})
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "reason", Err: err})
 		return
 	}
 
-	// ------------- Optional query parameter "tag" -------------
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.DeleteAsset(w, r, id, params)
+	}))
 
-	err = runtime.BindQueryParameter("form", true, false, "tag", r.URL.Query(), &params.Tag)
-	if err != nil {
-		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "tag", Err: err})
-		return
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
 	}
 
-	// ------------- Optional query parameter "page" -------------
+	handler.ServeHTTP(w, r)
+}
 
-	err = runtime.BindQueryParameter("form", true, false, "page", r.URL.Query(), &params.Page)
+// GetAsset operation middleware
+func (siw *ServerInterfaceWrapper) GetAsset(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id AssetId
+
+	err = runtime.BindStyledParameterWithOptions("simple", "id", chi.URLParam(r, "id"), &id, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
 	if err != nil {
-		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "page", Err: err})
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "id", Err: err})
 		return
 	}
 
-	// ------------- Optional query parameter "pageSize" -------------
+	ctx := r.Context()
 
-	err = runtime.BindQueryParameter("form", true, false, "pageSize", r.URL.Query(), &params.PageSize)
-	if err != nil {
-		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "pageSize", Err: err})
-		return
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetAsset(w, r, id)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
 	}
 
-	// ------------- Optional query parameter "sort" -------------
+	handler.ServeHTTP(w, r)
+}
 
-	err = runtime.BindQueryParameter("form", true, false, "sort", r.URL.Query(), &params.Sort)
+// UpdateAsset operation middleware
+func (siw *ServerInterfaceWrapper) UpdateAsset(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id AssetId
+
+	err = runtime.BindStyledParameterWithOptions("simple", "id", chi.URLParam(r, "id"), &id, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
 	if err != nil {
-		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "sort", Err: err})
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "id", Err: err})
 		return
 	}
 
-	// ------------- Optional query parameter "includeDeleted" -------------
+	ctx := r.Context()
 
-	err = runtime.BindQueryParameter("form", true, false, "includeDeleted", r.URL.Query(), &params.IncludeDeleted)
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.UpdateAsset(w, r, id)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// DownloadAsset operation middleware
+func (siw *ServerInterfaceWrapper) DownloadAsset(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id AssetId
+
+	err = runtime.BindStyledParameterWithOptions("simple", "id", chi.URLParam(r, "id"), &id, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
 	if err != nil {
-		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "includeDeleted", Err: err})
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "id", Err: err})
 		return
 	}
 
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.SearchAssets(w, r, params)
+		siw.Handler.DownloadAsset(w, r, id)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -370,8 +1758,19 @@ func (siw *ServerInterfaceWrapper) SearchAssets(w http.ResponseWriter, r *http.R
 	handler.ServeHTTP(w, r)
 }
 
-// UploadAsset operation middleware
-func (siw *ServerInterfaceWrapper) UploadAsset(w http.ResponseWriter, r *http.Request) {
+// GetAssetExif operation middleware
+func (siw *ServerInterfaceWrapper) GetAssetExif(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "id" -------------
+	var id AssetId
+
+	err = runtime.BindStyledParameterWithOptions("simple", "id", chi.URLParam(r, "id"), &id, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "id", Err: err})
+		return
+	}
 
 	ctx := r.Context()
 
@@ -380,7 +1779,7 @@ func (siw *ServerInterfaceWrapper) UploadAsset(w http.ResponseWriter, r *http.Re
 	r = r.WithContext(ctx)
 
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.UploadAsset(w, r)
+		siw.Handler.GetAssetExif(w, r, id)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -390,8 +1789,8 @@ func (siw *ServerInterfaceWrapper) UploadAsset(w http.ResponseWriter, r *http.Re
 	handler.ServeHTTP(w, r)
 }
 
-// DeleteAsset operation middleware
-func (siw *ServerInterfaceWrapper) DeleteAsset(w http.ResponseWriter, r *http.Request) {
+// ListAssetVersions operation middleware
+func (siw *ServerInterfaceWrapper) ListAssetVersions(w http.ResponseWriter, r *http.Request) {
 
 	var err error
 
@@ -411,7 +1810,7 @@ func (siw *ServerInterfaceWrapper) DeleteAsset(w http.ResponseWriter, r *http.Re
 	r = r.WithContext(ctx)
 
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.DeleteAsset(w, r, id)
+		siw.Handler.ListAssetVersions(w, r, id)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -421,8 +1820,8 @@ func (siw *ServerInterfaceWrapper) DeleteAsset(w http.ResponseWriter, r *http.Re
 	handler.ServeHTTP(w, r)
 }
 
-// GetAsset operation middleware
-func (siw *ServerInterfaceWrapper) GetAsset(w http.ResponseWriter, r *http.Request) {
+// GetAssetVersion operation middleware
+func (siw *ServerInterfaceWrapper) GetAssetVersion(w http.ResponseWriter, r *http.Request) {
 
 	var err error
 
@@ -435,6 +1834,15 @@ func (siw *ServerInterfaceWrapper) GetAsset(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// ------------- Path parameter "version" -------------
+	var version int
+
+	err = runtime.BindStyledParameterWithOptions("simple", "version", chi.URLParam(r, "version"), &version, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "version", Err: err})
+		return
+	}
+
 	ctx := r.Context()
 
 	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
@@ -442,7 +1850,7 @@ func (siw *ServerInterfaceWrapper) GetAsset(w http.ResponseWriter, r *http.Reque
 	r = r.WithContext(ctx)
 
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.GetAsset(w, r, id)
+		siw.Handler.GetAssetVersion(w, r, id, version)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -452,8 +1860,8 @@ func (siw *ServerInterfaceWrapper) GetAsset(w http.ResponseWriter, r *http.Reque
 	handler.ServeHTTP(w, r)
 }
 
-// UpdateAsset operation middleware
-func (siw *ServerInterfaceWrapper) UpdateAsset(w http.ResponseWriter, r *http.Request) {
+// SetAssetImmutable operation middleware
+func (siw *ServerInterfaceWrapper) SetAssetImmutable(w http.ResponseWriter, r *http.Request) {
 
 	var err error
 
@@ -473,7 +1881,27 @@ func (siw *ServerInterfaceWrapper) UpdateAsset(w http.ResponseWriter, r *http.Re
 	r = r.WithContext(ctx)
 
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.UpdateAsset(w, r, id)
+		siw.Handler.SetAssetImmutable(w, r, id)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// StreamEvents operation middleware
+func (siw *ServerInterfaceWrapper) StreamEvents(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.StreamEvents(w, r)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -505,6 +1933,14 @@ func (siw *ServerInterfaceWrapper) ListTags(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// ------------- Optional query parameter "namespace" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "namespace", r.URL.Query(), &params.Namespace)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "namespace", Err: err})
+		return
+	}
+
 	// ------------- Optional query parameter "page" -------------
 
 	err = runtime.BindQueryParameter("form", true, false, "page", r.URL.Query(), &params.Page)
@@ -532,6 +1968,76 @@ func (siw *ServerInterfaceWrapper) ListTags(w http.ResponseWriter, r *http.Reque
 	handler.ServeHTTP(w, r)
 }
 
+// NormalizeTags operation middleware
+func (siw *ServerInterfaceWrapper) NormalizeTags(w http.ResponseWriter, r *http.Request) {
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.NormalizeTags(w, r)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
+// ListRelatedTags operation middleware
+func (siw *ServerInterfaceWrapper) ListRelatedTags(w http.ResponseWriter, r *http.Request) {
+
+	var err error
+
+	// ------------- Path parameter "name" -------------
+	var name string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "name", chi.URLParam(r, "name"), &name, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "name", Err: err})
+		return
+	}
+
+	ctx := r.Context()
+
+	ctx = context.WithValue(ctx, BearerAuthScopes, []string{})
+
+	r = r.WithContext(ctx)
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params ListRelatedTagsParams
+
+	// ------------- Optional query parameter "page" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "page", r.URL.Query(), &params.Page)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "page", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "pageSize" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "pageSize", r.URL.Query(), &params.PageSize)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "pageSize", Err: err})
+		return
+	}
+
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ListRelatedTags(w, r, name, params)
+	}))
+
+	for _, middleware := range siw.HandlerMiddlewares {
+		handler = middleware(handler)
+	}
+
+	handler.ServeHTTP(w, r)
+}
+
 // GetHealthz operation middleware
 func (siw *ServerInterfaceWrapper) GetHealthz(w http.ResponseWriter, r *http.Request) {
 
@@ -569,8 +2075,27 @@ func (siw *ServerInterfaceWrapper) GetMediaVariant(w http.ResponseWriter, r *htt
 		return
 	}
 
+	// Parameter object where we will unmarshal all parameters from the context
+	var params GetMediaVariantParams
+
+	// ------------- Optional query parameter "w" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "w", r.URL.Query(), &params.W)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "w", Err: err})
+		return
+	}
+
+	// ------------- Optional query parameter "download" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "download", r.URL.Query(), &params.Download)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, &InvalidParamFormatError{ParamName: "download", Err: err})
+		return
+	}
+
 	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		siw.Handler.GetMediaVariant(w, r, id, variant)
+		siw.Handler.GetMediaVariant(w, r, id, variant, params)
 	}))
 
 	for _, middleware := range siw.HandlerMiddlewares {
@@ -707,12 +2232,42 @@ func HandlerWithOptions(si ServerInterface, options ChiServerOptions) http.Handl
 		ErrorHandlerFunc:   options.ErrorHandlerFunc,
 	}
 
+	r.Group(func(r chi.Router) {
+		r.Post(options.BaseURL+"/api/admin/keys/{id}/rotate", wrapper.RotateApiKey)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/api/admin/reprocess-all", wrapper.GetReprocessSweep)
+	})
+	r.Group(func(r chi.Router) {
+		r.Post(options.BaseURL+"/api/admin/reprocess-all", wrapper.StartReprocessSweep)
+	})
 	r.Group(func(r chi.Router) {
 		r.Get(options.BaseURL+"/api/assets", wrapper.SearchAssets)
 	})
 	r.Group(func(r chi.Router) {
 		r.Post(options.BaseURL+"/api/assets", wrapper.UploadAsset)
 	})
+	r.Group(func(r chi.Router) {
+		r.Post(options.BaseURL+"/api/assets/batch-get", wrapper.BatchGetAssets)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/api/assets/count", wrapper.CountAssets)
+	})
+	r.Group(func(r chi.Router) {
+		r.Post(options.BaseURL+"/api/assets/delete", wrapper.BulkDeleteAssets)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/api/assets/facets", wrapper.GetAssetFacets)
+	})
+	r.Group(func(r chi.Router) {
+		r.Post(options.BaseURL+"/api/assets/import", wrapper.ImportAssets)
+	})
+	r.Group(func(r chi.Router) {
+		r.Post(options.BaseURL+"/api/assets/reference", wrapper.ReferenceAsset)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/api/assets/wait", wrapper.WaitForAssets)
+	})
 	r.Group(func(r chi.Router) {
 		r.Delete(options.BaseURL+"/api/assets/{id}", wrapper.DeleteAsset)
 	})
@@ -722,9 +2277,33 @@ func HandlerWithOptions(si ServerInterface, options ChiServerOptions) http.Handl
 	r.Group(func(r chi.Router) {
 		r.Patch(options.BaseURL+"/api/assets/{id}", wrapper.UpdateAsset)
 	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/api/assets/{id}/download", wrapper.DownloadAsset)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/api/assets/{id}/exif", wrapper.GetAssetExif)
+	})
+	r.Group(func(r chi.Router) {
+		r.Put(options.BaseURL+"/api/assets/{id}/immutable", wrapper.SetAssetImmutable)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/api/assets/{id}/versions", wrapper.ListAssetVersions)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/api/assets/{id}/versions/{version}", wrapper.GetAssetVersion)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/api/events", wrapper.StreamEvents)
+	})
 	r.Group(func(r chi.Router) {
 		r.Get(options.BaseURL+"/api/tags", wrapper.ListTags)
 	})
+	r.Group(func(r chi.Router) {
+		r.Post(options.BaseURL+"/api/tags/normalize", wrapper.NormalizeTags)
+	})
+	r.Group(func(r chi.Router) {
+		r.Get(options.BaseURL+"/api/tags/{name}/related", wrapper.ListRelatedTags)
+	})
 	r.Group(func(r chi.Router) {
 		r.Get(options.BaseURL+"/healthz", wrapper.GetHealthz)
 	})
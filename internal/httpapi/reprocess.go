@@ -0,0 +1,60 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/arawak/ganache/internal/store"
+)
+
+// StartReprocessSweep starts regenerating the variants of every asset in the
+// background. The sweep itself is run by the worker main starts with
+// store.StartReprocessWorker, on whichever instance claims it.
+func (s *Server) StartReprocessSweep(w http.ResponseWriter, r *http.Request) {
+	sweep, err := s.store.StartReprocess(r.Context(), s.principalID(r))
+	if errors.Is(err, store.ErrReprocessRunning) {
+		writeError(w, http.StatusConflict, "reprocess_running", err.Error(), map[string]any{"sweep": toAPIReprocessSweep(sweep)})
+		return
+	}
+	if err != nil {
+		if writeCircuitError(w, err) {
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal", "failed to start reprocess sweep", map[string]any{"error": err.Error()})
+		return
+	}
+	s.logger.Info("variant reprocess sweep started", "sweep", sweep.ID, "total", sweep.Total, "by", s.principalID(r))
+	writeJSON(w, http.StatusAccepted, toAPIReprocessSweep(sweep))
+}
+
+// GetReprocessSweep reports the progress of the latest sweep.
+func (s *Server) GetReprocessSweep(w http.ResponseWriter, r *http.Request) {
+	sweep, err := s.store.LatestReprocess(r.Context())
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", "no reprocess sweep has been started", nil)
+			return
+		}
+		if writeCircuitError(w, err) {
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal", "failed to load reprocess sweep", map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, toAPIReprocessSweep(sweep))
+}
+
+func toAPIReprocessSweep(sweep *store.ReprocessSweep) ReprocessSweep {
+	return ReprocessSweep{
+		Id:         sweep.ID,
+		State:      ReprocessSweepState(sweep.State),
+		Total:      sweep.Total,
+		Processed:  sweep.Processed,
+		Errors:     sweep.Errors,
+		LastError:  sweep.LastError,
+		StartedBy:  sweep.StartedBy,
+		StartedAt:  sweep.StartedAt,
+		UpdatedAt:  sweep.UpdatedAt,
+		FinishedAt: sweep.FinishedAt,
+	}
+}
@@ -0,0 +1,187 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PermPublic, alone in a route's permission list, lets callers through without
+// authenticating. It is only meaningful in a route permissions file.
+const PermPublic = "public"
+
+// knownPermissions are the permission strings API keys and route overrides may use.
+var knownPermissions = []string{PermCanSearch, PermCanUpload, PermCanUpdate, PermCanDelete, PermCanAdmin, PermCanViewDeleted}
+
+// defaultRoutePermissions lists every authenticated route by "METHOD /pattern" with the
+// permissions it requires unless a RoutePermissions override says otherwise.
+var defaultRoutePermissions = map[string][]string{
+	"GET /api/assets":                         {PermCanSearch},
+	"POST /api/assets/batch-get":              {PermCanSearch},
+	"GET /api/assets/count":                   {PermCanSearch},
+	"GET /api/assets/facets":                  {PermCanSearch},
+	"GET /api/assets/wait":                    {PermCanSearch},
+	"GET /api/assets/{id}":                    {PermCanSearch},
+	"GET /api/assets/{id}/exif":               {PermCanSearch},
+	"GET /api/assets/{id}/versions":           {PermCanSearch},
+	"GET /api/assets/{id}/versions/{version}": {PermCanSearch},
+	"GET /api/assets/{id}/download":           {PermCanSearch},
+	"GET /api/tags":                           {PermCanSearch},
+	"POST /api/tags/normalize":                {PermCanSearch},
+	"GET /api/tags/{name}/related":            {PermCanSearch},
+	"GET /api/events":                         {PermCanSearch},
+	"POST /api/assets":                        {PermCanUpload},
+	"POST /api/assets/import":                 {PermCanUpload},
+	"POST /api/assets/reference":              {PermCanUpload},
+	"PATCH /api/assets/{id}":                  {PermCanUpdate},
+	"POST /api/assets/delete":                 {PermCanDelete},
+	"DELETE /api/assets/{id}":                 {PermCanDelete},
+	"PUT /api/assets/{id}/immutable":          {PermCanAdmin},
+	"POST /api/admin/keys/{id}/rotate":        {PermCanAdmin},
+	"GET /api/admin/reprocess-all":            {PermCanAdmin},
+	"POST /api/admin/reprocess-all":           {PermCanAdmin},
+	"GET /debug/media-cache":                  {PermCanAdmin},
+}
+
+// RoutePermissions overrides the permissions of individual routes, keyed like
+// defaultRoutePermissions. An empty list admits any authenticated caller.
+type RoutePermissions map[string][]string
+
+// LoadRoutePermissions reads a YAML mapping of "METHOD /pattern" to permission lists,
+// e.g. `GET /api/tags: [public]`. Unknown routes and permissions are rejected so a
+// typo cannot silently leave a route on its default.
+func LoadRoutePermissions(path string) (RoutePermissions, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read route permissions file: %w", err)
+	}
+	var raw map[string][]string
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse route permissions file %s: %w", path, err)
+	}
+	out := make(RoutePermissions, len(raw))
+	for route, perms := range raw {
+		method, pattern, _ := strings.Cut(strings.TrimSpace(route), " ")
+		key := strings.ToUpper(method) + " " + strings.TrimSpace(pattern)
+		if _, ok := defaultRoutePermissions[key]; !ok {
+			return nil, fmt.Errorf("route permissions file %s: unknown route %q", path, route)
+		}
+		if _, dup := out[key]; dup {
+			return nil, fmt.Errorf("route permissions file %s: route %q is listed twice", path, key)
+		}
+		for _, perm := range perms {
+			if perm == PermPublic && len(perms) > 1 {
+				return nil, fmt.Errorf("route permissions file %s: %q cannot be combined with other permissions on %s", path, PermPublic, key)
+			}
+			if perm != PermPublic && !slices.Contains(knownPermissions, perm) {
+				return nil, fmt.Errorf("route permissions file %s: unknown permission %q on %s", path, perm, key)
+			}
+		}
+		out[key] = append([]string{}, perms...)
+	}
+	return out, nil
+}
+
+// guard authenticates and authorizes requests to route, applying any override of its
+// default permissions. It panics for a route missing from defaultRoutePermissions.
+func (s *Server) guard(route string) func(http.Handler) http.Handler {
+	perms, ok := s.routePerms[route]
+	if !ok {
+		perms, ok = defaultRoutePermissions[route]
+		if !ok {
+			panic("httpapi: no default permissions for route " + route)
+		}
+	}
+	if slices.Equal(perms, []string{PermPublic}) {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	authenticate := s.authMiddleware()
+	authorize := s.requirePermissions(perms...)
+	return func(next http.Handler) http.Handler {
+		return authenticate(authorize(next))
+	}
+}
+
+// adminIPMiddleware refuses admin requests from addresses outside
+// GANACHE_ADMIN_IP_ALLOWLIST with 403, before they are authenticated, so a leaked
+// admin key is useless from elsewhere. The address is the one clientAddr settles on,
+// not RealIP's, so a forwarding header sent by the client itself cannot get it past.
+// An empty list admits every address.
+func (s *Server) adminIPMiddleware(next http.Handler) http.Handler {
+	if len(s.cfg.AdminIPAllowlist) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, ok := s.clientAddr(r)
+		if !ok || !slices.ContainsFunc(s.cfg.AdminIPAllowlist, func(p netip.Prefix) bool { return p.Contains(addr) }) {
+			s.logger.Warn("admin request from address outside allow-list", "remote", r.RemoteAddr, "path", r.URL.Path)
+			writeError(w, http.StatusForbidden, "ip_not_allowed", "admin endpoints are not reachable from this address", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type peerAddrKeyType struct{}
+
+var peerAddrKey = peerAddrKeyType{}
+
+// peerMiddleware keeps the connection's peer address before RealIP replaces
+// r.RemoteAddr with whatever the forwarding headers claim.
+func peerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), peerAddrKey, r.RemoteAddr)))
+	})
+}
+
+// clientAddr is the address of the client behind r. It is the connection's peer
+// unless that peer is in GANACHE_TRUSTED_PROXIES; then X-Forwarded-For is read from
+// the right, past further trusted proxies, so entries the client wrote itself are
+// never reached. X-Real-IP stands in when a trusted proxy sends no X-Forwarded-For.
+func (s *Server) clientAddr(r *http.Request) (netip.Addr, bool) {
+	peer, ok := r.Context().Value(peerAddrKey).(string)
+	if !ok {
+		peer = r.RemoteAddr
+	}
+	addr, ok := parseRemoteAddr(peer)
+	if !ok || !s.trustedProxy(addr) {
+		return addr, ok
+	}
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				return netip.Addr{}, false
+			}
+			if addr = hop.Unmap(); !s.trustedProxy(addr) {
+				break
+			}
+		}
+		return addr, true
+	}
+	if real, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return real.Unmap(), true
+	}
+	return addr, true
+}
+
+func (s *Server) trustedProxy(addr netip.Addr) bool {
+	return slices.ContainsFunc(s.cfg.TrustedProxies, func(p netip.Prefix) bool { return p.Contains(addr) })
+}
+
+// parseRemoteAddr parses a RemoteAddr, which RealIP leaves as a bare address and
+// net/http as host:port. IPv4-mapped IPv6 addresses are unmapped to match IPv4 ranges.
+func parseRemoteAddr(remote string) (netip.Addr, bool) {
+	if ap, err := netip.ParseAddrPort(remote); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	addr, err := netip.ParseAddr(remote)
+	return addr.Unmap(), err == nil
+}
@@ -0,0 +1,157 @@
+package httpapi
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/arawak/ganache/internal/config"
+)
+
+func TestLoadRoutePermissions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "routes.yaml")
+	content := `
+get /api/tags: [public]
+GET /api/assets: [can_search, can_upload]
+GET /api/assets/count: []
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	perms, err := LoadRoutePermissions(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := perms["GET /api/tags"]; len(got) != 1 || got[0] != PermPublic {
+		t.Fatalf("expected method to be normalized, got %v", perms)
+	}
+	if got := perms["GET /api/assets"]; len(got) != 2 {
+		t.Fatalf("unexpected search permissions: %v", got)
+	}
+	if got, ok := perms["GET /api/assets/count"]; !ok || len(got) != 0 {
+		t.Fatalf("expected empty list for count, got %v", got)
+	}
+
+	for name, body := range map[string]string{
+		"unknown route":      "GET /api/nope: [can_search]\n",
+		"unknown permission": "GET /api/tags: [can_fly]\n",
+		"public combined":    "GET /api/tags: [public, can_search]\n",
+	} {
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if _, err := LoadRoutePermissions(path); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestGuardAppliesOverrides(t *testing.T) {
+	keys := &APIKeyStore{byKey: map[string]*APIKey{
+		"searcher": {ID: "searcher", Permissions: []string{PermCanSearch}},
+	}}
+	s := &Server{
+		cfg:     &config.Config{AuthMode: config.AuthAPIKey},
+		apiKeys: keys,
+		routePerms: RoutePermissions{
+			"GET /api/tags":   {PermPublic},
+			"GET /api/assets": {PermCanUpload},
+		},
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	cases := []struct {
+		route, key string
+		expect     int
+	}{
+		{"GET /api/tags", "", http.StatusOK},
+		{"GET /api/assets", "searcher", http.StatusForbidden},
+		{"GET /api/assets/count", "searcher", http.StatusOK},
+		{"GET /api/assets/count", "", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if c.key != "" {
+			req.Header.Set("X-Api-Key", c.key)
+		}
+		rec := httptest.NewRecorder()
+		s.guard(c.route)(ok).ServeHTTP(rec, req)
+		if rec.Code != c.expect {
+			t.Fatalf("%s with key %q: expected %d, got %d", c.route, c.key, c.expect, rec.Code)
+		}
+	}
+}
+
+func TestAdminIPAllowlist(t *testing.T) {
+	cfg := &config.Config{
+		AuthMode:         config.AuthAPIKey,
+		OpenAPIPath:      "/openapi.yaml",
+		SwaggerUIPath:    "/swagger",
+		AdminIPAllowlist: []netip.Prefix{netip.MustParsePrefix("10.8.0.0/16"), netip.MustParsePrefix("fd00::/8")},
+		TrustedProxies:   []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")},
+	}
+	h := NewRouter(cfg, nil, nil, &APIKeyStore{}, nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	cases := []struct {
+		path, remote, forwarded, realIP string
+		expect                          int
+	}{
+		// Outside the range: refused before authentication is even looked at.
+		{"/api/admin/reprocess-all", "203.0.113.5:4000", "", "", http.StatusForbidden},
+		{"/debug/media-cache", "203.0.113.5:4000", "", "", http.StatusForbidden},
+		// Forwarding headers from a peer that is not a trusted proxy are ignored.
+		{"/api/admin/reprocess-all", "203.0.113.5:4000", "10.8.3.4", "", http.StatusForbidden},
+		{"/api/admin/reprocess-all", "203.0.113.5:4000", "", "10.8.3.4", http.StatusForbidden},
+		{"/api/admin/reprocess-all", "10.8.0.1:4000", "203.0.113.5", "", http.StatusUnauthorized},
+		// Behind a trusted proxy the client is the last hop it did not add itself.
+		{"/api/admin/reprocess-all", "192.0.2.1:4000", "203.0.113.5", "", http.StatusForbidden},
+		{"/api/admin/reprocess-all", "192.0.2.1:4000", "10.8.3.4, 203.0.113.5", "", http.StatusForbidden},
+		{"/api/admin/reprocess-all", "192.0.2.1:4000", "not-an-address", "", http.StatusForbidden},
+		{"/api/admin/reprocess-all", "192.0.2.1:4000", "10.8.3.4", "", http.StatusUnauthorized},
+		{"/api/admin/reprocess-all", "192.0.2.1:4000", "10.8.3.4, 192.0.2.7", "", http.StatusUnauthorized},
+		{"/api/admin/reprocess-all", "192.0.2.1:4000", "", "10.8.3.4", http.StatusUnauthorized},
+		// Inside the range: on to the usual authentication.
+		{"/api/admin/reprocess-all", "10.8.3.4:4000", "", "", http.StatusUnauthorized},
+		{"/api/admin/reprocess-all", "[::ffff:10.8.3.4]:4000", "", "", http.StatusUnauthorized},
+		{"/api/admin/reprocess-all", "[fd12::1]:4000", "", "", http.StatusUnauthorized},
+		// Other routes are not restricted.
+		{"/api/assets", "203.0.113.5:4000", "", "", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		req.RemoteAddr = c.remote
+		if c.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if c.realIP != "" {
+			req.Header.Set("X-Real-IP", c.realIP)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != c.expect {
+			t.Fatalf("%s from %s (forwarded %q, real %q): expected %d, got %d: %s", c.path, c.remote, c.forwarded, c.realIP, c.expect, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestDefaultRoutePermissionsAreKnown(t *testing.T) {
+	for route, perms := range defaultRoutePermissions {
+		method, _, _ := strings.Cut(route, " ")
+		if method != strings.ToUpper(method) {
+			t.Fatalf("route %q: method must be upper case", route)
+		}
+		for _, perm := range perms {
+			if !slices.Contains(knownPermissions, perm) {
+				t.Fatalf("route %q uses unknown permission %q", route, perm)
+			}
+		}
+	}
+}
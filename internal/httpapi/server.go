@@ -1,26 +1,37 @@
 package httpapi
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 
 	"github.com/arawak/ganache/internal/config"
+	"github.com/arawak/ganache/internal/events"
 	"github.com/arawak/ganache/internal/media"
 	"github.com/arawak/ganache/internal/store"
 	"github.com/arawak/ganache/internal/swaggerui"
@@ -31,12 +42,41 @@ type Server struct {
 	store   *store.Store
 	media   *media.Manager
 	apiKeys *APIKeyStore
+	events  *events.Bus
 	logger  *slog.Logger
+	// routePerms overrides defaultRoutePermissions; nil keeps the defaults.
+	routePerms RoutePermissions
 }
 
+const (
+	eventsClientBuffer = 64
+	eventsHeartbeat    = 25 * time.Second
+
+	// waitDefaultTimeout is how long GET /api/assets/wait blocks without a timeout
+	// parameter. Between events it looks again every waitRecheck, which catches
+	// assets whose event another instance relayed or a replica had not caught up on.
+	waitDefaultTimeout = 30 * time.Second
+	waitRecheck        = 5 * time.Second
+	// maxWaitAssets caps the assets one wait returns.
+	maxWaitAssets = 100
+
+	// maxBulkDeleteIDs caps a single batch delete so the IN clause stays reasonable.
+	maxBulkDeleteIDs = 1000
+	// maxBatchGetIDs caps a single batch get, which returns full assets.
+	maxBatchGetIDs = 100
+	// maxDeletionReasonLen matches the deletion_reason column.
+	maxDeletionReasonLen = 1024
+	// maxNormalizeTags caps a single tag normalization preview.
+	maxNormalizeTags = 1000
+)
+
 var (
 	openapiOnce sync.Once
 	openapiData []byte
+	openapiETag string
+	// openapiGzip is openapiData compressed once for clients that accept gzip; its
+	// ETag is openapiETag with a -gzip suffix, since it is a different representation.
+	openapiGzip []byte
 	openapiErr  error
 	openapiFile string
 )
@@ -63,107 +103,267 @@ func loadOpenAPI(path string) ([]byte, error) {
 			return
 		}
 		openapiData, openapiErr = os.ReadFile(openapiFile)
+		if openapiErr == nil {
+			sum := sha256.Sum256(openapiData)
+			openapiETag = fmt.Sprintf("\"%s\"", hex.EncodeToString(sum[:8]))
+			var buf bytes.Buffer
+			zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+			if _, err := zw.Write(openapiData); err == nil && zw.Close() == nil {
+				openapiGzip = buf.Bytes()
+			}
+		}
 	})
 	return openapiData, openapiErr
 }
 
-func NewRouter(cfg *config.Config, st *store.Store, mediaMgr *media.Manager, apiKeys *APIKeyStore, logger *slog.Logger) http.Handler {
+// NewRouter builds the HTTP handler. routePerms may be nil to keep the default
+// permissions of every route. maintenance may be nil, in which case the initial
+// GANACHE_MAINTENANCE_MODE setting applies for the life of the router. bus feeds
+// /api/events; pass the bus the store's outbox publishes to, or nil for a private one.
+func NewRouter(cfg *config.Config, st *store.Store, mediaMgr *media.Manager, apiKeys *APIKeyStore, routePerms RoutePermissions, maintenance *Maintenance, bus *events.Bus, logger *slog.Logger) http.Handler {
 	if logger == nil {
 		logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
 	}
-	s := &Server{cfg: cfg, store: st, media: mediaMgr, apiKeys: apiKeys, logger: logger}
+	if maintenance == nil {
+		maintenance = NewMaintenance(cfg.MaintenanceMode, logger)
+	}
+	if bus == nil {
+		bus = events.NewBus()
+	}
+	s := &Server{cfg: cfg, store: st, media: mediaMgr, apiKeys: apiKeys, events: bus, logger: logger, routePerms: routePerms}
 
 	r := chi.NewRouter()
-	r.Use(middleware.RequestID)
+	if cfg.ErrorFormat == config.ErrorFormatProblem {
+		r.Use(problemMiddleware)
+	}
+	if cfg.BigIntAsString {
+		r.Use(bigIntMiddleware)
+	}
+	r.Use(requestIDMiddleware)
+	r.Use(peerMiddleware)
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(60 * time.Second))
 	r.Use(loggingMiddleware(logger))
+	if cfg.SecureHeaders {
+		r.Use(secureHeadersMiddleware(cfg.SwaggerUIPath))
+	}
+	r.Use(maintenance.middleware)
 
 	if len(cfg.CORSAllowedOrigins) > 0 {
 		c := cors.New(cors.Options{
 			AllowedOrigins:   cfg.CORSAllowedOrigins,
-			AllowedMethods:   []string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"},
-			AllowedHeaders:   []string{"Authorization", "Content-Type", "Accept", "X-Api-Key"},
+			AllowedMethods:   []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+			AllowedHeaders:   []string{"Authorization", "Content-Type", "Accept", "X-Api-Key", "If-None-Match", middleware.RequestIDHeader},
+			ExposedHeaders:   []string{"ETag", middleware.RequestIDHeader},
 			AllowCredentials: true,
 		})
 		r.Use(c.Handler)
 	}
+	// After CORS, so preflight requests still get their Access-Control-* answer.
+	r.Use(s.optionsMiddleware)
 
-	r.Get("/healthz", s.GetHealthz)
-	r.Get("/readyz", s.GetReadyz)
-	r.Get(cfg.OpenAPIPath, s.serveOpenAPI)
-	r.Mount(cfg.SwaggerUIPath, swaggerui.Handler(cfg.OpenAPIPath))
+	r.Group(func(r chi.Router) {
+		r.Use(timeoutMiddleware(cfg.RequestTimeout))
+		// HEAD shares the GET handlers; net/http discards the body for HEAD requests.
+		for _, route := range []struct {
+			path    string
+			handler http.HandlerFunc
+		}{
+			{"/", s.serveRoot},
+			{"/healthz", s.GetHealthz},
+			{"/readyz", s.GetReadyz},
+			{cfg.OpenAPIPath, s.serveOpenAPI},
+		} {
+			r.Get(route.path, route.handler)
+			r.Head(route.path, route.handler)
+		}
+		r.Mount(cfg.SwaggerUIPath, swaggerui.Handler(cfg.OpenAPIPath))
+	})
 
 	wrapper := ServerInterfaceWrapper{Handler: s, ErrorHandlerFunc: func(w http.ResponseWriter, r *http.Request, err error) {
 		writeError(w, http.StatusBadRequest, "bad_request", err.Error(), nil)
 	}}
 
+	// route registers a handler behind the authentication and permissions that
+	// defaultRoutePermissions, or an override, sets for it.
+	route := func(r chi.Router, method, pattern string, h http.HandlerFunc) {
+		if s.refusedReadOnly(method + " " + pattern) {
+			r.Method(method, pattern, http.HandlerFunc(s.serveReadOnly))
+			return
+		}
+		r.With(s.guard(method+" "+pattern)).Method(method, pattern, h)
+	}
+
 	r.Group(func(r chi.Router) {
-		r.Use(s.authMiddleware())
-		r.With(s.requirePermissions(PermCanSearch)).Get("/api/assets", wrapper.SearchAssets)
-		r.With(s.requirePermissions(PermCanUpload)).Post("/api/assets", wrapper.UploadAsset)
-		r.With(s.requirePermissions(PermCanDelete)).Delete("/api/assets/{id}", wrapper.DeleteAsset)
-		r.With(s.requirePermissions(PermCanSearch)).Get("/api/assets/{id}", wrapper.GetAsset)
-		r.With(s.requirePermissions(PermCanUpdate)).Patch("/api/assets/{id}", wrapper.UpdateAsset)
-		r.With(s.requirePermissions(PermCanSearch)).Get("/api/tags", wrapper.ListTags)
+		r.Use(s.circuitMiddleware)
+
+		r.Group(func(r chi.Router) {
+			r.Use(timeoutMiddleware(cfg.QueryTimeout))
+			route(r, http.MethodGet, "/api/assets", wrapper.SearchAssets)
+			route(r, http.MethodPost, "/api/assets/batch-get", wrapper.BatchGetAssets)
+			route(r, http.MethodGet, "/api/assets/count", wrapper.CountAssets)
+			route(r, http.MethodGet, "/api/assets/facets", wrapper.GetAssetFacets)
+			route(r, http.MethodGet, "/api/assets/{id}", wrapper.GetAsset)
+			route(r, http.MethodGet, "/api/assets/{id}/exif", wrapper.GetAssetExif)
+			route(r, http.MethodGet, "/api/assets/{id}/versions", wrapper.ListAssetVersions)
+			route(r, http.MethodGet, "/api/assets/{id}/versions/{version}", wrapper.GetAssetVersion)
+			route(r, http.MethodGet, "/api/tags", wrapper.ListTags)
+			route(r, http.MethodPost, "/api/tags/normalize", wrapper.NormalizeTags)
+			route(r, http.MethodGet, "/api/tags/{name}/related", wrapper.ListRelatedTags)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(timeoutMiddleware(cfg.UploadTimeout))
+			route(r, http.MethodPost, "/api/assets", wrapper.UploadAsset)
+			route(r, http.MethodPost, "/api/assets/import", wrapper.ImportAssets)
+			route(r, http.MethodPost, "/api/assets/reference", wrapper.ReferenceAsset)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(timeoutMiddleware(cfg.RequestTimeout))
+			route(r, http.MethodPost, "/api/assets/delete", wrapper.BulkDeleteAssets)
+			route(r, http.MethodDelete, "/api/assets/{id}", wrapper.DeleteAsset)
+			route(r, http.MethodPatch, "/api/assets/{id}", wrapper.UpdateAsset)
+			route(r, http.MethodPut, "/api/assets/{id}/immutable", wrapper.SetAssetImmutable)
+			r.Group(func(r chi.Router) {
+				r.Use(s.adminIPMiddleware)
+				route(r, http.MethodPost, "/api/admin/keys/{id}/rotate", wrapper.RotateApiKey)
+				route(r, http.MethodGet, "/api/admin/reprocess-all", wrapper.GetReprocessSweep)
+				route(r, http.MethodPost, "/api/admin/reprocess-all", wrapper.StartReprocessSweep)
+				route(r, http.MethodGet, "/debug/media-cache", s.serveMediaCacheStats)
+			})
+			// Bounded by the request timeout like /media, not the query timeout.
+			route(r, http.MethodGet, "/api/assets/{id}/download", wrapper.DownloadAsset)
+		})
+
+		// Long-lived stream: no request timeout.
+		route(r, http.MethodGet, "/api/events", wrapper.StreamEvents)
+		// Bounded by GANACHE_LONG_POLL_MAX_TIMEOUT, and each query by the query timeout.
+		route(r, http.MethodGet, "/api/assets/wait", wrapper.WaitForAssets)
 	})
 
 	r.Group(func(r chi.Router) {
-		if !cfg.PublicMedia {
-			r.Use(s.authMiddleware())
-			r.Use(s.requirePermissions(PermCanSearch))
+		r.Use(timeoutMiddleware(cfg.RequestTimeout))
+		r.Use(s.circuitMiddleware)
+		if len(cfg.MediaAuthVariants) > 0 {
+			r.Use(forVariants(cfg.MediaAuthVariants, s.authMiddleware(), s.requirePermissions(PermCanSearch)))
 		}
 		r.Get("/media/{id}/{variant}", wrapper.GetMediaVariant)
 	})
 
-	r.Group(func(r chi.Router) {
-		if !cfg.PublicMedia {
-			r.Use(s.authMiddleware())
-			r.Use(s.requirePermissions(PermCanSearch))
+	return r
+}
+
+// allowableMethods are the methods an OPTIONS response may list besides OPTIONS itself.
+var allowableMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// optionsMiddleware answers OPTIONS requests with 204 and an Allow header listing the
+// methods the router serves for the path, or 404 when it serves none. Whether the
+// caller may use them is left to the real request.
+func (s *Server) optionsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
 		}
-		r.Get("/media/{id}/{variant}", wrapper.GetMediaVariant)
+		allow := s.allowedMethods(r)
+		if len(allow) == 1 {
+			writeError(w, http.StatusNotFound, "not_found", "no such route", nil)
+			return
+		}
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		w.WriteHeader(http.StatusNoContent)
 	})
+}
 
-	return r
+// allowedMethods lists OPTIONS and the methods the router serves for the request's
+// path, leaving out routes GANACHE_READ_ONLY refuses.
+func (s *Server) allowedMethods(r *http.Request) []string {
+	rctx := chi.RouteContext(r.Context())
+	path := r.URL.RawPath
+	if path == "" {
+		path = r.URL.Path
+	}
+	allow := []string{http.MethodOptions}
+	for _, m := range allowableMethods {
+		if pattern := rctx.Routes.Find(chi.NewRouteContext(), m, path); pattern != "" && !s.refusedReadOnly(m+" "+pattern) {
+			allow = append(allow, m)
+		}
+	}
+	return allow
 }
 
 func (s *Server) authMiddleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			switch s.cfg.AuthMode {
-			case config.AuthNone:
+			if s.cfg.AuthMode == config.AuthNone {
 				next.ServeHTTP(w, r)
 				return
-			case config.AuthAPIKey:
-				apiKey := strings.TrimSpace(r.Header.Get("X-Api-Key"))
-				if apiKey == "" {
-					writeError(w, http.StatusUnauthorized, "unauthorized", "missing api key", nil)
-					return
-				}
-				if s.apiKeys == nil {
-					writeError(w, http.StatusInternalServerError, "internal", "api key store not initialized", nil)
+			}
+			modes := s.cfg.AuthMode.Modes()
+			// Report the first mechanism that rejected credentials it was sent; when
+			// the caller sent none, a lone mechanism explains what it expected.
+			var rejected, missing *authError
+			for _, mode := range modes {
+				principal, err := s.authenticate(mode, r)
+				if principal != nil {
+					next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
 					return
 				}
-				entry, ok := s.apiKeys.Lookup(apiKey)
-				if !ok {
-					writeError(w, http.StatusUnauthorized, "unauthorized", "invalid api key", nil)
-					return
+				if err.presented && rejected == nil {
+					rejected = err
+				} else if !err.presented && missing == nil {
+					missing = err
 				}
-				principal := newPrincipalFromAPIKey(entry)
-				next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
-				return
-			case config.AuthOIDC:
-				writeError(w, http.StatusNotImplemented, "not_implemented", "oidc auth mode is not implemented yet", nil)
-				return
+			}
+			switch {
+			case rejected != nil:
+				writeError(w, rejected.status, rejected.code, rejected.message, nil)
+			case len(modes) == 1:
+				writeError(w, missing.status, missing.code, missing.message, nil)
 			default:
-				writeError(w, http.StatusUnauthorized, "unauthorized", "auth mode not supported", nil)
-				return
+				writeError(w, http.StatusUnauthorized, "unauthorized", "missing credentials: send X-Api-Key or Authorization: Bearer", nil)
 			}
 		})
 	}
 }
 
+// authError is why one auth mechanism did not produce a principal. presented is set
+// when the request carried that mechanism's credentials.
+type authError struct {
+	status    int
+	code      string
+	message   string
+	presented bool
+}
+
+// authenticate tries a single mechanism. Exactly one of the results is non-nil.
+func (s *Server) authenticate(mode config.AuthMode, r *http.Request) (*Principal, *authError) {
+	switch mode {
+	case config.AuthAPIKey:
+		apiKey := strings.TrimSpace(r.Header.Get("X-Api-Key"))
+		if apiKey == "" {
+			return nil, &authError{status: http.StatusUnauthorized, code: "unauthorized", message: "missing api key"}
+		}
+		if s.apiKeys == nil {
+			return nil, &authError{status: http.StatusInternalServerError, code: "internal", message: "api key store not initialized", presented: true}
+		}
+		entry, ok := s.apiKeys.Lookup(apiKey)
+		if !ok {
+			return nil, &authError{status: http.StatusUnauthorized, code: "unauthorized", message: "invalid api key", presented: true}
+		}
+		if err := entry.ValidAt(time.Now()); err != nil {
+			return nil, &authError{status: http.StatusUnauthorized, code: "unauthorized", message: err.Error(), presented: true}
+		}
+		return newPrincipalFromAPIKey(entry), nil
+	case config.AuthOIDC:
+		_, bearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return nil, &authError{status: http.StatusNotImplemented, code: "not_implemented", message: "oidc auth mode is not implemented yet", presented: bearer}
+	default:
+		return nil, &authError{status: http.StatusUnauthorized, code: "unauthorized", message: "auth mode not supported"}
+	}
+}
+
 func (s *Server) requirePermissions(perms ...string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -187,24 +387,123 @@ func (s *Server) requirePermissions(perms ...string) func(http.Handler) http.Han
 	}
 }
 
-func (s *Server) serveOpenAPI(w http.ResponseWriter, _ *http.Request) {
+// forVariants applies middlewares only to media requests for the listed variants,
+// leaving the other variants on the same route untouched.
+func forVariants(variants []string, middlewares ...func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		guarded := next
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			guarded = middlewares[i](guarded)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if slices.Contains(variants, chi.URLParam(r, "variant")) {
+				guarded.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type mediaCacheResponse struct {
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	HitRatio float64 `json:"hitRatio"`
+	Bytes    int64   `json:"bytes"`
+}
+
+// serveMediaCacheStats reports derivative cache hits and misses since startup and the
+// bytes of derivatives on disk, to judge whether pre-warming common sizes is worthwhile.
+func (s *Server) serveMediaCacheStats(w http.ResponseWriter, _ *http.Request) {
+	st := s.media.CacheStats()
+	writeJSON(w, http.StatusOK, mediaCacheResponse{Hits: st.Hits, Misses: st.Misses, HitRatio: st.HitRatio, Bytes: st.Bytes})
+}
+
+type rootResponse struct {
+	Service string            `json:"service"`
+	Version string            `json:"version"`
+	Links   map[string]string `json:"links"`
+}
+
+func (s *Server) serveRoot(w http.ResponseWriter, _ *http.Request) {
+	version := s.cfg.Version
+	if version == "" {
+		version = "dev"
+	}
+	writeJSON(w, http.StatusOK, rootResponse{
+		Service: s.cfg.ServiceName,
+		Version: version,
+		Links: map[string]string{
+			"swagger": s.cfg.SwaggerUIPath,
+			"openapi": s.cfg.OpenAPIPath,
+			"healthz": "/healthz",
+		},
+	})
+}
+
+func (s *Server) serveOpenAPI(w http.ResponseWriter, r *http.Request) {
 	data, err := loadOpenAPI("")
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "internal", "unable to load openapi.yaml", map[string]any{"error": err.Error()})
 		return
 	}
+	etag := openapiETag
+	if openapiGzip != nil && acceptsGzip(r.Header.Get("Accept-Encoding")) {
+		data = openapiGzip
+		etag = strings.TrimSuffix(openapiETag, `"`) + `-gzip"`
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	w.Header().Set("Vary", "Accept-Encoding")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if match := r.Header.Get("If-None-Match"); match != "" && match == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 	w.Header().Set("Content-Type", "application/yaml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
 	w.WriteHeader(http.StatusOK)
 	if _, err := w.Write(data); err != nil {
 		s.logger.Error("failed to write openapi response", "error", err)
 	}
 }
 
+// acceptsGzip reports whether an Accept-Encoding header admits gzip with a non-zero
+// quality, by name or, when gzip is not listed, through "*".
+func acceptsGzip(header string) bool {
+	star := false
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "x-gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok && strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					q = f
+				}
+			}
+		}
+		if coding == "*" {
+			star = q > 0
+			continue
+		}
+		return q > 0
+	}
+	return star
+}
+
 func (s *Server) GetHealthz(w http.ResponseWriter, _ *http.Request) {
 	writeJSON(w, http.StatusOK, Health{Status: Ok})
 }
 
 func (s *Server) GetReadyz(w http.ResponseWriter, _ *http.Request) {
+	if retryAfter, open := s.store.CircuitOpen(); open {
+		writeCircuitOpen(w, retryAfter)
+		return
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 	if err := s.store.Ping(ctx); err != nil {
@@ -219,65 +518,257 @@ func (s *Server) GetReadyz(w http.ResponseWriter, _ *http.Request) {
 }
 
 func (s *Server) SearchAssets(w http.ResponseWriter, r *http.Request, params SearchAssetsParams) {
-	pageSize := derefInt(params.PageSize, 30)
-	if pageSize < 1 {
-		pageSize = 1
+	if msg := s.searchComplexityError(getStringPtr(params.Q), derefStringSlice(params.Tag)); msg != "" {
+		writeError(w, http.StatusBadRequest, "bad_request", msg, nil)
+		return
 	}
-	if pageSize > 200 {
-		pageSize = 200
+	lang, msg := searchLang(params.Lang)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, "bad_request", msg, nil)
+		return
 	}
-
-	page := derefInt(params.Page, 1)
-	if page < 1 {
-		page = 1
+	page, pageSize, msg := s.pagination(params.Page, params.PageSize)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, "bad_request", msg, nil)
+		return
 	}
 
 	sp := store.SearchParams{
 		Query:          getStringPtr(params.Q),
 		Tags:           derefStringSlice(params.Tag),
+		Mime:           getStringPtr(params.Mime),
+		CreatedAfter:   params.CreatedAfter,
+		CreatedBefore:  params.CreatedBefore,
 		Page:           page,
 		PageSize:       pageSize,
 		Sort:           string(derefSort(params.Sort)),
 		IncludeDeleted: derefBool(params.IncludeDeleted, false),
+		Viewer:         s.viewer(r),
+		Lang:           lang,
 	}
-	s.logger.Debug("search", "query", sp.Query, "tags", sp.Tags, "page", sp.Page, "pageSize", sp.PageSize, "sort", sp.Sort)
-	assets, total, err := s.store.SearchAssets(r.Context(), sp)
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, "internal", "failed to search", map[string]any{"error": err.Error()})
-		return
+	s.logger.Debug("search", "query", sp.Query, "lang", sp.Lang, "tags", sp.Tags, "page", sp.Page, "pageSize", sp.PageSize, "sort", sp.Sort)
+	var assets []store.Asset
+	var total int
+	if !s.skipUnfilteredSearch(sp) {
+		var err error
+		assets, total, err = s.store.SearchAssets(r.Context(), sp)
+		if err != nil {
+			if writeQueryTimeout(w, err) {
+				return
+			}
+			writeError(w, http.StatusInternalServerError, "internal", "failed to search", map[string]any{"error": err.Error()})
+			return
+		}
 	}
+	includeVariants := derefBool(params.IncludeVariants, true)
+	showDeletion := s.canViewDeletion(r)
 	resp := AssetSearchResponse{Page: sp.Page, PageSize: sp.PageSize, Total: total}
 	for i := range assets {
-		resp.Items = append(resp.Items, s.toAPIAsset(&assets[i]))
+		item := s.toAPIAsset(&assets[i])
+		if showDeletion {
+			item.DeletedBy = assets[i].DeletedBy
+			item.DeletionReason = assets[i].DeletionReason
+		}
+		if !includeVariants {
+			item.Variants = nil
+			item.Sha256 = nil
+			item.HashAlgo = nil
+			item.OriginalFilename = nil
+			item.DisplayFilename = nil
+		}
+		resp.Items = append(resp.Items, item)
+	}
+	// Tags are ANDed, so an unknown tag always empties the result; only then is it
+	// worth asking which ones were unknown.
+	if total == 0 && len(sp.Tags) > 0 {
+		unknown, err := s.store.UnknownTags(r.Context(), sp.Tags)
+		if err != nil {
+			s.logger.Warn("failed to look up unknown tags", "error", err)
+		} else if len(unknown) > 0 {
+			resp.UnknownTags = &unknown
+		}
+	}
+	writeJSONWithETag(w, r, resp)
+}
+
+func (s *Server) CountAssets(w http.ResponseWriter, r *http.Request, params CountAssetsParams) {
+	if msg := s.searchComplexityError(getStringPtr(params.Q), derefStringSlice(params.Tag)); msg != "" {
+		writeError(w, http.StatusBadRequest, "bad_request", msg, nil)
+		return
+	}
+	lang, msg := searchLang(params.Lang)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, "bad_request", msg, nil)
+		return
+	}
+	sp := store.SearchParams{
+		Query:          getStringPtr(params.Q),
+		Tags:           derefStringSlice(params.Tag),
+		Mime:           getStringPtr(params.Mime),
+		CreatedAfter:   params.CreatedAfter,
+		CreatedBefore:  params.CreatedBefore,
+		IncludeDeleted: derefBool(params.IncludeDeleted, false),
+		Viewer:         s.viewer(r),
+		Lang:           lang,
+	}
+	total, err := s.store.CountAssets(r.Context(), sp)
+	if err != nil {
+		if writeQueryTimeout(w, err) {
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal", "failed to count", map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, AssetCountResponse{Total: total})
+}
+
+// GetAssetFacets counts the assets matching the search filters per creation-date
+// bucket or MIME type.
+func (s *Server) GetAssetFacets(w http.ResponseWriter, r *http.Request, params GetAssetFacetsParams) {
+	if params.Field != GetAssetFacetsParamsFieldCreatedAt && params.Field != GetAssetFacetsParamsFieldMime {
+		writeError(w, http.StatusBadRequest, "bad_request", "field must be created_at or mime", nil)
+		return
+	}
+	interval := GetAssetFacetsParamsIntervalMonth
+	if params.Interval != nil {
+		interval = *params.Interval
+	}
+	switch interval {
+	case GetAssetFacetsParamsIntervalYear, GetAssetFacetsParamsIntervalMonth, GetAssetFacetsParamsIntervalDay:
+	default:
+		writeError(w, http.StatusBadRequest, "bad_request", "interval must be year, month, or day", nil)
+		return
+	}
+	if msg := s.searchComplexityError(getStringPtr(params.Q), derefStringSlice(params.Tag)); msg != "" {
+		writeError(w, http.StatusBadRequest, "bad_request", msg, nil)
+		return
+	}
+	lang, msg := searchLang(params.Lang)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, "bad_request", msg, nil)
+		return
+	}
+	sp := store.SearchParams{
+		Query:          getStringPtr(params.Q),
+		Tags:           derefStringSlice(params.Tag),
+		Mime:           getStringPtr(params.Mime),
+		CreatedAfter:   params.CreatedAfter,
+		CreatedBefore:  params.CreatedBefore,
+		IncludeDeleted: derefBool(params.IncludeDeleted, false),
+		Viewer:         s.viewer(r),
+		Lang:           lang,
+	}
+	buckets, err := s.store.FacetAssets(r.Context(), sp, string(params.Field), string(interval))
+	if err != nil {
+		if writeQueryTimeout(w, err) {
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal", "failed to compute facets", map[string]any{"error": err.Error()})
+		return
+	}
+	resp := AssetFacetsResponse{Field: AssetFacetsResponseField(params.Field), Buckets: make([]FacetBucket, 0, len(buckets))}
+	if params.Field == GetAssetFacetsParamsFieldCreatedAt {
+		i := AssetFacetsResponseInterval(interval)
+		resp.Interval = &i
+	}
+	for _, b := range buckets {
+		resp.Buckets = append(resp.Buckets, FacetBucket{Key: b.Key, Count: b.Count})
 	}
 	writeJSON(w, http.StatusOK, resp)
 }
 
-func (s *Server) UploadAsset(w http.ResponseWriter, r *http.Request) {
-	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxUploadBytes+1024)
-	if err := r.ParseMultipartForm(s.cfg.MaxUploadBytes); err != nil {
+func (s *Server) UploadAsset(w http.ResponseWriter, r *http.Request, params UploadAssetParams) {
+	if d := params.OnDuplicate; d != nil && *d != UploadAssetParamsOnDuplicateConflict && *d != UploadAssetParamsOnDuplicateOk {
+		writeError(w, http.StatusBadRequest, "bad_request", "onDuplicate must be conflict or ok", nil)
+		return
+	}
+	if params.IfNoneMatch != nil {
+		sha, ok := ifNoneMatchSHA(*params.IfNoneMatch)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "bad_request", `If-None-Match must be a quoted hex SHA-256, e.g. "9f86d0..."`, nil)
+			return
+		}
+		// Answer before touching the body so a client using Expect: 100-continue
+		// never sends the bytes of a known duplicate.
+		existing, err := s.store.GetAssetByHash(r.Context(), sha)
+		if err == nil {
+			s.writeDuplicate(w, r, s.duplicateStatus(params.OnDuplicate), existing)
+			return
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			if writeCircuitError(w, err) {
+				return
+			}
+			writeError(w, http.StatusInternalServerError, "internal", "failed to look up asset by hash", map[string]any{"error": err.Error()})
+			return
+		}
+	}
+	limit := s.uploadBodyLimit()
+	if r.ContentLength > limit {
+		s.writeRequestTooLarge(w, limit)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	// Parts beyond MultipartMemory spill to temp files rather than being held in RAM.
+	if err := r.ParseMultipartForm(s.multipartMemory()); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			s.writeRequestTooLarge(w, limit)
+			return
+		}
 		writeError(w, http.StatusBadRequest, "bad_request", "failed to parse multipart", map[string]any{"error": err.Error()})
 		return
 	}
+	remapFormFields(r.MultipartForm, s.cfg.UploadFieldMap)
 	file, header, err := r.FormFile("file")
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "bad_request", "file is required", nil)
+		writeInvalidFields(w, []fieldError{{Field: "file", Message: "file is required"}})
 		return
 	}
 	defer file.Close()
 
-	save, err := s.media.Save(r.Context(), file, header.Filename, s.cfg.MaxUploadBytes, s.cfg.MaxPixels)
+	expectedSHA := getStringPtr(params.XContentSHA256)
+	if expectedSHA == "" {
+		expectedSHA = formValue(r.MultipartForm.Value, "sha256")
+	}
+
+	filename, displayName := sanitizeFilename(header.Filename, s.cfg.FilenameMaxLen)
+	save, discard, err := s.media.Save(r.Context(), file, filename, s.cfg.MaxUploadBytes, s.cfg.MaxPixels, expectedSHA)
 	if err != nil {
+		var infected *media.InfectedError
+		if errors.As(err, &infected) {
+			writeError(w, http.StatusUnprocessableEntity, "infected", "upload rejected by virus scan", map[string]any{"signature": infected.Signature})
+			return
+		}
+		if errors.Is(err, media.ErrScanFailed) {
+			s.logger.Error("virus scan failed", "error", err)
+			writeError(w, http.StatusServiceUnavailable, "scan_unavailable", "virus scan unavailable; try again later", nil)
+			return
+		}
+		if errors.Is(err, media.ErrDimensionsTooLarge) {
+			writeError(w, http.StatusBadRequest, "upload_failed", err.Error(), map[string]any{"maxWidth": s.cfg.MaxWidth, "maxHeight": s.cfg.MaxHeight})
+			return
+		}
+		if errors.Is(err, media.ErrTooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "file_too_large", err.Error(), nil)
+			return
+		}
 		status := http.StatusInternalServerError
 		switch err {
-		case media.ErrTooLarge:
-			status = http.StatusBadRequest
-		case media.ErrInvalidImage:
+		case media.ErrInvalidImage, media.ErrEmptyUpload, media.ErrTruncatedImage, media.ErrTooManyPixels:
 			status = http.StatusBadRequest
+		case media.ErrChecksumMismatch, media.ErrTransparency:
+			status = http.StatusUnprocessableEntity
 		}
 		writeError(w, status, "upload_failed", err.Error(), nil)
 		return
 	}
+	created := false
+	defer func() {
+		if !created {
+			s.discardSave(r.Context(), save.SHA256, discard)
+		}
+	}()
 
 	title := formValue(r.MultipartForm.Value, "title")
 	caption := formValue(r.MultipartForm.Value, "caption")
@@ -285,161 +776,1184 @@ func (s *Server) UploadAsset(w http.ResponseWriter, r *http.Request) {
 	source := formValue(r.MultipartForm.Value, "source")
 	usageNotes := formValue(r.MultipartForm.Value, "usageNotes")
 	tags := r.MultipartForm.Value["tags"]
-
-	// Validate field lengths
-	if len(title) > 255 {
-		writeError(w, http.StatusBadRequest, "bad_request", "title exceeds maximum length of 255 characters", nil)
-		return
+	visibility := formValue(r.MultipartForm.Value, "visibility")
+	var invalid []fieldError
+	if visibility != "" && !validVisibility(Visibility(visibility)) {
+		invalid = append(invalid, visibilityError)
 	}
-	if len(credit) > 255 {
-		writeError(w, http.StatusBadRequest, "bad_request", "credit exceeds maximum length of 255 characters", nil)
-		return
+
+	if derefBool(params.ImportMetadata, false) {
+		origPath := s.media.PathForVariant(save.SHA256, media.VariantOriginal, save.Ext)
+		meta, err := s.media.ExtractMetadata(origPath)
+		if err != nil {
+			s.logger.Warn("failed to read embedded metadata", "error", err, "sha256", save.SHA256)
+		} else {
+			if title == "" && len(meta.Title) <= 255 {
+				title = meta.Title
+			}
+			if caption == "" {
+				caption = meta.Caption
+			}
+			if credit == "" && len(meta.Credit) <= 255 {
+				credit = meta.Credit
+			}
+			for _, kw := range meta.Keywords {
+				if len(kw) <= 255 {
+					tags = append(tags, kw)
+				}
+			}
+		}
 	}
-	if len(source) > 255 {
-		writeError(w, http.StatusBadRequest, "bad_request", "source exceeds maximum length of 255 characters", nil)
+
+	if invalid = append(invalid, s.metadataErrors(title, credit, source, tags)...); len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
 		return
 	}
-	for _, tag := range tags {
-		if len(tag) > 255 {
-			writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("tag '%s' exceeds maximum length of 255 characters", tag), nil)
-			return
-		}
-	}
 
+	if filename == "" {
+		filename = save.SHA256 + save.Ext
+	}
 	assetInput := store.AssetCreate{
-		Title:            title,
-		Caption:          caption,
-		Credit:           credit,
-		Source:           source,
-		UsageNotes:       usageNotes,
-		Tags:             tags,
-		Width:            save.Width,
-		Height:           save.Height,
-		Bytes:            save.Bytes,
-		Mime:             save.Mime,
-		OriginalFilename: header.Filename,
-		SHA256:           save.SHA256,
+		Title:             title,
+		Caption:           caption,
+		Credit:            credit,
+		Source:            source,
+		UsageNotes:        usageNotes,
+		Tags:              tags,
+		Width:             save.Width,
+		Height:            save.Height,
+		Bytes:             save.Bytes,
+		Mime:              save.Mime,
+		OriginalFilename:  filename,
+		DisplayFilename:   displayName,
+		SHA256:            save.SHA256,
+		HashAlgo:          save.HashAlgo,
+		Preview:           save.Preview,
+		HasAlpha:          save.HasAlpha,
+		ColorSpace:        save.ColorSpace,
+		VariantState:      savedVariantState(save),
+		Visibility:        visibility,
+		AllowedPrincipals: r.MultipartForm.Value["allowedPrincipals"],
 	}
 
 	s.logger.Debug("upload asset", "title", assetInput.Title, "tagCount", len(assetInput.Tags))
 
+	persistStart := time.Now()
 	asset, err := s.store.CreateAsset(r.Context(), assetInput)
+	if s.cfg.ServerTiming {
+		w.Header().Set("Server-Timing", uploadServerTiming(save.Timings, time.Since(persistStart)))
+	}
 	if err != nil {
 		if errors.Is(err, store.ErrDuplicate) && asset != nil {
-			writeJSON(w, http.StatusConflict, s.toAPIAsset(asset))
+			s.writeDuplicate(w, r, s.duplicateStatus(params.OnDuplicate), asset)
+			return
+		}
+		if writeBlockedTags(w, err) {
+			return
+		}
+		if writeCircuitError(w, err) {
 			return
 		}
 		s.logger.Error("failed to create asset", "error", err, "title", assetInput.Title, "tags", assetInput.Tags)
 		writeError(w, http.StatusInternalServerError, "internal", "failed to persist asset", map[string]any{"error": err.Error()})
 		return
 	}
+	created = true
+	s.settleVariantState(r.Context(), asset)
 
 	writeJSON(w, http.StatusCreated, s.toAPIAsset(asset))
 }
 
-func (s *Server) GetAsset(w http.ResponseWriter, r *http.Request, id AssetId) {
-	asset, err := s.store.GetAsset(r.Context(), id, false)
-	if err != nil {
-		if errors.Is(err, store.ErrNotFound) {
-			writeError(w, http.StatusNotFound, "not_found", "asset not found", nil)
-			return
-		}
-		writeError(w, http.StatusInternalServerError, "internal", "failed to retrieve asset", map[string]any{"error": err.Error()})
+// writeDuplicate answers a request whose content matches existing with status and
+// the existing asset. A caller who may not view that asset gets a bare 409 instead,
+// which tells them only what they already know: the content they hold is stored.
+func (s *Server) writeDuplicate(w http.ResponseWriter, r *http.Request, status int, existing *store.Asset) {
+	if v := s.viewer(r); v != nil && !existing.CanView(*v) {
+		writeError(w, http.StatusConflict, "duplicate", "an asset with this content already exists", nil)
 		return
 	}
-	writeJSON(w, http.StatusOK, s.toAPIAsset(asset))
+	writeJSON(w, status, s.toAPIAsset(existing))
 }
 
-func (s *Server) UpdateAsset(w http.ResponseWriter, r *http.Request, id AssetId) {
-	var payload AssetUpdate
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		writeError(w, http.StatusBadRequest, "bad_request", "invalid json", nil)
+// discardSave removes the files Save newly stored for content sha once creating its
+// asset has failed. A concurrent upload of the same content may have created an asset
+// for them meanwhile, so they are kept if one exists or the lookup fails.
+func (s *Server) discardSave(ctx context.Context, sha string, discard func()) {
+	if _, err := s.store.GetAssetByHash(context.WithoutCancel(ctx), sha); !errors.Is(err, store.ErrNotFound) {
 		return
 	}
+	discard()
+}
 
-	// Validate field lengths
-	if payload.Title != nil && len(*payload.Title) > 255 {
-		writeError(w, http.StatusBadRequest, "bad_request", "title exceeds maximum length of 255 characters", nil)
-		return
+// savedVariantState is the variant state to store for an asset created from save.
+func savedVariantState(save *media.SaveResult) string {
+	if save.VariantsPending {
+		return store.VariantsPending
 	}
-	if payload.Credit != nil && len(*payload.Credit) > 255 {
-		writeError(w, http.StatusBadRequest, "bad_request", "credit exceeds maximum length of 255 characters", nil)
+	return store.VariantsReady
+}
+
+// settleVariantState catches derivatives that finished between Save queueing them
+// and the asset row being inserted, whose VariantsDone update had no row to change.
+func (s *Server) settleVariantState(ctx context.Context, a *store.Asset) {
+	if a.VariantState != store.VariantsPending || s.media.VariantsPending(a.SHA256) {
 		return
 	}
-	if payload.Source != nil && len(*payload.Source) > 255 {
-		writeError(w, http.StatusBadRequest, "bad_request", "source exceeds maximum length of 255 characters", nil)
+	state := store.VariantsFailed
+	if fileExists(s.media.PathForVariant(a.SHA256, media.VariantThumb, ".webp")) && fileExists(s.media.PathForVariant(a.SHA256, media.VariantContent, ".webp")) {
+		state = store.VariantsReady
+	}
+	if err := s.store.SetVariantState(ctx, a.SHA256, state); err != nil {
+		s.logger.Warn("failed to record variant state", "assetId", a.ID, "sha256", a.SHA256, "error", err)
 		return
 	}
-	if payload.Tags != nil {
-		for _, tag := range *payload.Tags {
-			if len(tag) > 255 {
-				writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("tag '%s' exceeds maximum length of 255 characters", tag), nil)
-				return
-			}
-		}
+	a.VariantState = state
+}
+
+// variantStatus reports which of an asset's variants can be served. Originals are
+// stored before the asset exists; derivatives follow the stored variant state.
+func variantStatus(a *store.Asset) AssetVariantStatus {
+	derived := VariantStateReady
+	switch a.VariantState {
+	case store.VariantsPending:
+		derived = VariantStatePending
+	case store.VariantsFailed:
+		derived = VariantStateMissing
 	}
+	return AssetVariantStatus{Original: VariantStateReady, Thumb: derived, Content: derived}
+}
 
-	upd := store.AssetUpdate{
-		Title:      payload.Title,
-		Caption:    payload.Caption,
-		Credit:     payload.Credit,
-		Source:     payload.Source,
-		UsageNotes: payload.UsageNotes,
-		Tags:       payload.Tags,
+// ReferenceAsset creates an asset for an original an earlier upload already stored,
+// so a client that hashed a file locally can skip sending the bytes again.
+func (s *Server) ReferenceAsset(w http.ResponseWriter, r *http.Request) {
+	var payload AssetReference
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&payload); err != nil {
+		msg, details := describeJSONError(err)
+		writeError(w, http.StatusBadRequest, "bad_request", msg, details)
+		return
 	}
-	asset, err := s.store.UpdateAsset(r.Context(), id, upd)
+	var invalid []fieldError
+	if payload.Visibility != nil && !validVisibility(*payload.Visibility) {
+		invalid = append(invalid, visibilityError)
+	}
+	title := getStringPtr(payload.Title)
+	credit := getStringPtr(payload.Credit)
+	source := getStringPtr(payload.Source)
+	tags := derefStringSlice(payload.Tags)
+	if invalid = append(invalid, s.metadataErrors(title, credit, source, tags)...); len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+
+	save, err := s.media.Stored(strings.ToLower(strings.TrimSpace(payload.Sha256)))
 	if err != nil {
-		if errors.Is(err, store.ErrNotFound) {
-			writeError(w, http.StatusNotFound, "not_found", "asset not found", nil)
+		if errors.Is(err, media.ErrNotStored) {
+			writeError(w, http.StatusNotFound, "not_stored", "no content with this sha256 is stored; upload the file instead", nil)
 			return
 		}
-		writeError(w, http.StatusInternalServerError, "internal", "failed to update asset", map[string]any{"error": err.Error()})
+		if errors.Is(err, media.ErrTransparency) {
+			writeError(w, http.StatusUnprocessableEntity, "upload_failed", err.Error(), nil)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal", "failed to read stored original", map[string]any{"error": err.Error()})
 		return
 	}
-	writeJSON(w, http.StatusOK, s.toAPIAsset(asset))
-}
 
-func (s *Server) DeleteAsset(w http.ResponseWriter, r *http.Request, id AssetId) {
-	if err := s.store.DeleteAsset(r.Context(), id); err != nil {
-		if errors.Is(err, store.ErrNotFound) {
-			writeError(w, http.StatusNotFound, "not_found", "asset not found", nil)
+	visibility := ""
+	if payload.Visibility != nil {
+		visibility = string(*payload.Visibility)
+	}
+	filename, displayName := sanitizeFilename(getStringPtr(payload.OriginalFilename), s.cfg.FilenameMaxLen)
+	if filename == "" {
+		filename = save.SHA256 + save.Ext
+	}
+	asset, err := s.store.CreateAsset(r.Context(), store.AssetCreate{
+		Title:             title,
+		Caption:           getStringPtr(payload.Caption),
+		Credit:            credit,
+		Source:            source,
+		UsageNotes:        getStringPtr(payload.UsageNotes),
+		Tags:              tags,
+		Width:             save.Width,
+		Height:            save.Height,
+		Bytes:             save.Bytes,
+		Mime:              save.Mime,
+		OriginalFilename:  filename,
+		DisplayFilename:   displayName,
+		SHA256:            save.SHA256,
+		HashAlgo:          save.HashAlgo,
+		Preview:           save.Preview,
+		HasAlpha:          save.HasAlpha,
+		ColorSpace:        save.ColorSpace,
+		Visibility:        visibility,
+		AllowedPrincipals: derefStringSlice(payload.AllowedPrincipals),
+	})
+	if err != nil {
+		if errors.Is(err, store.ErrDuplicate) && asset != nil {
+			s.writeDuplicate(w, r, http.StatusOK, asset)
 			return
 		}
-		writeError(w, http.StatusInternalServerError, "internal", "failed to delete asset", map[string]any{"error": err.Error()})
+		if writeBlockedTags(w, err) {
+			return
+		}
+		if writeCircuitError(w, err) {
+			return
+		}
+		s.logger.Error("failed to create asset", "error", err, "sha256", save.SHA256)
+		writeError(w, http.StatusInternalServerError, "internal", "failed to persist asset", map[string]any{"error": err.Error()})
 		return
 	}
-	w.WriteHeader(http.StatusNoContent)
+
+	writeJSON(w, http.StatusCreated, s.toAPIAsset(asset))
 }
 
-func (s *Server) ListTags(w http.ResponseWriter, r *http.Request, params ListTagsParams) {
-	page := derefInt(params.Page, 1)
-	if page < 1 {
-		page = 1
+func (s *Server) GetAsset(w http.ResponseWriter, r *http.Request, id AssetId) {
+	asset, ok := s.visibleAsset(w, r, id)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.toAPIAsset(asset))
+}
+
+// BatchGetAssets returns several assets in request order. Assets the caller may not
+// view are reported as missing, like a 404 from GetAsset.
+func (s *Server) BatchGetAssets(w http.ResponseWriter, r *http.Request) {
+	var payload BatchGetRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid json", nil)
+		return
+	}
+	if len(payload.Ids) == 0 {
+		writeError(w, http.StatusBadRequest, "bad_request", "ids must not be empty", nil)
+		return
+	}
+	if len(payload.Ids) > maxBatchGetIDs {
+		writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("at most %d ids per request", maxBatchGetIDs), nil)
+		return
+	}
+
+	assets, err := s.store.GetAssetsByIDs(r.Context(), payload.Ids)
+	if err != nil {
+		if writeCircuitError(w, err) {
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal", "failed to retrieve assets", map[string]any{"error": err.Error()})
+		return
+	}
+
+	viewer := s.viewer(r)
+	found := make(map[int64]bool, len(assets))
+	resp := BatchGetResponse{Items: make([]Asset, 0, len(assets)), Missing: []int64{}}
+	for i := range assets {
+		if viewer != nil && !assets[i].CanView(*viewer) {
+			continue
+		}
+		found[assets[i].ID] = true
+		resp.Items = append(resp.Items, s.toAPIAsset(&assets[i]))
+	}
+	seen := make(map[int64]bool, len(payload.Ids))
+	for _, id := range payload.Ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if !found[id] {
+			resp.Missing = append(resp.Missing, id)
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) RotateApiKey(w http.ResponseWriter, r *http.Request, id string) {
+	secret, err := s.apiKeys.Rotate(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrAPIKeyNotFound):
+			writeError(w, http.StatusNotFound, "not_found", "api key not found", nil)
+		case errors.Is(err, ErrAPIKeyAmbiguous):
+			writeError(w, http.StatusConflict, "conflict", err.Error(), nil)
+		default:
+			writeError(w, http.StatusInternalServerError, "internal", "failed to rotate api key", map[string]any{"error": err.Error()})
+		}
+		return
+	}
+	by := ""
+	if principal, ok := PrincipalFromContext(r.Context()); ok {
+		by = principal.ID
+	}
+	s.logger.Info("api key rotated", "id", id, "by", by)
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, ApiKeyRotation{Id: id, Key: secret})
+}
+
+func (s *Server) GetAssetExif(w http.ResponseWriter, r *http.Request, id AssetId) {
+	asset, ok := s.visibleAsset(w, r, id)
+	if !ok {
+		return
+	}
+	tags, err := s.media.EXIF(asset.SHA256, s.originalPath(asset))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			writeError(w, http.StatusNotFound, "not_found", "original not found", nil)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal", "failed to read metadata", map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, AssetExif(tags))
+}
+
+// ListAssetVersions returns the metadata states earlier edits replaced, newest first.
+// Versions the caller could not have seen at the time are left out.
+func (s *Server) ListAssetVersions(w http.ResponseWriter, r *http.Request, id AssetId) {
+	if _, ok := s.visibleAsset(w, r, id); !ok {
+		return
+	}
+	versions, err := s.store.ListVersions(r.Context(), id)
+	if err != nil {
+		if writeCircuitError(w, err) {
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal", "failed to list versions", map[string]any{"error": err.Error()})
+		return
+	}
+	viewer := s.viewer(r)
+	items := make([]AssetVersion, 0, len(versions))
+	for i := range versions {
+		if viewer != nil && !versions[i].CanView(*viewer) {
+			continue
+		}
+		items = append(items, toAPIVersion(&versions[i]))
+	}
+	writeJSON(w, http.StatusOK, AssetVersionList{Items: items})
+}
+
+// GetAssetVersion returns one earlier version of an asset. A version the caller could
+// not have seen at the time is reported as not found.
+func (s *Server) GetAssetVersion(w http.ResponseWriter, r *http.Request, id AssetId, version int) {
+	if _, ok := s.visibleAsset(w, r, id); !ok {
+		return
+	}
+	v, err := s.store.GetVersion(r.Context(), id, version)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", "version not found", nil)
+			return
+		}
+		if writeCircuitError(w, err) {
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal", "failed to retrieve version", map[string]any{"error": err.Error()})
+		return
+	}
+	if viewer := s.viewer(r); viewer != nil && !v.CanView(*viewer) {
+		writeError(w, http.StatusNotFound, "not_found", "version not found", nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, toAPIVersion(v))
+}
+
+// visibleAsset returns live asset id if the caller may see it, writing a 404 or 500
+// and returning false when not. Reads go through it, and routes that change an asset
+// check it first, so a caller cannot change, or learn of, a private asset they could
+// not GET.
+func (s *Server) visibleAsset(w http.ResponseWriter, r *http.Request, id AssetId) (*store.Asset, bool) {
+	asset, err := s.store.GetAsset(r.Context(), id, false)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", "asset not found", nil)
+			return nil, false
+		}
+		if writeCircuitError(w, err) {
+			return nil, false
+		}
+		writeError(w, http.StatusInternalServerError, "internal", "failed to retrieve asset", map[string]any{"error": err.Error()})
+		return nil, false
+	}
+	if v := s.viewer(r); v != nil && !asset.CanView(*v) {
+		writeError(w, http.StatusNotFound, "not_found", "asset not found", nil)
+		return nil, false
+	}
+	return asset, true
+}
+
+func toAPIVersion(v *store.AssetVersion) AssetVersion {
+	out := AssetVersion{
+		Version:    v.Version,
+		Title:      v.Title,
+		Caption:    v.Caption,
+		Credit:     v.Credit,
+		Source:     v.Source,
+		UsageNotes: v.UsageNotes,
+		Tags:       v.Tags,
+		Visibility: Visibility(v.Visibility),
+		Sha256:     v.SHA256,
+		ValidFrom:  v.ValidFrom,
+		ReplacedAt: v.ReplacedAt,
+		ReplacedBy: v.ReplacedBy,
+	}
+	if v.Visibility == store.VisibilityPrivate {
+		allowed := append([]string{}, v.AllowedPrincipals...)
+		out.AllowedPrincipals = &allowed
+	}
+	return out
+}
+
+// DownloadAsset serves the original as an attachment named after its sanitized
+// filename. http.ServeContent answers Range, If-Range, If-None-Match, and
+// If-Modified-Since requests against the content-addressed ETag.
+func (s *Server) DownloadAsset(w http.ResponseWriter, r *http.Request, id AssetId) {
+	asset, ok := s.visibleAsset(w, r, id)
+	if !ok {
+		return
+	}
+	path := s.originalPath(asset)
+	file, err := media.OpenOriginal(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			s.logger.Error("media file missing from storage", "assetId", asset.ID, "sha256", asset.SHA256, "variant", media.VariantOriginal, "path", path)
+			writeError(w, s.missingMediaStatus(), "file_missing", "media file missing from storage", nil)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal", "failed to open media", map[string]any{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+	mimeType, err := mediaContentType(GetMediaVariantParamsVariantOriginal, file, path, asset.Mime)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal", "failed to read media", map[string]any{"error": err.Error()})
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", mimeType)
+	h.Set("ETag", fmt.Sprintf("\"%s-original\"", asset.SHA256))
+	// The bytes behind an asset never change, but they sit behind authentication.
+	h.Set("Cache-Control", "private, max-age=31536000, immutable")
+	name := attachmentFilename(asset.OriginalFilename, filepath.Ext(path))
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	http.ServeContent(w, r, "", file.ModTime(), file)
+}
+
+func (s *Server) UpdateAsset(w http.ResponseWriter, r *http.Request, id AssetId) {
+	payload, err := decodeMergePatch(r.Body)
+	if err != nil {
+		msg, details := describeJSONError(err)
+		writeError(w, http.StatusBadRequest, "bad_request", msg, details)
+		return
+	}
+	if invalid := updateErrors(payload); len(invalid) > 0 {
+		writeInvalidFields(w, invalid)
+		return
+	}
+	if _, ok := s.visibleAsset(w, r, id); !ok {
+		return
+	}
+
+	upd := store.AssetUpdate{
+		Title:             payload.Title,
+		Caption:           payload.Caption,
+		Credit:            payload.Credit,
+		Source:            payload.Source,
+		UsageNotes:        payload.UsageNotes,
+		Tags:              payload.Tags,
+		AddTags:           derefStringSlice(payload.AddTags),
+		RemoveTags:        derefStringSlice(payload.RemoveTags),
+		Visibility:        (*string)(payload.Visibility),
+		AllowedPrincipals: payload.AllowedPrincipals,
+		By:                s.principalID(r),
+	}
+	asset, err := s.store.UpdateAsset(r.Context(), id, upd)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", "asset not found", nil)
+			return
+		}
+		if errors.Is(err, store.ErrImmutable) {
+			writeImmutable(w)
+			return
+		}
+		if writeBlockedTags(w, err) {
+			return
+		}
+		if writeCircuitError(w, err) {
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal", "failed to update asset", map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.toAPIAsset(asset))
+}
+
+func (s *Server) DeleteAsset(w http.ResponseWriter, r *http.Request, id AssetId, params DeleteAssetParams) {
+	reason := strings.TrimSpace(getStringPtr(params.Reason))
+	if len(reason) > maxDeletionReasonLen {
+		writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("reason exceeds maximum length of %d characters", maxDeletionReasonLen), nil)
+		return
+	}
+	if _, ok := s.visibleAsset(w, r, id); !ok {
+		return
+	}
+	if err := s.store.DeleteAsset(r.Context(), id, s.principalID(r), reason); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", "asset not found", nil)
+			return
+		}
+		if errors.Is(err, store.ErrImmutable) {
+			writeImmutable(w)
+			return
+		}
+		if writeCircuitError(w, err) {
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal", "failed to delete asset", map[string]any{"error": err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) SetAssetImmutable(w http.ResponseWriter, r *http.Request, id AssetId) {
+	var payload AssetImmutability
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid json", nil)
+		return
+	}
+	if _, ok := s.visibleAsset(w, r, id); !ok {
+		return
+	}
+	asset, err := s.store.SetImmutable(r.Context(), id, payload.Immutable)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", "asset not found", nil)
+			return
+		}
+		if writeCircuitError(w, err) {
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal", "failed to update asset", map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.toAPIAsset(asset))
+}
+
+// decodeMergePatch decodes an AssetUpdate with RFC 7386 semantics. encoding/json maps
+// both an absent member and an explicit null to a nil pointer, so members sent as
+// null are found in a second pass and turned into "clear" values.
+func decodeMergePatch(body io.Reader) (AssetUpdate, error) {
+	var payload AssetUpdate
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return payload, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&payload); err != nil {
+		return payload, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return payload, errTrailingJSON
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return payload, err
+	}
+	empty := ""
+	for name, raw := range members {
+		if string(bytes.TrimSpace(raw)) != "null" {
+			continue
+		}
+		switch name {
+		case "title":
+			payload.Title = &empty
+		case "caption":
+			payload.Caption = &empty
+		case "credit":
+			payload.Credit = &empty
+		case "source":
+			payload.Source = &empty
+		case "usageNotes":
+			payload.UsageNotes = &empty
+		case "tags":
+			payload.Tags = &[]string{}
+		}
+	}
+	return payload, nil
+}
+
+var errTrailingJSON = errors.New("unexpected data after JSON object")
+
+// describeJSONError turns a request body decode error into a client-facing message,
+// naming the offending field where there is one.
+func describeJSONError(err error) (string, map[string]any) {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, errTrailingJSON):
+		return err.Error(), nil
+	case errors.As(err, &syntaxErr):
+		return "invalid json", map[string]any{"offset": syntaxErr.Offset, "error": syntaxErr.Error()}
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return fmt.Sprintf("field %q must be %s", typeErr.Field, jsonKind(typeErr.Type)), map[string]any{"field": typeErr.Field}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		return fmt.Sprintf("unknown field %q", field), map[string]any{"field": field}
+	}
+	return "invalid json", nil
+}
+
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.Pointer:
+		return jsonKind(t.Elem())
+	}
+	return "a number"
+}
+
+// viewer returns the principal id that search and read results are filtered for, or
+// nil when no filtering applies (auth disabled, or the caller has can_admin).
+func (s *Server) viewer(r *http.Request) *string {
+	if s.cfg.AuthMode == config.AuthNone {
+		return nil
+	}
+	principal, ok := PrincipalFromContext(r.Context())
+	if !ok {
+		anonymous := ""
+		return &anonymous
+	}
+	if principal.HasPermission(PermCanAdmin) {
+		return nil
+	}
+	return &principal.ID
+}
+
+// principalID returns the id of the authenticated caller, or "" when auth is disabled.
+func (s *Server) principalID(r *http.Request) string {
+	if principal, ok := PrincipalFromContext(r.Context()); ok {
+		return principal.ID
+	}
+	return ""
+}
+
+// canViewDeletion reports whether the caller may see who deleted an asset and why.
+func (s *Server) canViewDeletion(r *http.Request) bool {
+	if s.cfg.AuthMode == config.AuthNone {
+		return true
+	}
+	principal, ok := PrincipalFromContext(r.Context())
+	return ok && (principal.HasPermission(PermCanViewDeleted) || principal.HasPermission(PermCanAdmin))
+}
+
+// requestPrincipal returns the caller's principal. Routes without authMiddleware (public
+// media) still honour an X-Api-Key header so private assets can be fetched there.
+func (s *Server) requestPrincipal(r *http.Request) (*Principal, bool) {
+	if principal, ok := PrincipalFromContext(r.Context()); ok {
+		return principal, true
+	}
+	if !s.cfg.AuthMode.Has(config.AuthAPIKey) || s.apiKeys == nil {
+		return nil, false
+	}
+	apiKey := strings.TrimSpace(r.Header.Get("X-Api-Key"))
+	if apiKey == "" {
+		return nil, false
+	}
+	entry, ok := s.apiKeys.Lookup(apiKey)
+	if !ok || entry.ValidAt(time.Now()) != nil {
+		return nil, false
+	}
+	return newPrincipalFromAPIKey(entry), true
+}
+
+func validVisibility(v Visibility) bool {
+	return v == Public || v == Private
+}
+
+// uploadServerTiming formats the phases of an upload as a Server-Timing header value,
+// in milliseconds.
+func uploadServerTiming(t media.SaveTimings, persist time.Duration) string {
+	phases := []struct {
+		name string
+		dur  time.Duration
+	}{
+		{"save", t.Write},
+		{"decode", t.Decode},
+		{"variants", t.Variants},
+		{"persist", persist},
+	}
+	parts := make([]string, len(phases))
+	for i, p := range phases {
+		parts[i] = fmt.Sprintf("%s;dur=%.3f", p.name, float64(p.dur)/float64(time.Millisecond))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// searchComplexityError rejects searches past GANACHE_MAX_SEARCH_TAGS or
+// GANACHE_MAX_SEARCH_QUERY_LENGTH, since every tag adds to the IN list and HAVING
+// count the query is built from. It returns "" when the search is within limits.
+func (s *Server) searchComplexityError(query string, tags []string) string {
+	if len([]rune(query)) > s.cfg.MaxSearchQueryLen {
+		return fmt.Sprintf("q exceeds maximum length of %d characters", s.cfg.MaxSearchQueryLen)
+	}
+	if len(tags) > s.cfg.MaxSearchTags {
+		return fmt.Sprintf("at most %d tags per search", s.cfg.MaxSearchTags)
+	}
+	return ""
+}
+
+// searchLang checks the lang search parameter and returns the language to stem the
+// query in, "" for none, or a message explaining why it was rejected.
+func searchLang(lang *string) (string, string) {
+	if lang == nil || *lang == "" {
+		return "", ""
+	}
+	if !store.SupportsLanguage(*lang) {
+		return "", fmt.Sprintf("lang must be one of %s", strings.Join(store.SearchLanguages, ", "))
+	}
+	return *lang, ""
+}
+
+// fieldError is a field of a well-formed request whose value breaks a rule.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+var visibilityError = fieldError{Field: "visibility", Message: "visibility must be public or private"}
+
+// writeInvalidFields rejects a request that parsed but whose content is invalid with
+// 422, listing every offending field in details.fields. Requests that do not parse
+// get 400 instead.
+func writeInvalidFields(w http.ResponseWriter, errs []fieldError) {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Message
+	}
+	writeError(w, http.StatusUnprocessableEntity, "invalid_fields", strings.Join(msgs, "; "), map[string]any{"fields": errs})
+}
+
+// fieldLengthErrors lists the metadata fields that do not fit their columns, one
+// entry per offending tag.
+func fieldLengthErrors(title, credit, source string, tags []string) []fieldError {
+	var errs []fieldError
+	for _, f := range []struct{ name, value string }{{"title", title}, {"credit", credit}, {"source", source}} {
+		if len(f.value) > 255 {
+			errs = append(errs, fieldError{Field: f.name, Message: f.name + " exceeds maximum length of 255 characters"})
+		}
+	}
+	return append(errs, tagLengthErrors("tags", tags)...)
+}
+
+func tagLengthErrors(field string, tags []string) []fieldError {
+	var errs []fieldError
+	for _, tag := range tags {
+		if len(tag) > 255 {
+			errs = append(errs, fieldError{Field: field, Message: fmt.Sprintf("tag '%s' exceeds maximum length of 255 characters", tag)})
+		}
+	}
+	return errs
+}
+
+// fieldLengthError describes the first metadata field that does not fit its column,
+// or returns "" when all of them do.
+func fieldLengthError(title, credit, source string, tags []string) string {
+	if errs := fieldLengthErrors(title, credit, source, tags); len(errs) > 0 {
+		return errs[0].Message
+	}
+	return ""
+}
+
+// metadataErrors validates the metadata of a new asset: column lengths, and the
+// fields GANACHE_REQUIRE_TITLE and GANACHE_REQUIRE_CREDIT demand.
+func (s *Server) metadataErrors(title, credit, source string, tags []string) []fieldError {
+	errs := fieldLengthErrors(title, credit, source, tags)
+	for _, field := range s.missingRequiredFields(title, credit) {
+		errs = append(errs, fieldError{Field: field, Message: field + " is required"})
+	}
+	return errs
+}
+
+// updateErrors validates a metadata patch. Fields it leaves out are not checked.
+func updateErrors(p AssetUpdate) []fieldError {
+	var errs []fieldError
+	if p.Tags != nil && (p.AddTags != nil || p.RemoveTags != nil) {
+		errs = append(errs, fieldError{Field: "tags", Message: "tags cannot be combined with addTags or removeTags"})
+	}
+	if p.Visibility != nil && !validVisibility(*p.Visibility) {
+		errs = append(errs, visibilityError)
+	}
+	errs = append(errs, fieldLengthErrors(getStringPtr(p.Title), getStringPtr(p.Credit), getStringPtr(p.Source), derefStringSlice(p.Tags))...)
+	errs = append(errs, tagLengthErrors("addTags", derefStringSlice(p.AddTags))...)
+	return append(errs, tagLengthErrors("removeTags", derefStringSlice(p.RemoveTags))...)
+}
+
+// missingRequiredFields lists the upload fields that GANACHE_REQUIRE_TITLE and
+// GANACHE_REQUIRE_CREDIT demand but the request left blank.
+func (s *Server) missingRequiredFields(title, credit string) []string {
+	var missing []string
+	if s.cfg.RequireTitle && strings.TrimSpace(title) == "" {
+		missing = append(missing, "title")
+	}
+	if s.cfg.RequireCredit && strings.TrimSpace(credit) == "" {
+		missing = append(missing, "credit")
+	}
+	return missing
+}
+
+// ifNoneMatchSHA extracts the lowercased content hash, of any supported algorithm,
+// from an If-None-Match value. The entity tag must be quoted; a weak W/ prefix is
+// tolerated.
+func ifNoneMatchSHA(v string) (string, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+	if len(v) < 2 || v[0] != '"' || v[len(v)-1] != '"' {
+		return "", false
+	}
+	sha := strings.ToLower(v[1 : len(v)-1])
+	if media.HashAlgoOf(sha) == "" {
+		return "", false
+	}
+	return sha, true
+}
+
+// skipUnfilteredSearch reports whether GANACHE_EMPTY_QUERY_BEHAVIOR=none applies to
+// sp: a search with no query text, tag, MIME type, or date range finds nothing.
+func (s *Server) skipUnfilteredSearch(sp store.SearchParams) bool {
+	if s.cfg.EmptyQuery != config.EmptyQueryNone {
+		return false
+	}
+	return strings.TrimSpace(sp.Query) == "" && len(store.NormalizeTags(sp.Tags)) == 0 && sp.Mime == "" && sp.CreatedAfter == nil && sp.CreatedBefore == nil
+}
+
+// duplicateStatus picks the status for an upload that matched an existing asset: the
+// request's onDuplicate wins, then GANACHE_DUPLICATE_RESPONSE. 409 is the default.
+func (s *Server) duplicateStatus(onDuplicate *UploadAssetParamsOnDuplicate) int {
+	mode := string(s.cfg.DuplicateResponse)
+	if onDuplicate != nil {
+		mode = string(*onDuplicate)
+	}
+	if mode == string(config.DuplicateOK) {
+		return http.StatusOK
+	}
+	return http.StatusConflict
+}
+
+func writeImmutable(w http.ResponseWriter) {
+	writeError(w, http.StatusConflict, "immutable", "asset is immutable; clear the flag before editing or deleting it", nil)
+}
+
+func (s *Server) BulkDeleteAssets(w http.ResponseWriter, r *http.Request) {
+	var payload BulkDeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request", "invalid json", nil)
+		return
+	}
+	if len(payload.Ids) == 0 {
+		writeError(w, http.StatusBadRequest, "bad_request", "ids must not be empty", nil)
+		return
+	}
+	if len(payload.Ids) > maxBulkDeleteIDs {
+		writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("at most %d ids per request", maxBulkDeleteIDs), nil)
+		return
+	}
+	reason := strings.TrimSpace(getStringPtr(payload.Reason))
+	if len(reason) > maxDeletionReasonLen {
+		writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("reason exceeds maximum length of %d characters", maxDeletionReasonLen), nil)
+		return
+	}
+
+	statuses, err := s.store.BulkDelete(r.Context(), payload.Ids, s.viewer(r), s.principalID(r), reason)
+	if err != nil {
+		if writeCircuitError(w, err) {
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal", "failed to delete assets", map[string]any{"error": err.Error()})
+		return
+	}
+
+	resp := BulkDeleteResponse{Results: make([]BulkDeleteResult, 0, len(statuses))}
+	seen := make(map[int64]bool, len(statuses))
+	for _, id := range payload.Ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		status := statuses[id]
+		resp.Results = append(resp.Results, BulkDeleteResult{Id: id, Status: BulkDeleteResultStatus(status)})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) ListTags(w http.ResponseWriter, r *http.Request, params ListTagsParams) {
+	page, size, msg := s.pagination(params.Page, params.PageSize)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, "bad_request", msg, nil)
+		return
+	}
+
+	tags, total, err := s.store.ListTags(r.Context(), getStringPtr(params.Namespace), getStringPtr(params.Prefix), page, size)
+	if err != nil {
+		if writeCircuitError(w, err) {
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal", "failed to list tags", map[string]any{"error": err.Error()})
+		return
+	}
+	resp := TagListResponse{Items: make([]Tag, 0, len(tags)), Page: page, PageSize: size, Total: total}
+	for _, t := range tags {
+		resp.Items = append(resp.Items, Tag{Name: t})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// ListRelatedTags ranks the tags that share live assets with name.
+func (s *Server) ListRelatedTags(w http.ResponseWriter, r *http.Request, name string, params ListRelatedTagsParams) {
+	page, size, msg := s.pagination(params.Page, params.PageSize)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, "bad_request", msg, nil)
+		return
 	}
 
-	size := derefInt(params.PageSize, 100)
-	if size < 1 {
-		size = 1
+	related, total, err := s.store.RelatedTags(r.Context(), name, s.viewer(r), page, size)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "not_found", "tag not found", nil)
+			return
+		}
+		if writeQueryTimeout(w, err) {
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal", "failed to list related tags", map[string]any{"error": err.Error()})
+		return
+	}
+	resp := RelatedTagsResponse{Tag: store.NormalizeTag(name), Items: make([]RelatedTag, 0, len(related)), Page: page, PageSize: size, Total: total}
+	for _, t := range related {
+		resp.Items = append(resp.Items, RelatedTag{Name: t.Name, Count: t.Count})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// NormalizeTags reports how raw tags would be stored, without touching the database,
+// so editors can see collisions such as "Portrait" and "portrait" before saving.
+func (s *Server) NormalizeTags(w http.ResponseWriter, r *http.Request) {
+	var payload TagNormalizeRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&payload); err != nil {
+		msg, details := describeJSONError(err)
+		writeError(w, http.StatusBadRequest, "bad_request", msg, details)
+		return
+	}
+	if len(payload.Tags) == 0 {
+		writeError(w, http.StatusBadRequest, "bad_request", "tags must not be empty", nil)
+		return
+	}
+	if len(payload.Tags) > maxNormalizeTags {
+		writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("at most %d tags per request", maxNormalizeTags), nil)
+		return
+	}
+	resp := TagNormalizeResponse{Results: make([]TagNormalization, 0, len(payload.Tags)), Tags: store.NormalizeTags(payload.Tags)}
+	for _, t := range payload.Tags {
+		resp.Results = append(resp.Results, TagNormalization{Input: t, Normalized: store.NormalizeTag(t)})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// StreamEvents sends the caller every event for an asset it may view, so a private
+// asset's events reach only the principals on its access list.
+func (s *Server) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	ch, cancel := s.events.Subscribe(eventsClientBuffer)
+	defer cancel()
+	viewer := s.viewer(r)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.WriteString(w, "retry: 3000\n\n"); err != nil {
+		return
+	}
+	if err := rc.Flush(); err != nil {
+		s.logger.Error("event stream not flushable", "error", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case ev, ok := <-ch:
+			if !ok {
+				// Dropped for falling behind; the client reconnects.
+				return
+			}
+			if viewer != nil && !s.eventVisible(r.Context(), ev, *viewer) {
+				continue
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				s.logger.Error("failed to encode event", "error", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// eventVisible reports whether viewer may see the asset ev is about, deleted or not.
+// An asset that cannot be read, or a lookup that fails, hides the event.
+func (s *Server) eventVisible(ctx context.Context, ev events.Event, viewer string) bool {
+	ctx, done := s.queryContext(ctx)
+	defer done()
+	asset, err := s.store.GetAsset(ctx, ev.AssetID, true)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) && ctx.Err() == nil {
+			s.logger.Warn("failed to check event visibility", "assetId", ev.AssetID, "error", err)
+		}
+		return false
+	}
+	return asset.CanView(viewer)
+}
+
+// WaitForAssets is a long-poll alternative to StreamEvents. It answers as soon as
+// assets newer than the cursor exist, waking on asset.created events, or with none
+// once the timeout elapses.
+func (s *Server) WaitForAssets(w http.ResponseWriter, r *http.Request, params WaitForAssetsParams) {
+	timeout := min(waitDefaultTimeout, s.cfg.LongPollMaxTimeout)
+	if params.Timeout != nil {
+		d, err := time.ParseDuration(*params.Timeout)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "bad_request", "timeout must be a positive duration such as 30s", nil)
+			return
+		}
+		timeout = min(d, s.cfg.LongPollMaxTimeout)
+	}
+	if params.Since != nil && *params.Since < 0 {
+		writeError(w, http.StatusBadRequest, "bad_request", "since must not be negative", nil)
+		return
+	}
+
+	// Subscribing before the first look means an asset created in between still
+	// wakes the wait.
+	ch, cancel := s.events.Subscribe(eventsClientBuffer)
+	defer cancel()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	recheck := time.NewTicker(waitRecheck)
+	defer recheck.Stop()
+
+	var since int64
+	if params.Since != nil {
+		since = *params.Since
+	} else {
+		ctx, done := s.queryContext(r.Context())
+		latest, err := s.store.LatestAssetID(ctx)
+		done()
+		if err != nil {
+			if writeQueryTimeout(w, err) {
+				return
+			}
+			writeError(w, http.StatusInternalServerError, "internal", "failed to list assets", map[string]any{"error": err.Error()})
+			return
+		}
+		since = latest
+	}
+
+	viewer := s.viewer(r)
+	assets, cursor, err := waitForAssets(r.Context(), since, ch, deadline.C, recheck.C, func(since int64) ([]store.Asset, int64, error) {
+		ctx, done := s.queryContext(r.Context())
+		defer done()
+		return s.store.AssetsAfter(ctx, since, viewer, maxWaitAssets)
+	})
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		if writeQueryTimeout(w, err) {
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal", "failed to list assets", map[string]any{"error": err.Error()})
+		return
 	}
-	if size > 500 {
-		size = 500
+	resp := AssetWaitResponse{Items: make([]Asset, 0, len(assets)), Cursor: cursor}
+	for i := range assets {
+		resp.Items = append(resp.Items, s.toAPIAsset(&assets[i]))
 	}
+	writeJSON(w, http.StatusOK, resp)
+}
 
-	tags, total, err := s.store.ListTags(r.Context(), getStringPtr(params.Prefix), page, size)
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, "internal", "failed to list tags", map[string]any{"error": err.Error()})
-		return
+// waitForAssets is WaitForAssets' loop. It calls fetch with the cursor until fetch
+// returns assets, looking again on every asset.created event from ch and every tick
+// of recheck, and gives up with none when deadline fires. The cursor fetch returns
+// is kept even when it brings no assets. It returns ctx's error once ctx is done.
+func waitForAssets(ctx context.Context, since int64, ch <-chan events.Event, deadline, recheck <-chan time.Time, fetch func(since int64) ([]store.Asset, int64, error)) ([]store.Asset, int64, error) {
+	for {
+		assets, cursor, err := fetch(since)
+		if err != nil {
+			return nil, since, err
+		}
+		since = cursor
+		if len(assets) > 0 {
+			return assets, since, nil
+		}
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return nil, since, ctx.Err()
+			case <-deadline:
+				return nil, since, nil
+			case <-recheck:
+				break wait
+			case ev, ok := <-ch:
+				if !ok {
+					// Dropped for falling behind; rechecks carry on alone.
+					ch = nil
+					continue
+				}
+				if ev.Type == events.AssetCreated {
+					break wait
+				}
+			}
+		}
 	}
-	resp := TagListResponse{Items: make([]Tag, 0, len(tags)), Page: page, PageSize: size, Total: total}
-	for _, t := range tags {
-		resp.Items = append(resp.Items, Tag{Name: t})
+}
+
+// queryContext bounds ctx by GANACHE_QUERY_TIMEOUT for routes that are not wrapped in
+// its middleware.
+func (s *Server) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.cfg.QueryTimeout <= 0 {
+		return ctx, func() {}
 	}
-	writeJSON(w, http.StatusOK, resp)
+	return context.WithTimeout(ctx, s.cfg.QueryTimeout)
 }
 
-func (s *Server) GetMediaVariant(w http.ResponseWriter, r *http.Request, id AssetId, variant GetMediaVariantParamsVariant) {
+func (s *Server) GetMediaVariant(w http.ResponseWriter, r *http.Request, id AssetId, variant GetMediaVariantParamsVariant, params GetMediaVariantParams) {
 	asset, err := s.store.GetAsset(r.Context(), id, false)
 	if err != nil {
+		if writeCircuitError(w, err) {
+			return
+		}
 		status := http.StatusInternalServerError
 		if errors.Is(err, store.ErrNotFound) {
 			status = http.StatusNotFound
@@ -447,37 +1961,79 @@ func (s *Server) GetMediaVariant(w http.ResponseWriter, r *http.Request, id Asse
 		writeError(w, status, "not_found", "asset not found", nil)
 		return
 	}
+	private := asset.Visibility == store.VisibilityPrivate
+	if private && s.cfg.AuthMode != config.AuthNone {
+		principal, ok := s.requestPrincipal(r)
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "authentication required", nil)
+			return
+		}
+		if !principal.HasPermission(PermCanAdmin) && !asset.CanView(principal.ID) {
+			writeError(w, http.StatusNotFound, "not_found", "asset not found", nil)
+			return
+		}
+	}
 	var path string
-	ext := guessExt(asset.OriginalFilename)
+	ext := s.guessExt(asset)
+	etag := fmt.Sprintf("\"%s-%s\"", asset.SHA256, variant)
 	switch variant {
 	case GetMediaVariantParamsVariantThumb:
 		path = s.media.PathForVariant(asset.SHA256, media.VariantThumb, ext)
+		if params.W != nil {
+			if !slices.Contains(s.cfg.ThumbWidths, *params.W) {
+				writeError(w, http.StatusNotFound, "not_found", "thumbnail width not available", nil)
+				return
+			}
+			path = s.media.PathForThumbWidth(asset.SHA256, *params.W)
+			etag = fmt.Sprintf("\"%s-%s-w%d\"", asset.SHA256, variant, *params.W)
+		}
 	case GetMediaVariantParamsVariantContent:
 		path = s.media.PathForVariant(asset.SHA256, media.VariantContent, ext)
 	case GetMediaVariantParamsVariantOriginal:
-		path = s.media.PathForVariant(asset.SHA256, media.VariantOriginal, ext)
+		path = s.originalPath(asset)
 	default:
 		writeError(w, http.StatusNotFound, "not_found", "variant not found", nil)
 		return
 	}
 
-	etag := fmt.Sprintf("\"%s-%s\"", asset.SHA256, variant)
 	if match := r.Header.Get("If-None-Match"); match != "" && match == etag {
 		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
-	file, err := os.Open(path)
+	if variant != GetMediaVariantParamsVariantOriginal {
+		placeholder, err := s.ensureVariant(asset, path)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "internal", "failed to generate variant", map[string]any{"error": err.Error()})
+			return
+		}
+		if placeholder {
+			s.servePlaceholder(w, private)
+			return
+		}
+	}
+
+	file, size, err := openVariant(variant, path)
 	if err != nil {
-		writeError(w, http.StatusNotFound, "not_found", "variant not found", nil)
+		if errors.Is(err, os.ErrNotExist) {
+			if variant != GetMediaVariantParamsVariantOriginal && s.cfg.PlaceholderImage != "" {
+				s.logger.Warn("serving placeholder for missing variant", "assetId", asset.ID, "sha256", asset.SHA256, "variant", string(variant), "path", path)
+				s.servePlaceholder(w, private)
+				return
+			}
+			s.logger.Error("media file missing from storage", "assetId", asset.ID, "sha256", asset.SHA256, "variant", string(variant), "path", path)
+			writeError(w, s.missingMediaStatus(), "file_missing", "media file missing from storage", nil)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "internal", "failed to open media", map[string]any{"error": err.Error()})
 		return
 	}
 	defer file.Close()
 
-	info, _ := file.Stat()
-	mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(path)))
-	if mimeType == "" {
-		mimeType = asset.Mime
+	mimeType, err := mediaContentType(variant, file, path, asset.Mime)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal", "failed to read media", map[string]any{"error": err.Error()})
+		return
 	}
 	w.Header().Set("Content-Type", mimeType)
 	w.Header().Set("ETag", etag)
@@ -485,9 +2041,19 @@ func (s *Server) GetMediaVariant(w http.ResponseWriter, r *http.Request, id Asse
 	if variant != GetMediaVariantParamsVariantOriginal {
 		cache = "public, max-age=31536000, immutable"
 	}
+	if private || s.cfg.MediaRequiresAuth(string(variant)) {
+		// Shared caches must not hand a private asset, or a variant that needs
+		// auth, to other clients.
+		cache = "private, max-age=3600"
+		w.Header().Set("Vary", "X-Api-Key")
+	}
 	w.Header().Set("Cache-Control", cache)
-	if info != nil {
-		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	if derefBool(params.Download, false) {
+		name := attachmentFilename(asset.OriginalFilename, filepath.Ext(path))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	}
+	if size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
 	}
 	w.WriteHeader(http.StatusOK)
 	if _, err := io.Copy(w, file); err != nil {
@@ -495,10 +2061,144 @@ func (s *Server) GetMediaVariant(w http.ResponseWriter, r *http.Request, id Asse
 	}
 }
 
+// ensureVariant makes sure the derivative at path of asset can be served. Derivatives
+// queued for the background workers, or lost with a restart, are generated now
+// rather than reported missing. With GANACHE_PLACEHOLDER_IMAGE set the request does
+// not wait for that: generation is queued and ensureVariant reports that the
+// placeholder should be served instead, both while it runs and after it failed.
+func (s *Server) ensureVariant(asset *store.Asset, path string) (placeholder bool, err error) {
+	_, statErr := os.Stat(path)
+	if statErr == nil && !s.media.VariantsPending(asset.SHA256) {
+		return false, nil
+	}
+	origPath := s.originalPath(asset)
+	if !media.OriginalExists(origPath) {
+		return false, nil
+	}
+	if s.cfg.PlaceholderImage != "" {
+		s.media.QueueVariants(asset.SHA256, origPath)
+		return statErr != nil, nil
+	}
+	return false, s.media.EnsureVariants(asset.SHA256, origPath)
+}
+
+// openVariant opens the file behind a media variant and reports the length of the
+// bytes it serves, or -1 when unknown. Originals stored compressed are decompressed.
+func openVariant(variant GetMediaVariantParamsVariant, path string) (io.ReadSeekCloser, int64, error) {
+	if variant == GetMediaVariantParamsVariantOriginal {
+		orig, err := media.OpenOriginal(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		return orig, orig.Size(), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return f, -1, nil
+	}
+	return f, info.Size(), nil
+}
+
+// placeholderHeader marks a response carrying GANACHE_PLACEHOLDER_IMAGE instead of the
+// requested variant.
+const placeholderHeader = "X-Ganache-Placeholder"
+
+// servePlaceholder answers a request for a missing derivative with the configured
+// placeholder image. It is cached only briefly and carries no ETag, so clients fetch
+// the real variant once it has been generated.
+func (s *Server) servePlaceholder(w http.ResponseWriter, private bool) {
+	file, err := os.Open(s.cfg.PlaceholderImage)
+	if err != nil {
+		s.logger.Error("failed to open placeholder image", "error", err, "path", s.cfg.PlaceholderImage)
+		writeError(w, http.StatusInternalServerError, "internal", "failed to open placeholder image", nil)
+		return
+	}
+	defer file.Close()
+
+	mimeType, err := servedContentType(file, s.cfg.PlaceholderImage, "application/octet-stream")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal", "failed to read placeholder image", map[string]any{"error": err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set(placeholderHeader, "true")
+	if private {
+		w.Header().Set("Cache-Control", "private, max-age=60")
+		w.Header().Set("Vary", "X-Api-Key")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=60")
+	}
+	if info, err := file.Stat(); err == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, file); err != nil {
+		s.logger.Error("failed to copy placeholder to response", "error", err)
+	}
+}
+
+// mediaContentType picks the Content-Type for a variant. Originals are labelled with
+// the MIME type detected at upload, which does not depend on the file's extension;
+// derivatives, and originals whose row has no MIME type, go through servedContentType.
+func mediaContentType(variant GetMediaVariantParamsVariant, file io.ReadSeeker, path, storedMime string) (string, error) {
+	if variant == GetMediaVariantParamsVariantOriginal && storedMime != "" {
+		return storedMime, nil
+	}
+	return servedContentType(file, path, storedMime)
+}
+
+// servedContentType reports the MIME type of the bytes actually stored at path, so
+// derivatives are labelled by their own encoding rather than by their extension or
+// the original's type. When the content cannot be identified it falls back to an
+// image type for the extension, then to storedMime. The file is left positioned at its start.
+func servedContentType(file io.ReadSeeker, path, storedMime string) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if sniffed := http.DetectContentType(head[:n]); strings.HasPrefix(sniffed, "image/") {
+		return sniffed, nil
+	}
+	if byExt := mime.TypeByExtension(strings.ToLower(filepath.Ext(path))); strings.HasPrefix(byExt, "image/") {
+		return byExt, nil
+	}
+	return storedMime, nil
+}
+
+// missingMediaStatus is the status for an asset whose file is gone from storage:
+// 410 by default, or 404 when GANACHE_MISSING_MEDIA_RESPONSE=not_found.
+func (s *Server) missingMediaStatus() int {
+	if s.cfg.MissingMedia == config.MissingMediaNotFound {
+		return http.StatusNotFound
+	}
+	return http.StatusGone
+}
+
 func (s *Server) toAPIAsset(a *store.Asset) Asset {
 	orig := a.OriginalFilename
 	sha := a.SHA256
-	return Asset{
+	hashAlgo := a.HashAlgo
+	variants := AssetVariantUrls{
+		Thumb:    s.mediaURL(a.ID, media.VariantThumb),
+		Content:  s.mediaURL(a.ID, media.VariantContent),
+		Original: s.mediaURL(a.ID, media.VariantOriginal),
+	}
+	if widths := s.cfg.ThumbWidths; len(widths) > 0 {
+		thumbs := make([]ThumbnailUrl, 0, len(widths))
+		for _, width := range widths {
+			thumbs = append(thumbs, ThumbnailUrl{Width: width, Url: fmt.Sprintf("%s?w=%d", variants.Thumb, width)})
+		}
+		variants.Thumbs = &thumbs
+	}
+	out := Asset{
 		Id:               a.ID,
 		Title:            a.Title,
 		Caption:          a.Caption,
@@ -506,31 +2206,355 @@ func (s *Server) toAPIAsset(a *store.Asset) Asset {
 		Source:           a.Source,
 		UsageNotes:       a.UsageNotes,
 		Tags:             a.Tags,
+		Immutable:        a.Immutable,
+		Visibility:       Visibility(a.Visibility),
 		Width:            a.Width,
 		Height:           a.Height,
+		AspectRatio:      aspectRatio(a.Width, a.Height),
+		Orientation:      orientation(a.Width, a.Height),
 		Bytes:            a.Bytes,
 		Mime:             a.Mime,
 		OriginalFilename: &orig,
+		DisplayFilename:  a.DisplayFilename,
+		VariantStatus:    variantStatus(a),
 		Sha256:           &sha,
+		HashAlgo:         &hashAlgo,
+		HasAlpha:         a.HasAlpha,
+		ColorSpace:       a.ColorSpace,
 		CreatedAt:        a.CreatedAt,
 		UpdatedAt:        a.UpdatedAt,
 		DeletedAt:        a.DeletedAt,
-		Variants: AssetVariantUrls{
-			Thumb:    fmt.Sprintf("/media/%d/thumb", a.ID),
-			Content:  fmt.Sprintf("/media/%d/content", a.ID),
-			Original: fmt.Sprintf("/media/%d/original", a.ID),
-		},
+		Preview:          a.Preview,
+		Relevance:        a.Relevance,
+		Variants:         &variants,
+		VariantsReady:    !s.media.VariantsPending(a.SHA256),
+	}
+	if a.Visibility == store.VisibilityPrivate {
+		allowed := append([]string{}, a.AllowedPrincipals...)
+		out.AllowedPrincipals = &allowed
+	}
+	return out
+}
+
+// aspectRatio returns width / height rounded to 4 decimal places, enough to tell
+// common ratios apart, or 0 for an asset without a height.
+func aspectRatio(width, height int) float64 {
+	if height <= 0 {
+		return 0
+	}
+	return math.Round(float64(width)/float64(height)*10000) / 10000
+}
+
+func orientation(width, height int) AssetOrientation {
+	switch {
+	case width > height:
+		return Landscape
+	case width < height:
+		return Portrait
 	}
+	return Square
+}
+
+// mediaURL returns the variant path, prefixed with PublicBaseURL when one is configured.
+func (s *Server) mediaURL(id int64, variant string) string {
+	return strings.TrimRight(s.cfg.PublicBaseURL, "/") + fmt.Sprintf("/media/%d/%s", id, variant)
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
+	if bytesAsStrings(w) {
+		v = quoteByteCounts(v)
+	}
 	_ = json.NewEncoder(w).Encode(v)
 }
 
+// writeJSONWithETag writes v with status 200 and a weak ETag hashed from its
+// encoding, so the tag changes exactly when the body does. A request whose
+// If-None-Match already names the tag gets 304 without the body.
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, v any) {
+	if bytesAsStrings(w) {
+		v = quoteByteCounts(v)
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal", "failed to encode response", map[string]any{"error": err.Error()})
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(append(body, '\n'))
+}
+
+// etagMatches reports whether an If-None-Match value names etag, comparing weakly
+// as RFC 9110 requires: a W/ prefix on either side is ignored, and "*" matches.
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
 func writeError(w http.ResponseWriter, status int, code, message string, details map[string]any) {
-	writeJSON(w, status, Error{Code: code, Message: message, Details: &details})
+	// requestIDMiddleware has already put the id on the response.
+	var requestID *string
+	if id := w.Header().Get(middleware.RequestIDHeader); id != "" {
+		requestID = &id
+	}
+	if pw, ok := findWriter[*problemWriter](w); ok {
+		p := Problem{
+			Type:      "urn:ganache:error:" + code,
+			Title:     http.StatusText(status),
+			Status:    status,
+			Detail:    message,
+			Instance:  pw.instance,
+			Code:      code,
+			RequestId: requestID,
+		}
+		if len(details) > 0 {
+			p.Details = &details
+		}
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(status)
+		var body any = p
+		if bytesAsStrings(w) {
+			body = quoteByteCounts(p)
+		}
+		_ = json.NewEncoder(w).Encode(body)
+		return
+	}
+	writeJSON(w, status, Error{Code: code, Message: message, Details: &details, RequestId: requestID})
+}
+
+// maxRequestIDLen bounds a client-supplied X-Request-ID.
+const maxRequestIDLen = 128
+
+// requestIDMiddleware runs chi's RequestID, which keeps an X-Request-ID sent by the
+// client and generates one otherwise, and echoes the id on the response. A supplied
+// id that is too long or not printable ASCII is replaced rather than logged.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	withID := middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(middleware.RequestIDHeader, middleware.GetReqID(r.Context()))
+		next.ServeHTTP(w, r)
+	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := r.Header.Get(middleware.RequestIDHeader); id != "" && !validRequestID(id) {
+			r.Header.Del(middleware.RequestIDHeader)
+		}
+		withID.ServeHTTP(w, r)
+	})
+}
+
+func validRequestID(id string) bool {
+	if len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// problemWriter marks a response whose errors are written as RFC 7807 problem
+// details; instance is the request path they report.
+type problemWriter struct {
+	http.ResponseWriter
+	instance string
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush events.
+func (w *problemWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// problemMiddleware switches writeError to problem+json for the rest of the chain.
+func problemMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&problemWriter{ResponseWriter: w, instance: r.URL.Path}, r)
+	})
+}
+
+// findWriter looks for a marker writer of type T through any writers wrapping w.
+func findWriter[T http.ResponseWriter](w http.ResponseWriter) (T, bool) {
+	for {
+		if t, ok := w.(T); ok {
+			return t, true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		w = u.Unwrap()
+	}
+}
+
+// bigIntWriter marks a response whose byte counts writeJSON and writeError encode as
+// strings.
+type bigIntWriter struct {
+	http.ResponseWriter
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush events.
+func (w *bigIntWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// bigIntMiddleware makes writeJSON and writeError quote byte counts for the rest of
+// the chain, for JavaScript clients that would round integers above 2^53.
+func bigIntMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&bigIntWriter{ResponseWriter: w}, r)
+	})
+}
+
+// bytesAsStrings reports whether a bigIntWriter is among the writers wrapping w.
+func bytesAsStrings(w http.ResponseWriter) bool {
+	_, ok := findWriter[*bigIntWriter](w)
+	return ok
+}
+
+// quoteByteCounts returns the JSON form of v with every integer member named
+// "bytes" or ending in "Bytes", at any depth, turned into a string, so the field's
+// type does not depend on its size. Other members are left as they are. v is
+// returned unchanged if it does not encode.
+func quoteByteCounts(v any) any {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return v
+	}
+	quoteByteMembers(doc)
+	return doc
+}
+
+func quoteByteMembers(v any) {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, member := range t {
+			if n, ok := member.(json.Number); ok && (k == "bytes" || strings.HasSuffix(k, "Bytes")) {
+				t[k] = n.String()
+				continue
+			}
+			quoteByteMembers(member)
+		}
+	case []any:
+		for _, item := range t {
+			quoteByteMembers(item)
+		}
+	}
+}
+
+func writeBlockedTags(w http.ResponseWriter, err error) bool {
+	var blocked *store.BlockedTagsError
+	if !errors.As(err, &blocked) {
+		return false
+	}
+	writeError(w, http.StatusUnprocessableEntity, "blocked_tags", "one or more tags are not allowed", map[string]any{"rejected": blocked.Tags})
+	return true
+}
+
+// writeQueryTimeout answers with 504 (deadline) or 503 (cancellation) when err stems
+// from the request context ending, hiding the driver error from the client, and as
+// writeCircuitError does when the breaker refused the call.
+func writeQueryTimeout(w http.ResponseWriter, err error) bool {
+	if writeCircuitError(w, err) {
+		return true
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		w.Header().Set("Retry-After", "5")
+		writeError(w, http.StatusGatewayTimeout, "timeout", "query timed out", nil)
+		return true
+	case errors.Is(err, context.Canceled):
+		writeError(w, http.StatusServiceUnavailable, "canceled", "query canceled", nil)
+		return true
+	}
+	return false
+}
+
+// circuitMiddleware answers 503 straight away while the database breaker is open, so
+// requests do not queue behind connection attempts that are known to fail.
+func (s *Server) circuitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.store != nil {
+			if retryAfter, open := s.store.CircuitOpen(); open {
+				writeCircuitOpen(w, retryAfter)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeCircuitError answers a call the breaker refused mid-handler as
+// circuitMiddleware answers one it catches up front, and reports whether err was one.
+func writeCircuitError(w http.ResponseWriter, err error) bool {
+	var open *store.CircuitOpenError
+	if !errors.As(err, &open) {
+		return false
+	}
+	writeCircuitOpen(w, open.RetryAfter)
+	return true
+}
+
+func writeCircuitOpen(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeError(w, http.StatusServiceUnavailable, "db_unavailable", "database unavailable", nil)
+}
+
+// timeoutMiddleware applies middleware.Timeout, or nothing when d is not positive.
+func timeoutMiddleware(d time.Duration) func(http.Handler) http.Handler {
+	if d <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return middleware.Timeout(d)
+}
+
+const (
+	// apiCSP suits JSON and image responses, which never load subresources.
+	apiCSP = "default-src 'none'; frame-ancestors 'none'"
+	// swaggerCSP allows the embedded Swagger UI, which uses an inline bootstrap
+	// script and stylesheet and data: URIs for its icons.
+	swaggerCSP = "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; connect-src 'self'; frame-ancestors 'none'"
+)
+
+// secureHeadersMiddleware sets headers that stop browsers from sniffing, framing, or
+// running content from responses. Pages under swaggerPath get a CSP the UI can run with.
+func secureHeadersMiddleware(swaggerPath string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Set("X-Content-Type-Options", "nosniff")
+			h.Set("X-Frame-Options", "DENY")
+			h.Set("Referrer-Policy", "no-referrer")
+			if r.URL.Path == swaggerPath || strings.HasPrefix(r.URL.Path, swaggerPath+"/") {
+				h.Set("Content-Security-Policy", swaggerCSP)
+			} else {
+				h.Set("Content-Security-Policy", apiCSP)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 func loggingMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
@@ -538,11 +2562,60 @@ func loggingMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
 			next.ServeHTTP(w, r)
-			logger.Info("request", "method", r.Method, "path", r.URL.Path, "duration", time.Since(start).String())
+			logger.Info("request", "method", r.Method, "path", r.URL.Path, "duration", time.Since(start).String(), "requestId", middleware.GetReqID(r.Context()))
 		})
 	}
 }
 
+func (s *Server) multipartMemory() int64 {
+	if s.cfg.MultipartMemory <= 0 {
+		return config.DefaultMultipartMemory
+	}
+	return s.cfg.MultipartMemory
+}
+
+func (s *Server) uploadSlackBytes() int64 {
+	if s.cfg.UploadSlackBytes <= 0 {
+		return config.DefaultUploadSlackBytes
+	}
+	return s.cfg.UploadSlackBytes
+}
+
+// uploadBodyLimit bounds an upload request body: the largest file any format may be,
+// plus UploadSlackBytes for the multipart boundaries, part headers, and metadata
+// fields around it.
+func (s *Server) uploadBodyLimit() int64 {
+	return s.cfg.UploadByteCeiling() + s.uploadSlackBytes()
+}
+
+// writeRequestTooLarge answers an upload whose body is over uploadBodyLimit. It is
+// kept apart from file_too_large so a client can tell an oversized file from
+// metadata fields that pushed a file within the limit over the request limit.
+func (s *Server) writeRequestTooLarge(w http.ResponseWriter, limit int64) {
+	msg := fmt.Sprintf("request body exceeds %d bytes (the file limit plus %d bytes for multipart framing and metadata fields)", limit, s.uploadSlackBytes())
+	writeError(w, http.StatusRequestEntityTooLarge, "request_too_large", msg, map[string]any{
+		"maxRequestBytes": limit,
+		"maxFileBytes":    s.cfg.UploadByteCeiling(),
+	})
+}
+
+// pagination applies the defaults to a listing's page and pageSize and clamps them
+// to page ≥ 1 and pageSize in [1, MaxPageSize]. With StrictPagination, out-of-range
+// values are instead reported in msg, naming the limit.
+func (s *Server) pagination(pageParam, sizeParam *int) (page, size int, msg string) {
+	page = derefInt(pageParam, 1)
+	size = derefInt(sizeParam, s.cfg.DefaultPageSize)
+	if s.cfg.StrictPagination {
+		if page < 1 {
+			return 0, 0, "page must be at least 1"
+		}
+		if size < 1 || size > s.cfg.MaxPageSize {
+			return 0, 0, fmt.Sprintf("pageSize must be between 1 and %d", s.cfg.MaxPageSize)
+		}
+	}
+	return max(page, 1), min(max(size, 1), s.cfg.MaxPageSize), ""
+}
+
 func getStringPtr(v *string) string {
 	if v == nil {
 		return ""
@@ -578,6 +2651,29 @@ func derefSort(v *SearchAssetsParamsSort) SearchAssetsParamsSort {
 	return *v
 }
 
+// remapFormFields renames aliased multipart fields to their canonical names so
+// integrators can keep their own schema. A field sent under its canonical name
+// wins over an alias for it.
+func remapFormFields(form *multipart.Form, fieldMap map[string]string) {
+	if form == nil {
+		return
+	}
+	for alias, canonical := range fieldMap {
+		if vals, ok := form.Value[alias]; ok {
+			if _, exists := form.Value[canonical]; !exists {
+				form.Value[canonical] = vals
+			}
+			delete(form.Value, alias)
+		}
+		if files, ok := form.File[alias]; ok {
+			if _, exists := form.File[canonical]; !exists {
+				form.File[canonical] = files
+			}
+			delete(form.File, alias)
+		}
+	}
+}
+
 func formValue(values map[string][]string, key string) string {
 	if values == nil {
 		return ""
@@ -589,7 +2685,100 @@ func formValue(values map[string][]string, key string) string {
 	return vals[0]
 }
 
-func guessExt(filename string) string {
+// sanitizeFilename reduces an uploaded filename to its last path component in two
+// forms of at most maxLen bytes. display drops control, line-separator, and bidi
+// formatting characters and collapses whitespace; safe, stored as original_filename
+// and used in headers, also drops quotes, characters Windows rejects, and leading
+// dots. Either may come back empty.
+func sanitizeFilename(raw string, maxLen int) (safe, display string) {
+	name := strings.ToValidUTF8(raw, "")
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.In(r, unicode.Bidi_Control, unicode.Zl, unicode.Zp) {
+			return -1
+		}
+		return r
+	}, name)
+	display = truncateFilename(strings.Join(strings.Fields(name), " "), maxLen)
+
+	safe = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`"'<>:*?|;`, r) {
+			return -1
+		}
+		return r
+	}, display)
+	safe = strings.TrimLeft(safe, ". ")
+	return safe, display
+}
+
+// truncateFilename cuts name to maxLen bytes on a rune boundary, keeping a short
+// extension.
+func truncateFilename(name string, maxLen int) string {
+	if len(name) <= maxLen {
+		return name
+	}
+	ext := filepath.Ext(name)
+	if len(ext) > maxLen/4 {
+		ext = ""
+	}
+	stem := name[:maxLen-len(ext)]
+	for !utf8.ValidString(stem) {
+		stem = stem[:len(stem)-1]
+	}
+	return strings.TrimSpace(stem) + ext
+}
+
+// attachmentFilename strips directory components and control or quoting characters from
+// the stored filename and swaps its extension for ext, the extension of the file served.
+func attachmentFilename(original, ext string) string {
+	name := filepath.Base(strings.ReplaceAll(original, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' || r == '/' {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(strings.TrimSuffix(name, filepath.Ext(name)))
+	if name == "" || name == "." || name == ".." {
+		name = "download"
+	}
+	return name + ext
+}
+
+// guessExt mirrors the extension media.Save chose for the asset's original.
+func (s *Server) guessExt(asset *store.Asset) string {
+	ext := s.media.CanonicalExt(asset.OriginalFilename, asset.Mime)
+	if ext == "" {
+		ext = ".bin"
+	}
+	return ext
+}
+
+// originalPath locates the stored original, falling back to the pre-aliasing
+// extension when the canonical file is missing. The path is that of the
+// uncompressed original; open it with media.OpenOriginal.
+func (s *Server) originalPath(asset *store.Asset) string {
+	ext := s.guessExt(asset)
+	path := s.media.PathForVariant(asset.SHA256, media.VariantOriginal, ext)
+	if !media.OriginalExists(path) {
+		if legacy := legacyExt(asset.OriginalFilename); legacy != ext {
+			alt := s.media.PathForVariant(asset.SHA256, media.VariantOriginal, legacy)
+			if media.OriginalExists(alt) {
+				return alt
+			}
+		}
+	}
+	return path
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func legacyExt(filename string) string {
 	ext := strings.ToLower(strings.TrimSpace(filepath.Ext(filename)))
 	if ext == "" {
 		ext = ".bin"
@@ -0,0 +1,1103 @@
+package httpapi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/arawak/ganache/internal/config"
+	"github.com/arawak/ganache/internal/events"
+	"github.com/arawak/ganache/internal/media"
+	"github.com/arawak/ganache/internal/store"
+)
+
+func TestServeRoot(t *testing.T) {
+	s := &Server{cfg: &config.Config{ServiceName: "ganache", Version: "1.2.3", SwaggerUIPath: "/swagger", OpenAPIPath: "/openapi.yaml"}}
+
+	rec := httptest.NewRecorder()
+	s.serveRoot(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var body rootResponse
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Service != "ganache" || body.Version != "1.2.3" {
+		t.Fatalf("unexpected body: %+v", body)
+	}
+	if body.Links["swagger"] != "/swagger" || body.Links["openapi"] != "/openapi.yaml" || body.Links["healthz"] != "/healthz" {
+		t.Fatalf("unexpected links: %v", body.Links)
+	}
+}
+
+func TestAttachmentFilename(t *testing.T) {
+	cases := []struct {
+		original, ext, expect string
+	}{
+		{"photo.JPG", ".JPG", "photo.JPG"},
+		{"photo.jpg", ".webp", "photo.webp"},
+		{`..\..\evil"name.png`, ".png", "evilname.png"},
+		{"/etc/passwd", ".bin", "passwd.bin"},
+		{"", ".webp", "download.webp"},
+	}
+	for _, c := range cases {
+		if got := attachmentFilename(c.original, c.ext); got != c.expect {
+			t.Fatalf("attachmentFilename(%q, %q) = %q, expected %q", c.original, c.ext, got, c.expect)
+		}
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	cases := []struct {
+		raw, safe, display string
+	}{
+		{"photo.jpg", "photo.jpg", "photo.jpg"},
+		{"../../etc/passwd", "passwd", "passwd"},
+		{`..\..\boot.ini`, "boot.ini", "boot.ini"},
+		{"evil\r\nSet-Cookie: x.png", "evilSet-Cookie x.png", "evilSet-Cookie: x.png"},
+		{"invoice\u202Egnp.exe", "invoicegnp.exe", "invoicegnp.exe"},
+		{`say "cheese"; rm.jpg`, "say cheese rm.jpg", `say "cheese"; rm.jpg`},
+		{"  .hidden   file .png", "hidden file .png", ".hidden file .png"},
+		{"..", "", ".."},
+		{strings.Repeat("é", 20) + ".jpeg", strings.Repeat("é", 13) + ".jpeg", strings.Repeat("é", 13) + ".jpeg"},
+	}
+	for _, c := range cases {
+		safe, display := sanitizeFilename(c.raw, 32)
+		if safe != c.safe || display != c.display {
+			t.Fatalf("sanitizeFilename(%q) = %q, %q, expected %q, %q", c.raw, safe, display, c.safe, c.display)
+		}
+	}
+}
+
+func TestMediaURL(t *testing.T) {
+	cases := map[string]string{
+		"":                          "/media/7/thumb",
+		"https://cdn.example.com":   "https://cdn.example.com/media/7/thumb",
+		"https://cdn.example.com/":  "https://cdn.example.com/media/7/thumb",
+		"https://example.com/img//": "https://example.com/img/media/7/thumb",
+	}
+	for base, expect := range cases {
+		s := &Server{cfg: &config.Config{PublicBaseURL: base}}
+		if got := s.mediaURL(7, "thumb"); got != expect {
+			t.Fatalf("mediaURL with base %q = %q, expected %q", base, got, expect)
+		}
+	}
+}
+
+func TestWriteQueryTimeout(t *testing.T) {
+	rec := httptest.NewRecorder()
+	if !writeQueryTimeout(rec, fmt.Errorf("%w: invalid connection", context.DeadlineExceeded)) {
+		t.Fatalf("expected deadline to be handled")
+	}
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %d", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "invalid connection") {
+		t.Fatalf("driver error leaked: %s", rec.Body.String())
+	}
+
+	if writeQueryTimeout(httptest.NewRecorder(), errors.New("syntax error")) {
+		t.Fatalf("expected unrelated error to be left alone")
+	}
+
+	// The breaker may refuse a call after circuitMiddleware let the request in.
+	for _, write := range []func(http.ResponseWriter, error) bool{writeQueryTimeout, writeCircuitError} {
+		rec = httptest.NewRecorder()
+		if !write(rec, fmt.Errorf("get asset: %w", &store.CircuitOpenError{RetryAfter: 1500 * time.Millisecond})) {
+			t.Fatalf("expected an open circuit to be handled")
+		}
+		if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "2" || !strings.Contains(rec.Body.String(), "db_unavailable") {
+			t.Fatalf("expected 503 db_unavailable with Retry-After 2, got %d %q %s", rec.Code, rec.Header().Get("Retry-After"), rec.Body.String())
+		}
+	}
+	if writeCircuitError(httptest.NewRecorder(), context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline to be left to writeQueryTimeout")
+	}
+}
+
+func TestRequestIDEchoed(t *testing.T) {
+	h := requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusBadRequest, "bad_request", "nope", nil)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/assets", nil)
+	req.Header.Set("X-Request-ID", "ticket-1234")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-ID"); got != "ticket-1234" {
+		t.Fatalf("expected supplied id to be echoed, got %q", got)
+	}
+	var body Error
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.RequestId == nil || *body.RequestId != "ticket-1234" {
+		t.Fatalf("expected request id in error body, got %+v", body)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/assets", nil)
+	req.Header.Set("X-Request-ID", "bad id\x01")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-ID"); got == "" || strings.Contains(got, " ") {
+		t.Fatalf("expected an invalid id to be replaced, got %q", got)
+	}
+}
+
+func TestWriteErrorProblemFormat(t *testing.T) {
+	h := problemMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "not_found", "asset not found", map[string]any{"id": 7})
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/assets/7?x=1", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Fatalf("expected problem+json, got %q", ct)
+	}
+	var p Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if p.Type != "urn:ganache:error:not_found" || p.Title != "Not Found" || p.Status != http.StatusNotFound ||
+		p.Detail != "asset not found" || p.Instance != "/api/assets/7" || p.Code != "not_found" {
+		t.Fatalf("unexpected problem: %+v", p)
+	}
+	if p.Details == nil || (*p.Details)["id"] != float64(7) {
+		t.Fatalf("expected details to be kept, got %+v", p.Details)
+	}
+
+	rec = httptest.NewRecorder()
+	writeError(rec, http.StatusNotFound, "not_found", "asset not found", nil)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected the native format by default, got %q", ct)
+	}
+}
+
+func TestHeadAndConditionalOpenAPI(t *testing.T) {
+	if _, err := loadOpenAPI("../../openapi.yaml"); err != nil {
+		t.Fatalf("load openapi: %v", err)
+	}
+
+	cfg := &config.Config{AuthMode: config.AuthNone, OpenAPIPath: "/openapi.yaml", SwaggerUIPath: "/swagger", PublicMedia: true}
+	h := NewRouter(cfg, nil, nil, nil, nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("HEAD /healthz: expected 200, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.yaml", nil))
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("GET /openapi.yaml: expected 200 with ETag, got %d %q", rec.Code, etag)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/openapi.yaml", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("conditional GET: expected 304, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/openapi.yaml", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != etag {
+		t.Fatalf("HEAD /openapi.yaml: expected 200 with ETag, got %d", rec.Code)
+	}
+}
+
+func TestOpenAPIGzip(t *testing.T) {
+	data, err := loadOpenAPI("../../openapi.yaml")
+	if err != nil {
+		t.Fatalf("load openapi: %v", err)
+	}
+	cfg := &config.Config{AuthMode: config.AuthNone, OpenAPIPath: "/openapi.yaml", SwaggerUIPath: "/swagger"}
+	h := NewRouter(cfg, nil, nil, nil, nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	req := httptest.NewRequest(http.MethodGet, "/openapi.yaml", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("ETag") == openapiETag {
+		t.Fatalf("expected a gzip response with its own ETag, got %v", rec.Header())
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(zr); !bytes.Equal(got, data) {
+		t.Fatal("gzip body does not decompress to the spec")
+	}
+
+	req.Header.Set("Accept-Encoding", "gzip;q=0, *")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.Len() != len(data) {
+		t.Fatalf("expected identity when gzip is refused, got %v", rec.Header())
+	}
+}
+
+func TestMediaRouteRegisteredOnce(t *testing.T) {
+	cfg := &config.Config{AuthMode: config.AuthAPIKey, OpenAPIPath: "/openapi.yaml", SwaggerUIPath: "/swagger", MediaAuthVariants: []string{"original"}}
+	h := NewRouter(cfg, nil, nil, nil, nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	count := 0
+	err := chi.Walk(h.(chi.Routes), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if method == http.MethodGet && route == "/media/{id}/{variant}" {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected GET /media/{id}/{variant} to be registered once, found %d", count)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/media/1/original", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the original to require auth, got %d", rec.Code)
+	}
+}
+
+func TestOptionsAllow(t *testing.T) {
+	cfg := &config.Config{AuthMode: config.AuthAPIKey, OpenAPIPath: "/openapi.yaml", SwaggerUIPath: "/swagger"}
+	h := NewRouter(cfg, nil, nil, nil, nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	cases := map[string]string{
+		"/api/assets":               "OPTIONS, GET, POST",
+		"/api/assets/42":            "OPTIONS, GET, PATCH, DELETE",
+		"/api/assets/42/exif":       "OPTIONS, GET",
+		"/api/assets/42/versions/3": "OPTIONS, GET",
+		"/healthz":                  "OPTIONS, GET, HEAD",
+		"/media/42/thumb":           "OPTIONS, GET",
+		"/api/tags/cat/related":     "OPTIONS, GET",
+	}
+	for path, want := range cases {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, path, nil))
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("OPTIONS %s: expected 204 without credentials, got %d", path, rec.Code)
+		}
+		if got := rec.Header().Get("Allow"); got != want {
+			t.Fatalf("OPTIONS %s: Allow = %q, want %q", path, got, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, "/nope", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("OPTIONS on unknown path: expected 404, got %d", rec.Code)
+	}
+}
+
+func TestReadOnlyRefusesWrites(t *testing.T) {
+	cfg := &config.Config{AuthMode: config.AuthAPIKey, OpenAPIPath: "/openapi.yaml", SwaggerUIPath: "/swagger", ReadOnly: true}
+	h := NewRouter(cfg, nil, nil, nil, nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, req := range []struct{ method, path, allow string }{
+		{http.MethodPost, "/api/assets", "OPTIONS, GET"},
+		{http.MethodDelete, "/api/assets/42", "OPTIONS, GET"},
+		{http.MethodPost, "/api/admin/keys/k1/rotate", "OPTIONS"},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(req.method, req.path, nil))
+		if rec.Code != http.StatusMethodNotAllowed || !strings.Contains(rec.Body.String(), "read_only") {
+			t.Fatalf("%s %s: expected 405 read_only before auth, got %d %s", req.method, req.path, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("Allow"); got != req.allow {
+			t.Fatalf("%s %s: Allow = %q, want %q", req.method, req.path, got, req.allow)
+		}
+	}
+
+	for _, path := range []string{"/api/tags/normalize", "/api/assets/batch-get"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("POST %s: expected to reach auth, got %d", path, rec.Code)
+		}
+	}
+}
+
+func TestDecodeMergePatch(t *testing.T) {
+	payload, err := decodeMergePatch(strings.NewReader(`{"caption": null, "credit": "AP", "tags": null, "addTags": ["x"]}`))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if payload.Caption == nil || *payload.Caption != "" {
+		t.Fatalf("null caption should clear, got %v", payload.Caption)
+	}
+	if payload.Credit == nil || *payload.Credit != "AP" {
+		t.Fatalf("unexpected credit %v", payload.Credit)
+	}
+	if payload.Tags == nil || len(*payload.Tags) != 0 {
+		t.Fatalf("null tags should clear, got %v", payload.Tags)
+	}
+	if payload.Title != nil || payload.Source != nil || payload.UsageNotes != nil {
+		t.Fatalf("absent members must stay nil")
+	}
+	if payload.AddTags == nil || len(*payload.AddTags) != 1 {
+		t.Fatalf("unexpected addTags %v", payload.AddTags)
+	}
+
+	if _, err := decodeMergePatch(strings.NewReader(`[1]`)); err == nil {
+		t.Fatalf("expected error for non-object body")
+	}
+}
+
+func TestDecodeMergePatchRejectsMalformed(t *testing.T) {
+	cases := []struct {
+		body, msg, field string
+	}{
+		{`{"titel": "typo"}`, `unknown field "titel"`, "titel"},
+		{`{"title": 5}`, `field "title" must be a string`, "title"},
+		{`{"title": "ok"} {"caption": "x"}`, "unexpected data after JSON object", ""},
+		{`{"title": "ok"}}`, "unexpected data after JSON object", ""},
+		{`{"title": `, "invalid json", ""},
+	}
+	for _, tc := range cases {
+		_, err := decodeMergePatch(strings.NewReader(tc.body))
+		if err == nil {
+			t.Fatalf("%s: expected error", tc.body)
+		}
+		msg, details := describeJSONError(err)
+		if msg != tc.msg {
+			t.Fatalf("%s: expected message %q, got %q", tc.body, tc.msg, msg)
+		}
+		if tc.field != "" && details["field"] != tc.field {
+			t.Fatalf("%s: expected field %q, got %v", tc.body, tc.field, details)
+		}
+	}
+}
+
+func TestDuplicateStatus(t *testing.T) {
+	s := &Server{cfg: &config.Config{DuplicateResponse: config.DuplicateConflict}}
+	if got := s.duplicateStatus(nil); got != http.StatusConflict {
+		t.Fatalf("expected default 409, got %d", got)
+	}
+	ok := UploadAssetParamsOnDuplicateOk
+	if got := s.duplicateStatus(&ok); got != http.StatusOK {
+		t.Fatalf("expected 200 when requested, got %d", got)
+	}
+
+	s.cfg.DuplicateResponse = config.DuplicateOK
+	if got := s.duplicateStatus(nil); got != http.StatusOK {
+		t.Fatalf("expected configured 200, got %d", got)
+	}
+	conflict := UploadAssetParamsOnDuplicateConflict
+	if got := s.duplicateStatus(&conflict); got != http.StatusConflict {
+		t.Fatalf("request should override config, got %d", got)
+	}
+}
+
+func TestIfNoneMatchSHA(t *testing.T) {
+	sha := strings.Repeat("ab", 32)
+	for _, v := range []string{`"` + sha + `"`, `W/"` + strings.ToUpper(sha) + `"`, ` "` + sha + `" `} {
+		if got, ok := ifNoneMatchSHA(v); !ok || got != sha {
+			t.Fatalf("ifNoneMatchSHA(%q) = %q, %v", v, got, ok)
+		}
+	}
+	sha512 := strings.Repeat("cd", 64)
+	if got, ok := ifNoneMatchSHA(`"` + sha512 + `"`); !ok || got != sha512 {
+		t.Fatalf("expected a SHA-512 digest to be accepted, got %q, %v", got, ok)
+	}
+	for _, v := range []string{sha, `"` + sha[:62] + `"`, `"` + strings.Repeat("zz", 32) + `"`, "*"} {
+		if _, ok := ifNoneMatchSHA(v); ok {
+			t.Fatalf("expected %q to be rejected", v)
+		}
+	}
+}
+
+func TestSkipUnfilteredSearch(t *testing.T) {
+	s := &Server{cfg: &config.Config{EmptyQuery: config.EmptyQueryAll}}
+	if s.skipUnfilteredSearch(store.SearchParams{}) {
+		t.Fatalf("expected unfiltered search to run by default")
+	}
+	s.cfg.EmptyQuery = config.EmptyQueryNone
+	if !s.skipUnfilteredSearch(store.SearchParams{Query: "  ", Tags: []string{" "}}) {
+		t.Fatalf("expected blank query and tags to count as unfiltered")
+	}
+	now := time.Now()
+	for _, sp := range []store.SearchParams{{Query: "cat"}, {Tags: []string{"cat"}}, {Mime: "image/png"}, {CreatedAfter: &now}} {
+		if s.skipUnfilteredSearch(sp) {
+			t.Fatalf("expected filtered search %+v to run", sp)
+		}
+	}
+}
+
+func TestMissingRequiredFields(t *testing.T) {
+	s := &Server{cfg: &config.Config{}}
+	if got := s.missingRequiredFields("", ""); len(got) != 0 {
+		t.Fatalf("nothing is required by default, got %v", got)
+	}
+	s.cfg.RequireTitle = true
+	s.cfg.RequireCredit = true
+	if got := s.missingRequiredFields("  ", ""); len(got) != 2 || got[0] != "title" || got[1] != "credit" {
+		t.Fatalf("expected title and credit, got %v", got)
+	}
+	if got := s.missingRequiredFields("Headline", "AP"); len(got) != 0 {
+		t.Fatalf("expected no missing fields, got %v", got)
+	}
+}
+
+func TestUpdateAssetInvalidFields(t *testing.T) {
+	s := &Server{cfg: &config.Config{}}
+	body := `{"title": "` + strings.Repeat("x", 256) + `", "visibility": "secret", "tags": ["a"], "addTags": ["b"]}`
+	rec := httptest.NewRecorder()
+	s.UpdateAsset(rec, httptest.NewRequest(http.MethodPatch, "/api/assets/1", strings.NewReader(body)), 1)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Code    string `json:"code"`
+		Details struct {
+			Fields []fieldError `json:"fields"`
+		} `json:"details"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	var fields []string
+	for _, f := range resp.Details.Fields {
+		fields = append(fields, f.Field)
+	}
+	if resp.Code != "invalid_fields" || strings.Join(fields, ",") != "tags,visibility,title" {
+		t.Fatalf("expected every invalid field, got %s %v", resp.Code, resp.Details.Fields)
+	}
+
+	rec = httptest.NewRecorder()
+	s.UpdateAsset(rec, httptest.NewRequest(http.MethodPatch, "/api/assets/1", strings.NewReader(`{"title": 5}`)), 1)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a malformed patch to stay 400, got %d", rec.Code)
+	}
+}
+
+func TestSearchComplexityError(t *testing.T) {
+	s := &Server{cfg: &config.Config{MaxSearchTags: 2, MaxSearchQueryLen: 5}}
+	if msg := s.searchComplexityError("héllo", []string{"a", "b"}); msg != "" {
+		t.Fatalf("expected search within limits, got %q", msg)
+	}
+	if msg := s.searchComplexityError("hello!", nil); !strings.Contains(msg, "q exceeds") {
+		t.Fatalf("expected long query to be rejected, got %q", msg)
+	}
+	if msg := s.searchComplexityError("", []string{"a", "b", "c"}); !strings.Contains(msg, "at most 2 tags") {
+		t.Fatalf("expected too many tags to be rejected, got %q", msg)
+	}
+}
+
+func TestPagination(t *testing.T) {
+	s := &Server{cfg: &config.Config{DefaultPageSize: 30, MaxPageSize: 200}}
+	ptr := func(v int) *int { return &v }
+	if page, size, msg := s.pagination(nil, nil); page != 1 || size != 30 || msg != "" {
+		t.Fatalf("expected defaults, got %d %d %q", page, size, msg)
+	}
+	if page, size, msg := s.pagination(ptr(0), ptr(1000)); page != 1 || size != 200 || msg != "" {
+		t.Fatalf("expected clamping by default, got %d %d %q", page, size, msg)
+	}
+
+	s.cfg.StrictPagination = true
+	if _, _, msg := s.pagination(nil, ptr(1000)); msg != "pageSize must be between 1 and 200" {
+		t.Fatalf("expected an oversized page to be rejected, got %q", msg)
+	}
+	if _, _, msg := s.pagination(ptr(0), nil); msg != "page must be at least 1" {
+		t.Fatalf("expected page 0 to be rejected, got %q", msg)
+	}
+	if page, size, msg := s.pagination(ptr(3), ptr(200)); page != 3 || size != 200 || msg != "" {
+		t.Fatalf("expected values in range to pass, got %d %d %q", page, size, msg)
+	}
+}
+
+func TestUploadSizeLimits(t *testing.T) {
+	const maxFile, slack = 4096, 1024
+	s := &Server{
+		cfg:   &config.Config{MaxUploadBytes: maxFile, UploadSlackBytes: slack, MaxPixels: 1000},
+		media: media.NewManager(t.TempDir(), media.Options{}),
+	}
+	upload := func(fileBytes, captionBytes int, knownLength bool) (*httptest.ResponseRecorder, int) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		if captionBytes > 0 {
+			mw.WriteField("caption", strings.Repeat("c", captionBytes))
+		}
+		part, err := mw.CreateFormFile("file", "a.jpg")
+		if err != nil {
+			t.Fatalf("create file part: %v", err)
+		}
+		part.Write(bytes.Repeat([]byte{'x'}, fileBytes))
+		mw.Close()
+		size := body.Len()
+		req := httptest.NewRequest(http.MethodPost, "/api/assets", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		if !knownLength {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		s.UploadAsset(rec, req, UploadAssetParams{})
+		return rec, size
+	}
+	code := func(rec *httptest.ResponseRecorder) string {
+		var body Error
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return body.Code
+	}
+
+	// A file exactly at the limit passes the size checks and fails as the
+	// non-image it is, even with metadata taking up most of the slack.
+	for _, knownLength := range []bool{true, false} {
+		rec, size := upload(maxFile, slack-400, knownLength)
+		if size > maxFile+slack {
+			t.Fatalf("test body is %d bytes, over the %d byte limit", size, maxFile+slack)
+		}
+		if rec.Code != http.StatusBadRequest || code(rec) != "upload_failed" {
+			t.Fatalf("file at the limit: expected 400 upload_failed, got %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	rec, _ := upload(maxFile+1, 0, true)
+	if rec.Code != http.StatusRequestEntityTooLarge || code(rec) != "file_too_large" {
+		t.Fatalf("file over the limit: expected 413 file_too_large, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	for _, knownLength := range []bool{true, false} {
+		rec, _ := upload(maxFile, slack, knownLength)
+		if rec.Code != http.StatusRequestEntityTooLarge || code(rec) != "request_too_large" {
+			t.Fatalf("metadata over the slack (known length %v): expected 413 request_too_large, got %d: %s", knownLength, rec.Code, rec.Body.String())
+		}
+		var body Error
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		if body.Details == nil || (*body.Details)["maxRequestBytes"] != float64(maxFile+slack) || (*body.Details)["maxFileBytes"] != float64(maxFile) {
+			t.Fatalf("expected the limits in details, got %s", rec.Body.String())
+		}
+	}
+}
+
+func TestBigIntAsString(t *testing.T) {
+	type payload struct {
+		Bytes    int64            `json:"bytes"`
+		Total    int              `json:"total"`
+		Nested   []map[string]any `json:"nested"`
+		MaxBytes *int64           `json:"maxBytes"`
+	}
+	v := payload{Bytes: 9007199254740993, Total: 3, Nested: []map[string]any{{"totalBytes": 12, "bytesLabel": 1}}}
+
+	rec := httptest.NewRecorder()
+	writeJSON(rec, http.StatusOK, v)
+	if body := rec.Body.String(); !strings.Contains(body, `"bytes":9007199254740993`) {
+		t.Fatalf("expected numbers without the middleware, got %s", body)
+	}
+
+	handler := bigIntMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, v)
+	}))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	body := rec.Body.String()
+	for _, want := range []string{`"bytes":"9007199254740993"`, `"total":3`, `"totalBytes":"12"`, `"bytesLabel":1`, `"maxBytes":null`} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %s in %s", want, body)
+		}
+	}
+
+	handler = problemMiddleware(bigIntMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusRequestEntityTooLarge, "request_too_large", "too large", map[string]any{"maxRequestBytes": int64(1 << 40)})
+	})))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/assets", nil))
+	if body := rec.Body.String(); !strings.Contains(body, `"maxRequestBytes":"1099511627776"`) || !strings.Contains(body, `"status":413`) {
+		t.Fatalf("expected a quoted detail in the problem, got %s", body)
+	}
+}
+
+func TestWriteJSONWithETag(t *testing.T) {
+	serve := func(v any, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/assets", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		writeJSONWithETag(rec, req, v)
+		return rec
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	page := AssetSearchResponse{Items: []Asset{{Id: 1, UpdatedAt: now}}, Page: 1, PageSize: 30, Total: 1}
+
+	rec := serve(page, "")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) || !strings.Contains(rec.Body.String(), `"total":1`) {
+		t.Fatalf("expected 200 with a weak ETag, got %d %q", rec.Code, etag)
+	}
+	for _, match := range []string{etag, strings.TrimPrefix(etag, "W/"), `"other", ` + etag, "*"} {
+		if rec := serve(page, match); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+			t.Fatalf("If-None-Match %s: expected 304 without a body, got %d", match, rec.Code)
+		}
+	}
+
+	updated := page
+	updated.Items = []Asset{{Id: 1, UpdatedAt: now.Add(time.Second)}}
+	grown := page
+	grown.Total = 2
+	for _, changed := range []AssetSearchResponse{updated, grown} {
+		if rec := serve(changed, etag); rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+			t.Fatalf("expected a changed page to get a new ETag, got %d %q", rec.Code, rec.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestSearchLang(t *testing.T) {
+	ptr := func(v string) *string { return &v }
+	for _, lang := range []*string{nil, ptr("")} {
+		if got, msg := searchLang(lang); got != "" || msg != "" {
+			t.Fatalf("expected no stemming by default, got %q %q", got, msg)
+		}
+	}
+	if got, msg := searchLang(ptr("fr")); got != "fr" || msg != "" {
+		t.Fatalf("expected fr to be accepted, got %q %q", got, msg)
+	}
+	if _, msg := searchLang(ptr("FR")); msg != "lang must be one of de, en, es, fr" {
+		t.Fatalf("expected an unsupported language to be rejected, got %q", msg)
+	}
+}
+
+func TestMissingMediaStatus(t *testing.T) {
+	s := &Server{cfg: &config.Config{MissingMedia: config.MissingMediaGone}}
+	if got := s.missingMediaStatus(); got != http.StatusGone {
+		t.Fatalf("expected 410, got %d", got)
+	}
+	s.cfg.MissingMedia = config.MissingMediaNotFound
+	if got := s.missingMediaStatus(); got != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", got)
+	}
+}
+
+func TestServePlaceholder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "placeholder.png")
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	if err := os.WriteFile(path, png, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{cfg: &config.Config{PlaceholderImage: path}, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	rec := httptest.NewRecorder()
+	s.servePlaceholder(rec, false)
+	if rec.Code != http.StatusOK || rec.Header().Get(placeholderHeader) != "true" {
+		t.Fatalf("expected 200 with %s, got %d %v", placeholderHeader, rec.Code, rec.Header())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "image/png" {
+		t.Fatalf("expected image/png, got %q", got)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=60" {
+		t.Fatalf("unexpected Cache-Control %q", got)
+	}
+	if rec.Header().Get("ETag") != "" || rec.Body.Len() != len(png) {
+		t.Fatalf("expected placeholder bytes without ETag, got %d bytes, ETag %q", rec.Body.Len(), rec.Header().Get("ETag"))
+	}
+
+	rec = httptest.NewRecorder()
+	s.servePlaceholder(rec, true)
+	if got := rec.Header().Get("Cache-Control"); got != "private, max-age=60" {
+		t.Fatalf("expected private cache for a private asset, got %q", got)
+	}
+}
+
+func TestEnsureVariantServesPlaceholderWithoutWaiting(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	// No workers are started, so queued variants stay pending.
+	m := media.NewManager(t.TempDir(), media.Options{AsyncVariants: true})
+	res, _, err := m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "a.png", 1<<20, 1<<20, "")
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	asset := &store.Asset{SHA256: res.SHA256, Mime: res.Mime, OriginalFilename: "a.png"}
+	thumb := m.PathForVariant(res.SHA256, media.VariantThumb, "")
+	s := &Server{cfg: &config.Config{PlaceholderImage: "placeholder.png"}, media: m}
+
+	if placeholder, err := s.ensureVariant(asset, thumb); err != nil || !placeholder {
+		t.Fatalf("expected the placeholder while variants are pending, got %v, %v", placeholder, err)
+	}
+	if !m.VariantsPending(res.SHA256) {
+		t.Fatalf("expected generation to be left queued")
+	}
+
+	s.cfg.PlaceholderImage = ""
+	if placeholder, err := s.ensureVariant(asset, thumb); err != nil || placeholder {
+		t.Fatalf("expected the variant to be generated without a placeholder, got %v, %v", placeholder, err)
+	}
+	if _, err := os.Stat(thumb); err != nil {
+		t.Fatalf("expected the thumb to exist: %v", err)
+	}
+
+	// A failed generation is retried in the background, with the placeholder served.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.StartVariantWorkers(ctx, 1, nil)
+	s.cfg.PlaceholderImage = "placeholder.png"
+	if err := os.WriteFile(m.PathForVariant(res.SHA256, media.VariantOriginal, ".png"), []byte("corrupt"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(thumb); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if placeholder, err := s.ensureVariant(asset, thumb); err != nil || !placeholder {
+			t.Fatalf("expected the placeholder for a variant that cannot be generated, got %v, %v", placeholder, err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for m.VariantsPending(res.SHA256) {
+			if time.Now().After(deadline) {
+				t.Fatalf("queued generation did not finish")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestVariantStatus(t *testing.T) {
+	cases := map[string]VariantState{
+		store.VariantsReady:   VariantStateReady,
+		store.VariantsPending: VariantStatePending,
+		store.VariantsFailed:  VariantStateMissing,
+	}
+	for stored, expect := range cases {
+		got := variantStatus(&store.Asset{VariantState: stored})
+		if got.Original != VariantStateReady || got.Thumb != expect || got.Content != expect {
+			t.Fatalf("variantStatus(%q) = %+v, expected derivatives %q", stored, got, expect)
+		}
+	}
+}
+
+func TestRemapFormFields(t *testing.T) {
+	form := &multipart.Form{
+		Value: map[string][]string{
+			"headline": {"From CMS"},
+			"byline":   {"Alias credit"},
+			"credit":   {"Canonical credit"},
+		},
+		File: map[string][]*multipart.FileHeader{
+			"image": {{Filename: "a.jpg"}},
+		},
+	}
+	remapFormFields(form, map[string]string{"headline": "title", "byline": "credit", "image": "file"})
+
+	if got := formValue(form.Value, "title"); got != "From CMS" {
+		t.Fatalf("expected aliased title, got %q", got)
+	}
+	if got := formValue(form.Value, "credit"); got != "Canonical credit" {
+		t.Fatalf("canonical field should win, got %q", got)
+	}
+	if _, ok := form.Value["headline"]; ok {
+		t.Fatalf("alias should be removed after remapping")
+	}
+	if len(form.File["file"]) != 1 || form.File["file"][0].Filename != "a.jpg" {
+		t.Fatalf("expected aliased file field, got %v", form.File)
+	}
+
+	remapFormFields(form, nil)
+	if got := formValue(form.Value, "title"); got != "From CMS" {
+		t.Fatalf("nil map should leave fields untouched, got %q", got)
+	}
+}
+
+func TestServedContentType(t *testing.T) {
+	pngHead := "\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"
+	webpHead := "RIFF\x00\x00\x00\x00WEBPVP8 "
+	cases := []struct {
+		data, path, stored, want string
+	}{
+		{pngHead, "thumb/ab/cd/x.webp", "image/png", "image/png"},
+		{webpHead, "content/ab/cd/x.webp", "image/jpeg", "image/webp"},
+		{"opaque", "original/ab/cd/x.bin", "image/x-custom", "image/x-custom"},
+		{"opaque", "original/ab/cd/x.gif", "image/x-custom", "image/gif"},
+	}
+	for _, tc := range cases {
+		r := strings.NewReader(tc.data)
+		got, err := servedContentType(r, tc.path, tc.stored)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.path, err)
+		}
+		if got != tc.want {
+			t.Fatalf("%s: expected %s, got %s", tc.path, tc.want, got)
+		}
+		if rest, _ := io.ReadAll(r); string(rest) != tc.data {
+			t.Fatalf("%s: reader not rewound", tc.path)
+		}
+	}
+}
+
+func TestMediaContentTypePrefersStoredMimeForOriginal(t *testing.T) {
+	pngHead := "\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"
+	cases := []struct {
+		variant                  GetMediaVariantParamsVariant
+		data, path, stored, want string
+	}{
+		{GetMediaVariantParamsVariantOriginal, pngHead, "original/ab/cd/x.jpg", "image/png", "image/png"},
+		{GetMediaVariantParamsVariantOriginal, "opaque", "original/ab/cd/x", "image/gif", "image/gif"},
+		{GetMediaVariantParamsVariantOriginal, pngHead, "original/ab/cd/x.jpg", "", "image/png"},
+		{GetMediaVariantParamsVariantOriginal, "opaque", "original/ab/cd/x.jpg", "", "image/jpeg"},
+		{GetMediaVariantParamsVariantThumb, pngHead, "thumb/ab/cd/x.webp", "image/gif", "image/png"},
+	}
+	for _, tc := range cases {
+		got, err := mediaContentType(tc.variant, strings.NewReader(tc.data), tc.path, tc.stored)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.path, err)
+		}
+		if got != tc.want {
+			t.Fatalf("%s %s (stored %q): expected %s, got %s", tc.variant, tc.path, tc.stored, tc.want, got)
+		}
+	}
+}
+
+func TestCanViewDeletion(t *testing.T) {
+	s := &Server{cfg: &config.Config{AuthMode: config.AuthNone}}
+	req := httptest.NewRequest(http.MethodGet, "/api/assets", nil)
+	if !s.canViewDeletion(req) {
+		t.Fatalf("expected deletion details without auth")
+	}
+	s.cfg.AuthMode = config.AuthAPIKey
+	if s.canViewDeletion(req) {
+		t.Fatalf("expected anonymous callers to be denied")
+	}
+	for perm, want := range map[string]bool{PermCanSearch: false, PermCanViewDeleted: true, PermCanAdmin: true} {
+		p := &Principal{ID: "k", Permissions: map[string]struct{}{perm: {}}}
+		if got := s.canViewDeletion(req.WithContext(WithPrincipal(req.Context(), p))); got != want {
+			t.Fatalf("%s: expected %v, got %v", perm, want, got)
+		}
+	}
+}
+
+func TestSecureHeadersMiddleware(t *testing.T) {
+	h := secureHeadersMiddleware("/swagger")(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for path, want := range map[string]string{
+		"/api/assets":             apiCSP,
+		"/media/1/thumb":          apiCSP,
+		"/swaggerish":             apiCSP,
+		"/swagger":                swaggerCSP,
+		"/swagger/swagger-ui.css": swaggerCSP,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if got := rec.Header().Get("Content-Security-Policy"); got != want {
+			t.Fatalf("%s: expected CSP %q, got %q", path, want, got)
+		}
+		if rec.Header().Get("X-Content-Type-Options") != "nosniff" || rec.Header().Get("X-Frame-Options") != "DENY" {
+			t.Fatalf("%s: missing nosniff or frame headers: %v", path, rec.Header())
+		}
+	}
+}
+
+func TestUploadServerTiming(t *testing.T) {
+	got := uploadServerTiming(media.SaveTimings{Write: 1500 * time.Microsecond, Decode: 2 * time.Millisecond, Variants: 40 * time.Millisecond}, 250*time.Microsecond)
+	want := "save;dur=1.500, decode;dur=2.000, variants;dur=40.000, persist;dur=0.250"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestToAPIAssetRelevance(t *testing.T) {
+	s := &Server{cfg: &config.Config{}, media: media.NewManager(t.TempDir(), media.Options{})}
+	score := 2.5
+	for _, tc := range []struct {
+		relevance *float64
+		want      string
+	}{
+		{&score, `"relevance":2.5`},
+		{nil, `"relevance":null`},
+	} {
+		body, err := json.Marshal(s.toAPIAsset(&store.Asset{ID: 1, Relevance: tc.relevance}))
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		if !strings.Contains(string(body), tc.want) {
+			t.Fatalf("expected %s in %s", tc.want, body)
+		}
+	}
+}
+
+func TestToAPIAssetShape(t *testing.T) {
+	s := &Server{cfg: &config.Config{}, media: media.NewManager(t.TempDir(), media.Options{})}
+	for _, tc := range []struct {
+		width, height int
+		ratio         float64
+		orientation   AssetOrientation
+	}{
+		{1200, 800, 1.5, Landscape},
+		{1080, 1920, 0.5625, Portrait},
+		{500, 500, 1, Square},
+		{1000, 3000, 0.3333, Portrait},
+	} {
+		got := s.toAPIAsset(&store.Asset{ID: 1, Width: tc.width, Height: tc.height})
+		if got.AspectRatio != tc.ratio || got.Orientation != tc.orientation {
+			t.Fatalf("%dx%d: expected %v %s, got %v %s", tc.width, tc.height, tc.ratio, tc.orientation, got.AspectRatio, got.Orientation)
+		}
+	}
+}
+
+func TestBatchGetAssetsValidation(t *testing.T) {
+	tooMany := make([]string, maxBatchGetIDs+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprint(i + 1)
+	}
+	s := &Server{}
+	for _, body := range []string{`{"ids": []}`, `not json`, `{"ids": [` + strings.Join(tooMany, ",") + `]}`} {
+		rec := httptest.NewRecorder()
+		s.BatchGetAssets(rec, httptest.NewRequest(http.MethodPost, "/api/assets/batch-get", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%.40s: expected 400, got %d", body, rec.Code)
+		}
+	}
+}
+
+func TestGetAssetFacetsValidation(t *testing.T) {
+	s := &Server{cfg: &config.Config{MaxSearchTags: 1, MaxSearchQueryLen: 5}}
+	week := GetAssetFacetsParamsInterval("week")
+	long, lang := "cricket", "xx"
+	tags := TagFilter{"a", "b"}
+	for _, params := range []GetAssetFacetsParams{
+		{Field: "title"},
+		{Field: GetAssetFacetsParamsFieldCreatedAt, Interval: &week},
+		{Field: GetAssetFacetsParamsFieldMime, Q: &long},
+		{Field: GetAssetFacetsParamsFieldMime, Tag: &tags},
+		{Field: GetAssetFacetsParamsFieldMime, Lang: &lang},
+	} {
+		rec := httptest.NewRecorder()
+		s.GetAssetFacets(rec, httptest.NewRequest(http.MethodGet, "/api/assets/facets", nil), params)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%+v: expected 400, got %d", params, rec.Code)
+		}
+	}
+}
+
+func TestWaitForAssetsValidation(t *testing.T) {
+	s := &Server{cfg: &config.Config{LongPollMaxTimeout: time.Minute}}
+	negative, zero, garbage := int64(-1), "0s", "soon"
+	for _, params := range []WaitForAssetsParams{{Since: &negative}, {Timeout: &zero}, {Timeout: &garbage}} {
+		rec := httptest.NewRecorder()
+		s.WaitForAssets(rec, httptest.NewRequest(http.MethodGet, "/api/assets/wait", nil), params)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%+v: expected 400, got %d", params, rec.Code)
+		}
+	}
+}
+
+func TestWaitForAssetsLoop(t *testing.T) {
+	ctx := context.Background()
+	never := make(chan time.Time)
+	fired := func() chan time.Time {
+		c := make(chan time.Time, 1)
+		c <- time.Now()
+		return c
+	}
+
+	// Only asset.created looks again, from the cursor the empty look returned.
+	ch := make(chan events.Event)
+	var calls []int64
+	assets, cursor, err := waitForAssets(ctx, 0, ch, never, never, func(since int64) ([]store.Asset, int64, error) {
+		calls = append(calls, since)
+		if len(calls) == 1 {
+			go func() {
+				ch <- events.Event{Type: events.AssetUpdated}
+				ch <- events.Event{Type: events.AssetCreated}
+			}()
+			return nil, 5, nil
+		}
+		return []store.Asset{{ID: 7}}, 7, nil
+	})
+	if err != nil || len(assets) != 1 || cursor != 7 || !slices.Equal(calls, []int64{0, 5}) {
+		t.Fatalf("unexpected result %v, %d, %v after looks from %v", assets, cursor, err, calls)
+	}
+
+	// A recheck looks again too, even once the event stream has been dropped.
+	closed := make(chan events.Event)
+	close(closed)
+	calls = nil
+	_, cursor, err = waitForAssets(ctx, 3, closed, never, fired(), func(since int64) ([]store.Asset, int64, error) {
+		calls = append(calls, since)
+		if len(calls) == 1 {
+			return nil, 3, nil
+		}
+		return []store.Asset{{ID: 4}}, 4, nil
+	})
+	if err != nil || cursor != 4 || len(calls) != 2 {
+		t.Fatalf("expected the recheck to find the asset, got %d, %v after %d looks", cursor, err, len(calls))
+	}
+
+	// At the deadline it answers with no assets and the cursor moved past hidden ones.
+	assets, cursor, err = waitForAssets(ctx, 3, nil, fired(), never, func(int64) ([]store.Asset, int64, error) {
+		return nil, 9, nil
+	})
+	if err != nil || len(assets) != 0 || cursor != 9 {
+		t.Fatalf("expected an empty answer at cursor 9, got %v, %d, %v", assets, cursor, err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := waitForAssets(canceled, 0, nil, never, never, func(int64) ([]store.Asset, int64, error) { return nil, 0, nil }); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the request's cancellation, got %v", err)
+	}
+	boom := errors.New("boom")
+	if _, _, err := waitForAssets(ctx, 0, nil, never, never, func(int64) ([]store.Asset, int64, error) { return nil, 0, boom }); !errors.Is(err, boom) {
+		t.Fatalf("expected the fetch error, got %v", err)
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	s := &Server{}
+	rec := httptest.NewRecorder()
+	s.NormalizeTags(rec, httptest.NewRequest(http.MethodPost, "/api/tags/normalize", strings.NewReader(`{"tags": ["Portrait", " portrait ", "street   art", "  "]}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp TagNormalizeResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := []string{"portrait", "portrait", "street art", ""}
+	if len(resp.Results) != len(want) {
+		t.Fatalf("expected %d results, got %+v", len(want), resp.Results)
+	}
+	for i, r := range resp.Results {
+		if r.Normalized != want[i] {
+			t.Fatalf("result %d: expected %q, got %+v", i, want[i], r)
+		}
+	}
+	if len(resp.Tags) != 2 || resp.Tags[0] != "portrait" || resp.Tags[1] != "street art" {
+		t.Fatalf("unexpected tag set: %v", resp.Tags)
+	}
+
+	for _, body := range []string{`{"tags": []}`, `{"tag": ["a"]}`} {
+		rec := httptest.NewRecorder()
+		s.NormalizeTags(rec, httptest.NewRequest(http.MethodPost, "/api/tags/normalize", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", body, rec.Code)
+		}
+	}
+}
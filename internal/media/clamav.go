@@ -0,0 +1,131 @@
+package media
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// ErrInfected is matched by the error Save returns when the scanner flags an upload.
+var ErrInfected = errors.New("upload is infected")
+
+// ErrScanFailed wraps scanner failures; uploads are rejected rather than stored unscanned.
+var ErrScanFailed = errors.New("virus scan failed")
+
+// InfectedError names the signature the scanner matched.
+type InfectedError struct {
+	Signature string
+}
+
+func (e *InfectedError) Error() string {
+	return fmt.Sprintf("upload is infected: %s", e.Signature)
+}
+
+func (e *InfectedError) Is(target error) bool {
+	return target == ErrInfected
+}
+
+// Scanner checks upload bytes before they are committed to storage. Scan returns an
+// *InfectedError for malicious content and any other error when it could not decide.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) error
+}
+
+// clamavChunkSize is the INSTREAM chunk size; clamd's default StreamMaxLength is far larger.
+const clamavChunkSize = 64 * 1024
+
+// ClamAV scans through a clamd daemon using its INSTREAM command.
+type ClamAV struct {
+	// Addr is host:port for TCP, or a unix socket path (optionally prefixed "unix:").
+	Addr    string
+	Timeout time.Duration
+}
+
+func NewClamAV(addr string, timeout time.Duration) *ClamAV {
+	return &ClamAV{Addr: addr, Timeout: timeout}
+}
+
+func (c *ClamAV) Scan(ctx context.Context, r io.Reader) error {
+	network, addr := "tcp", c.Addr
+	if rest, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", rest
+	} else if strings.HasPrefix(addr, "/") {
+		network = "unix"
+	}
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	reply, err := clamavInstream(conn, r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+	return parseClamAVReply(reply)
+}
+
+// clamavInstream sends r as length-prefixed chunks followed by a zero-length chunk
+// and returns clamd's reply.
+func clamavInstream(conn io.ReadWriter, r io.Reader) (string, error) {
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 4+clamavChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, werr := conn.Write(buf[:4+n]); werr != nil {
+				return "", werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", err
+	}
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return "", err
+	}
+	return strings.TrimRight(reply, "\x00\n"), nil
+}
+
+// parseClamAVReply interprets "stream: OK", "stream: <signature> FOUND", and
+// "<message> ERROR" replies.
+func parseClamAVReply(reply string) error {
+	result := strings.TrimSpace(reply)
+	if _, rest, ok := strings.Cut(result, ": "); ok {
+		result = rest
+	}
+	switch {
+	case result == "OK":
+		return nil
+	case strings.HasSuffix(result, " FOUND"):
+		return &InfectedError{Signature: strings.TrimSuffix(result, " FOUND")}
+	default:
+		return fmt.Errorf("%w: clamd replied %q", ErrScanFailed, reply)
+	}
+}
@@ -0,0 +1,110 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeClamd answers INSTREAM requests, flagging streams that contain "EICAR".
+func fakeClamd(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				cmd := make([]byte, len("zINSTREAM\x00"))
+				if _, err := io.ReadFull(conn, cmd); err != nil || string(cmd) != "zINSTREAM\x00" {
+					io.WriteString(conn, "UNKNOWN COMMAND\x00")
+					return
+				}
+				var data bytes.Buffer
+				for {
+					var size uint32
+					if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					if _, err := io.CopyN(&data, conn, int64(size)); err != nil {
+						return
+					}
+				}
+				if bytes.Contains(data.Bytes(), []byte("EICAR")) {
+					io.WriteString(conn, "stream: Eicar-Test-Signature FOUND\x00")
+					return
+				}
+				io.WriteString(conn, "stream: OK\x00")
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestClamAVScan(t *testing.T) {
+	scanner := NewClamAV(fakeClamd(t), 5*time.Second)
+	ctx := context.Background()
+
+	if err := scanner.Scan(ctx, bytes.NewReader(bytes.Repeat([]byte("clean"), 50000))); err != nil {
+		t.Fatalf("expected clean stream to pass, got %v", err)
+	}
+	err := scanner.Scan(ctx, bytes.NewReader([]byte("X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR")))
+	var infected *InfectedError
+	if !errors.As(err, &infected) || infected.Signature != "Eicar-Test-Signature" || !errors.Is(err, ErrInfected) {
+		t.Fatalf("expected infected error, got %v", err)
+	}
+
+	down := NewClamAV("127.0.0.1:1", time.Second)
+	if err := down.Scan(ctx, bytes.NewReader([]byte("x"))); !errors.Is(err, ErrScanFailed) {
+		t.Fatalf("expected scan failure for unreachable daemon, got %v", err)
+	}
+}
+
+func TestParseClamAVReply(t *testing.T) {
+	if err := parseClamAVReply("stream: OK"); err != nil {
+		t.Fatalf("expected OK, got %v", err)
+	}
+	if err := parseClamAVReply("INSTREAM size limit exceeded. ERROR"); !errors.Is(err, ErrScanFailed) {
+		t.Fatalf("expected scan failure, got %v", err)
+	}
+}
+
+type rejectAll struct{}
+
+func (rejectAll) Scan(context.Context, io.Reader) error {
+	return &InfectedError{Signature: "Test"}
+}
+
+func TestSaveRejectsInfectedBeforeStoring(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	root := t.TempDir()
+	m := NewManager(root, Options{Scanner: rejectAll{}})
+	if _, _, err := m.Save(context.Background(), &buf, "a.png", 1<<20, 1<<20, ""); !errors.Is(err, ErrInfected) {
+		t.Fatalf("expected infected error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, VariantOriginal)); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing stored, got %v", err)
+	}
+}
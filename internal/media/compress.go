@@ -0,0 +1,216 @@
+package media
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CompressedSuffix is appended to the name of an original stored gzip-compressed,
+// e.g. "<sha>.png.gz". Content is still addressed by the hash of the uncompressed bytes.
+const CompressedSuffix = ".gz"
+
+// incompressibleMimes are formats whose data is already compressed, so gzip would
+// only cost CPU on every read.
+var incompressibleMimes = map[string]bool{
+	"image/jpeg": true,
+	"image/webp": true,
+	"image/gif":  true,
+}
+
+// compressOriginal replaces the original at path with a gzip-compressed copy when
+// Options.CompressOriginals is set and compression saves at least a tenth of its
+// size. Otherwise the original is left as is and any stale compressed copy is
+// removed, so exactly one form is on disk. The caller holds the SHA-256's file lock.
+func (m *Manager) compressOriginal(path, mimeType string, size int64) error {
+	gzPath := path + CompressedSuffix
+	// The gzip trailer records the size modulo 2^32, which OpenOriginal relies on.
+	if !m.opts.CompressOriginals || incompressibleMimes[mimeType] || size > math.MaxUint32 {
+		if err := os.Remove(gzPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".compress-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	zw := gzip.NewWriter(tmp)
+	if _, err := io.Copy(zw, bufio.NewReader(src)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if info.Size() > size-size/10 {
+		if err := os.Remove(gzPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := os.Rename(tmp.Name(), gzPath); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// OriginalFile is a stored original opened by OpenOriginal. It reads and seeks
+// over the uncompressed bytes whichever form the original is stored in.
+type OriginalFile struct {
+	f  *os.File
+	zr *gzip.Reader
+	// pos is how far zr has decompressed; off is where the next Read starts.
+	pos, off int64
+	size     int64
+	modTime  time.Time
+}
+
+// OpenOriginal opens the original whose uncompressed path is path, falling back to
+// its compressed form at path+CompressedSuffix.
+func OpenOriginal(path string) (*OriginalFile, error) {
+	if f, err := os.Open(path); err == nil {
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &OriginalFile{f: f, size: info.Size(), modTime: info.ModTime()}, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	f, err := os.Open(path + CompressedSuffix)
+	if err != nil {
+		return nil, err
+	}
+	o, err := openCompressed(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return o, nil
+}
+
+func openCompressed(f *os.File) (*OriginalFile, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var trailer [4]byte
+	if _, err := f.ReadAt(trailer[:], info.Size()-4); err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return &OriginalFile{
+		f:       f,
+		zr:      zr,
+		size:    int64(binary.LittleEndian.Uint32(trailer[:])),
+		modTime: info.ModTime(),
+	}, nil
+}
+
+// OriginalExists reports whether an original is stored at path in either form.
+func OriginalExists(path string) bool {
+	if _, err := os.Stat(path); err == nil {
+		return true
+	}
+	_, err := os.Stat(path + CompressedSuffix)
+	return err == nil
+}
+
+// Size is the length of the uncompressed original.
+func (o *OriginalFile) Size() int64 { return o.size }
+
+// ModTime is when the stored file was last written.
+func (o *OriginalFile) ModTime() time.Time { return o.modTime }
+
+func (o *OriginalFile) Read(p []byte) (int, error) {
+	if o.zr == nil {
+		return o.f.Read(p)
+	}
+	if err := o.sync(); err != nil {
+		return 0, err
+	}
+	n, err := o.zr.Read(p)
+	o.pos += int64(n)
+	o.off = o.pos
+	return n, err
+}
+
+// Seek positions the next Read within the uncompressed bytes. For a compressed
+// original the work is deferred to that Read, so finding the size with
+// io.SeekEnd costs nothing.
+func (o *OriginalFile) Seek(offset int64, whence int) (int64, error) {
+	if o.zr == nil {
+		return o.f.Seek(offset, whence)
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.off
+	case io.SeekEnd:
+		offset += o.size
+	}
+	if offset < 0 {
+		return 0, errors.New("media: seek before start of original")
+	}
+	o.off = offset
+	return offset, nil
+}
+
+// sync brings the decompressor to off. It cannot seek, so moving backwards
+// restarts decompression and moving forwards discards the bytes in between.
+func (o *OriginalFile) sync() error {
+	if o.off < o.pos {
+		if _, err := o.f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := o.zr.Reset(o.f); err != nil {
+			return err
+		}
+		o.pos = 0
+	}
+	if o.off > o.pos {
+		n, err := io.CopyN(io.Discard, o.zr, o.off-o.pos)
+		o.pos += n
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *OriginalFile) Close() error {
+	return o.f.Close()
+}
+
+// trimCompressed strips CompressedSuffix from the name of a stored original.
+func trimCompressed(name string) string {
+	return strings.TrimSuffix(name, CompressedSuffix)
+}
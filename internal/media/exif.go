@@ -0,0 +1,422 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// maxEXIFCacheEntries bounds the number of parsed EXIF blocks kept in memory.
+const maxEXIFCacheEntries = 1024
+
+// maxEXIFValueBytes drops opaque values (maker notes, embedded previews) that are
+// too large to be useful in a JSON response.
+const maxEXIFValueBytes = 256
+
+const (
+	tagExifIFD    = 0x8769
+	tagGPSIFD     = 0x8825
+	tagInteropIFD = 0xA005
+	tagMakerNote  = 0x927C
+	tagUserNote   = 0x9286
+)
+
+var exifTagNames = map[uint16]string{
+	0x010E: "ImageDescription",
+	0x010F: "Make",
+	0x0110: "Model",
+	0x0112: "Orientation",
+	0x011A: "XResolution",
+	0x011B: "YResolution",
+	0x0128: "ResolutionUnit",
+	0x0131: "Software",
+	0x0132: "DateTime",
+	0x013B: "Artist",
+	0x8298: "Copyright",
+	0x829A: "ExposureTime",
+	0x829D: "FNumber",
+	0x8822: "ExposureProgram",
+	0x8827: "ISOSpeedRatings",
+	0x9000: "ExifVersion",
+	0x9003: "DateTimeOriginal",
+	0x9004: "DateTimeDigitized",
+	0x9010: "OffsetTime",
+	0x9011: "OffsetTimeOriginal",
+	0x9201: "ShutterSpeedValue",
+	0x9202: "ApertureValue",
+	0x9204: "ExposureBiasValue",
+	0x9205: "MaxApertureValue",
+	0x9207: "MeteringMode",
+	0x9209: "Flash",
+	0x920A: "FocalLength",
+	0x9286: "UserComment",
+	0xA001: "ColorSpace",
+	0xA002: "PixelXDimension",
+	0xA003: "PixelYDimension",
+	0xA402: "ExposureMode",
+	0xA403: "WhiteBalance",
+	0xA405: "FocalLengthIn35mmFilm",
+	0xA430: "CameraOwnerName",
+	0xA431: "BodySerialNumber",
+	0xA432: "LensSpecification",
+	0xA433: "LensMake",
+	0xA434: "LensModel",
+	0xA435: "LensSerialNumber",
+}
+
+var gpsTagNames = map[uint16]string{
+	0x0000: "GPSVersionID",
+	0x0001: "GPSLatitudeRef",
+	0x0002: "GPSLatitude",
+	0x0003: "GPSLongitudeRef",
+	0x0004: "GPSLongitude",
+	0x0005: "GPSAltitudeRef",
+	0x0006: "GPSAltitude",
+	0x0007: "GPSTimeStamp",
+	0x0010: "GPSImgDirectionRef",
+	0x0011: "GPSImgDirection",
+	0x0012: "GPSMapDatum",
+	0x001D: "GPSDateStamp",
+}
+
+// exifCache holds parsed EXIF keyed by content hash; originals never change
+// once stored, so entries never go stale.
+type exifCache struct {
+	mu      sync.Mutex
+	entries map[string]map[string]any
+}
+
+func (c *exifCache) get(sha string) (map[string]any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.entries[sha]
+	return v, ok
+}
+
+func (c *exifCache) put(sha string, v map[string]any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]map[string]any)
+	}
+	if len(c.entries) >= maxEXIFCacheEntries {
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[sha] = v
+}
+
+// EXIF returns the EXIF tags of the original at path, keyed by tag name.
+// Results are cached by sha. A file without EXIF yields an empty map, not an error.
+func (m *Manager) EXIF(sha, path string) (map[string]any, error) {
+	if v, ok := m.exif.get(sha); ok {
+		return v, nil
+	}
+	f, err := OpenOriginal(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxMetadataScan))
+	if err != nil {
+		return nil, err
+	}
+	tags := parseEXIF(findEXIFBlock(data))
+	m.exif.put(sha, tags)
+	return tags, nil
+}
+
+// readOrientation returns the EXIF Orientation of the original at path; see
+// loadOrientation.
+func readOrientation(path string) int {
+	f, err := OpenOriginal(path)
+	if err != nil {
+		return 1
+	}
+	defer f.Close()
+	return loadOrientation(f)
+}
+
+// loadOrientation returns the EXIF Orientation (1-8) of the file read from r, or 1,
+// the stored pixels as they are, when it has none or an invalid one.
+func loadOrientation(r io.Reader) int {
+	data, err := io.ReadAll(io.LimitReader(r, maxMetadataScan))
+	if err != nil {
+		return 1
+	}
+	if o, ok := parseEXIF(findEXIFBlock(data))["Orientation"].(int); ok && o >= 1 && o <= 8 {
+		return o
+	}
+	return 1
+}
+
+// findEXIFBlock locates the TIFF-structured EXIF payload in a JPEG APP1 segment,
+// a PNG eXIf chunk, a WebP EXIF chunk, or a bare TIFF file.
+func findEXIFBlock(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return jpegEXIFBlock(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return pngEXIFBlock(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return webpEXIFBlock(data)
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return data
+	}
+	return nil
+}
+
+func jpegEXIFBlock(data []byte) []byte {
+	const header = "Exif\x00\x00"
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil
+		}
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		if size < 2 || pos+2+size > len(data) {
+			return nil
+		}
+		segment := data[pos+4 : pos+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte(header)) {
+			return segment[len(header):]
+		}
+		pos += 2 + size
+	}
+	return nil
+}
+
+func pngEXIFBlock(data []byte) []byte {
+	pos := 8
+	for pos+8 <= len(data) {
+		size := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		kind := string(data[pos+4 : pos+8])
+		if size < 0 || pos+8+size > len(data) {
+			return nil
+		}
+		if kind == "eXIf" {
+			return data[pos+8 : pos+8+size]
+		}
+		if kind == "IDAT" || kind == "IEND" {
+			return nil
+		}
+		pos += 12 + size
+	}
+	return nil
+}
+
+func webpEXIFBlock(data []byte) []byte {
+	pos := 12
+	for pos+8 <= len(data) {
+		kind := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		if size < 0 || pos+8+size > len(data) {
+			return nil
+		}
+		if kind == "EXIF" {
+			// Some encoders keep the JPEG-style header inside the chunk.
+			return bytes.TrimPrefix(data[pos+8:pos+8+size], []byte("Exif\x00\x00"))
+		}
+		pos += 8 + size + size%2
+	}
+	return nil
+}
+
+// parseEXIF walks IFD0 and the Exif and GPS sub-IFDs of a TIFF block. Known tags
+// are reported by name, others as "Tag0xNNNN"; the thumbnail IFD is ignored.
+func parseEXIF(block []byte) map[string]any {
+	out := make(map[string]any)
+	if len(block) < 8 {
+		return out
+	}
+	var order binary.ByteOrder
+	switch string(block[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return out
+	}
+	if order.Uint16(block[2:4]) != 42 {
+		return out
+	}
+	t := tiffReader{data: block, order: order}
+	ifd0 := t.readIFD(order.Uint32(block[4:8]))
+	t.collect(out, ifd0, exifTagNames)
+	if e, ok := ifd0[tagExifIFD]; ok {
+		if off, ok := t.offset(e); ok {
+			t.collect(out, t.readIFD(off), exifTagNames)
+		}
+	}
+	if e, ok := ifd0[tagGPSIFD]; ok {
+		if off, ok := t.offset(e); ok {
+			t.collect(out, t.readIFD(off), gpsTagNames)
+		}
+	}
+	return out
+}
+
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	raw   []byte
+}
+
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+func (t tiffReader) readIFD(off uint32) map[uint16]tiffEntry {
+	entries := make(map[uint16]tiffEntry)
+	start := int(off)
+	if start < 0 || start+2 > len(t.data) {
+		return entries
+	}
+	n := int(t.order.Uint16(t.data[start : start+2]))
+	for i := 0; i < n; i++ {
+		p := start + 2 + i*12
+		if p+12 > len(t.data) {
+			break
+		}
+		tag := t.order.Uint16(t.data[p : p+2])
+		typ := t.order.Uint16(t.data[p+2 : p+4])
+		count := t.order.Uint32(t.data[p+4 : p+8])
+		size, ok := tiffTypeSizes[typ]
+		if !ok || count == 0 || uint64(count)*uint64(size) > uint64(len(t.data)) {
+			continue
+		}
+		total := int(count) * size
+		raw := t.data[p+8 : p+12]
+		if total > 4 {
+			valOff := int(t.order.Uint32(t.data[p+8 : p+12]))
+			if valOff < 0 || valOff+total > len(t.data) {
+				continue
+			}
+			raw = t.data[valOff : valOff+total]
+		} else {
+			raw = raw[:total]
+		}
+		entries[tag] = tiffEntry{typ: typ, count: count, raw: raw}
+	}
+	return entries
+}
+
+func (t tiffReader) offset(e tiffEntry) (uint32, bool) {
+	if e.typ != 4 || e.count != 1 {
+		return 0, false
+	}
+	return t.order.Uint32(e.raw), true
+}
+
+func (t tiffReader) collect(out map[string]any, entries map[uint16]tiffEntry, names map[uint16]string) {
+	for tag, e := range entries {
+		switch tag {
+		case tagExifIFD, tagGPSIFD, tagInteropIFD, tagMakerNote:
+			continue
+		}
+		name, ok := names[tag]
+		if !ok {
+			name = fmt.Sprintf("Tag0x%04X", tag)
+		}
+		if tag == tagUserNote && e.typ == 7 && len(e.raw) > 8 {
+			// UserComment carries an 8-byte character code prefix.
+			e.raw = e.raw[8:]
+		}
+		if v := t.value(e); v != nil {
+			out[name] = v
+		}
+	}
+}
+
+func (t tiffReader) value(e tiffEntry) any {
+	switch e.typ {
+	case 2:
+		if s := strings.TrimSpace(strings.TrimRight(string(e.raw), "\x00")); s != "" {
+			return s
+		}
+		return nil
+	case 1, 7:
+		if len(e.raw) > maxEXIFValueBytes {
+			return nil
+		}
+		if s := strings.TrimRight(string(e.raw), "\x00 "); isPrintable(s) && s != "" {
+			return s
+		}
+		nums := make([]int, len(e.raw))
+		for i, b := range e.raw {
+			nums[i] = int(b)
+		}
+		return single(nums)
+	case 3:
+		nums := make([]int, e.items(2))
+		for i := range nums {
+			nums[i] = int(t.order.Uint16(e.raw[i*2:]))
+		}
+		return single(nums)
+	case 4:
+		nums := make([]int64, e.items(4))
+		for i := range nums {
+			nums[i] = int64(t.order.Uint32(e.raw[i*4:]))
+		}
+		return single(nums)
+	case 9:
+		nums := make([]int64, e.items(4))
+		for i := range nums {
+			nums[i] = int64(int32(t.order.Uint32(e.raw[i*4:])))
+		}
+		return single(nums)
+	case 5, 10:
+		nums := make([]float64, e.items(8))
+		for i := range nums {
+			num, den := t.order.Uint32(e.raw[i*8:]), t.order.Uint32(e.raw[i*8+4:])
+			if den == 0 {
+				continue
+			}
+			if e.typ == 10 {
+				nums[i] = float64(int32(num)) / float64(int32(den))
+			} else {
+				nums[i] = float64(num) / float64(den)
+			}
+		}
+		return single(nums)
+	}
+	return nil
+}
+
+// items is how many values of size bytes e holds: its count, unless raw is shorter
+// than the count claims.
+func (e tiffEntry) items(size int) int {
+	return min(int(e.count), len(e.raw)/size)
+}
+
+func single[T any](vals []T) any {
+	switch len(vals) {
+	case 0:
+		return nil
+	case 1:
+		return vals[0]
+	}
+	return vals
+}
+
+func isPrintable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7E {
+			return false
+		}
+	}
+	return true
+}
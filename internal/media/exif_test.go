@@ -0,0 +1,110 @@
+package media
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseEXIF(t *testing.T) {
+	le := binary.LittleEndian
+	entry := func(tag, typ uint16, count, value uint32) []byte {
+		b := make([]byte, 12)
+		le.PutUint16(b[0:], tag)
+		le.PutUint16(b[2:], typ)
+		le.PutUint32(b[4:], count)
+		le.PutUint32(b[8:], value)
+		return b
+	}
+	// Header (8) + IFD0 (30) + "Canon\0" (6) + Exif IFD (30) + rational (8).
+	tiff := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+	tiff = append(tiff, 2, 0)
+	tiff = append(tiff, entry(0x010F, 2, 6, 38)...)
+	tiff = append(tiff, entry(tagExifIFD, 4, 1, 44)...)
+	tiff = append(tiff, 0, 0, 0, 0)
+	tiff = append(tiff, "Canon\x00"...)
+	tiff = append(tiff, 2, 0)
+	tiff = append(tiff, entry(0x829A, 5, 1, 74)...)
+	tiff = append(tiff, entry(0x8827, 3, 1, 400)...)
+	tiff = append(tiff, 0, 0, 0, 0)
+	tiff = append(tiff, 1, 0, 0, 0, 250, 0, 0, 0)
+
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	jpeg := []byte{0xFF, 0xD8, 0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(jpeg[4:], uint16(len(app1)+2))
+	jpeg = append(jpeg, app1...)
+	jpeg = append(jpeg, 0xFF, 0xD9)
+
+	tags := parseEXIF(findEXIFBlock(jpeg))
+	if tags["Make"] != "Canon" {
+		t.Fatalf("unexpected make %v", tags["Make"])
+	}
+	if tags["ISOSpeedRatings"] != 400 {
+		t.Fatalf("unexpected iso %v", tags["ISOSpeedRatings"])
+	}
+	if tags["ExposureTime"] != 0.004 {
+		t.Fatalf("unexpected exposure %v", tags["ExposureTime"])
+	}
+	if _, ok := tags["Tag0x8769"]; ok {
+		t.Fatalf("sub-IFD pointers should not be reported")
+	}
+}
+
+func TestParseEXIFMalformedUserComment(t *testing.T) {
+	le := binary.LittleEndian
+	entry := func(tag, typ uint16, count, value uint32) []byte {
+		b := make([]byte, 12)
+		le.PutUint16(b[0:], tag)
+		le.PutUint16(b[2:], typ)
+		le.PutUint32(b[4:], count)
+		le.PutUint32(b[8:], value)
+		return b
+	}
+	// Header (8) + IFD0 (18) + value. Only an UNDEFINED UserComment carries the
+	// 8-byte character code prefix; one typed SHORT must keep all its bytes.
+	userComment := func(typ uint16, count uint32, value []byte) any {
+		tiff := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+		tiff = append(tiff, 1, 0)
+		tiff = append(tiff, entry(tagUserNote, typ, count, 26)...)
+		tiff = append(tiff, 0, 0, 0, 0)
+		return parseEXIF(append(tiff, value...))["UserComment"]
+	}
+	var shorts []byte
+	for i := range 10 {
+		shorts = le.AppendUint16(shorts, uint16(i))
+	}
+	if got, ok := userComment(3, 10, shorts).([]int); !ok || len(got) != 10 || got[9] != 9 {
+		t.Fatalf("unexpected SHORT user comment %v", got)
+	}
+	if got := userComment(7, 13, []byte("ASCII\x00\x00\x00hello")); got != "hello" {
+		t.Fatalf("unexpected UNDEFINED user comment %v", got)
+	}
+
+	// Entries whose raw bytes are shorter than their count yield what is there.
+	e := tiffEntry{typ: 3, count: 10, raw: []byte{1, 0, 2, 0, 3}}
+	if got := (tiffReader{order: le}).value(e); len(got.([]int)) != 2 {
+		t.Fatalf("expected two values, got %v", got)
+	}
+	if got := (tiffReader{order: le}).value(tiffEntry{typ: 5, count: 1, raw: []byte{1}}); got != nil {
+		t.Fatalf("expected no value, got %v", got)
+	}
+}
+
+func TestEXIFWithoutMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plain.jpg")
+	if err := os.WriteFile(path, []byte{0xFF, 0xD8, 0xFF, 0xD9}, 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	m := NewManager(t.TempDir(), Options{})
+	tags, err := m.EXIF("abc", path)
+	if err != nil {
+		t.Fatalf("exif: %v", err)
+	}
+	if tags == nil || len(tags) != 0 {
+		t.Fatalf("expected empty tags, got %v", tags)
+	}
+	if cached, ok := m.exif.get("abc"); !ok || len(cached) != 0 {
+		t.Fatalf("expected result to be cached")
+	}
+}
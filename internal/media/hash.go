@@ -0,0 +1,51 @@
+package media
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+)
+
+// Hash algorithms Save can address content by. Digests are stored as hex, and their
+// lengths differ, so catalogs mixing algorithms stay addressable by digest alone.
+const (
+	HashSHA256 = "sha256"
+	HashSHA512 = "sha512"
+)
+
+// hashSizes maps each supported algorithm to its digest size in bytes.
+var hashSizes = map[string]int{
+	HashSHA256: sha256.Size,
+	HashSHA512: sha512.Size,
+}
+
+func newHash(algo string) hash.Hash {
+	if algo == HashSHA512 {
+		return sha512.New()
+	}
+	return sha256.New()
+}
+
+// hashAlgo is the algorithm Save addresses new content by.
+func (m *Manager) hashAlgo() string {
+	if m.opts.HashAlgo == "" {
+		return HashSHA256
+	}
+	return m.opts.HashAlgo
+}
+
+// HashAlgoOf reports which supported algorithm produces hex digests like digest,
+// judged by length, or "" when digest is not one.
+func HashAlgoOf(digest string) string {
+	for algo, size := range hashSizes {
+		if len(digest) != size*2 {
+			continue
+		}
+		if _, err := hex.DecodeString(digest); err != nil {
+			return ""
+		}
+		return algo
+	}
+	return ""
+}
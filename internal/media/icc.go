@@ -0,0 +1,419 @@
+package media
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strings"
+	"unicode/utf16"
+)
+
+// maxColorSpaceLen matches the asset.color_space column.
+const maxColorSpaceLen = 64
+
+// maxICCProfileBytes bounds an embedded profile; real ones are a few KB, rarely a
+// few hundred.
+const maxICCProfileBytes = 4 << 20
+
+// xyzD50ToSRGB converts CIE XYZ relative to the D50 profile connection space to
+// linear sRGB (D65), with Bradford chromatic adaptation folded in.
+var xyzD50ToSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// iccProfile is the part of an embedded ICC profile needed to name its color
+// space and convert RGB matrix/TRC profiles, which covers Adobe RGB, Display P3,
+// ProPhoto and most camera and monitor profiles, to sRGB. LUT-based profiles are
+// only named.
+type iccProfile struct {
+	description string
+	// space is the data color space signature, e.g. "RGB" or "CMYK".
+	space string
+	// matrix maps linear profile RGB to linear sRGB; nil when the profile is not a
+	// matrix/TRC RGB profile.
+	matrix *[3][3]float64
+	curves [3]func(float64) float64
+}
+
+// colorSpace is the name the profile gives itself, such as "Adobe RGB (1998)" or
+// "Display P3", falling back to its data color space; "" without a profile.
+func (p *iccProfile) colorSpace() string {
+	if p == nil {
+		return ""
+	}
+	name := p.description
+	if name == "" {
+		name = p.space
+	}
+	if len(name) > maxColorSpaceLen {
+		name = strings.ToValidUTF8(name[:maxColorSpaceLen], "")
+	}
+	return name
+}
+
+// isSRGB reports whether the profile already describes sRGB, so pixels need no
+// conversion.
+func (p *iccProfile) isSRGB() bool {
+	return strings.Contains(strings.ToLower(p.description), "srgb")
+}
+
+// toSRGB converts img from the profile's color space to sRGB, the space browsers
+// assume for untagged images such as our WebP variants. Images in sRGB or in a
+// space the profile cannot be converted from are returned unchanged.
+func (p *iccProfile) toSRGB(img image.Image) image.Image {
+	if p == nil || p.matrix == nil || p.isSRGB() {
+		return img
+	}
+	var linear [3][256]float64
+	for c := range linear {
+		for i := range linear[c] {
+			linear[c][i] = p.curves[c](float64(i) / 255)
+		}
+	}
+	var encode [4097]uint8
+	for i := range encode {
+		encode[i] = uint8(math.Round(srgbEncode(float64(i)/4096) * 255))
+	}
+	quantize := func(v float64) uint8 {
+		switch {
+		case !(v > 0): // also catches NaN from a malformed curve
+			return encode[0]
+		case v >= 1:
+			return encode[4096]
+		}
+		return encode[int(math.Round(v*4096))]
+	}
+
+	m := p.matrix
+	b := img.Bounds()
+	out := image.NewNRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			r, g, bl := linear[0][c.R], linear[1][c.G], linear[2][c.B]
+			out.SetNRGBA(x, y, color.NRGBA{
+				R: quantize(m[0][0]*r + m[0][1]*g + m[0][2]*bl),
+				G: quantize(m[1][0]*r + m[1][1]*g + m[1][2]*bl),
+				B: quantize(m[2][0]*r + m[2][1]*g + m[2][2]*bl),
+				A: c.A,
+			})
+		}
+	}
+	return out
+}
+
+// readICCProfile parses the profile embedded in the original at path, or returns
+// nil when it has none.
+func readICCProfile(path string) *iccProfile {
+	f, err := OpenOriginal(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	return loadICCProfile(f)
+}
+
+func loadICCProfile(r io.Reader) *iccProfile {
+	data, err := io.ReadAll(io.LimitReader(r, maxMetadataScan))
+	if err != nil {
+		return nil
+	}
+	return parseICCProfile(findICCProfile(data))
+}
+
+func srgbEncode(v float64) float64 {
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// findICCProfile returns the ICC profile embedded in a JPEG (APP2 ICC_PROFILE
+// segments), PNG (iCCP chunk), or WebP (ICCP chunk), or nil if there is none.
+func findICCProfile(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return jpegICCProfile(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return pngICCProfile(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return webpICCProfile(data)
+	}
+	return nil
+}
+
+// jpegICCProfile joins the APP2 segments a profile is split across, in their
+// sequence order.
+func jpegICCProfile(data []byte) []byte {
+	const header = "ICC_PROFILE\x00"
+	var chunks [][]byte
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			break
+		}
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		size := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		if size < 2 || pos+2+size > len(data) {
+			break
+		}
+		segment := data[pos+4 : pos+2+size]
+		if marker == 0xE2 && len(segment) > len(header)+2 && string(segment[:len(header)]) == header {
+			seq, count := int(segment[len(header)]), int(segment[len(header)+1])
+			if chunks == nil {
+				chunks = make([][]byte, count)
+			}
+			if seq < 1 || seq > len(chunks) {
+				return nil
+			}
+			chunks[seq-1] = segment[len(header)+2:]
+		}
+		pos += 2 + size
+	}
+	var profile []byte
+	for _, c := range chunks {
+		if c == nil {
+			return nil
+		}
+		profile = append(profile, c...)
+	}
+	return profile
+}
+
+func pngICCProfile(data []byte) []byte {
+	pos := 8
+	for pos+8 <= len(data) {
+		size := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		kind := string(data[pos+4 : pos+8])
+		if size < 0 || pos+8+size > len(data) {
+			return nil
+		}
+		if kind == "iCCP" {
+			// Profile name, NUL, compression method (always zlib), compressed profile.
+			chunk := data[pos+8 : pos+8+size]
+			nul := bytes.IndexByte(chunk, 0)
+			if nul < 0 || nul+2 > len(chunk) {
+				return nil
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(chunk[nul+2:]))
+			if err != nil {
+				return nil
+			}
+			defer zr.Close()
+			profile, err := io.ReadAll(io.LimitReader(zr, maxICCProfileBytes))
+			if err != nil {
+				return nil
+			}
+			return profile
+		}
+		if kind == "IDAT" || kind == "IEND" {
+			return nil
+		}
+		pos += 12 + size
+	}
+	return nil
+}
+
+func webpICCProfile(data []byte) []byte {
+	pos := 12
+	for pos+8 <= len(data) {
+		kind := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		if size < 0 || pos+8+size > len(data) {
+			return nil
+		}
+		if kind == "ICCP" {
+			return data[pos+8 : pos+8+size]
+		}
+		pos += 8 + size + size%2
+	}
+	return nil
+}
+
+// parseICCProfile reads the description, color space, and, for RGB matrix/TRC
+// profiles, the conversion to sRGB. It returns nil for data that is not a profile.
+func parseICCProfile(data []byte) *iccProfile {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil
+	}
+	tags := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint32(data[128:132]))
+	for i := 0; i < count && 132+12*(i+1) <= len(data); i++ {
+		entry := data[132+12*i:]
+		off, size := int(binary.BigEndian.Uint32(entry[4:8])), int(binary.BigEndian.Uint32(entry[8:12]))
+		if off < 0 || size < 8 || off+size > len(data) || off+size < off {
+			continue
+		}
+		tags[string(entry[:4])] = data[off : off+size]
+	}
+
+	p := &iccProfile{
+		description: iccText(tags["desc"]),
+		space:       strings.TrimSpace(string(data[16:20])),
+	}
+	if p.space != "RGB" || string(data[20:24]) != "XYZ " {
+		return p
+	}
+	var primaries [3][3]float64
+	for c, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		xyz, ok := iccXYZ(tags[sig])
+		if !ok {
+			return p
+		}
+		for row := 0; row < 3; row++ {
+			primaries[row][c] = xyz[row]
+		}
+	}
+	for c, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		curve, ok := iccCurve(tags[sig])
+		if !ok {
+			return p
+		}
+		p.curves[c] = curve
+	}
+	var m [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				m[i][j] += xyzD50ToSRGB[i][k] * primaries[k][j]
+			}
+		}
+	}
+	p.matrix = &m
+	return p
+}
+
+// iccText decodes a v2 textDescriptionType or the first record of a v4
+// multiLocalizedUnicodeType.
+func iccText(tag []byte) string {
+	if len(tag) < 12 {
+		return ""
+	}
+	var s string
+	switch string(tag[:4]) {
+	case "desc":
+		n := int(binary.BigEndian.Uint32(tag[8:12]))
+		if n < 0 || 12+n > len(tag) {
+			return ""
+		}
+		s = string(tag[12 : 12+n])
+	case "mluc":
+		if len(tag) < 28 || binary.BigEndian.Uint32(tag[8:12]) == 0 {
+			return ""
+		}
+		n, off := int(binary.BigEndian.Uint32(tag[20:24])), int(binary.BigEndian.Uint32(tag[24:28]))
+		if n < 0 || off < 0 || off+n > len(tag) || off+n < off {
+			return ""
+		}
+		units := make([]uint16, n/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(tag[off+2*i:])
+		}
+		s = string(utf16.Decode(units))
+	}
+	s = strings.TrimSpace(strings.TrimRight(s, "\x00"))
+	if !isPrintable(s) {
+		return ""
+	}
+	return s
+}
+
+// iccXYZ decodes an XYZType holding one s15Fixed16 XYZ triple.
+func iccXYZ(tag []byte) ([3]float64, bool) {
+	var xyz [3]float64
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return xyz, false
+	}
+	for i := range xyz {
+		xyz[i] = s15Fixed16(tag[8+4*i:])
+	}
+	return xyz, true
+}
+
+// iccCurve decodes a curveType or parametricCurveType into a function from encoded
+// to linear values on [0, 1].
+func iccCurve(tag []byte) (func(float64) float64, bool) {
+	if len(tag) < 12 {
+		return nil, false
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:12]))
+		if n < 0 || 12+2*n > len(tag) {
+			return nil, false
+		}
+		switch n {
+		case 0:
+			return func(v float64) float64 { return v }, true
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:14])) / 256
+			return func(v float64) float64 { return math.Pow(v, gamma) }, true
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+		}
+		return func(v float64) float64 {
+			pos := v * float64(n-1)
+			i := min(int(pos), n-2)
+			return table[i] + (table[i+1]-table[i])*(pos-float64(i))
+		}, true
+	case "para":
+		fn := int(binary.BigEndian.Uint16(tag[8:10]))
+		need := []int{1, 3, 4, 5, 7}
+		if fn >= len(need) || len(tag) < 12+4*need[fn] {
+			return nil, false
+		}
+		var a [7]float64
+		for i := 0; i < need[fn]; i++ {
+			a[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g := a[0]
+		switch fn {
+		case 0:
+			return func(v float64) float64 { return math.Pow(v, g) }, true
+		case 1:
+			return func(v float64) float64 {
+				if v >= -a[2]/a[1] {
+					return math.Pow(a[1]*v+a[2], g)
+				}
+				return 0
+			}, true
+		case 2:
+			return func(v float64) float64 {
+				if v >= -a[2]/a[1] {
+					return math.Pow(a[1]*v+a[2], g) + a[3]
+				}
+				return a[3]
+			}, true
+		case 3:
+			return func(v float64) float64 {
+				if v >= a[4] {
+					return math.Pow(a[1]*v+a[2], g)
+				}
+				return a[3] * v
+			}, true
+		default:
+			return func(v float64) float64 {
+				if v >= a[4] {
+					return math.Pow(a[1]*v+a[2], g) + a[5]
+				}
+				return a[3]*v + a[6]
+			}, true
+		}
+	}
+	return nil, false
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
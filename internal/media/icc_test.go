@@ -0,0 +1,172 @@
+package media
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"testing"
+)
+
+// testICCProfile builds a v2 matrix/TRC RGB profile with Adobe RGB (1998)'s D50
+// primaries and gamma under the given description.
+func testICCProfile(description string) []byte {
+	be := binary.BigEndian
+	xyz := func(x, y, z float64) []byte {
+		b := append([]byte("XYZ "), 0, 0, 0, 0)
+		for _, v := range []float64{x, y, z} {
+			b = be.AppendUint32(b, uint32(int32(math.Round(v*65536))))
+		}
+		return b
+	}
+	desc := append([]byte("desc"), 0, 0, 0, 0)
+	desc = be.AppendUint32(desc, uint32(len(description)+1))
+	desc = append(desc, description...)
+	desc = append(desc, 0)
+	trc := append([]byte("curv"), 0, 0, 0, 0, 0, 0, 0, 1, 0x02, 0x33) // gamma 563/256
+
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", desc},
+		{"rXYZ", xyz(0.60974, 0.31111, 0.01947)},
+		{"gXYZ", xyz(0.20528, 0.62567, 0.06087)},
+		{"bXYZ", xyz(0.14919, 0.06322, 0.74457)},
+		{"rTRC", trc},
+		{"gTRC", trc},
+		{"bTRC", trc},
+	}
+	header := make([]byte, 128)
+	copy(header[12:], "mntrRGB XYZ ")
+	copy(header[36:], "acsp")
+	table := be.AppendUint32(nil, uint32(len(tags)))
+	var body []byte
+	offset := 128 + 4 + 12*len(tags)
+	for _, tag := range tags {
+		table = append(table, tag.sig...)
+		table = be.AppendUint32(table, uint32(offset+len(body)))
+		table = be.AppendUint32(table, uint32(len(tag.data)))
+		body = append(body, tag.data...)
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+	}
+	profile := append(append(header, table...), body...)
+	be.PutUint32(profile[0:4], uint32(len(profile)))
+	return profile
+}
+
+// pngWithICCProfile encodes img as a PNG carrying profile in an iCCP chunk.
+func pngWithICCProfile(t *testing.T, img image.Image, profile []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	_, _ = zw.Write(profile)
+	_ = zw.Close()
+	chunk := append([]byte("iCCP"), "test\x00\x00"...)
+	chunk = append(chunk, compressed.Bytes()...)
+
+	data := buf.Bytes()
+	ihdrEnd := 8 + 12 + int(binary.BigEndian.Uint32(data[8:12]))
+	out := append([]byte{}, data[:ihdrEnd]...)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(chunk)-4))
+	out = append(out, chunk...)
+	out = binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(chunk))
+	return append(out, data[ihdrEnd:]...)
+}
+
+func TestICCProfile(t *testing.T) {
+	profile := testICCProfile("Adobe RGB (1998)")
+
+	// A JPEG splits the profile across APP2 segments, which may be stored out of order.
+	half := len(profile) / 2
+	app2 := func(seq byte, part []byte) []byte {
+		segment := append([]byte("ICC_PROFILE\x00"), seq, 2)
+		segment = append(segment, part...)
+		b := []byte{0xFF, 0xE2, 0, 0}
+		binary.BigEndian.PutUint16(b[2:], uint16(len(segment)+2))
+		return append(b, segment...)
+	}
+	jpeg := []byte{0xFF, 0xD8}
+	jpeg = append(jpeg, app2(2, profile[half:])...)
+	jpeg = append(jpeg, app2(1, profile[:half])...)
+	jpeg = append(jpeg, 0xFF, 0xD9)
+	if got := findICCProfile(jpeg); !bytes.Equal(got, profile) {
+		t.Fatalf("expected the APP2 segments to be joined, got %d bytes", len(got))
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 1))
+	pixels := []color.NRGBA{{100, 150, 200, 255}, {200, 60, 40, 255}, {255, 255, 255, 255}, {128, 128, 128, 128}}
+	for x, c := range pixels {
+		img.SetNRGBA(x, 0, c)
+	}
+	p := parseICCProfile(findICCProfile(pngWithICCProfile(t, img, profile)))
+	if p.colorSpace() != "Adobe RGB (1998)" || p.matrix == nil {
+		t.Fatalf("unexpected profile %+v", p)
+	}
+
+	// Expected values from the Adobe RGB to sRGB conversion, relative colorimetric.
+	want := []color.NRGBA{{66, 151, 203, 255}, {231, 57, 34, 255}, {255, 255, 255, 255}, {129, 129, 129, 128}}
+	converted := p.toSRGB(img)
+	near := func(a, b uint8) bool {
+		d := int(a) - int(b)
+		return d >= -1 && d <= 1
+	}
+	for x, w := range want {
+		got := color.NRGBAModel.Convert(converted.At(x, 0)).(color.NRGBA)
+		if !near(got.R, w.R) || !near(got.G, w.G) || !near(got.B, w.B) || got.A != w.A {
+			t.Fatalf("pixel %d: expected %v, got %v", x, w, got)
+		}
+	}
+
+	if srgb := parseICCProfile(testICCProfile("sRGB IEC61966-2.1")); srgb.toSRGB(img) != image.Image(img) {
+		t.Fatalf("expected sRGB images to be left alone")
+	}
+	var none *iccProfile
+	if none.colorSpace() != "" || none.toSRGB(img) != image.Image(img) {
+		t.Fatalf("expected images without a profile to be left alone")
+	}
+	if parseICCProfile([]byte("not a profile")) != nil || findICCProfile([]byte{0xFF, 0xD8, 0xFF, 0xD9}) != nil {
+		t.Fatalf("expected no profile")
+	}
+}
+
+func TestSaveRecordsColorSpace(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := range img.Pix {
+		img.Pix[i] = 200
+	}
+	m := NewManager(t.TempDir(), Options{})
+	res, _, err := m.Save(context.Background(), bytes.NewReader(pngWithICCProfile(t, img, testICCProfile("Adobe RGB (1998)"))), "a.png", 1<<20, 1<<20, "")
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if res.ColorSpace != "Adobe RGB (1998)" {
+		t.Fatalf("unexpected color space %q", res.ColorSpace)
+	}
+	if got, err := m.Stored(res.SHA256); err != nil || got.ColorSpace != res.ColorSpace {
+		t.Fatalf("expected Stored to report the color space, got %+v, %v", got, err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	res, _, err = m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "b.png", 1<<20, 1<<20, "")
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if res.ColorSpace != "" {
+		t.Fatalf("expected no color space for an untagged image, got %q", res.ColorSpace)
+	}
+}
@@ -2,12 +2,15 @@ package media
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"image"
+	"image/color"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
@@ -17,8 +20,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
-	_ "golang.org/x/image/webp"
+	"golang.org/x/image/draw"
 )
 
 const (
@@ -27,16 +31,81 @@ const (
 	VariantThumb    = "thumb"
 )
 
+// Default derivative widths, used when Options leaves them unset.
+const (
+	DefaultContentMaxWidth = 1600
+	DefaultThumbMaxWidth   = 400
+)
+
 var ErrTooLarge = errors.New("upload too large")
 var ErrInvalidImage = errors.New("invalid image")
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+var ErrEmptyUpload = errors.New("uploaded file is empty")
+var ErrTruncatedImage = errors.New("image data is truncated or corrupt")
+var ErrTooManyPixels = errors.New("image dimensions exceed pixel limit")
+var ErrDimensionsTooLarge = errors.New("image width or height exceeds limit")
+var ErrNotStored = errors.New("no stored original with this sha256")
+
+// Options tunes variant generation.
+type Options struct {
+	// ContentMaxWidth and ThumbMaxWidth bound the content and default thumb
+	// derivatives; zero uses DefaultContentMaxWidth and DefaultThumbMaxWidth.
+	// Images are never upscaled.
+	ContentMaxWidth int
+	ThumbMaxWidth   int
+	// ThumbWidths lists extra thumbnail widths generated alongside the default thumb.
+	ThumbWidths []int
+	// ExtAliases maps lowercase extensions (without the dot) to the canonical one
+	// originals are stored under, e.g. "jfif" → "jpeg".
+	ExtAliases map[string]string
+	// FormatMaxBytes and FormatMaxPixels override Save's maxBytes and maxPixels for
+	// a decoded format ("jpeg", "png", "gif", "webp").
+	FormatMaxBytes  map[string]int64
+	FormatMaxPixels map[string]int
+	// MaxWidth and MaxHeight, when positive, reject images wider or taller than
+	// this regardless of their pixel count.
+	MaxWidth  int
+	MaxHeight int
+	// AsyncVariants makes Save store only the original and queue derivative
+	// generation for the workers started by StartVariantWorkers.
+	AsyncVariants    bool
+	VariantQueueSize int
+	// VariantsDone, when set, is called after queued or on-demand derivative
+	// generation for sha finishes, with its error, once the sha is no longer pending.
+	VariantsDone func(sha string, err error)
+	// PreviewMaxWidth, when positive, makes Save return a WebP preview no wider and no
+	// taller than this as a data URI, small enough to embed in asset JSON as a
+	// placeholder.
+	PreviewMaxWidth int
+	// Scanner, when set, must clear every upload before Save moves it into place.
+	Scanner Scanner
+	// HashAlgo is the algorithm Save addresses new content by, HashSHA256 when empty.
+	HashAlgo string
+	// CompressOriginals makes Save gzip originals at rest where that saves space;
+	// see compressOriginal. Read them back with OpenOriginal.
+	CompressOriginals bool
+	// RejectTransparency makes Save refuse images with transparent pixels with
+	// ErrTransparency.
+	RejectTransparency bool
+	// Background, when set, is the color transparent pixels are flattened against in
+	// derivatives and previews. Originals are always stored as uploaded.
+	Background color.Color
+}
 
 // Manager handles filesystem operations for assets.
 type Manager struct {
-	root string
+	root     string
+	opts     Options
+	stats    cacheStats
+	exif     exifCache
+	variants *variantQueue
+	// files serializes writes to the files of one SHA-256, so concurrent saves of
+	// the same content cannot interleave renames or derivative generation.
+	files keyedMutex
 }
 
-func NewManager(root string) *Manager {
-	return &Manager{root: root}
+func NewManager(root string, opts Options) *Manager {
+	return &Manager{root: root, opts: opts, variants: newVariantQueue(opts.VariantQueueSize)}
 }
 
 // Save streams the upload to disk, computes SHA-256, validates pixels, and generates stub variants.
@@ -44,119 +113,491 @@ type SaveResult struct {
 	SHA256 string
 	Bytes  int64
 	Mime   string
+	// Width and Height are the size as displayed, swapped from the stored pixels'
+	// when the EXIF orientation turns the image by 90 degrees.
 	Width  int
 	Height int
 	Ext    string
+	// HasAlpha reports whether the image has transparent pixels.
+	HasAlpha bool
+	// ColorSpace names the color space of the original's embedded ICC profile, e.g.
+	// "Adobe RGB (1998)"; empty when it has none and is taken to be sRGB.
+	ColorSpace string
+	// HashAlgo is the algorithm SHA256 was computed with; despite its name, SHA256
+	// holds whichever digest content is addressed by.
+	HashAlgo string
+	// Preview is a data URI of the tiny preview; empty unless Options.PreviewMaxWidth is set.
+	Preview string
+	// VariantsPending is set when derivative generation was queued rather than done.
+	VariantsPending bool
+	Timings         SaveTimings
 }
 
-func (m *Manager) Save(ctx context.Context, r io.Reader, filename string, maxBytes int64, maxPixels int) (*SaveResult, error) {
+// SaveTimings records how long each phase of Save took.
+type SaveTimings struct {
+	// Write covers streaming the upload to disk and hashing it.
+	Write time.Duration
+	// Decode covers reading the header and fully decoding the pixels.
+	Decode time.Duration
+	// Variants covers generating derivatives, or queueing them with AsyncVariants.
+	Variants time.Duration
+}
+
+// When expectedSHA256 is non-empty the computed hash must match it (case-insensitively)
+// or ErrChecksumMismatch is returned before anything is written to the store.
+// maxBytes and maxPixels apply to formats without an override in Options. With a
+// Scanner configured, an upload it rejects is never moved into the store. When Save
+// fails after storing new content, it removes the original and derivatives it wrote,
+// so no file is left behind without an asset. When it succeeds, discard removes them
+// too, for a caller that fails to create the asset; it does nothing when the content
+// was already stored, since those files belong to an existing asset.
+func (m *Manager) Save(ctx context.Context, r io.Reader, filename string, maxBytes int64, maxPixels int, expectedSHA256 string) (_ *SaveResult, discard func(), err error) {
 	if err := os.MkdirAll(m.root, 0o755); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	lim := &io.LimitedReader{R: r, N: maxBytes + 1}
+	// The format is only known once the file is on disk, so stream up to the
+	// largest limit any format allows and apply the format's own limit afterwards.
+	ceiling := maxBytes
+	for _, n := range m.opts.FormatMaxBytes {
+		ceiling = max(ceiling, n)
+	}
+	lim := &io.LimitedReader{R: r, N: ceiling + 1}
 	br := bufio.NewReader(lim)
 	peek, _ := br.Peek(8192)
 	mimeType := http.DetectContentType(peek)
 
 	tmp, err := os.CreateTemp(m.root, "upload-*")
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer func() {
 		tmp.Close()
 		os.Remove(tmp.Name())
 	}()
 
-	hash := sha256.New()
-	mw := io.MultiWriter(tmp, hash)
-	written, err := io.Copy(mw, br)
+	var timings SaveTimings
+	start := time.Now()
+	hash := newHash(m.hashAlgo())
+	writers := []io.Writer{tmp, hash}
+	// The expected checksum is a SHA-256 whatever new content is addressed by.
+	check := hash
+	if expectedSHA256 != "" && m.hashAlgo() != HashSHA256 {
+		check = sha256.New()
+		writers = append(writers, check)
+	}
+	written, err := io.Copy(io.MultiWriter(writers...), br)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	timings.Write = time.Since(start)
+	if lim.N < 0 || written > ceiling {
+		return nil, nil, ErrTooLarge
 	}
-	if lim.N < 0 || written > maxBytes {
-		return nil, ErrTooLarge
+	if written == 0 {
+		return nil, nil, ErrEmptyUpload
+	}
+	shaHex := hex.EncodeToString(hash.Sum(nil))
+	if expectedSHA256 != "" && !strings.EqualFold(strings.TrimSpace(expectedSHA256), hex.EncodeToString(check.Sum(nil))) {
+		return nil, nil, ErrChecksumMismatch
 	}
 
 	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	start = time.Now()
 	cfg, format, err := image.DecodeConfig(tmp)
 	if err != nil {
-		return nil, ErrInvalidImage
+		if written > maxBytes {
+			return nil, nil, ErrTooLarge
+		}
+		return nil, nil, ErrInvalidImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, nil, ErrInvalidImage
+	}
+	byteLimit, pixelLimit := m.limitsFor(format, maxBytes, maxPixels)
+	if written > byteLimit {
+		return nil, nil, ErrTooLarge
 	}
-	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxPixels {
-		return nil, ErrInvalidImage
+	// Decoders allocate the full declared canvas up front, so a tiny file claiming
+	// huge dimensions must be rejected before any pixel data is decoded.
+	if exceedsPixelBudget(cfg.Width, cfg.Height, pixelLimit) {
+		return nil, nil, ErrTooManyPixels
+	}
+	if (m.opts.MaxWidth > 0 && cfg.Width > m.opts.MaxWidth) || (m.opts.MaxHeight > 0 && cfg.Height > m.opts.MaxHeight) {
+		return nil, nil, ErrDimensionsTooLarge
+	}
+	// The header can be intact while the pixel data is cut short; only a full decode notices.
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	img, err := decodeBounded(ctx, tmp, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	timings.Decode = time.Since(start)
+	alpha := hasAlpha(img)
+	if alpha && m.opts.RejectTransparency {
+		return nil, nil, ErrTransparency
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	profile := loadICCProfile(tmp)
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	orientation := loadOrientation(tmp)
+	preview, err := m.preview(img, profile, orientation)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	ext := strings.ToLower(filepath.Ext(filename))
-	if ext == "" {
-		if mimeExts, _ := mime.ExtensionsByType(mimeType); len(mimeExts) > 0 {
-			ext = mimeExts[0]
+	if m.opts.Scanner != nil {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+		if err := m.opts.Scanner.Scan(ctx, tmp); err != nil {
+			return nil, nil, err
 		}
 	}
+
+	ext := m.CanonicalExt(filename, mimeType)
 	if ext == "" {
 		// default to format-based extension
 		ext = "." + format
 	}
-	shaHex := hex.EncodeToString(hash.Sum(nil))
 
 	origPath := m.pathFor(shaHex, VariantOriginal, ext)
 	if err := m.ensureDir(origPath); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
+	unlock := m.files.lock(shaHex)
+	// Content that was already stored belongs to an existing asset and must outlive
+	// this upload whatever happens to it.
+	existing, err := m.storedOriginal(shaHex)
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+	if existing == "" {
+		defer func() {
+			if err != nil {
+				m.discard(shaHex, origPath)
+			}
+		}()
+	}
 	// Try to rename first (fast path)
 	if err := os.Rename(tmp.Name(), origPath); err != nil {
 		// If rename fails, try copy as fallback (handles cross-device moves)
 		if copyErr := copyFile(tmp.Name(), origPath); copyErr != nil {
+			unlock()
 			// If both rename and copy fail, return the original error
-			return nil, fmt.Errorf("failed to move file to destination: rename failed (%w), copy failed (%v)", err, copyErr)
+			return nil, nil, fmt.Errorf("failed to move file to destination: rename failed (%w), copy failed (%v)", err, copyErr)
 		}
 	}
+	err = m.compressOriginal(origPath, mimeType, written)
+	unlock()
+	if err != nil {
+		return nil, nil, fmt.Errorf("compress original: %w", err)
+	}
 
-	if err := m.generateVariants(origPath, shaHex); err != nil {
-		return nil, err
+	start = time.Now()
+	if m.opts.AsyncVariants {
+		err = m.enqueueVariants(origPath, shaHex)
+	} else {
+		err = m.generateVariants(origPath, shaHex)
+	}
+	if err != nil {
+		return nil, nil, err
 	}
+	timings.Variants = time.Since(start)
 
+	discard = func() {}
+	if existing == "" {
+		discard = func() { m.discard(shaHex, origPath) }
+	}
+	width, height := orientedSize(cfg.Width, cfg.Height, orientation)
 	return &SaveResult{
-		SHA256: shaHex,
-		Bytes:  written,
-		Mime:   mimeType,
-		Width:  cfg.Width,
-		Height: cfg.Height,
-		Ext:    ext,
-	}, nil
+		SHA256:          shaHex,
+		HashAlgo:        m.hashAlgo(),
+		Bytes:           written,
+		Mime:            mimeType,
+		Width:           width,
+		Height:          height,
+		Ext:             ext,
+		HasAlpha:        alpha,
+		ColorSpace:      profile.colorSpace(),
+		Preview:         preview,
+		VariantsPending: m.VariantsPending(shaHex),
+		Timings:         timings,
+	}, discard, nil
+}
+
+// limitsFor returns the byte and pixel limits for a decoded format, falling back
+// to the given global limits where the format has no override.
+func (m *Manager) limitsFor(format string, maxBytes int64, maxPixels int) (int64, int) {
+	if n, ok := m.opts.FormatMaxBytes[format]; ok {
+		maxBytes = n
+	}
+	if n, ok := m.opts.FormatMaxPixels[format]; ok {
+		maxPixels = n
+	}
+	return maxBytes, maxPixels
+}
+
+// exceedsPixelBudget reports whether width*height is over maxPixels, without
+// overflowing on hostile header values.
+func exceedsPixelBudget(width, height, maxPixels int) bool {
+	return int64(width)*int64(height) > int64(maxPixels)
+}
+
+// decodeBounded fully decodes the image to prove the pixel data is intact, and
+// returns it. The caller has already capped the declared dimensions, which bounds
+// memory; ctx bounds wall time. A decoder that is abandoned on cancellation
+// finishes in the background but is still limited by the pixel budget.
+func decodeBounded(ctx context.Context, r io.Reader, declared image.Config) (image.Image, error) {
+	type result struct {
+		img image.Image
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		img, _, err := image.Decode(r)
+		if err != nil {
+			done <- result{err: ErrTruncatedImage}
+			return
+		}
+		// Frames larger than the header claims would slip past the pixel check.
+		if b := img.Bounds(); b.Dx() > declared.Width || b.Dy() > declared.Height {
+			done <- result{err: ErrTooManyPixels}
+			return
+		}
+		done <- result{img: img}
+	}()
+	select {
+	case res := <-done:
+		return res.img, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 }
 
+// preview encodes img scaled into an Options.PreviewMaxWidth square as a WebP data
+// URI, in sRGB like the variants. Bounding the height too keeps the URI small for
+// images of any aspect ratio. It returns "" when previews are disabled.
+func (m *Manager) preview(img image.Image, profile *iccProfile, orientation int) (string, error) {
+	if m.opts.PreviewMaxWidth <= 0 {
+		return "", nil
+	}
+	var buf bytes.Buffer
+	n := m.opts.PreviewMaxWidth
+	if err := encodeWebP(&buf, m.forWeb(fitBox(img, n, n), profile, orientation)); err != nil {
+		return "", fmt.Errorf("generate preview: %w", err)
+	}
+	return "data:image/webp;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// derivative scales img to fit maxWidth and prepares it for the web with forWeb.
+// Turning and converting after scaling keeps the per-pixel work proportional to the
+// output.
+func (m *Manager) derivative(img image.Image, profile *iccProfile, orientation, maxWidth int) image.Image {
+	return m.forWeb(fitOriented(img, orientation, maxWidth), profile, orientation)
+}
+
+// forWeb turns img upright by its EXIF orientation, which our WebP output does not
+// carry, converts its pixels from the original's embedded color profile to sRGB,
+// which browsers assume for untagged WebP, and flattens transparency if configured.
+func (m *Manager) forWeb(img image.Image, profile *iccProfile, orientation int) image.Image {
+	return m.flatten(profile.toSRGB(orient(img, orientation)))
+}
+
+// generateVariants writes the WebP derivatives of the original at origPath. The
+// original is decoded at most once, and only if some derivative is missing; the
+// output depends only on the original, so regenerating a variant reproduces it.
+// Generation for one SHA-256 is serialized, so a second caller finds the first
+// one's output cached instead of racing it.
 func (m *Manager) generateVariants(origPath, sha string) error {
-	contentPath := m.pathFor(sha, VariantContent, ".webp")
-	thumbPath := m.pathFor(sha, VariantThumb, ".webp")
-	if err := m.ensureDir(contentPath); err != nil {
+	return m.writeVariants(origPath, sha, false)
+}
+
+// RegenerateVariants rewrites every derivative of sha from its stored original, so
+// existing assets pick up changes to the resize settings or logic. Each file is
+// replaced atomically and keeps being served until its replacement is in place. It
+// returns ErrNotStored when there is no original for sha.
+func (m *Manager) RegenerateVariants(sha string) error {
+	origPath, err := m.storedOriginal(sha)
+	if err != nil {
 		return err
 	}
-	if err := m.ensureDir(thumbPath); err != nil {
-		return err
+	if origPath == "" {
+		return ErrNotStored
 	}
-	// Stub generation: copy original to variants. Replace with libvips later.
-	if err := copyIfMissing(origPath, contentPath); err != nil {
-		return err
+	return m.writeVariants(origPath, sha, true)
+}
+
+// writeVariants generates the derivatives of the original at origPath, replacing
+// existing ones when replace is set and keeping them otherwise.
+func (m *Manager) writeVariants(origPath, sha string, replace bool) error {
+	defer m.files.lock(sha)()
+
+	targets := []struct {
+		path  string
+		width int
+	}{
+		{m.pathFor(sha, VariantContent, ".webp"), orDefault(m.opts.ContentMaxWidth, DefaultContentMaxWidth)},
+		{m.pathFor(sha, VariantThumb, ".webp"), orDefault(m.opts.ThumbMaxWidth, DefaultThumbMaxWidth)},
 	}
-	if err := copyIfMissing(origPath, thumbPath); err != nil {
-		return err
+	for _, width := range m.opts.ThumbWidths {
+		targets = append(targets, struct {
+			path  string
+			width int
+		}{m.PathForThumbWidth(sha, width), width})
+	}
+
+	var src image.Image
+	var profile *iccProfile
+	var orientation int
+	for _, t := range targets {
+		if err := m.ensureDir(t.path); err != nil {
+			return err
+		}
+		produce := func(w io.Writer) error {
+			if src == nil {
+				img, err := decodeFile(origPath)
+				if err != nil {
+					return err
+				}
+				src, profile, orientation = img, readICCProfile(origPath), readOrientation(origPath)
+			}
+			return encodeWebP(w, m.derivative(src, profile, orientation, t.width))
+		}
+		var err error
+		if replace {
+			err = m.replace(t.path, produce)
+		} else {
+			err = m.materialize(t.path, produce)
+		}
+		if err != nil {
+			return fmt.Errorf("generate %s: %w", filepath.Base(t.path), err)
+		}
 	}
 	return nil
 }
 
-func (m *Manager) ensureDir(path string) error {
-	return os.MkdirAll(filepath.Dir(path), 0o755)
+// decodeFile decodes the original at path, which may be stored compressed.
+func decodeFile(path string) (image.Image, error) {
+	f, err := OpenOriginal(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	return img, nil
+}
+
+// fitWithin scales img down, keeping its aspect ratio, to fit a box maxWidth wide and
+// webpMaxDimension high, so very tall originals still give encodable derivatives.
+// A maxWidth of zero or above webpMaxDimension is taken as webpMaxDimension. Images
+// that already fit are returned unchanged.
+func fitWithin(img image.Image, maxWidth int) image.Image {
+	if maxWidth <= 0 || maxWidth > webpMaxDimension {
+		maxWidth = webpMaxDimension
+	}
+	return fitBox(img, maxWidth, webpMaxDimension)
+}
+
+// fitOriented is fitWithin for img as displayed under an EXIF orientation: for the
+// orientations that swap width and height (5-8), maxWidth bounds the stored height.
+func fitOriented(img image.Image, orientation, maxWidth int) image.Image {
+	if orientation < 5 {
+		return fitWithin(img, maxWidth)
+	}
+	if maxWidth <= 0 || maxWidth > webpMaxDimension {
+		maxWidth = webpMaxDimension
+	}
+	return fitBox(img, webpMaxDimension, maxWidth)
+}
+
+// fitBox scales img down, keeping its aspect ratio, to fit a maxWidth by maxHeight box.
+func fitBox(img image.Image, maxWidth, maxHeight int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxWidth && h <= maxHeight {
+		return img
+	}
+	width, height := maxWidth, max(1, (h*maxWidth+w/2)/w)
+	if height > maxHeight {
+		width, height = max(1, (w*maxHeight+h/2)/h), maxHeight
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// orientedSize returns the size of a width by height image as displayed under an
+// EXIF orientation.
+func orientedSize(width, height, orientation int) (int, int) {
+	if orientation >= 5 {
+		return height, width
+	}
+	return width, height
+}
+
+// orient turns img upright by its EXIF orientation: 2-4 mirror or rotate it by 180
+// degrees, 5-8 transpose it, rotating by 90 degrees either way with or without a
+// mirror. Orientation 1, or any other value, returns img unchanged.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	src, ok := img.(*image.NRGBA)
+	if !ok || src.Rect.Min != (image.Point{}) {
+		src = image.NewNRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+		draw.Draw(src, src.Rect, img, img.Bounds().Min, draw.Src)
+	}
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	width, height := orientedSize(w, h, orientation)
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):][:4], src.Pix[src.PixOffset(sx, sy):][:4])
+		}
+	}
+	return dst
 }
 
-func copyIfMissing(src, dst string) error {
-	if _, err := os.Stat(dst); err == nil {
-		return nil
+func orDefault(v, def int) int {
+	if v > 0 {
+		return v
 	}
-	return copyFile(src, dst)
+	return def
+}
+
+func (m *Manager) ensureDir(path string) error {
+	return os.MkdirAll(filepath.Dir(path), 0o755)
 }
 
 func copyFile(src, dst string) error {
@@ -190,10 +631,125 @@ func (m *Manager) pathFor(sha, variant, ext string) string {
 	}
 }
 
+// CanonicalExt returns the extension an original is stored under: the filename's
+// extension lowercased with ExtAliases applied, or one derived from mimeType when the
+// name has none. It returns "" if neither yields an extension.
+func (m *Manager) CanonicalExt(filename, mimeType string) string {
+	ext := strings.ToLower(strings.TrimSpace(filepath.Ext(filename)))
+	if ext == "" {
+		// ExtensionsByType sorts its result, so image/jpeg yields ".jfif" first; the
+		// alias table below folds it back to the canonical form.
+		if mimeExts, _ := mime.ExtensionsByType(mimeType); len(mimeExts) > 0 {
+			ext = mimeExts[0]
+		}
+	}
+	if ext == "" {
+		return ""
+	}
+	if canonical, ok := m.opts.ExtAliases[ext[1:]]; ok {
+		return "." + canonical
+	}
+	return ext
+}
+
+// Stored describes the original already on disk for sha, as Save would have, so an
+// asset can reference it without the bytes being uploaded again. Missing derivatives
+// are generated. It returns ErrNotStored when there is no such original, and
+// ErrTransparency when Options.RejectTransparency refuses it.
+func (m *Manager) Stored(sha string) (*SaveResult, error) {
+	algo := HashAlgoOf(sha)
+	if algo == "" || strings.ToLower(sha) != sha {
+		return nil, ErrNotStored
+	}
+	origPath, err := m.storedOriginal(sha)
+	if err != nil {
+		return nil, err
+	}
+	if origPath == "" {
+		return nil, ErrNotStored
+	}
+
+	f, err := OpenOriginal(origPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	peek, _ := br.Peek(8192)
+	cfg, _, err := image.DecodeConfig(br)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	img, err := decodeFile(origPath)
+	if err != nil {
+		return nil, err
+	}
+	alpha := hasAlpha(img)
+	if alpha && m.opts.RejectTransparency {
+		return nil, ErrTransparency
+	}
+	if err := m.EnsureVariants(sha, origPath); err != nil {
+		return nil, err
+	}
+	profile, orientation := readICCProfile(origPath), readOrientation(origPath)
+	preview, err := m.preview(img, profile, orientation)
+	if err != nil {
+		return nil, err
+	}
+	width, height := orientedSize(cfg.Width, cfg.Height, orientation)
+	return &SaveResult{
+		SHA256:     sha,
+		HashAlgo:   algo,
+		Bytes:      f.Size(),
+		Mime:       http.DetectContentType(peek),
+		Width:      width,
+		Height:     height,
+		Ext:        filepath.Ext(origPath),
+		HasAlpha:   alpha,
+		ColorSpace: profile.colorSpace(),
+		Preview:    preview,
+	}, nil
+}
+
+// storedOriginal returns the uncompressed path of the original stored for sha under
+// any extension, or "" when there is none.
+func (m *Manager) storedOriginal(sha string) (string, error) {
+	matches, err := filepath.Glob(m.pathFor(sha, VariantOriginal, ".*"))
+	if err != nil || len(matches) == 0 {
+		return "", err
+	}
+	return trimCompressed(matches[0]), nil
+}
+
+// discard removes the original at origPath, in either form, and every derivative of
+// sha. Save calls it to undo a failed upload of new content; files that are already
+// gone are ignored.
+func (m *Manager) discard(sha, origPath string) {
+	defer m.files.lock(sha)()
+
+	paths := []string{
+		origPath,
+		origPath + CompressedSuffix,
+		m.pathFor(sha, VariantContent, ".webp"),
+		m.pathFor(sha, VariantThumb, ".webp"),
+	}
+	for _, width := range m.opts.ThumbWidths {
+		paths = append(paths, m.PathForThumbWidth(sha, width))
+	}
+	for _, path := range paths {
+		os.Remove(path)
+	}
+}
+
 func (m *Manager) PathForVariant(sha, variant, ext string) string {
 	return m.pathFor(sha, variant, ext)
 }
 
+// PathForThumbWidth returns the path of the thumbnail generated for a configured width.
+func (m *Manager) PathForThumbWidth(sha string, width int) string {
+	return filepath.Join(m.root, VariantThumb, sha[0:2], sha[2:4], fmt.Sprintf("%s-w%d.webp", sha, width))
+}
+
 func (m *Manager) IsWritable() error {
 	testPath := filepath.Join(m.root, ".writetest")
 	if err := os.MkdirAll(m.root, 0o755); err != nil {
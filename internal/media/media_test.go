@@ -1,11 +1,32 @@
 package media
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/gen2brain/webp"
 )
 
 func TestPathForVariant(t *testing.T) {
-	m := NewManager("/root")
+	m := NewManager("/root", Options{})
 	sha := "abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789"
 	orig := m.PathForVariant(sha, VariantOriginal, ".jpg")
 	if orig != "/root/original/ab/cd/"+sha+".jpg" {
@@ -19,4 +40,783 @@ func TestPathForVariant(t *testing.T) {
 	if thumb != "/root/thumb/ab/cd/"+sha+".webp" {
 		t.Fatalf("unexpected thumb path: %s", thumb)
 	}
+	sized := m.PathForThumbWidth(sha, 400)
+	if sized != "/root/thumb/ab/cd/"+sha+"-w400.webp" {
+		t.Fatalf("unexpected sized thumb path: %s", sized)
+	}
+}
+
+func TestSaveChecksumMismatch(t *testing.T) {
+	m := NewManager(t.TempDir(), Options{})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	good := hex.EncodeToString(sum[:])
+
+	if _, _, err := m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "a.png", 1<<20, 1<<20, strings.Repeat("0", 64)); err != ErrChecksumMismatch {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+	res, _, err := m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "a.png", 1<<20, 1<<20, strings.ToUpper(good))
+	if err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if res.SHA256 != good {
+		t.Fatalf("unexpected sha: %s", res.SHA256)
+	}
+}
+
+func TestSaveRejectsEmptyAndTruncated(t *testing.T) {
+	m := NewManager(t.TempDir(), Options{})
+	if _, _, err := m.Save(context.Background(), bytes.NewReader(nil), "a.png", 1<<20, 1<<20, ""); err != ErrEmptyUpload {
+		t.Fatalf("expected ErrEmptyUpload, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i * 7)
+	}
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	truncated := buf.Bytes()[:buf.Len()/2]
+	if _, _, err := m.Save(context.Background(), bytes.NewReader(truncated), "a.png", 1<<20, 1<<20, ""); err != ErrTruncatedImage {
+		t.Fatalf("expected ErrTruncatedImage, got %v", err)
+	}
+}
+
+func TestSaveRejectsDecompressionBomb(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	// Rewrite the IHDR chunk to declare 100000x100000 pixels over the same tiny payload.
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:20], 100000)
+	binary.BigEndian.PutUint32(data[20:24], 100000)
+	binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+
+	m := NewManager(t.TempDir(), Options{})
+	if _, _, err := m.Save(context.Background(), bytes.NewReader(data), "bomb.png", 1<<20, 50_000_000, ""); err != ErrTooManyPixels {
+		t.Fatalf("expected ErrTooManyPixels, got %v", err)
+	}
+}
+
+func TestSaveMaxDimensions(t *testing.T) {
+	encode := func(w, h int) []byte {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+			t.Fatalf("encode png: %v", err)
+		}
+		return buf.Bytes()
+	}
+	m := NewManager(t.TempDir(), Options{MaxWidth: 100, MaxHeight: 50})
+	ctx := context.Background()
+	if _, _, err := m.Save(ctx, bytes.NewReader(encode(101, 1)), "wide.png", 1<<20, 1<<20, ""); err != ErrDimensionsTooLarge {
+		t.Fatalf("expected ErrDimensionsTooLarge for a wide strip, got %v", err)
+	}
+	if _, _, err := m.Save(ctx, bytes.NewReader(encode(1, 51)), "tall.png", 1<<20, 1<<20, ""); err != ErrDimensionsTooLarge {
+		t.Fatalf("expected ErrDimensionsTooLarge for a tall strip, got %v", err)
+	}
+	if _, _, err := m.Save(ctx, bytes.NewReader(encode(100, 50)), "ok.png", 1<<20, 1<<20, ""); err != nil {
+		t.Fatalf("expected image at the limits to be accepted, got %v", err)
+	}
+}
+
+func TestSaveFormatLimits(t *testing.T) {
+	img := image.NewPaletted(image.Rect(0, 0, 10, 10), color.Palette{color.Black, color.White})
+	var pngBuf, gifBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	if err := gif.Encode(&gifBuf, img, nil); err != nil {
+		t.Fatalf("encode gif: %v", err)
+	}
+
+	m := NewManager(t.TempDir(), Options{
+		FormatMaxBytes:  map[string]int64{"gif": 1 << 20},
+		FormatMaxPixels: map[string]int{"png": 50},
+	})
+	ctx := context.Background()
+	if _, _, err := m.Save(ctx, bytes.NewReader(pngBuf.Bytes()), "a.png", 1<<20, 1<<20, ""); err != ErrTooManyPixels {
+		t.Fatalf("expected png pixel override to apply, got %v", err)
+	}
+	if _, _, err := m.Save(ctx, bytes.NewReader(pngBuf.Bytes()), "a.png", 16, 1<<20, ""); err != ErrTooLarge {
+		t.Fatalf("expected global byte limit for png, got %v", err)
+	}
+	if _, _, err := m.Save(ctx, bytes.NewReader(gifBuf.Bytes()), "a.gif", 16, 1<<20, ""); err != nil {
+		t.Fatalf("expected gif byte override to allow upload, got %v", err)
+	}
+}
+
+func TestExceedsPixelBudget(t *testing.T) {
+	if exceedsPixelBudget(100, 100, 10000) {
+		t.Fatalf("exact budget should be allowed")
+	}
+	if !exceedsPixelBudget(1<<31-1, 1<<31-1, 50_000_000) {
+		t.Fatalf("huge dimensions should exceed budget")
+	}
+}
+
+func TestFitWithin(t *testing.T) {
+	cases := []struct {
+		w, h, maxWidth int
+		wantW, wantH   int
+	}{
+		{100, 50, 640, 100, 50},
+		{1280, 640, 640, 640, 320},
+		// Tall originals are bounded by the WebP limit before the width is.
+		{100, 20000, 640, 82, webpMaxDimension},
+		{2000, 40000, 1280, 819, webpMaxDimension},
+		{4, 20000, 32, 3, webpMaxDimension},
+		{20000, 10, 0, webpMaxDimension, 8},
+	}
+	for _, c := range cases {
+		got := fitWithin(image.NewNRGBA(image.Rect(0, 0, c.w, c.h)), c.maxWidth).Bounds()
+		if got.Dx() != c.wantW || got.Dy() != c.wantH {
+			t.Fatalf("%dx%d in %d: expected %dx%d, got %dx%d", c.w, c.h, c.maxWidth, c.wantW, c.wantH, got.Dx(), got.Dy())
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// A 3x2 image whose pixels record their own coordinates.
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	// The source pixel each orientation puts at the top left and top right.
+	cases := []struct {
+		orientation       int
+		width, height     int
+		topLeft, topRight [2]uint8
+	}{
+		{1, 3, 2, [2]uint8{0, 0}, [2]uint8{2, 0}},
+		{2, 3, 2, [2]uint8{2, 0}, [2]uint8{0, 0}},
+		{3, 3, 2, [2]uint8{2, 1}, [2]uint8{0, 1}},
+		{4, 3, 2, [2]uint8{0, 1}, [2]uint8{2, 1}},
+		{5, 2, 3, [2]uint8{0, 0}, [2]uint8{0, 1}},
+		{6, 2, 3, [2]uint8{0, 1}, [2]uint8{0, 0}},
+		{7, 2, 3, [2]uint8{2, 1}, [2]uint8{2, 0}},
+		{8, 2, 3, [2]uint8{2, 0}, [2]uint8{2, 1}},
+	}
+	for _, c := range cases {
+		got := orient(src, c.orientation)
+		b := got.Bounds()
+		if b.Dx() != c.width || b.Dy() != c.height {
+			t.Fatalf("orientation %d: expected %dx%d, got %dx%d", c.orientation, c.width, c.height, b.Dx(), b.Dy())
+		}
+		at := func(x, y int) [2]uint8 {
+			p := color.NRGBAModel.Convert(got.At(x, y)).(color.NRGBA)
+			return [2]uint8{p.R, p.G}
+		}
+		if at(0, 0) != c.topLeft || at(c.width-1, 0) != c.topRight {
+			t.Fatalf("orientation %d: expected corners %v %v, got %v %v", c.orientation, c.topLeft, c.topRight, at(0, 0), at(c.width-1, 0))
+		}
+	}
+}
+
+func TestSaveAppliesEXIFOrientation(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 20)), nil); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+	withOrientation := func(o uint16) []byte {
+		// A big-endian TIFF block with IFD0 holding just the Orientation tag.
+		tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1, 0x01, 0x12, 0, 3, 0, 0, 0, 1, byte(o >> 8), byte(o), 0, 0, 0, 0, 0, 0}
+		app1 := append([]byte("Exif\x00\x00"), tiff...)
+		out := []byte{0xFF, 0xD8, 0xFF, 0xE1, byte((len(app1) + 2) >> 8), byte(len(app1) + 2)}
+		out = append(out, app1...)
+		return append(out, buf.Bytes()[2:]...)
+	}
+	m := NewManager(t.TempDir(), Options{})
+	for o, want := range map[uint16][2]int{1: {40, 20}, 3: {40, 20}, 6: {20, 40}, 8: {20, 40}, 9: {40, 20}} {
+		res, _, err := m.Save(context.Background(), bytes.NewReader(withOrientation(o)), "a.jpg", 1<<20, 1<<20, "")
+		if err != nil {
+			t.Fatalf("orientation %d: save: %v", o, err)
+		}
+		if got := [2]int{res.Width, res.Height}; got != want {
+			t.Fatalf("orientation %d: expected %v, got %v", o, want, got)
+		}
+		if got, err := m.Stored(res.SHA256); err != nil || got.Width != want[0] || got.Height != want[1] {
+			t.Fatalf("orientation %d: expected Stored to report %v, got %+v, %v", o, want, got, err)
+		}
+	}
+}
+
+func TestCanonicalExt(t *testing.T) {
+	m := NewManager("/root", Options{ExtAliases: map[string]string{"jfif": "jpeg", "jpe": "jpeg"}})
+	cases := []struct {
+		filename, mime, want string
+	}{
+		{"photo.JPG", "image/jpeg", ".jpg"},
+		{"photo.JFIF", "image/jpeg", ".jpeg"},
+		{"photo.jpe", "image/jpeg", ".jpeg"},
+		{"scan.png", "image/png", ".png"},
+		{"noext", "image/jpeg", ".jpeg"},
+		{"noext", "application/x-unknown", ""},
+	}
+	for _, c := range cases {
+		if got := m.CanonicalExt(c.filename, c.mime); got != c.want {
+			t.Fatalf("CanonicalExt(%q, %q) = %q, want %q", c.filename, c.mime, got, c.want)
+		}
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	root := t.TempDir()
+	m := NewManager(root, Options{ThumbWidths: []int{200}})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	var sha string
+	for i := 0; i < 2; i++ {
+		res, _, err := m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "a.png", 1<<20, 1<<20, "")
+		if err != nil {
+			t.Fatalf("save: %v", err)
+		}
+		sha = res.SHA256
+	}
+	st := m.CacheStats()
+	if st.Misses != 3 || st.Hits != 3 || st.HitRatio != 0.5 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	var want int64
+	for _, path := range []string{m.PathForVariant(sha, VariantContent, ""), m.PathForVariant(sha, VariantThumb, ""), m.PathForThumbWidth(sha, 200)} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("stat derivative: %v", err)
+		}
+		want += info.Size()
+	}
+	if st.Bytes != want {
+		t.Fatalf("expected %d derivative bytes, got %d", want, st.Bytes)
+	}
+
+	fresh := NewManager(root, Options{})
+	if err := fresh.ScanDerivatives(context.Background()); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if got := fresh.CacheStats().Bytes; got != st.Bytes {
+		t.Fatalf("scan found %d bytes, expected %d", got, st.Bytes)
+	}
+}
+
+func TestAsyncVariants(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	m := NewManager(t.TempDir(), Options{AsyncVariants: true})
+	res, _, err := m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "a.png", 1<<20, 1<<20, "")
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if !res.VariantsPending || !m.VariantsPending(res.SHA256) {
+		t.Fatalf("expected variants to be queued")
+	}
+	thumb := m.PathForVariant(res.SHA256, VariantThumb, "")
+	if _, err := os.Stat(thumb); !os.IsNotExist(err) {
+		t.Fatalf("thumb should not exist before generation, got %v", err)
+	}
+
+	// A request for the variant forces generation ahead of the workers.
+	if err := m.EnsureVariants(res.SHA256, m.PathForVariant(res.SHA256, VariantOriginal, res.Ext)); err != nil {
+		t.Fatalf("ensure: %v", err)
+	}
+	if _, err := os.Stat(thumb); err != nil {
+		t.Fatalf("thumb should exist after generation: %v", err)
+	}
+	if m.VariantsPending(res.SHA256) {
+		t.Fatalf("variants should no longer be pending")
+	}
+
+	// The queued job finds the task already done.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.StartVariantWorkers(ctx, 1, func(sha string, err error) { t.Errorf("worker error for %s: %v", sha, err) })
+	other := image.NewRGBA(image.Rect(0, 0, 5, 5))
+	buf.Reset()
+	if err := png.Encode(&buf, other); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	res, _, err = m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "b.png", 1<<20, 1<<20, "")
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for m.VariantsPending(res.SHA256) {
+		if time.Now().After(deadline) {
+			t.Fatalf("worker did not generate variants")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(m.PathForVariant(res.SHA256, VariantContent, "")); err != nil {
+		t.Fatalf("content should exist after worker ran: %v", err)
+	}
+}
+
+func TestVariantsAreDownscaledWebP(t *testing.T) {
+	// A photo-like original: smooth shading with some grain.
+	src := image.NewRGBA(image.Rect(0, 0, 300, 150))
+	for y := 0; y < 150; y++ {
+		for x := 0; x < 300; x++ {
+			grain := uint8((x*7 + y*13) % 9)
+			src.SetRGBA(x, y, color.RGBA{uint8(x*200/300) + grain, uint8(y*200/150) + grain, uint8(120 + (x+y)%40), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+	m := NewManager(t.TempDir(), Options{ContentMaxWidth: 200, ThumbMaxWidth: 60, ThumbWidths: []int{100, 400}})
+	res, _, err := m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "a.jpg", 1<<20, 1<<20, "")
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	cases := []struct {
+		path          string
+		width, height int
+	}{
+		{m.PathForVariant(res.SHA256, VariantContent, ""), 200, 100},
+		{m.PathForVariant(res.SHA256, VariantThumb, ""), 60, 30},
+		{m.PathForThumbWidth(res.SHA256, 100), 100, 50},
+		{m.PathForThumbWidth(res.SHA256, 400), 300, 150}, // never upscaled
+	}
+	for _, c := range cases {
+		data, err := os.ReadFile(c.path)
+		if err != nil {
+			t.Fatalf("read %s: %v", c.path, err)
+		}
+		img, err := webp.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s is not a valid WebP: %v", c.path, err)
+		}
+		if b := img.Bounds(); b.Dx() != c.width || b.Dy() != c.height {
+			t.Fatalf("%s is %dx%d, want %dx%d", c.path, b.Dx(), b.Dy(), c.width, c.height)
+		}
+		if len(data) >= buf.Len() {
+			t.Fatalf("%s is %d bytes, not smaller than the %d-byte original", c.path, len(data), buf.Len())
+		}
+
+		// Regeneration reproduces the same bytes.
+		if err := os.Remove(c.path); err != nil {
+			t.Fatalf("remove: %v", err)
+		}
+		if err := m.EnsureVariants(res.SHA256, m.PathForVariant(res.SHA256, VariantOriginal, res.Ext)); err != nil {
+			t.Fatalf("regenerate: %v", err)
+		}
+		again, err := os.ReadFile(c.path)
+		if err != nil {
+			t.Fatalf("read regenerated %s: %v", c.path, err)
+		}
+		if !bytes.Equal(data, again) {
+			t.Fatalf("regenerated %s differs", c.path)
+		}
+	}
+}
+
+func TestRegenerateVariants(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 120, 60))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	root := t.TempDir()
+	res, _, err := NewManager(root, Options{ThumbMaxWidth: 60}).Save(context.Background(), &buf, "a.png", 1<<20, 1<<20, "")
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	thumbPath := NewManager(root, Options{}).PathForVariant(res.SHA256, VariantThumb, "")
+	contentPath := NewManager(root, Options{}).PathForVariant(res.SHA256, VariantContent, "")
+	oldThumb, _ := os.ReadFile(thumbPath)
+	oldContent, _ := os.ReadFile(contentPath)
+
+	// A narrower thumb setting only reaches stored assets through regeneration.
+	m := NewManager(root, Options{ThumbMaxWidth: 30})
+	if err := m.EnsureVariants(res.SHA256, m.PathForVariant(res.SHA256, VariantOriginal, res.Ext)); err != nil {
+		t.Fatalf("ensure: %v", err)
+	}
+	if kept, _ := os.ReadFile(thumbPath); !bytes.Equal(kept, oldThumb) {
+		t.Fatalf("expected EnsureVariants to keep the existing thumb")
+	}
+	if err := m.RegenerateVariants(res.SHA256); err != nil {
+		t.Fatalf("regenerate: %v", err)
+	}
+	if thumb, _ := os.ReadFile(thumbPath); bytes.Equal(thumb, oldThumb) {
+		t.Fatalf("expected the thumb to be regenerated at the new width")
+	}
+	if content, _ := os.ReadFile(contentPath); !bytes.Equal(content, oldContent) {
+		t.Fatalf("expected the unchanged content variant to regenerate identically")
+	}
+
+	if err := m.RegenerateVariants(strings.Repeat("0", 64)); err != ErrNotStored {
+		t.Fatalf("expected ErrNotStored for unknown content, got %v", err)
+	}
+}
+
+func TestConcurrentSavesGenerateVariantsOnce(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 64))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	m := NewManager(t.TempDir(), Options{})
+	const n = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "a.png", 1<<20, 1<<20, "")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	}
+
+	// Serialized generation means only the first save encodes; the rest find
+	// both derivatives in place.
+	if st := m.CacheStats(); st.Misses != 2 || st.Hits != 2*(n-1) {
+		t.Fatalf("expected 2 misses and %d hits, got %+v", 2*(n-1), st)
+	}
+	if len(m.files.locks) != 0 {
+		t.Fatalf("expected released locks to be dropped, %d remain", len(m.files.locks))
+	}
+}
+
+func TestSaveSHA512(t *testing.T) {
+	m := NewManager(t.TempDir(), Options{HashAlgo: HashSHA512})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	sum256 := sha256.Sum256(buf.Bytes())
+	sum512 := sha512.Sum512(buf.Bytes())
+
+	if _, _, err := m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "a.png", 1<<20, 1<<20, hex.EncodeToString(sum512[:])); err != ErrChecksumMismatch {
+		t.Fatalf("expected the checksum to be compared as SHA-256, got %v", err)
+	}
+	res, _, err := m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "a.png", 1<<20, 1<<20, hex.EncodeToString(sum256[:]))
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if res.SHA256 != hex.EncodeToString(sum512[:]) || res.HashAlgo != HashSHA512 {
+		t.Fatalf("expected a SHA-512 address, got %s %s", res.HashAlgo, res.SHA256)
+	}
+	if want := filepath.Join(m.root, "original", res.SHA256[:2], res.SHA256[2:4], res.SHA256+".png"); m.PathForVariant(res.SHA256, VariantOriginal, ".png") != want {
+		t.Fatalf("unexpected original path for a SHA-512 digest")
+	}
+	got, err := m.Stored(res.SHA256)
+	if err != nil || got.HashAlgo != HashSHA512 {
+		t.Fatalf("expected the SHA-512 original to be addressable, got %+v, %v", got, err)
+	}
+	if sha, _ := parseStoredName(res.SHA256 + ".webp"); sha != res.SHA256 {
+		t.Fatalf("expected walks to recognise SHA-512 names")
+	}
+}
+
+func TestStored(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 6, 4))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	m := NewManager(t.TempDir(), Options{})
+	saved, _, err := m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "a.png", 1<<20, 1<<20, "")
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if err := os.Remove(m.PathForVariant(saved.SHA256, VariantThumb, saved.Ext)); err != nil {
+		t.Fatalf("remove thumb: %v", err)
+	}
+
+	got, err := m.Stored(saved.SHA256)
+	if err != nil {
+		t.Fatalf("stored: %v", err)
+	}
+	if got.SHA256 != saved.SHA256 || got.Bytes != saved.Bytes || got.Mime != "image/png" || got.Width != 6 || got.Height != 4 || got.Ext != saved.Ext {
+		t.Fatalf("unexpected result: %+v, saved %+v", got, saved)
+	}
+	if _, err := os.Stat(m.PathForVariant(saved.SHA256, VariantThumb, saved.Ext)); err != nil {
+		t.Fatalf("expected thumb to be regenerated: %v", err)
+	}
+
+	for _, sha := range []string{strings.Repeat("0", 64), strings.ToUpper(saved.SHA256), "../" + saved.SHA256[3:], "abc"} {
+		if _, err := m.Stored(sha); err != ErrNotStored {
+			t.Fatalf("Stored(%q): expected ErrNotStored, got %v", sha, err)
+		}
+	}
+}
+
+func TestCompressOriginals(t *testing.T) {
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.NoCompression}
+	if err := enc.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 64))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	m := NewManager(t.TempDir(), Options{CompressOriginals: true})
+	saved, _, err := m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "a.png", 1<<20, 1<<20, "")
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if sum := sha256.Sum256(buf.Bytes()); saved.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("expected the address of the uncompressed bytes, got %s", saved.SHA256)
+	}
+	path := m.PathForVariant(saved.SHA256, VariantOriginal, saved.Ext)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected only the compressed original on disk, got %v", err)
+	}
+	if !OriginalExists(path) {
+		t.Fatalf("expected the compressed original to exist")
+	}
+
+	f, err := OpenOriginal(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	if end, err := f.Seek(0, io.SeekEnd); err != nil || end != int64(buf.Len()) || f.Size() != end {
+		t.Fatalf("expected size %d, got %d (%v)", buf.Len(), end, err)
+	}
+	if _, err := f.Seek(100, io.SeekStart); err != nil {
+		t.Fatalf("seek: %v", err)
+	}
+	tail, err := io.ReadAll(f)
+	if err != nil || !bytes.Equal(tail, buf.Bytes()[100:]) {
+		t.Fatalf("expected the uncompressed tail from offset 100, got %d bytes (%v)", len(tail), err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("seek: %v", err)
+	}
+	if all, err := io.ReadAll(f); err != nil || !bytes.Equal(all, buf.Bytes()) {
+		t.Fatalf("expected to re-read the whole original after seeking back (%v)", err)
+	}
+
+	got, err := m.Stored(saved.SHA256)
+	if err != nil || got.Bytes != saved.Bytes || got.Ext != ".png" {
+		t.Fatalf("expected the compressed original to be addressable, got %+v, %v", got, err)
+	}
+	if sha, _ := parseStoredName(saved.SHA256 + ".png.gz"); sha != saved.SHA256 {
+		t.Fatalf("expected walks to recognise compressed originals")
+	}
+
+	var jpg bytes.Buffer
+	if err := jpeg.Encode(&jpg, image.NewRGBA(image.Rect(0, 0, 64, 64)), nil); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+	saved, _, err = m.Save(context.Background(), bytes.NewReader(jpg.Bytes()), "a.jpg", 1<<20, 1<<20, "")
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if _, err := os.Stat(m.PathForVariant(saved.SHA256, VariantOriginal, saved.Ext)); err != nil {
+		t.Fatalf("expected the JPEG to be stored uncompressed: %v", err)
+	}
+}
+
+func TestSavePreview(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 200, 100))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	m := NewManager(t.TempDir(), Options{PreviewMaxWidth: 32})
+	res, _, err := m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "a.png", 1<<20, 1<<20, "")
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	const prefix = "data:image/webp;base64,"
+	if !strings.HasPrefix(res.Preview, prefix) {
+		t.Fatalf("expected webp data URI, got %q", res.Preview)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(res.Preview, prefix))
+	if err != nil {
+		t.Fatalf("decode base64: %v", err)
+	}
+	cfg, err := webp.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode preview: %v", err)
+	}
+	if cfg.Width != 32 || cfg.Height != 16 {
+		t.Fatalf("expected 32x16 preview, got %dx%d", cfg.Width, cfg.Height)
+	}
+
+	m = NewManager(t.TempDir(), Options{})
+	res, _, err = m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "a.png", 1<<20, 1<<20, "")
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if res.Preview != "" {
+		t.Fatalf("expected no preview by default, got %q", res.Preview)
+	}
+
+	// A very tall image is bounded by its height, so the URI stays tiny.
+	buf.Reset()
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 100, 16000))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	m = NewManager(t.TempDir(), Options{PreviewMaxWidth: 64})
+	res, _, err = m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "tall.png", 1<<20, 1<<30, "")
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if len(res.Preview) > 1024 {
+		t.Fatalf("expected a small preview URI for a 100x16000 image, got %d bytes", len(res.Preview))
+	}
+	data, _ = base64.StdEncoding.DecodeString(strings.TrimPrefix(res.Preview, prefix))
+	if cfg, err = webp.DecodeConfig(bytes.NewReader(data)); err != nil || cfg.Width != 1 || cfg.Height != 64 {
+		t.Fatalf("expected a 1x64 preview, got %dx%d (%v)", cfg.Width, cfg.Height, err)
+	}
+}
+
+func TestTransparency(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 16; x < 32; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: 0xff, A: 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+
+	m := NewManager(t.TempDir(), Options{RejectTransparency: true})
+	if _, _, err := m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "a.png", 1<<20, 1<<20, ""); err != ErrTransparency {
+		t.Fatalf("expected ErrTransparency, got %v", err)
+	}
+
+	m = NewManager(t.TempDir(), Options{Background: color.White})
+	res, _, err := m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "a.png", 1<<20, 1<<20, "")
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if !res.HasAlpha {
+		t.Fatalf("expected HasAlpha for a transparent original")
+	}
+	data, err := os.ReadFile(m.PathForVariant(res.SHA256, VariantContent, ""))
+	if err != nil {
+		t.Fatalf("read content: %v", err)
+	}
+	img := decodeWebP(t, data)
+	if r, g, b, a := img.At(2, 16).RGBA(); a != 0xffff || r < 0xf000 || g < 0xf000 || b < 0xf000 {
+		t.Fatalf("expected transparent pixels flattened to white, got %x %x %x %x", r, g, b, a)
+	}
+}
+
+func TestSaveCleansUpAfterVariantFailure(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	countFiles := func(m *Manager) int {
+		n := 0
+		err := m.WalkFiles(context.Background(), func(f StoredFile) error {
+			if f.SHA256 != "" {
+				n++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("walk: %v", err)
+		}
+		return n
+	}
+	// A file where the thumb tree should be makes writing that derivative fail after
+	// the original and the content derivative are in place.
+	blockThumbs := func(root string) {
+		if err := os.MkdirAll(root, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(root, VariantThumb), nil, 0o644); err != nil {
+			t.Fatalf("block thumbs: %v", err)
+		}
+	}
+
+	root := t.TempDir()
+	blockThumbs(root)
+	m := NewManager(root, Options{})
+	if _, _, err := m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "a.png", 1<<20, 1<<20, ""); err == nil {
+		t.Fatalf("expected the variant failure to fail the save")
+	}
+	if n := countFiles(m); n != 0 {
+		t.Fatalf("expected no orphaned files, found %d", n)
+	}
+	if entries, _ := os.ReadDir(root); len(entries) != 3 {
+		t.Fatalf("expected only the original, content, and blocked thumb entries in the root, got %d", len(entries))
+	}
+
+	// Content an earlier upload stored survives a later upload of it failing.
+	root = t.TempDir()
+	m = NewManager(root, Options{})
+	saved, _, err := m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "a.png", 1<<20, 1<<20, "")
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if err := os.RemoveAll(filepath.Join(root, VariantThumb)); err != nil {
+		t.Fatalf("remove thumbs: %v", err)
+	}
+	blockThumbs(root)
+	if _, _, err := m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "a.png", 1<<20, 1<<20, ""); err == nil {
+		t.Fatalf("expected the variant failure to fail the save")
+	}
+	if !OriginalExists(m.PathForVariant(saved.SHA256, VariantOriginal, saved.Ext)) {
+		t.Fatalf("expected the existing original to be kept")
+	}
+}
+
+func TestSaveDiscard(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	countFiles := func(m *Manager) int {
+		n := 0
+		err := m.WalkFiles(context.Background(), func(f StoredFile) error {
+			if f.SHA256 != "" {
+				n++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("walk: %v", err)
+		}
+		return n
+	}
+
+	m := NewManager(t.TempDir(), Options{ThumbWidths: []int{4}})
+	_, discard, err := m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "a.png", 1<<20, 1<<20, "")
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if countFiles(m) == 0 {
+		t.Fatalf("expected the save to store files")
+	}
+	discard()
+	if n := countFiles(m); n != 0 {
+		t.Fatalf("expected discard to remove every file the save stored, found %d", n)
+	}
+
+	// Discarding a second upload of stored content keeps the first one's files.
+	saved, _, err := m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "a.png", 1<<20, 1<<20, "")
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	stored := countFiles(m)
+	_, discard, err = m.Save(context.Background(), bytes.NewReader(buf.Bytes()), "a.png", 1<<20, 1<<20, "")
+	if err != nil {
+		t.Fatalf("save again: %v", err)
+	}
+	discard()
+	if n := countFiles(m); n != stored || !OriginalExists(m.PathForVariant(saved.SHA256, VariantOriginal, saved.Ext)) {
+		t.Fatalf("expected the existing files to be kept, found %d of %d", n, stored)
+	}
 }
@@ -0,0 +1,258 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// maxMetadataScan bounds how much of a file is read when looking for embedded metadata.
+const maxMetadataScan = 4 << 20
+
+const (
+	nsDublinCore = "http://purl.org/dc/elements/1.1/"
+	nsPhotoshop  = "http://ns.adobe.com/photoshop/1.0/"
+)
+
+// EmbeddedMetadata holds descriptive fields read from IPTC-IIM and XMP blocks.
+type EmbeddedMetadata struct {
+	Title    string
+	Caption  string
+	Credit   string
+	Keywords []string
+}
+
+// ExtractMetadata reads IPTC (JPEG APP13) and XMP packets from the file at path.
+// XMP values take precedence over IPTC ones; keywords from both are merged.
+// A file without embedded metadata yields an empty result, not an error.
+func (m *Manager) ExtractMetadata(path string) (*EmbeddedMetadata, error) {
+	f, err := OpenOriginal(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxMetadataScan))
+	if err != nil {
+		return nil, err
+	}
+
+	meta := parseIPTC(jpegIPTCBlock(data))
+	if xmp := findXMPPacket(data); xmp != nil {
+		meta.merge(parseXMP(xmp))
+	}
+	return meta, nil
+}
+
+func (em *EmbeddedMetadata) merge(other *EmbeddedMetadata) {
+	if other.Title != "" {
+		em.Title = other.Title
+	}
+	if other.Caption != "" {
+		em.Caption = other.Caption
+	}
+	if other.Credit != "" {
+		em.Credit = other.Credit
+	}
+	em.Keywords = append(em.Keywords, other.Keywords...)
+}
+
+// jpegIPTCBlock walks JPEG segments up to start-of-scan and returns the IPTC-IIM
+// payload of the Photoshop APP13 resource 0x0404, or nil if there is none.
+func jpegIPTCBlock(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil
+		}
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		if size < 2 || pos+2+size > len(data) {
+			return nil
+		}
+		segment := data[pos+4 : pos+2+size]
+		if marker == 0xED {
+			if block := photoshopIPTC(segment); block != nil {
+				return block
+			}
+		}
+		pos += 2 + size
+	}
+	return nil
+}
+
+func photoshopIPTC(segment []byte) []byte {
+	const header = "Photoshop 3.0\x00"
+	if !bytes.HasPrefix(segment, []byte(header)) {
+		return nil
+	}
+	res := segment[len(header):]
+	for len(res) >= 12 && string(res[:4]) == "8BIM" {
+		id := binary.BigEndian.Uint16(res[4:6])
+		nameLen := int(res[6])
+		// Pascal string padded to an even length (including the length byte).
+		nameSize := nameLen + 1
+		if nameSize%2 != 0 {
+			nameSize++
+		}
+		off := 6 + nameSize
+		if off+4 > len(res) {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint32(res[off : off+4]))
+		off += 4
+		if off+size > len(res) {
+			return nil
+		}
+		if id == 0x0404 {
+			return res[off : off+size]
+		}
+		if size%2 != 0 {
+			size++
+		}
+		if off+size > len(res) {
+			return nil
+		}
+		res = res[off+size:]
+	}
+	return nil
+}
+
+// parseIPTC decodes the application record (2:xx) datasets we care about.
+func parseIPTC(block []byte) *EmbeddedMetadata {
+	meta := &EmbeddedMetadata{}
+	var headline, objectName string
+	for len(block) >= 5 && block[0] == 0x1C {
+		record, dataset := block[1], block[2]
+		size := int(binary.BigEndian.Uint16(block[3:5]))
+		if size&0x8000 != 0 || 5+size > len(block) {
+			// Extended datasets are not used for the text fields we read.
+			break
+		}
+		value := strings.TrimSpace(string(block[5 : 5+size]))
+		block = block[5+size:]
+		if record != 2 || value == "" {
+			continue
+		}
+		switch dataset {
+		case 5:
+			objectName = value
+		case 105:
+			headline = value
+		case 120:
+			meta.Caption = value
+		case 110:
+			meta.Credit = value
+		case 25:
+			meta.Keywords = append(meta.Keywords, value)
+		}
+	}
+	meta.Title = headline
+	if meta.Title == "" {
+		meta.Title = objectName
+	}
+	return meta
+}
+
+func findXMPPacket(data []byte) []byte {
+	start := bytes.Index(data, []byte("<x:xmpmeta"))
+	if start < 0 {
+		return nil
+	}
+	const closing = "</x:xmpmeta>"
+	end := bytes.Index(data[start:], []byte(closing))
+	if end < 0 {
+		return nil
+	}
+	return data[start : start+end+len(closing)]
+}
+
+// parseXMP extracts dc:title, dc:description, dc:subject, and photoshop:Credit
+// (falling back to photoshop:Headline and dc:creator) from an XMP packet.
+func parseXMP(packet []byte) *EmbeddedMetadata {
+	meta := &EmbeddedMetadata{}
+	var headline, creator string
+	var current string
+	dec := xml.NewDecoder(bytes.NewReader(packet))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if prop := xmpProperty(t.Name); prop != "" {
+				current = prop
+			}
+			for _, attr := range t.Attr {
+				switch xmpProperty(attr.Name) {
+				case "credit":
+					meta.Credit = strings.TrimSpace(attr.Value)
+				case "headline":
+					headline = strings.TrimSpace(attr.Value)
+				}
+			}
+		case xml.EndElement:
+			if xmpProperty(t.Name) != "" {
+				current = ""
+			}
+		case xml.CharData:
+			value := strings.TrimSpace(string(t))
+			if value == "" {
+				continue
+			}
+			switch current {
+			case "title":
+				if meta.Title == "" {
+					meta.Title = value
+				}
+			case "description":
+				if meta.Caption == "" {
+					meta.Caption = value
+				}
+			case "subject":
+				meta.Keywords = append(meta.Keywords, value)
+			case "credit":
+				meta.Credit = value
+			case "headline":
+				headline = value
+			case "creator":
+				if creator == "" {
+					creator = value
+				}
+			}
+		}
+	}
+	if meta.Title == "" {
+		meta.Title = headline
+	}
+	if meta.Credit == "" {
+		meta.Credit = creator
+	}
+	return meta
+}
+
+func xmpProperty(name xml.Name) string {
+	switch name.Space {
+	case nsDublinCore:
+		switch name.Local {
+		case "title", "description", "subject", "creator":
+			return name.Local
+		}
+	case nsPhotoshop:
+		switch name.Local {
+		case "Credit":
+			return "credit"
+		case "Headline":
+			return "headline"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,64 @@
+package media
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseXMP(t *testing.T) {
+	packet := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:photoshop="http://ns.adobe.com/photoshop/1.0/" photoshop:Credit="Jane Doe / Agency">
+   <dc:title><rdf:Alt><rdf:li xml:lang="x-default">Greaves celebrates</rdf:li></rdf:Alt></dc:title>
+   <dc:description><rdf:Alt><rdf:li xml:lang="x-default">Justin Greaves raises his bat.</rdf:li></rdf:Alt></dc:description>
+   <dc:subject><rdf:Bag><rdf:li>cricket</rdf:li><rdf:li>West Indies</rdf:li></rdf:Bag></dc:subject>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`)
+
+	meta := parseXMP(findXMPPacket(append([]byte("junk"), packet...)))
+	if meta.Title != "Greaves celebrates" {
+		t.Fatalf("unexpected title %q", meta.Title)
+	}
+	if meta.Caption != "Justin Greaves raises his bat." {
+		t.Fatalf("unexpected caption %q", meta.Caption)
+	}
+	if meta.Credit != "Jane Doe / Agency" {
+		t.Fatalf("unexpected credit %q", meta.Credit)
+	}
+	if len(meta.Keywords) != 2 || meta.Keywords[0] != "cricket" || meta.Keywords[1] != "West Indies" {
+		t.Fatalf("unexpected keywords %v", meta.Keywords)
+	}
+}
+
+func TestJPEGIPTC(t *testing.T) {
+	dataset := func(num byte, value string) []byte {
+		b := []byte{0x1C, 2, num, 0, 0}
+		binary.BigEndian.PutUint16(b[3:], uint16(len(value)))
+		return append(b, value...)
+	}
+	var iim []byte
+	iim = append(iim, dataset(105, "Headline")...)
+	iim = append(iim, dataset(120, "A caption")...)
+	iim = append(iim, dataset(110, "Photographer")...)
+	iim = append(iim, dataset(25, "one")...)
+	iim = append(iim, dataset(25, "two")...)
+
+	res := []byte("8BIM\x04\x04\x00\x00")
+	res = binary.BigEndian.AppendUint32(res, uint32(len(iim)))
+	res = append(res, iim...)
+	segment := append([]byte("Photoshop 3.0\x00"), res...)
+
+	jpeg := []byte{0xFF, 0xD8, 0xFF, 0xED}
+	jpeg = binary.BigEndian.AppendUint16(jpeg, uint16(len(segment)+2))
+	jpeg = append(jpeg, segment...)
+	jpeg = append(jpeg, 0xFF, 0xDA)
+
+	meta := parseIPTC(jpegIPTCBlock(jpeg))
+	if meta.Title != "Headline" || meta.Caption != "A caption" || meta.Credit != "Photographer" {
+		t.Fatalf("unexpected metadata %+v", meta)
+	}
+	if len(meta.Keywords) != 2 {
+		t.Fatalf("unexpected keywords %v", meta.Keywords)
+	}
+}
@@ -0,0 +1,120 @@
+package media
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// cacheStats counts derivative cache activity. Fields are updated atomically so the
+// upload path pays only an increment per variant.
+type cacheStats struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+	bytes  atomic.Int64
+}
+
+// CacheStats is a snapshot of derivative cache counters since startup. Bytes is the
+// size of all derivatives on disk once ScanDerivatives has run, plus what has been
+// written since.
+type CacheStats struct {
+	Hits     int64
+	Misses   int64
+	HitRatio float64
+	Bytes    int64
+}
+
+// CacheStats returns the current derivative cache counters.
+func (m *Manager) CacheStats() CacheStats {
+	st := CacheStats{
+		Hits:   m.stats.hits.Load(),
+		Misses: m.stats.misses.Load(),
+		Bytes:  m.stats.bytes.Load(),
+	}
+	if total := st.Hits + st.Misses; total > 0 {
+		st.HitRatio = float64(st.Hits) / float64(total)
+	}
+	return st
+}
+
+// ScanDerivatives walks the content and thumb trees once and adds their size to the
+// byte counter. It is meant to run in the background at startup.
+func (m *Manager) ScanDerivatives(ctx context.Context) error {
+	var total int64
+	for _, variant := range []string{VariantContent, VariantThumb} {
+		root := filepath.Join(m.root, variant)
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return fs.SkipDir
+				}
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if d.Type().IsRegular() {
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				total += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	m.stats.bytes.Add(total)
+	return nil
+}
+
+// materialize produces a derivative at dst with produce unless it is already
+// cached, recording the hit or miss. The file is written under a temporary name
+// and renamed, so an interrupted write is never mistaken for a cached variant.
+func (m *Manager) materialize(dst string, produce func(io.Writer) error) error {
+	if _, err := os.Stat(dst); err == nil {
+		m.stats.hits.Add(1)
+		return nil
+	}
+	m.stats.misses.Add(1)
+	return m.replace(dst, produce)
+}
+
+// replace writes dst from produce through a temp file renamed over it, so readers
+// see either the old file or the complete new one.
+func (m *Manager) replace(dst string, produce func(io.Writer) error) error {
+	var old int64
+	if info, err := os.Stat(dst); err == nil {
+		old = info.Size()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".variant-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	if err := produce(w); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	if info, err := os.Stat(dst); err == nil {
+		m.stats.bytes.Add(info.Size() - old)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package media
+
+import (
+	"errors"
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// ErrTransparency is returned by Save for images with transparent pixels when
+// Options.RejectTransparency is set.
+var ErrTransparency = errors.New("image has transparent pixels")
+
+// hasAlpha reports whether any pixel of img is not fully opaque. An alpha channel
+// whose pixels are all opaque does not count.
+func hasAlpha(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// flatten composites img over Options.Background so derivatives of a transparent
+// original have no alpha left. Opaque images, and all images when no background is
+// configured, are returned unchanged.
+func (m *Manager) flatten(img image.Image) image.Image {
+	if m.opts.Background == nil || !hasAlpha(img) {
+		return img
+	}
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(m.opts.Background), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,137 @@
+package media
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultVariantQueueSize is used when Options.VariantQueueSize is not set.
+const DefaultVariantQueueSize = 1000
+
+// variantTask generates the derivatives of one original exactly once, whether it
+// is picked up by a background worker or forced by a request for the variant.
+type variantTask struct {
+	origPath string
+	sha      string
+	once     sync.Once
+	err      error
+}
+
+// variantQueue tracks originals whose derivatives have not been generated yet.
+type variantQueue struct {
+	jobs  chan *variantTask
+	mu    sync.Mutex
+	tasks map[string]*variantTask
+}
+
+func newVariantQueue(size int) *variantQueue {
+	if size <= 0 {
+		size = DefaultVariantQueueSize
+	}
+	return &variantQueue{jobs: make(chan *variantTask, size), tasks: make(map[string]*variantTask)}
+}
+
+// task returns the pending task for sha, creating one if there is none. The bool
+// reports whether it was created by this call.
+func (q *variantQueue) task(sha, origPath string) (*variantTask, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if t, ok := q.tasks[sha]; ok {
+		return t, false
+	}
+	t := &variantTask{origPath: origPath, sha: sha}
+	q.tasks[sha] = t
+	return t, true
+}
+
+func (q *variantQueue) pending(sha string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.tasks[sha]
+	return ok
+}
+
+// run generates the task's variants unless that already happened; concurrent
+// callers block until the first one finishes.
+func (m *Manager) run(t *variantTask) error {
+	t.once.Do(func() {
+		t.err = m.generateVariants(t.origPath, t.sha)
+		m.variants.mu.Lock()
+		delete(m.variants.tasks, t.sha)
+		m.variants.mu.Unlock()
+		if m.opts.VariantsDone != nil {
+			m.opts.VariantsDone(t.sha, t.err)
+		}
+	})
+	return t.err
+}
+
+// enqueueVariants schedules derivative generation for a freshly stored original.
+// When the queue is full the work is done inline so uploads apply backpressure
+// instead of dropping jobs.
+func (m *Manager) enqueueVariants(origPath, sha string) error {
+	t, created := m.variants.task(sha, origPath)
+	if !created {
+		return nil
+	}
+	select {
+	case m.variants.jobs <- t:
+		return nil
+	default:
+		return m.run(t)
+	}
+}
+
+// StartVariantWorkers starts n workers generating queued derivatives until ctx is
+// done. onError, if set, is called for jobs that fail; their variants are retried
+// the next time they are requested.
+func (m *Manager) StartVariantWorkers(ctx context.Context, n int, onError func(sha string, err error)) {
+	for i := 0; i < max(n, 1); i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case t := <-m.variants.jobs:
+					if err := m.run(t); err != nil && onError != nil {
+						onError(t.sha, err)
+					}
+				}
+			}
+		}()
+	}
+}
+
+// VariantsPending reports whether the derivatives of sha are still queued or
+// being generated.
+func (m *Manager) VariantsPending(sha string) bool {
+	return m.variants.pending(sha)
+}
+
+// QueueVariants schedules generation of the derivatives of the original at origPath
+// if they are pending or missing, without waiting for it, for callers that have
+// something else to serve meanwhile. The job goes to the background workers when
+// AsyncVariants runs them and the queue has room, and to a goroutine of its own
+// otherwise. Variants already pending are left to whoever is generating them.
+func (m *Manager) QueueVariants(sha, origPath string) {
+	t, created := m.variants.task(sha, origPath)
+	if !created {
+		return
+	}
+	if m.opts.AsyncVariants {
+		select {
+		case m.variants.jobs <- t:
+			return
+		default:
+		}
+	}
+	go func() { _ = m.run(t) }()
+}
+
+// EnsureVariants generates the derivatives of the original at origPath now if they
+// are pending or missing, waiting for a worker already on the job. It is a no-op
+// for variants that exist.
+func (m *Manager) EnsureVariants(sha, origPath string) error {
+	t, _ := m.variants.task(sha, origPath)
+	return m.run(t)
+}
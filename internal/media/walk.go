@@ -0,0 +1,67 @@
+package media
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// StoredFile describes a file found under one of the variant trees.
+type StoredFile struct {
+	Path    string
+	Variant string
+	// SHA256 is empty when the file name does not follow the storage layout.
+	SHA256 string
+	// Width is set for per-width thumbnails and zero otherwise.
+	Width int
+}
+
+// WalkFiles calls fn for every regular file in the original, content, and thumb trees.
+// Missing trees are skipped.
+func (m *Manager) WalkFiles(ctx context.Context, fn func(StoredFile) error) error {
+	for _, variant := range []string{VariantOriginal, VariantContent, VariantThumb} {
+		root := filepath.Join(m.root, variant)
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return fs.SkipDir
+				}
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			sha, width := parseStoredName(d.Name())
+			return fn(StoredFile{Path: path, Variant: variant, SHA256: sha, Width: width})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseStoredName splits "<sha>.<ext>", "<sha>.<ext>.gz", or "<sha>-w<width>.webp"
+// into its parts.
+func parseStoredName(name string) (string, int) {
+	name = trimCompressed(name)
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	width := 0
+	if sha, w, ok := strings.Cut(base, "-w"); ok {
+		n, err := strconv.Atoi(w)
+		if err != nil || n <= 0 {
+			return "", 0
+		}
+		base, width = sha, n
+	}
+	if HashAlgoOf(base) == "" {
+		return "", 0
+	}
+	return base, width
+}
@@ -0,0 +1,30 @@
+package media
+
+import (
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/gen2brain/webp"
+)
+
+// webpMaxDimension is the largest width or height a lossy (VP8) WebP can carry.
+const webpMaxDimension = 1<<14 - 1
+
+// webpQuality is the lossy quality derivatives are encoded at, on libwebp's 0-100
+// scale.
+const webpQuality = 80
+
+// Importing the package also registers its WebP decoder with image.Decode, so
+// uploaded WebP originals are read by the same libwebp that writes the variants.
+
+// encodeWebP writes img as a lossy WebP, keeping an alpha channel if img has one.
+// It runs libwebp compiled to Go, so it needs no cgo, and a given image always
+// encodes to the same bytes.
+func encodeWebP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 || b.Dx() > webpMaxDimension || b.Dy() > webpMaxDimension {
+		return fmt.Errorf("webp: cannot encode %dx%d image", b.Dx(), b.Dy())
+	}
+	return webp.Encode(w, img, webp.Options{Quality: webpQuality, Method: webp.DefaultMethod})
+}
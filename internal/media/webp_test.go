@@ -0,0 +1,80 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"github.com/gen2brain/webp"
+)
+
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	cases := map[string]*image.NRGBA{
+		"1x1":         image.NewNRGBA(image.Rect(0, 0, 1, 1)),
+		"gradient":    image.NewNRGBA(image.Rect(0, 0, 37, 23)),
+		"translucent": image.NewNRGBA(image.Rect(0, 0, 16, 16)),
+		"noise":       image.NewNRGBA(image.Rect(0, 0, 600, 3)),
+	}
+	for y := 0; y < 23; y++ {
+		for x := 0; x < 37; x++ {
+			cases["gradient"].SetNRGBA(x, y, color.NRGBA{uint8(x * 7), uint8(y * 11), uint8(x + y), 255})
+		}
+	}
+	for i := range cases["translucent"].Pix {
+		cases["translucent"].Pix[i] = 128
+	}
+	rng.Read(cases["noise"].Pix)
+
+	for name, img := range cases {
+		var buf bytes.Buffer
+		if err := encodeWebP(&buf, img); err != nil {
+			t.Fatalf("%s: encode: %v", name, err)
+		}
+		got := decodeWebP(t, buf.Bytes())
+		if got.Bounds() != img.Bounds() {
+			t.Fatalf("%s: decoded bounds %v, want %v", name, got.Bounds(), img.Bounds())
+		}
+		if name == "noise" {
+			continue // lossy coding does not keep noise
+		}
+		// Lossy coding may shift a channel a little, but not change the picture.
+		var diff, n int
+		for y := 0; y < img.Bounds().Dy(); y++ {
+			for x := 0; x < img.Bounds().Dx(); x++ {
+				want := img.NRGBAAt(x, y)
+				c := color.NRGBAModel.Convert(got.At(x, y)).(color.NRGBA)
+				diff += absDiff(c.R, want.R) + absDiff(c.G, want.G) + absDiff(c.B, want.B) + absDiff(c.A, want.A)
+				n += 4
+			}
+		}
+		if mean := float64(diff) / float64(n); mean > 8 {
+			t.Fatalf("%s: mean channel error %.1f after decoding", name, mean)
+		}
+	}
+
+	if err := encodeWebP(&bytes.Buffer{}, image.NewNRGBA(image.Rect(0, 0, 1, webpMaxDimension+1))); err == nil {
+		t.Fatalf("expected an image taller than WebP allows to be refused")
+	}
+}
+
+// decodeWebP decodes data to RGB the way browsers do. webp.Decode hands back the
+// limited-range YCbCr planes, which image/color reads as full-range, so white would
+// come out as 235.
+func decodeWebP(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	anim, err := webp.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode webp: %v", err)
+	}
+	return anim.Image[0]
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
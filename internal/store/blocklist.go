@@ -0,0 +1,84 @@
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TagBlocklist rejects tags by exact match or, for entries ending in "*", by prefix.
+// Entries are normalized the same way as tags.
+type TagBlocklist struct {
+	exact    map[string]struct{}
+	prefixes []string
+}
+
+// BlockedTagsError lists the normalized tags that matched the blocklist.
+type BlockedTagsError struct {
+	Tags []string
+}
+
+func (e *BlockedTagsError) Error() string {
+	return fmt.Sprintf("blocked tags: %s", strings.Join(e.Tags, ", "))
+}
+
+// LoadTagBlocklist reads one entry per line; blank lines and lines starting with "#" are ignored.
+func LoadTagBlocklist(path string) (*TagBlocklist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read blocked tags file: %w", err)
+	}
+	defer f.Close()
+
+	var entries []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read blocked tags file: %w", err)
+	}
+	return NewTagBlocklist(entries), nil
+}
+
+func NewTagBlocklist(entries []string) *TagBlocklist {
+	b := &TagBlocklist{exact: make(map[string]struct{})}
+	for _, e := range entries {
+		if prefix, ok := strings.CutSuffix(e, "*"); ok {
+			if p := NormalizeTag(prefix); p != "" {
+				b.prefixes = append(b.prefixes, p)
+			}
+			continue
+		}
+		if n := NormalizeTag(e); n != "" {
+			b.exact[n] = struct{}{}
+		}
+	}
+	return b
+}
+
+// Blocked returns the entries of the already-normalized tags that are blocked.
+func (b *TagBlocklist) Blocked(tags []string) []string {
+	if b == nil {
+		return nil
+	}
+	var out []string
+	for _, t := range tags {
+		if _, ok := b.exact[t]; ok {
+			out = append(out, t)
+			continue
+		}
+		for _, p := range b.prefixes {
+			if strings.HasPrefix(t, p) {
+				out = append(out, t)
+				break
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTagBlocklist(t *testing.T) {
+	b := NewTagBlocklist([]string{"Project  Falcon", "internal-*"})
+	got := b.Blocked(NormalizeTags([]string{"project falcon", "Internal-Review", "cricket"}))
+	if len(got) != 2 || got[0] != "internal-review" || got[1] != "project falcon" {
+		t.Fatalf("unexpected blocked tags %v", got)
+	}
+
+	var nilList *TagBlocklist
+	if got := nilList.Blocked([]string{"anything"}); got != nil {
+		t.Fatalf("expected nil blocklist to allow everything, got %v", got)
+	}
+}
+
+func TestLoadTagBlocklist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocked.txt")
+	if err := os.WriteFile(path, []byte("# comment\n\nsecret\ncode-*\n"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	b, err := LoadTagBlocklist(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := b.Blocked([]string{"secret", "code-red", "open"}); len(got) != 2 {
+		t.Fatalf("unexpected blocked tags %v", got)
+	}
+}
@@ -0,0 +1,140 @@
+package store
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ErrCircuitOpen is matched (via errors.Is) by the error returned while the database
+// circuit breaker is open.
+var ErrCircuitOpen = errors.New("database circuit open")
+
+// CircuitOpenError short-circuits a call while the database is considered down.
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("database circuit open; retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// breaker opens after threshold consecutive connection failures and rejects calls
+// until cooldown has passed. The first call after that is let through as a probe,
+// and the circuit stays open to every other call until it reports: success closes
+// the circuit, another connection failure reopens it. A probe that reports neither,
+// because it was canceled or never recorded, frees the slot for another once
+// cooldown has passed again. A nil breaker (threshold disabled) allows everything.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	// probeUntil is when the probe in flight, if any, is given up on.
+	probeUntil time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow reports whether a call may go ahead, taking the probe slot when the circuit
+// is half-open.
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if wait, open := b.wait(); open {
+		return &CircuitOpenError{RetryAfter: wait}
+	}
+	if b.failures >= b.threshold {
+		b.probeUntil = b.now().Add(b.cooldown)
+	}
+	return nil
+}
+
+// open reports whether allow would refuse a call now, without taking the probe slot.
+func (b *breaker) open() (time.Duration, bool) {
+	if b == nil {
+		return 0, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.wait()
+}
+
+// wait returns how long calls are refused for: until cooldown has passed, then while
+// a probe is in flight. b.mu must be held.
+func (b *breaker) wait() (time.Duration, bool) {
+	now := b.now()
+	if wait := b.openUntil.Sub(now); wait > 0 {
+		return wait, true
+	}
+	if wait := b.probeUntil.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, false
+}
+
+// record updates the breaker with the outcome of a call. It takes a pointer so it can
+// be deferred against a named error result.
+func (b *breaker) record(errp *error) {
+	if b == nil {
+		return
+	}
+	var err error
+	if errp != nil {
+		err = *errp
+	}
+	// A refused call has nothing to report.
+	if errors.Is(err, ErrCircuitOpen) {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probeUntil = time.Time{}
+	// Cancellations and deadlines say more about the caller than the database; they
+	// end a probe all the same, so another call may try.
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	if !isConnError(err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = b.now().Add(b.cooldown)
+	}
+}
+
+// isConnError reports whether err means the database could not be reached, as opposed
+// to a query-level failure such as a constraint violation or a missing row.
+func isConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
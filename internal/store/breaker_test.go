@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newBreaker(3, 10*time.Second)
+	b.now = func() time.Time { return now }
+
+	connErr := error(&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")})
+	for i := 0; i < 2; i++ {
+		b.record(&connErr)
+	}
+	if err := b.allow(); err != nil {
+		t.Fatalf("breaker opened early: %v", err)
+	}
+	b.record(&connErr)
+	err := b.allow()
+	var open *CircuitOpenError
+	if !errors.As(err, &open) || !errors.Is(err, ErrCircuitOpen) || open.RetryAfter != 10*time.Second {
+		t.Fatalf("expected open circuit with 10s retry, got %v", err)
+	}
+
+	now = now.Add(11 * time.Second)
+	if _, open := b.open(); open {
+		t.Fatalf("expected the circuit to be half-open after cooldown")
+	}
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected probe after cooldown, got %v", err)
+	}
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected calls to be refused while the probe is in flight, got %v", err)
+	}
+	if _, open := b.open(); !open {
+		t.Fatalf("expected the circuit to report open while the probe is in flight")
+	}
+	canceled := context.Canceled
+	b.record(&canceled)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected a canceled probe to make way for another, got %v", err)
+	}
+	now = now.Add(11 * time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected a probe that never reported to be given up on, got %v", err)
+	}
+	b.record(&connErr)
+	if err := b.allow(); err == nil {
+		t.Fatalf("failed probe should reopen the circuit")
+	}
+
+	now = now.Add(11 * time.Second)
+	var ok error
+	b.record(&ok)
+	b.record(&connErr)
+	if err := b.allow(); err != nil {
+		t.Fatalf("success should reset the failure count, got %v", err)
+	}
+}
+
+func TestBreakerIgnoresQueryAndContextErrors(t *testing.T) {
+	b := newBreaker(1, time.Minute)
+	for _, err := range []error{ErrNotFound, context.Canceled, context.DeadlineExceeded} {
+		b.record(&err)
+	}
+	if err := b.allow(); err != nil {
+		t.Fatalf("non-connection errors must not open the circuit: %v", err)
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	var b *breaker = newBreaker(0, time.Minute)
+	connErr := error(&net.OpError{Op: "dial", Err: errors.New("refused")})
+	b.record(&connErr)
+	if err := b.allow(); err != nil {
+		t.Fatalf("disabled breaker should allow everything: %v", err)
+	}
+	if _, open := (&Store{}).CircuitOpen(); open {
+		t.Fatalf("store without breaker should report closed")
+	}
+}
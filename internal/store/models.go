@@ -11,16 +11,28 @@ type Asset struct {
 	UsageNotes       string     `db:"usage_notes"`
 	Width            int        `db:"width"`
 	Height           int        `db:"height"`
+	HasAlpha         *bool      `db:"has_alpha"`
+	ColorSpace       *string    `db:"color_space"`
 	Bytes            int64      `db:"bytes"`
 	Mime             string     `db:"mime"`
 	OriginalFilename string     `db:"original_filename"`
+	DisplayFilename  *string    `db:"display_filename"`
 	SHA256           string     `db:"sha256"`
+	HashAlgo         string     `db:"hash_algo"`
+	Preview          *string    `db:"preview"`
+	VariantState     string     `db:"variant_state"`
 	TagText          string     `db:"tag_text"`
+	Immutable        bool       `db:"immutable"`
+	Visibility       string     `db:"visibility"`
 	CreatedAt        time.Time  `db:"created_at"`
 	UpdatedAt        time.Time  `db:"updated_at"`
 	DeletedAt        *time.Time `db:"deleted_at"`
+	DeletedBy        *string    `db:"deleted_by"`
+	DeletionReason   *string    `db:"deletion_reason"`
 	Relevance        *float64   `db:"relevance"`
 	Tags             []string   `db:"-"`
+	// AllowedPrincipals lists who may see a private asset; it is empty for public ones.
+	AllowedPrincipals []string `db:"-"`
 }
 
 type AssetCreate struct {
@@ -35,7 +47,23 @@ type AssetCreate struct {
 	Bytes            int64
 	Mime             string
 	OriginalFilename string
-	SHA256           string
+	// DisplayFilename is the uploaded name as shown to people; empty stores none.
+	DisplayFilename string
+	SHA256          string
+	// HashAlgo is the algorithm SHA256 was computed with, "sha256" when empty.
+	HashAlgo string
+	// Preview is a data URI placeholder image; empty stores none.
+	Preview string
+	// HasAlpha records that the original has transparent pixels. Assets stored
+	// before it was recorded read back nil.
+	HasAlpha bool
+	// ColorSpace names the original's embedded ICC profile; empty stores none.
+	ColorSpace string
+	// VariantState defaults to VariantsReady when empty.
+	VariantState string
+	// Visibility defaults to VisibilityPublic when empty.
+	Visibility        string
+	AllowedPrincipals []string
 }
 
 type AssetUpdate struct {
@@ -44,14 +72,33 @@ type AssetUpdate struct {
 	Credit     *string
 	Source     *string
 	UsageNotes *string
+	// Tags replaces the full tag set. When nil, AddTags and RemoveTags edit the
+	// current set instead; they are ignored when Tags is set.
 	Tags       *[]string
+	AddTags    []string
+	RemoveTags []string
+	Visibility *string
+	// AllowedPrincipals replaces the access list of a private asset.
+	AllowedPrincipals *[]string
+	// By is the principal making the edit, recorded on the version it replaces;
+	// empty stores none.
+	By string
 }
 
 type SearchParams struct {
 	Query          string
 	Tags           []string
+	Mime           string
+	CreatedAfter   *time.Time
+	CreatedBefore  *time.Time
 	Page           int
 	PageSize       int
 	Sort           string
 	IncludeDeleted bool
+	// Viewer limits results to public assets and private ones whose access list names
+	// this principal. Nil means no limit (auth disabled, or an admin).
+	Viewer *string
+	// Lang, one of SearchLanguages, stems Query in that language so it also matches
+	// other forms of its words. Empty searches for the words as given.
+	Lang string
 }
@@ -0,0 +1,219 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/arawak/ganache/internal/events"
+)
+
+const (
+	// outboxBatch is how many events one pass reads.
+	outboxBatch = 100
+	// outboxPoll is how often the worker looks for events written by other
+	// instances; changes made through this Store wake it at once.
+	outboxPoll = time.Second
+	// outboxMaxBackoff caps the delay between retries of an event that keeps failing.
+	outboxMaxBackoff = 5 * time.Minute
+	// outboxRetention is how long delivered events are kept before being pruned, and
+	// how long a consumer may go without a pass before pruning stops waiting for it.
+	outboxRetention = 24 * time.Hour
+)
+
+type outboxRow struct {
+	ID        int64     `db:"id"`
+	Type      string    `db:"type"`
+	AssetID   int64     `db:"asset_id"`
+	CreatedAt time.Time `db:"created_at"`
+	Attempts  int       `db:"attempts"`
+}
+
+// enqueueTx records an event for each asset in tx, so it is delivered if and only if
+// the change it describes commits. Ids come from event_outbox_seq, whose row stays
+// locked until tx ends: a rolled-back transaction leaves no gap, and no event becomes
+// visible below one already read. That serializes transactions that write events, so
+// enqueueTx is the last statement before Commit.
+func enqueueTx(ctx context.Context, tx *sqlx.Tx, typ events.Type, assetIDs ...int64) error {
+	if len(assetIDs) == 0 {
+		return nil
+	}
+	var last int64
+	if err := tx.GetContext(ctx, &last, "SELECT last_id FROM event_outbox_seq WHERE id = 1 FOR UPDATE"); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE event_outbox_seq SET last_id = ? WHERE id = 1", last+int64(len(assetIDs))); err != nil {
+		return err
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("(?, ?, ?),", len(assetIDs)), ",")
+	args := make([]any, 0, 3*len(assetIDs))
+	for i, id := range assetIDs {
+		args = append(args, last+int64(i)+1, string(typ), id)
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO event_outbox (id, type, asset_id) VALUES "+placeholders, args...)
+	return err
+}
+
+// wakeOutbox nudges the worker after a commit that enqueued events.
+func (s *Store) wakeOutbox() {
+	select {
+	case s.outbox <- struct{}{}:
+	default:
+	}
+}
+
+// StartOutbox starts a worker that hands deliver every committed event, in id order,
+// until ctx is done. Each instance runs its own worker under its own consumer name,
+// so every instance sees every event. The consumer's cursor is kept in
+// event_consumer and only moves past an event once deliver returns nil for it, so
+// delivery is at least once: a failed event is retried with backoff, holding back
+// the ones after it, and a worker started again under the same name resumes where it
+// stopped. A name seen for the first time starts after the newest event. onError, if
+// set, is called for database errors and failed deliveries.
+func (s *Store) StartOutbox(ctx context.Context, consumer string, deliver func(context.Context, events.Event) error, onError func(error)) {
+	report := func(err error) {
+		if err != nil && ctx.Err() == nil && onError != nil {
+			onError(err)
+		}
+	}
+	go func() {
+		cursor, err := s.outboxCursor(ctx, consumer)
+		for err != nil {
+			report(err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(outboxPoll):
+			}
+			cursor, err = s.outboxCursor(ctx, consumer)
+		}
+
+		attempt := 1
+		lastPrune := time.Time{}
+		for {
+			n, next, failed, err := s.deliverOutbox(ctx, consumer, cursor, deliver)
+			report(err)
+			report(failed)
+			moved := next != cursor
+			cursor = next
+			if moved {
+				attempt = 1
+			}
+			if time.Since(lastPrune) > time.Hour {
+				report(s.pruneOutbox(ctx))
+				lastPrune = time.Now()
+			}
+			if failed != nil {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(outboxBackoff(attempt)):
+				}
+				attempt++
+				continue
+			}
+			if n == outboxBatch && moved {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.outbox:
+			case <-time.After(outboxPoll):
+			}
+		}
+	}()
+}
+
+// outboxCursor returns consumer's cursor, registering it after the newest event if
+// it has none.
+func (s *Store) outboxCursor(ctx context.Context, consumer string) (_ int64, err error) {
+	if err := s.breaker.allow(); err != nil {
+		return 0, err
+	}
+	defer s.breaker.record(&err)
+
+	if _, err := s.db.ExecContext(ctx, "INSERT INTO event_consumer (name, cursor_id) SELECT ?, COALESCE(MAX(id), 0) FROM event_outbox ON DUPLICATE KEY UPDATE name = name", consumer); err != nil {
+		return 0, err
+	}
+	var cursor int64
+	if err := s.db.GetContext(ctx, &cursor, "SELECT cursor_id FROM event_consumer WHERE name = ?", consumer); err != nil {
+		return 0, err
+	}
+	return cursor, nil
+}
+
+// deliverOutbox hands deliver the next batch of events after cursor, stopping at the
+// first one it fails, and stores the new cursor. It returns how many rows it read, the
+// new cursor, the delivery failure if there was one, and any database error.
+func (s *Store) deliverOutbox(ctx context.Context, consumer string, cursor int64, deliver func(context.Context, events.Event) error) (_ int, next int64, failed, err error) {
+	if err := s.breaker.allow(); err != nil {
+		return 0, cursor, nil, err
+	}
+	defer s.breaker.record(&err)
+
+	var rows []outboxRow
+	if err := s.db.SelectContext(ctx, &rows, "SELECT id, type, asset_id, created_at, attempts FROM event_outbox WHERE id > ? ORDER BY id LIMIT ?", cursor, outboxBatch); err != nil {
+		return 0, cursor, nil, err
+	}
+	next = cursor
+	for _, row := range rows {
+		ev := events.Event{ID: row.ID, Type: events.Type(row.Type), AssetID: row.AssetID, At: row.CreatedAt.UTC()}
+		if derr := deliver(ctx, ev); derr != nil {
+			failed = fmt.Errorf("event %d: %w", row.ID, derr)
+			msg := derr.Error()
+			if len(msg) > 1024 {
+				msg = strings.ToValidUTF8(msg[:1024], "")
+			}
+			backoff := outboxBackoff(row.Attempts + 1)
+			if _, err := s.db.ExecContext(ctx, "UPDATE event_outbox SET attempts = attempts + 1, last_error = ?, next_attempt_at = NOW(6) + INTERVAL ? MICROSECOND WHERE id = ?", msg, backoff.Microseconds(), row.ID); err != nil {
+				return len(rows), cursor, failed, err
+			}
+			break
+		}
+		next = row.ID
+	}
+	if next == cursor {
+		return len(rows), cursor, failed, nil
+	}
+	if _, err := s.db.ExecContext(ctx, "UPDATE event_outbox SET delivered_at = NOW(6) WHERE id > ? AND id <= ? AND delivered_at IS NULL", cursor, next); err != nil {
+		return len(rows), cursor, failed, err
+	}
+	if _, err := s.db.ExecContext(ctx, `INSERT INTO event_consumer (name, cursor_id) VALUES (?, ?)
+	ON DUPLICATE KEY UPDATE cursor_id = GREATEST(cursor_id, VALUES(cursor_id)), updated_at = NOW(6)`, consumer, next); err != nil {
+		return len(rows), cursor, failed, err
+	}
+	return len(rows), next, failed, nil
+}
+
+// pruneOutbox deletes events delivered more than outboxRetention ago that every
+// consumer active within outboxRetention has moved past, so an instance that is only
+// restarting does not lose the events it has yet to deliver. Every worker runs it;
+// deleting rows another worker already deleted is harmless.
+func (s *Store) pruneOutbox(ctx context.Context) (err error) {
+	if err := s.breaker.allow(); err != nil {
+		return err
+	}
+	defer s.breaker.record(&err)
+
+	retention := int64(outboxRetention / time.Second)
+	_, err = s.db.ExecContext(ctx, `DELETE FROM event_outbox WHERE delivered_at < NOW(6) - INTERVAL ? SECOND
+	AND id <= (SELECT COALESCE(MIN(cursor_id), 0) FROM event_consumer WHERE updated_at > NOW(6) - INTERVAL ? SECOND)`, retention, retention)
+	return err
+}
+
+// outboxBackoff is the delay before retry attempt n (1-based): 1s doubling up to
+// outboxMaxBackoff.
+func outboxBackoff(attempt int) time.Duration {
+	d := time.Second
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= outboxMaxBackoff {
+			return outboxMaxBackoff
+		}
+	}
+	return d
+}
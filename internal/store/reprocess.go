@@ -0,0 +1,279 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Reprocess sweep states.
+const (
+	ReprocessRunning = "running"
+	ReprocessDone    = "done"
+)
+
+const (
+	// reprocessBatch is how many assets one sweep pass claims.
+	reprocessBatch = 10
+	// reprocessPoll is how often the worker looks for sweeps started or left running
+	// by other instances; sweeps started through this Store wake it at once.
+	reprocessPoll = 10 * time.Second
+	// reprocessLease is how long a worker may take over a batch, on top of the
+	// delays between its assets, before another worker may claim it again.
+	reprocessLease = 5 * time.Minute
+)
+
+// ErrReprocessRunning is returned by StartReprocess while a sweep is still running.
+var ErrReprocessRunning = errors.New("a reprocess sweep is already running")
+
+// ReprocessSweep is the progress of a sweep regenerating the variants of every
+// asset. Assets are visited in id order and CursorID is the last one done, so a
+// sweep interrupted by a restart resumes where it stopped.
+type ReprocessSweep struct {
+	ID         int64      `db:"id"`
+	State      string     `db:"state"`
+	CursorID   int64      `db:"cursor_id"`
+	Total      int        `db:"total"`
+	Processed  int        `db:"processed"`
+	Errors     int        `db:"errors"`
+	LastError  *string    `db:"last_error"`
+	StartedBy  *string    `db:"started_by"`
+	StartedAt  time.Time  `db:"started_at"`
+	UpdatedAt  time.Time  `db:"updated_at"`
+	FinishedAt *time.Time `db:"finished_at"`
+}
+
+const reprocessColumns = "id, state, cursor_id, total, processed, errors, last_error, started_by, started_at, updated_at, finished_at"
+
+// StartReprocess records a new sweep over the assets not deleted now and wakes the
+// StartReprocessWorker worker. It returns the running sweep along with
+// ErrReprocessRunning when one has not finished yet.
+func (s *Store) StartReprocess(ctx context.Context, by string) (_ *ReprocessSweep, err error) {
+	if err := s.breaker.allow(); err != nil {
+		return nil, err
+	}
+	defer s.breaker.record(&err)
+
+	// The unique key on running_guard admits one running sweep, so two admins
+	// starting sweeps side by side cannot both insert one. Nothing here locks the
+	// running sweep, which a worker may be claiming a batch of.
+	for attempt := 0; ; attempt++ {
+		running, err := s.runningReprocess(ctx)
+		if err == nil {
+			return running, ErrReprocessRunning
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+
+		var total int
+		if err := s.db.GetContext(ctx, &total, "SELECT COUNT(*) FROM asset WHERE deleted_at IS NULL"); err != nil {
+			return nil, queryErr(ctx, err)
+		}
+		res, err := s.db.ExecContext(ctx, "INSERT INTO reprocess_sweep (total, started_by) VALUES (?, ?)", total, nullString(by))
+		if isDuplicate(err) && attempt < 2 {
+			// Another sweep was started since the check; report that one, or try
+			// again if it has already finished.
+			continue
+		}
+		if err != nil {
+			return nil, queryErr(ctx, err)
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		var sweep ReprocessSweep
+		if err := s.db.GetContext(ctx, &sweep, "SELECT "+reprocessColumns+" FROM reprocess_sweep WHERE id = ?", id); err != nil {
+			return nil, queryErr(ctx, err)
+		}
+		select {
+		case s.reprocess <- struct{}{}:
+		default:
+		}
+		return &sweep, nil
+	}
+}
+
+// runningReprocess returns the running sweep without locking it, or ErrNotFound.
+func (s *Store) runningReprocess(ctx context.Context) (*ReprocessSweep, error) {
+	var sweep ReprocessSweep
+	if err := s.db.GetContext(ctx, &sweep, "SELECT "+reprocessColumns+" FROM reprocess_sweep WHERE state = ? LIMIT 1", ReprocessRunning); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, queryErr(ctx, err)
+	}
+	return &sweep, nil
+}
+
+// LatestReprocess returns the most recently started sweep, or ErrNotFound when none
+// has been started. It reads from the primary, where the worker records progress.
+func (s *Store) LatestReprocess(ctx context.Context) (_ *ReprocessSweep, err error) {
+	if err := s.breaker.allow(); err != nil {
+		return nil, err
+	}
+	defer s.breaker.record(&err)
+
+	var sweep ReprocessSweep
+	if err := s.db.GetContext(ctx, &sweep, "SELECT "+reprocessColumns+" FROM reprocess_sweep ORDER BY id DESC LIMIT 1"); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, queryErr(ctx, err)
+	}
+	return &sweep, nil
+}
+
+// StartReprocessWorker starts a worker that runs the running sweep, if any, until ctx
+// is done, calling regenerate for each asset not deleted. Assets are handled one at
+// a time with delay between them, so a sweep over a large catalog does not starve
+// live traffic of CPU and disk. A failing asset is counted and skipped rather than
+// retried. Several instances may run workers; each batch of a sweep is leased to
+// one of them at a time. onError, if set, is called for failed assets and database
+// errors.
+func (s *Store) StartReprocessWorker(ctx context.Context, delay time.Duration, regenerate func(context.Context, AssetFile) error, onError func(error)) {
+	go func() {
+		for {
+			more, err := s.reprocessBatch(ctx, delay, regenerate, onError)
+			if err != nil && ctx.Err() == nil && onError != nil {
+				onError(err)
+			}
+			if more && err == nil {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.reprocess:
+			case <-time.After(reprocessPoll):
+			}
+		}
+	}()
+}
+
+// reprocessBatch claims the next batch of the running sweep, regenerates its assets,
+// and records the progress. It reports whether the sweep has assets left.
+func (s *Store) reprocessBatch(ctx context.Context, delay time.Duration, regenerate func(context.Context, AssetFile) error, onError func(error)) (_ bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if err := s.breaker.allow(); err != nil {
+		return false, err
+	}
+	defer s.breaker.record(&err)
+
+	// The progress of a batch cut short by shutdown must still be saved, so the
+	// database calls do not end with ctx; only regenerate and the delay watch it.
+	dbctx := context.WithoutCancel(ctx)
+	sweep, assets, token, err := s.claimReprocess(dbctx, delay)
+	if err != nil || sweep == nil || len(assets) == 0 {
+		return false, err
+	}
+
+	cursor, processed, failed := sweep.CursorID, 0, 0
+	lastError := sweep.LastError
+	for i, a := range assets {
+		if i > 0 && delay > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if rerr := regenerate(ctx, a); rerr != nil {
+			if ctx.Err() != nil {
+				// Leave the interrupted asset for the next pass.
+				break
+			}
+			if onError != nil {
+				onError(rerr)
+			}
+			msg := rerr.Error()
+			if len(msg) > 1024 {
+				msg = strings.ToValidUTF8(msg[:1024], "")
+			}
+			lastError = &msg
+			failed++
+		}
+		cursor = a.ID
+		processed++
+	}
+
+	// Releasing the lease lets another worker carry on at once, even when shutdown
+	// cut the batch short. Assets created since the sweep started are visited too,
+	// so processed is kept from passing the total counted then.
+	res, err := s.db.ExecContext(dbctx, `UPDATE reprocess_sweep SET cursor_id = ?, processed = LEAST(total, processed + ?), errors = errors + ?, last_error = ?,
+		lease_token = NULL, lease_until = NULL, updated_at = NOW(6) WHERE id = ? AND lease_token = ?`,
+		cursor, processed, failed, lastError, sweep.ID, token)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		// The lease ran out and another worker claimed the batch; its progress is
+		// the one recorded.
+		return false, fmt.Errorf("reprocess sweep %d: lease on batch after asset %d expired", sweep.ID, sweep.CursorID)
+	}
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	// The pass that finds no assets left marks the sweep done.
+	return true, nil
+}
+
+// claimReprocess leases the next batch of the running sweep to the caller in a short
+// transaction, so regeneration runs without holding any lock. It returns a nil sweep
+// when there is none or another worker holds an unexpired lease on it, and marks
+// the sweep done, returning no assets, when none are left. The lease lasts long
+// enough for the batch with its delays; a worker that dies mid-batch leaves the
+// lease to expire and the batch to be claimed again.
+func (s *Store) claimReprocess(ctx context.Context, delay time.Duration) (*ReprocessSweep, []AssetFile, string, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var sweep ReprocessSweep
+	err = tx.GetContext(ctx, &sweep, "SELECT "+reprocessColumns+" FROM reprocess_sweep WHERE state = ? AND (lease_until IS NULL OR lease_until < NOW(6)) LIMIT 1 FOR UPDATE SKIP LOCKED", ReprocessRunning)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, "", nil
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	var assets []AssetFile
+	err = tx.SelectContext(ctx, &assets, "SELECT id, sha256, FALSE AS deleted FROM asset WHERE deleted_at IS NULL AND id > ? ORDER BY id LIMIT ?", sweep.CursorID, reprocessBatch)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if len(assets) == 0 {
+		if _, err := tx.ExecContext(ctx, "UPDATE reprocess_sweep SET state = ?, lease_token = NULL, lease_until = NULL, updated_at = NOW(6), finished_at = NOW(6) WHERE id = ?", ReprocessDone, sweep.ID); err != nil {
+			return nil, nil, "", err
+		}
+		return &sweep, nil, "", tx.Commit()
+	}
+
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, nil, "", err
+	}
+	token := hex.EncodeToString(b[:])
+	lease := reprocessLease + time.Duration(len(assets))*delay
+	_, err = tx.ExecContext(ctx, "UPDATE reprocess_sweep SET lease_token = ?, lease_until = NOW(6) + INTERVAL ? MICROSECOND WHERE id = ?", token, lease.Microseconds(), sweep.ID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, "", err
+	}
+	return &sweep, assets, token, nil
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SearchLanguages lists the languages SearchParams.Lang can stem queries in.
+var SearchLanguages = []string{"de", "en", "es", "fr"}
+
+// stemmer is a light suffix-stripping stemmer. It only needs to find a prefix that
+// the other forms of a word share, because stemmed words are matched as prefixes.
+type stemmer struct {
+	// suffixes are tried longest first; the first that leaves at least minStem
+	// runes is removed.
+	suffixes []string
+	minStem  int
+	// keep lists endings that look like a suffix but are part of the word, such as
+	// the "ss" of "glass".
+	keep      []string
+	stopwords map[string]bool
+}
+
+func newStemmer(minStem int, suffixes, keep, stopwords string) *stemmer {
+	st := &stemmer{
+		suffixes:  strings.Fields(suffixes),
+		minStem:   minStem,
+		keep:      strings.Fields(keep),
+		stopwords: make(map[string]bool),
+	}
+	sort.SliceStable(st.suffixes, func(i, j int) bool { return len(st.suffixes[i]) > len(st.suffixes[j]) })
+	for _, w := range strings.Fields(stopwords) {
+		st.stopwords[w] = true
+	}
+	return st
+}
+
+// Words are folded to lowercase without accents before they are stemmed, so the
+// lists below are too.
+var stemmers = map[string]*stemmer{
+	"de": newStemmer(4,
+		"ungen ung heiten heit keiten keit ien ie ern em en er es e s n",
+		"",
+		"am an auf aus bei das dem den der des die ein eine einem einen einer eines im in ist mit und von vom zu zum zur"),
+	"en": newStemmer(3,
+		"ations ation ments ment ingly edly ings ing ies ied ers er ed es ly y s",
+		"ss us is",
+		"a an and are as at be by for from in into is it of on or the to with"),
+	"es": newStemmer(4,
+		"aciones acion amientos amiento imientos imiento idades idad mente istas ista icos icas ico ica ias ia es os as o a e s",
+		"",
+		"al con de del el en es la las lo los para por que se su sus un una unas unos y"),
+	"fr": newStemmer(4,
+		"issements issement atrices atrice ateurs ateur ations ation ements ement euses euse ismes isme istes iste iques ique ables able ances ance ences ence ites ite ives ive ies ie aux eux es e s x",
+		"",
+		"au aux avec ce ces dans de des du elle en et il ils la le les leur lui mais ou par pour sa se ses sur un une"),
+}
+
+// SupportsLanguage reports whether lang is one of SearchLanguages.
+func SupportsLanguage(lang string) bool {
+	return stemmers[lang] != nil
+}
+
+func (st *stemmer) stem(word string) string {
+	for _, k := range st.keep {
+		if strings.HasSuffix(word, k) {
+			return word
+		}
+	}
+	for _, suffix := range st.suffixes {
+		if strings.HasSuffix(word, suffix) && utf8.RuneCountInString(word)-len(suffix) >= st.minStem {
+			return strings.TrimSuffix(word, suffix)
+		}
+	}
+	return word
+}
+
+// stemQuery rewrites a free-text query as a boolean-mode full-text query that
+// matches the words of query in any form lang's stemmer folds together: stopwords
+// and single letters are dropped and every other word becomes "<stem>*". Anything
+// but letters and digits separates words, so no boolean operator survives. It
+// returns "" when lang is unsupported or no word is left.
+func stemQuery(lang, query string) string {
+	st := stemmers[lang]
+	if st == nil {
+		return ""
+	}
+	words := strings.FieldsFunc(strings.ToLower(stripAccents(query)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var terms []string
+	seen := make(map[string]bool)
+	for _, word := range words {
+		if utf8.RuneCountInString(word) < 2 || st.stopwords[word] {
+			continue
+		}
+		if stem := st.stem(word); !seen[stem] {
+			seen[stem] = true
+			terms = append(terms, stem+"*")
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+// fullText returns the full-text query to match and its search modifier, or "" for a
+// blank query. A query made only of stopwords is searched as given.
+func (p SearchParams) fullText() (string, string) {
+	query := strings.TrimSpace(p.Query)
+	if query == "" {
+		return "", ""
+	}
+	if p.Lang != "" {
+		if stemmed := stemQuery(p.Lang, query); stemmed != "" {
+			return stemmed, booleanMode
+		}
+	}
+	return query, naturalLanguageMode
+}
@@ -0,0 +1,48 @@
+package store
+
+import "testing"
+
+func TestStemQuery(t *testing.T) {
+	cases := []struct {
+		lang, query, want string
+	}{
+		{"fr", "photographie", "photograph*"},
+		{"fr", "Photographe", "photograph*"},
+		{"fr", "les photos de mariage", "photo* mariag*"},
+		{"fr", "l'été", "ete*"},
+		{"en", "photographers photography", "photograph*"},
+		{"en", "glass +cats -dogs", "glass* cat* dog*"},
+		{"es", "fotografía fotógrafo", "fotograf*"},
+		{"de", "Fotografie Fotografen", "fotograf*"},
+		{"en", "the and of", ""},
+		{"xx", "photographie", ""},
+	}
+	for _, tc := range cases {
+		if got := stemQuery(tc.lang, tc.query); got != tc.want {
+			t.Fatalf("stemQuery(%q, %q) = %q, want %q", tc.lang, tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestSearchParamsFullText(t *testing.T) {
+	cases := []struct {
+		params      SearchParams
+		query, mode string
+	}{
+		{SearchParams{Query: "  "}, "", ""},
+		{SearchParams{Query: " photographie "}, "photographie", naturalLanguageMode},
+		{SearchParams{Query: "photographie", Lang: "fr"}, "photograph*", booleanMode},
+		// Nothing is left to stem, so the query is searched as given.
+		{SearchParams{Query: "de la", Lang: "fr"}, "de la", naturalLanguageMode},
+	}
+	for _, tc := range cases {
+		if query, mode := tc.params.fullText(); query != tc.query || mode != tc.mode {
+			t.Fatalf("%+v: got %q %q, want %q %q", tc.params, query, mode, tc.query, tc.mode)
+		}
+	}
+	for _, lang := range SearchLanguages {
+		if !SupportsLanguage(lang) {
+			t.Fatalf("expected a stemmer for %s", lang)
+		}
+	}
+}
@@ -4,40 +4,184 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
+
+	"github.com/arawak/ganache/internal/events"
 )
 
 var ErrNotFound = errors.New("not found")
 var ErrDuplicate = errors.New("duplicate asset")
 
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
+
+// Variant states record whether an asset's derivatives have been generated.
+const (
+	VariantsReady   = "ready"
+	VariantsPending = "pending"
+	VariantsFailed  = "failed"
+)
+
+// ErrImmutable is returned when an edit or delete targets an asset frozen by SetImmutable.
+var ErrImmutable = errors.New("asset is immutable")
+
 const defaultPageSize = 30
 
+// allowedSort maps sort names to ORDER BY clauses. Each ends with a.id so rows that
+// tie on score and timestamp (bulk imports) keep the same order from page to page.
 var allowedSort = map[string]string{
-	"newest":    "created_at DESC",
-	"oldest":    "created_at ASC",
-	"relevance": "relevance DESC, created_at DESC",
+	"newest":    "created_at DESC, a.id DESC",
+	"oldest":    "created_at ASC, a.id ASC",
+	"relevance": "relevance DESC, created_at DESC, a.id DESC",
+}
+
+// Full-text search modifiers: plain queries use natural language mode, stemmed ones
+// (see stemQuery) need boolean mode for their prefix terms.
+const (
+	naturalLanguageMode = "IN NATURAL LANGUAGE MODE"
+	booleanMode         = "IN BOOLEAN MODE"
+)
+
+// fullTextMatch filters on the combined FULLTEXT index over all searchable columns.
+func fullTextMatch(mode string) string {
+	return "MATCH(a.title, a.caption, a.tag_text) AGAINST (? " + mode + ")"
+}
+
+// RelevanceWeights scales each column's full-text score in the relevance sort, so
+// e.g. a title match can outrank a caption match. The zero value scores with the
+// combined index, weighing all columns equally.
+type RelevanceWeights struct {
+	Title   float64
+	Tags    float64
+	Caption float64
+}
+
+// relevanceExpr returns the relevance score expression and the number of query
+// placeholders it holds. Columns with a zero weight are left out.
+func relevanceExpr(w RelevanceWeights, mode string) (string, int) {
+	var terms []string
+	for _, c := range []struct {
+		column string
+		weight float64
+	}{{"a.title", w.Title}, {"a.tag_text", w.Tags}, {"a.caption", w.Caption}} {
+		if c.weight > 0 {
+			terms = append(terms, strconv.FormatFloat(c.weight, 'g', -1, 64)+" * MATCH("+c.column+") AGAINST (? "+mode+")")
+		}
+	}
+	if len(terms) == 0 {
+		return fullTextMatch(mode), 1
+	}
+	return "(" + strings.Join(terms, " + ") + ")", len(terms)
 }
 
 type Store struct {
-	db *sqlx.DB
+	db        *sqlx.DB
+	replica   *sqlx.DB
+	blocklist *TagBlocklist
+	breaker   *breaker
+	tags      *tagCache
+	weights   RelevanceWeights
+	// relevanceSortedOnly skips scoring searches that are not sorted by relevance.
+	relevanceSortedOnly bool
+	// outbox signals the StartOutbox worker that events were committed.
+	outbox chan struct{}
+	// reprocess signals the StartReprocessWorker worker that a sweep was started.
+	reprocess chan struct{}
+}
+
+// Options configures optional Store behavior; the zero value matches New.
+type Options struct {
+	// Replica, when set, serves read-only queries outside transactions.
+	Replica *sqlx.DB
+	// BlockedTags rejects matching tags on create and update.
+	BlockedTags *TagBlocklist
+	// BreakerThreshold is the number of consecutive connection failures that open the
+	// circuit; zero disables the breaker. While open, calls fail fast with ErrCircuitOpen
+	// for BreakerCooldown.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// TagCacheTTL caches ListTags results for this long; mutations through this
+	// Store invalidate them. Zero disables the cache.
+	TagCacheTTL time.Duration
+	// RelevanceWeights weighs columns in the relevance sort.
+	RelevanceWeights RelevanceWeights
+	// RelevanceSortedOnly computes relevance scores only for searches sorted by
+	// relevance, leaving Asset.Relevance nil for other sort orders.
+	RelevanceSortedOnly bool
 }
 
 func New(db *sqlx.DB) *Store {
-	return &Store{db: db}
+	return &Store{db: db, outbox: make(chan struct{}, 1), reprocess: make(chan struct{}, 1)}
+}
+
+func NewWithOptions(db *sqlx.DB, opts Options) *Store {
+	return &Store{
+		db:                  db,
+		replica:             opts.Replica,
+		blocklist:           opts.BlockedTags,
+		breaker:             newBreaker(opts.BreakerThreshold, opts.BreakerCooldown),
+		tags:                newTagCache(opts.TagCacheTTL),
+		weights:             opts.RelevanceWeights,
+		outbox:              make(chan struct{}, 1),
+		reprocess:           make(chan struct{}, 1),
+		relevanceSortedOnly: opts.RelevanceSortedOnly,
+	}
+}
+
+// CircuitOpen reports whether the database breaker is currently rejecting calls, and
+// for how much longer.
+func (s *Store) CircuitOpen() (time.Duration, bool) {
+	return s.breaker.open()
 }
 
 func (s *Store) DB() *sqlx.DB {
 	return s.db
 }
 
-func (s *Store) Ping(ctx context.Context) error {
-	return s.db.PingContext(ctx)
+// reader returns the pool used for queries outside a transaction.
+func (s *Store) reader() *sqlx.DB {
+	if s.replica != nil {
+		return s.replica
+	}
+	return s.db
+}
+
+func (s *Store) Ping(ctx context.Context) (err error) {
+	if err := s.breaker.allow(); err != nil {
+		return err
+	}
+	defer s.breaker.record(&err)
+
+	if err := s.db.PingContext(ctx); err != nil {
+		return err
+	}
+	if s.replica != nil {
+		if err := s.replica.PingContext(ctx); err != nil {
+			return fmt.Errorf("replica: %w", err)
+		}
+	}
+	return nil
 }
 
-func (s *Store) CreateAsset(ctx context.Context, in AssetCreate) (*Asset, error) {
+func (s *Store) CreateAsset(ctx context.Context, in AssetCreate) (_ *Asset, err error) {
+	if err := s.breaker.allow(); err != nil {
+		return nil, err
+	}
+	defer s.breaker.record(&err)
+	defer s.tags.invalidate()
+
 	tags := NormalizeTags(in.Tags)
+	if blocked := s.blocklist.Blocked(tags); len(blocked) > 0 {
+		return nil, &BlockedTagsError{Tags: blocked}
+	}
 	tagText := TagText(tags)
 
 	tx, err := s.db.BeginTxx(ctx, nil)
@@ -46,11 +190,23 @@ func (s *Store) CreateAsset(ctx context.Context, in AssetCreate) (*Asset, error)
 	}
 	defer func() { _ = tx.Rollback() }()
 
-	query := `INSERT INTO asset (title, caption, credit, source, usage_notes, width, height, bytes, mime, original_filename, sha256, tag_text)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	visibility := in.Visibility
+	if visibility == "" {
+		visibility = VisibilityPublic
+	}
+	hashAlgo := in.HashAlgo
+	if hashAlgo == "" {
+		hashAlgo = "sha256"
+	}
+	variantState := in.VariantState
+	if variantState == "" {
+		variantState = VariantsReady
+	}
+	query := `INSERT INTO asset (title, caption, credit, source, usage_notes, width, height, has_alpha, color_space, bytes, mime, original_filename, display_filename, sha256, hash_algo, preview, variant_state, tag_text, visibility)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	res, err := tx.ExecContext(ctx, query,
-		in.Title, in.Caption, in.Credit, in.Source, in.UsageNotes,
-		in.Width, in.Height, in.Bytes, in.Mime, in.OriginalFilename, in.SHA256, tagText,
+		NormalizeText(in.Title), in.Caption, in.Credit, in.Source, in.UsageNotes,
+		in.Width, in.Height, in.HasAlpha, nullString(in.ColorSpace), in.Bytes, in.Mime, in.OriginalFilename, nullString(in.DisplayFilename), in.SHA256, hashAlgo, nullString(in.Preview), variantState, tagText, visibility,
 	)
 	if err != nil {
 		// Duplicate hash? return conflict by fetching existing asset.
@@ -71,14 +227,23 @@ func (s *Store) CreateAsset(ctx context.Context, in AssetCreate) (*Asset, error)
 	if err := s.replaceTagsTx(ctx, tx, id, tags, tagText); err != nil {
 		return nil, err
 	}
+	if len(in.AllowedPrincipals) > 0 {
+		if err := replaceACLTx(ctx, tx, id, in.AllowedPrincipals); err != nil {
+			return nil, err
+		}
+	}
 
 	asset, err := s.getAssetByID(ctx, tx, id)
 	if err != nil {
 		return nil, err
 	}
+	if err := enqueueTx(ctx, tx, events.AssetCreated, id); err != nil {
+		return nil, err
+	}
 	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
+	s.wakeOutbox()
 	return asset, nil
 }
 
@@ -91,13 +256,13 @@ func (s *Store) getAssetByID(ctx context.Context, tx *sqlx.Tx, id int64) (*Asset
 }
 
 func (s *Store) fetchAsset(ctx context.Context, tx *sqlx.Tx, where string, arg any) (*Asset, error) {
-	query := "SELECT id, title, caption, credit, source, usage_notes, width, height, bytes, mime, original_filename, sha256, tag_text, created_at, updated_at, deleted_at FROM asset WHERE " + where
+	query := "SELECT id, title, caption, credit, source, usage_notes, width, height, has_alpha, color_space, bytes, mime, original_filename, display_filename, sha256, hash_algo, preview, variant_state, tag_text, immutable, visibility, created_at, updated_at, deleted_at, deleted_by, deletion_reason FROM asset WHERE " + where
 	var a Asset
 	var err error
 	if tx != nil {
 		err = tx.GetContext(ctx, &a, query, arg)
 	} else {
-		err = s.db.GetContext(ctx, &a, query, arg)
+		err = s.reader().GetContext(ctx, &a, query, arg)
 	}
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, ErrNotFound
@@ -108,10 +273,18 @@ func (s *Store) fetchAsset(ctx context.Context, tx *sqlx.Tx, where string, arg a
 	if err := s.attachTags(ctx, tx, []*Asset{&a}); err != nil {
 		return nil, err
 	}
+	if err := s.attachACL(ctx, tx, []*Asset{&a}); err != nil {
+		return nil, err
+	}
 	return &a, nil
 }
 
-func (s *Store) GetAsset(ctx context.Context, id int64, includeDeleted bool) (*Asset, error) {
+func (s *Store) GetAsset(ctx context.Context, id int64, includeDeleted bool) (_ *Asset, err error) {
+	if err := s.breaker.allow(); err != nil {
+		return nil, err
+	}
+	defer s.breaker.record(&err)
+
 	where := "id = ?"
 	if !includeDeleted {
 		where += " AND deleted_at IS NULL"
@@ -119,18 +292,199 @@ func (s *Store) GetAsset(ctx context.Context, id int64, includeDeleted bool) (*A
 	return s.fetchAsset(ctx, nil, where, id)
 }
 
-func (s *Store) UpdateAsset(ctx context.Context, id int64, upd AssetUpdate) (*Asset, error) {
+// GetAssetsByIDs returns the live assets among ids with one query, in the order of
+// ids with duplicates collapsed. Ids that do not exist or are deleted are left out.
+func (s *Store) GetAssetsByIDs(ctx context.Context, ids []int64) (_ []Asset, err error) {
+	if err := s.breaker.allow(); err != nil {
+		return nil, err
+	}
+	defer s.breaker.record(&err)
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	query := "SELECT id, title, caption, credit, source, usage_notes, width, height, has_alpha, color_space, bytes, mime, original_filename, display_filename, sha256, hash_algo, preview, variant_state, tag_text, immutable, visibility, created_at, updated_at, deleted_at, deleted_by, deletion_reason FROM asset WHERE id IN (" + placeholders + ") AND deleted_at IS NULL"
+	var rows []Asset
+	if err := s.reader().SelectContext(ctx, &rows, query, toAny(ids)...); err != nil {
+		return nil, queryErr(ctx, err)
+	}
+
+	index := make(map[int64]*Asset, len(rows))
+	assets := make([]*Asset, len(rows))
+	for i := range rows {
+		index[rows[i].ID] = &rows[i]
+		assets[i] = &rows[i]
+	}
+	if err := s.attachACL(ctx, nil, assets); err != nil {
+		return nil, queryErr(ctx, err)
+	}
+	if err := s.attachTags(ctx, nil, assets); err != nil {
+		return nil, queryErr(ctx, err)
+	}
+
+	out := make([]Asset, 0, len(rows))
+	for _, id := range ids {
+		if a, ok := index[id]; ok {
+			out = append(out, *a)
+			delete(index, id)
+		}
+	}
+	return out, nil
+}
+
+// AssetsAfter returns the live assets created after the cursor afterID, oldest
+// first, and the cursor to pass next time. The cursor is the id of an asset.created
+// event in the outbox, and those ids are handed out in commit order, so an asset whose
+// insert commits late still lands after every cursor already returned. At most limit
+// events are read per call. A non-nil viewer limits the assets like
+// SearchParams.Viewer; the cursor still moves past the ones it hides. Reads go to the
+// primary, so replica lag does not hold up a waiting client.
+func (s *Store) AssetsAfter(ctx context.Context, afterID int64, viewer *string, limit int) (_ []Asset, cursor int64, err error) {
+	if err := s.breaker.allow(); err != nil {
+		return nil, 0, err
+	}
+	defer s.breaker.record(&err)
+
+	var created []struct {
+		ID      int64 `db:"id"`
+		AssetID int64 `db:"asset_id"`
+	}
+	if err := s.db.SelectContext(ctx, &created, "SELECT id, asset_id FROM event_outbox WHERE id > ? AND type = ? ORDER BY id LIMIT ?", afterID, string(events.AssetCreated), limit); err != nil {
+		return nil, 0, queryErr(ctx, err)
+	}
+	if len(created) == 0 {
+		return nil, afterID, nil
+	}
+	cursor = created[len(created)-1].ID
+	ids := make([]int64, len(created))
+	for i, c := range created {
+		ids[i] = c.AssetID
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	query := "SELECT a.id, a.title, a.caption, a.credit, a.source, a.usage_notes, a.width, a.height, a.has_alpha, a.color_space, a.bytes, a.mime, a.original_filename, a.display_filename, a.sha256, a.hash_algo, a.preview, a.variant_state, a.tag_text, a.immutable, a.visibility, a.created_at, a.updated_at, a.deleted_at, a.deleted_by, a.deletion_reason FROM asset a WHERE a.id IN (" + placeholders + ") AND a.deleted_at IS NULL"
+	args := toAny(ids)
+	if viewer != nil {
+		query += " AND (a.visibility = 'public' OR EXISTS (SELECT 1 FROM asset_acl acl WHERE acl.asset_id = a.id AND acl.principal_id = ?))"
+		args = append(args, *viewer)
+	}
+
+	var rows []Asset
+	if err := s.db.SelectContext(ctx, &rows, query, args...); err != nil {
+		return nil, 0, queryErr(ctx, err)
+	}
+	index := make(map[int64]*Asset, len(rows))
+	ptrs := make([]*Asset, len(rows))
+	for i := range rows {
+		index[rows[i].ID] = &rows[i]
+		ptrs[i] = &rows[i]
+	}
+	if err := s.attachACL(ctx, nil, ptrs); err != nil {
+		return nil, 0, queryErr(ctx, err)
+	}
+	if err := s.attachTags(ctx, nil, ptrs); err != nil {
+		return nil, 0, queryErr(ctx, err)
+	}
+
+	assets := make([]Asset, 0, len(rows))
+	for _, id := range ids {
+		if a, ok := index[id]; ok {
+			assets = append(assets, *a)
+			delete(index, id)
+		}
+	}
+	return assets, cursor, nil
+}
+
+// LatestAssetID returns a cursor for AssetsAfter standing for now: every asset that
+// exists was created at or before it. It is 0 when no events have been recorded.
+func (s *Store) LatestAssetID(ctx context.Context) (_ int64, err error) {
+	if err := s.breaker.allow(); err != nil {
+		return 0, err
+	}
+	defer s.breaker.record(&err)
+
+	var id int64
+	if err := s.db.GetContext(ctx, &id, "SELECT COALESCE(MAX(id), 0) FROM event_outbox"); err != nil {
+		return 0, queryErr(ctx, err)
+	}
+	return id, nil
+}
+
+// GetAssetByHash returns the asset whose original has the given SHA-256, deleted or
+// not, matching what CreateAsset would report as a duplicate.
+func (s *Store) GetAssetByHash(ctx context.Context, sha string) (_ *Asset, err error) {
+	if err := s.breaker.allow(); err != nil {
+		return nil, err
+	}
+	defer s.breaker.record(&err)
+
+	return s.getAssetByHash(ctx, nil, sha)
+}
+
+// SetVariantState records whether the derivatives of the original with the given
+// SHA-256 exist. It is not an edit, so updated_at is left alone and no event is sent.
+func (s *Store) SetVariantState(ctx context.Context, sha, state string) (err error) {
+	if err := s.breaker.allow(); err != nil {
+		return err
+	}
+	defer s.breaker.record(&err)
+
+	_, err = s.db.ExecContext(ctx, "UPDATE asset SET variant_state = ? WHERE sha256 = ?", state, sha)
+	return err
+}
+
+func (s *Store) UpdateAsset(ctx context.Context, id int64, upd AssetUpdate) (_ *Asset, err error) {
+	if err := s.breaker.allow(); err != nil {
+		return nil, err
+	}
+	defer s.breaker.record(&err)
+	defer s.tags.invalidate()
+
+	var tags []string
+	if upd.Tags != nil {
+		tags = NormalizeTags(*upd.Tags)
+		if blocked := s.blocklist.Blocked(tags); len(blocked) > 0 {
+			return nil, &BlockedTagsError{Tags: blocked}
+		}
+	}
+	added := NormalizeTags(upd.AddTags)
+	if blocked := s.blocklist.Blocked(added); len(blocked) > 0 {
+		return nil, &BlockedTagsError{Tags: blocked}
+	}
+	editTags := upd.Tags == nil && (len(upd.AddTags) > 0 || len(upd.RemoveTags) > 0)
+	writeTags := upd.Tags != nil || editTags
+
 	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = tx.Rollback() }()
 
+	if err := lockMutable(ctx, tx, id); err != nil {
+		return nil, err
+	}
+	if editTags {
+		current := &Asset{ID: id}
+		if err := s.attachTags(ctx, tx, []*Asset{current}); err != nil {
+			return nil, err
+		}
+		tags = EditTags(current.Tags, added, upd.RemoveTags)
+	}
+
+	var before *Asset
+	if upd.Title != nil || upd.Caption != nil || upd.Credit != nil || upd.Source != nil || upd.UsageNotes != nil || writeTags || upd.Visibility != nil || upd.AllowedPrincipals != nil {
+		if before, err = s.getAssetByID(ctx, tx, id); err != nil {
+			return nil, err
+		}
+	}
+
 	setParts := []string{}
 	args := []any{}
 	if upd.Title != nil {
 		setParts = append(setParts, "title = ?")
-		args = append(args, *upd.Title)
+		args = append(args, NormalizeText(*upd.Title))
 	}
 	if upd.Caption != nil {
 		setParts = append(setParts, "caption = ?")
@@ -149,12 +503,14 @@ func (s *Store) UpdateAsset(ctx context.Context, id int64, upd AssetUpdate) (*As
 		args = append(args, *upd.UsageNotes)
 	}
 
-	var tags []string
-	if upd.Tags != nil {
-		tags = NormalizeTags(*upd.Tags)
+	if writeTags {
 		setParts = append(setParts, "tag_text = ?")
 		args = append(args, TagText(tags))
 	}
+	if upd.Visibility != nil {
+		setParts = append(setParts, "visibility = ?")
+		args = append(args, *upd.Visibility)
+	}
 
 	if len(setParts) > 0 {
 		setParts = append(setParts, "updated_at = NOW()")
@@ -170,34 +526,219 @@ func (s *Store) UpdateAsset(ctx context.Context, id int64, upd AssetUpdate) (*As
 		}
 	}
 
-	if upd.Tags != nil {
+	if writeTags {
 		if err := s.replaceTagsTx(ctx, tx, id, tags, TagText(tags)); err != nil {
 			return nil, err
 		}
 	}
+	if upd.AllowedPrincipals != nil {
+		if err := replaceACLTx(ctx, tx, id, *upd.AllowedPrincipals); err != nil {
+			return nil, err
+		}
+	}
 
 	asset, err := s.getAssetByID(ctx, tx, id)
 	if err != nil {
 		return nil, err
 	}
+	if before != nil {
+		if err := s.snapshotVersionTx(ctx, tx, before, asset, upd.By); err != nil {
+			return nil, err
+		}
+	}
+	if err := enqueueTx(ctx, tx, events.AssetUpdated, id); err != nil {
+		return nil, err
+	}
 	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
+	s.wakeOutbox()
 	return asset, nil
 }
 
-func (s *Store) DeleteAsset(ctx context.Context, id int64) error {
-	res, err := s.db.ExecContext(ctx, "UPDATE asset SET deleted_at = NOW(), updated_at = NOW() WHERE id = ? AND deleted_at IS NULL", id)
+// DeleteAsset soft-deletes an asset, recording the principal that deleted it and an
+// optional reason. Empty values are stored as NULL.
+func (s *Store) DeleteAsset(ctx context.Context, id int64, deletedBy, reason string) (err error) {
+	if err := s.breaker.allow(); err != nil {
+		return err
+	}
+	defer s.breaker.record(&err)
+	defer s.tags.invalidate()
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, "UPDATE asset SET deleted_at = NOW(), deleted_by = ?, deletion_reason = ?, updated_at = NOW() WHERE id = ? AND deleted_at IS NULL AND immutable = 0", nullString(deletedBy), nullString(reason), id)
 	if err != nil {
 		return err
 	}
 	affected, _ := res.RowsAffected()
 	if affected == 0 {
+		var immutable bool
+		err := tx.GetContext(ctx, &immutable, "SELECT immutable FROM asset WHERE id = ? AND deleted_at IS NULL", id)
+		if err == nil && immutable {
+			return ErrImmutable
+		}
+		return ErrNotFound
+	}
+	if err := enqueueTx(ctx, tx, events.AssetDeleted, id); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.wakeOutbox()
+	return nil
+}
+
+// SetImmutable freezes or unfreezes an asset. Frozen assets reject UpdateAsset,
+// DeleteAsset, and BulkDelete until the flag is cleared.
+func (s *Store) SetImmutable(ctx context.Context, id int64, immutable bool) (_ *Asset, err error) {
+	if err := s.breaker.allow(); err != nil {
+		return nil, err
+	}
+	defer s.breaker.record(&err)
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := s.fetchAsset(ctx, tx, "id = ? AND deleted_at IS NULL", id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE asset SET immutable = ?, updated_at = NOW() WHERE id = ?", immutable, id); err != nil {
+		return nil, err
+	}
+	asset, err := s.getAssetByID(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := enqueueTx(ctx, tx, events.AssetUpdated, id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.wakeOutbox()
+	return asset, nil
+}
+
+// lockMutable locks a live asset row for the rest of tx and fails if it is frozen.
+func lockMutable(ctx context.Context, tx *sqlx.Tx, id int64) error {
+	var immutable bool
+	err := tx.GetContext(ctx, &immutable, "SELECT immutable FROM asset WHERE id = ? AND deleted_at IS NULL FOR UPDATE", id)
+	if errors.Is(err, sql.ErrNoRows) {
 		return ErrNotFound
 	}
+	if err != nil {
+		return err
+	}
+	if immutable {
+		return ErrImmutable
+	}
 	return nil
 }
 
+// BulkDeleteStatus is the per-id outcome of BulkDelete.
+type BulkDeleteStatus string
+
+const (
+	BulkDeleted        BulkDeleteStatus = "deleted"
+	BulkNotFound       BulkDeleteStatus = "not_found"
+	BulkAlreadyDeleted BulkDeleteStatus = "already_deleted"
+	BulkImmutable      BulkDeleteStatus = "immutable"
+)
+
+// BulkDelete soft-deletes the given assets with a single UPDATE and reports what
+// happened to each id. Rows are locked while they are classified so the statuses
+// match what the UPDATE actually changed. Duplicate ids are collapsed. A non-nil
+// viewer limits the call like SearchParams.Viewer: assets it may not view are
+// reported as not found and left alone. deletedBy and reason are recorded on every
+// asset the call deletes.
+func (s *Store) BulkDelete(ctx context.Context, ids []int64, viewer *string, deletedBy, reason string) (_ map[int64]BulkDeleteStatus, err error) {
+	if err := s.breaker.allow(); err != nil {
+		return nil, err
+	}
+	defer s.breaker.record(&err)
+	defer s.tags.invalidate()
+
+	results := make(map[int64]BulkDeleteStatus, len(ids))
+	if len(ids) == 0 {
+		return results, nil
+	}
+	unique := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := results[id]; ok {
+			continue
+		}
+		results[id] = BulkNotFound
+		unique = append(unique, id)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(unique)), ",")
+	query := "SELECT id, deleted_at IS NOT NULL, immutable FROM asset a WHERE id IN (" + placeholders + ")"
+	args := toAny(unique)
+	if viewer != nil {
+		query += " AND (a.visibility = 'public' OR EXISTS (SELECT 1 FROM asset_acl acl WHERE acl.asset_id = a.id AND acl.principal_id = ?))"
+		args = append(args, *viewer)
+	}
+	rows, err := tx.QueryxContext(ctx, query+" FOR UPDATE", args...)
+	if err != nil {
+		return nil, err
+	}
+	var live []int64
+	for rows.Next() {
+		var id int64
+		var deleted, immutable bool
+		if err := rows.Scan(&id, &deleted, &immutable); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		switch {
+		case deleted:
+			results[id] = BulkAlreadyDeleted
+		case immutable:
+			results[id] = BulkImmutable
+		default:
+			results[id] = BulkDeleted
+			live = append(live, id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, err
+	}
+	rows.Close()
+
+	if len(live) > 0 {
+		livePlaceholders := strings.TrimSuffix(strings.Repeat("?,", len(live)), ",")
+		if _, err := tx.ExecContext(ctx, "UPDATE asset SET deleted_at = NOW(), deleted_by = ?, deletion_reason = ?, updated_at = NOW() WHERE id IN ("+livePlaceholders+") AND deleted_at IS NULL AND immutable = 0", append([]any{nullString(deletedBy), nullString(reason)}, toAny(live)...)...); err != nil {
+			return nil, err
+		}
+		if err := enqueueTx(ctx, tx, events.AssetDeleted, live...); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if len(live) > 0 {
+		s.wakeOutbox()
+	}
+	return results, nil
+}
+
 func (s *Store) replaceTagsTx(ctx context.Context, tx *sqlx.Tx, assetID int64, tags []string, tagText string) error {
 	if _, err := tx.ExecContext(ctx, "DELETE FROM asset_tag WHERE asset_id = ?", assetID); err != nil {
 		return err
@@ -226,7 +767,12 @@ func (s *Store) replaceTagsTx(ctx context.Context, tx *sqlx.Tx, assetID int64, t
 	return err
 }
 
-func (s *Store) SearchAssets(ctx context.Context, params SearchParams) ([]Asset, int, error) {
+func (s *Store) SearchAssets(ctx context.Context, params SearchParams) (_ []Asset, _ int, err error) {
+	if err := s.breaker.allow(); err != nil {
+		return nil, 0, err
+	}
+	defer s.breaker.record(&err)
+
 	page := params.Page
 	if page <= 0 {
 		page = 1
@@ -237,84 +783,257 @@ func (s *Store) SearchAssets(ctx context.Context, params SearchParams) ([]Asset,
 	}
 	offset := (page - 1) * pageSize
 
-	where := []string{"1=1"}
-	args := []any{}
-	if !params.IncludeDeleted {
-		where = append(where, "a.deleted_at IS NULL")
-	}
+	base, having, args := searchFilter(params)
 
-	relevanceSelect := ""
-	if params.Query != "" {
-		where = append(where, "MATCH(a.title, a.caption, a.tag_text) AGAINST (? IN NATURAL LANGUAGE MODE)")
-		args = append(args, params.Query)
-		relevanceSelect = ", MATCH(a.title, a.caption, a.tag_text) AGAINST (? IN NATURAL LANGUAGE MODE) AS relevance"
+	total, err := s.countAssets(ctx, base, having, args)
+	if err != nil {
+		return nil, 0, queryErr(ctx, err)
 	}
 
-	join := ""
-	having := ""
-	if len(params.Tags) > 0 {
-		tags := NormalizeTags(params.Tags)
-		if len(tags) > 0 {
-			placeholders := strings.Repeat("?,", len(tags))
-			placeholders = strings.TrimSuffix(placeholders, ",")
-			join = "JOIN asset_tag at ON at.asset_id = a.id JOIN tag t ON t.id = at.tag_id"
-			where = append(where, "t.name IN ("+placeholders+")")
-			for _, t := range tags {
-				args = append(args, t)
-			}
-			having = "HAVING COUNT(DISTINCT t.name) = ?"
-			args = append(args, len(tags))
-		}
+	// Blank queries are no query at all, matching searchFilter.
+	query, mode := params.fullText()
+	relevanceSelect, relevanceArgs := "", 0
+	if s.scoresRelevance(query, params.Sort) {
+		var expr string
+		expr, relevanceArgs = relevanceExpr(s.weights, mode)
+		relevanceSelect = ", " + expr + " AS relevance"
 	}
 
 	orderClause := allowedSort[params.Sort]
 	if orderClause == "" {
 		orderClause = allowedSort["newest"]
 	}
-	if params.Sort == "relevance" && params.Query == "" {
+	if params.Sort == "relevance" && query == "" {
 		orderClause = allowedSort["newest"]
 	}
 
-	whereSQL := strings.Join(where, " AND ")
-	base := "FROM asset a " + join + " WHERE " + whereSQL
-
-	var total int
-	if having != "" {
-		countQuery := "SELECT COUNT(*) FROM (SELECT a.id " + base + " GROUP BY a.id " + having + ") sub"
-		if err := s.db.GetContext(ctx, &total, countQuery, args...); err != nil {
-			return nil, 0, err
-		}
-	} else {
-		countQuery := "SELECT COUNT(DISTINCT a.id) " + base
-		if err := s.db.GetContext(ctx, &total, countQuery, args...); err != nil {
-			return nil, 0, err
-		}
-	}
-
-	selectQuery := "SELECT a.id, a.title, a.caption, a.credit, a.source, a.usage_notes, a.width, a.height, a.bytes, a.mime, a.original_filename, a.sha256, a.tag_text, a.created_at, a.updated_at, a.deleted_at" + relevanceSelect + " " + base + " GROUP BY a.id " + having + " ORDER BY " + orderClause + " LIMIT ? OFFSET ?"
+	selectQuery := "SELECT a.id, a.title, a.caption, a.credit, a.source, a.usage_notes, a.width, a.height, a.has_alpha, a.color_space, a.bytes, a.mime, a.original_filename, a.display_filename, a.sha256, a.hash_algo, a.preview, a.variant_state, a.tag_text, a.immutable, a.visibility, a.created_at, a.updated_at, a.deleted_at, a.deleted_by, a.deletion_reason" + relevanceSelect + " " + base + " GROUP BY a.id " + having + " ORDER BY " + orderClause + " LIMIT ? OFFSET ?"
 	listArgs := []any{}
-	if relevanceSelect != "" {
-		listArgs = append(listArgs, params.Query)
+	for i := 0; i < relevanceArgs; i++ {
+		listArgs = append(listArgs, query)
 	}
 	listArgs = append(listArgs, args...)
 	listArgs = append(listArgs, pageSize, offset)
 
 	var rows []Asset
-	if err := s.db.SelectContext(ctx, &rows, selectQuery, listArgs...); err != nil {
-		return nil, 0, err
+	if err := s.reader().SelectContext(ctx, &rows, selectQuery, listArgs...); err != nil {
+		return nil, 0, queryErr(ctx, err)
 	}
 
 	assets := make([]*Asset, len(rows))
 	for i := range rows {
 		assets[i] = &rows[i]
 	}
+	if err := s.attachACL(ctx, nil, assets); err != nil {
+		return nil, 0, queryErr(ctx, err)
+	}
 	if err := s.attachTags(ctx, nil, assets); err != nil {
-		return nil, 0, err
+		return nil, 0, queryErr(ctx, err)
 	}
 
 	return rows, total, nil
 }
 
+// scoresRelevance reports whether SearchAssets computes relevance scores for a
+// search with the given full-text query and sort order. Relevance sorts need them;
+// other sorts only report them unless RelevanceSortedOnly is set.
+func (s *Store) scoresRelevance(query, sort string) bool {
+	return query != "" && (sort == "relevance" || !s.relevanceSortedOnly)
+}
+
+// CountAssets returns the number of assets matching the search filters without fetching rows.
+// Paging and sort fields of params are ignored.
+func (s *Store) CountAssets(ctx context.Context, params SearchParams) (_ int, err error) {
+	if err := s.breaker.allow(); err != nil {
+		return 0, err
+	}
+	defer s.breaker.record(&err)
+
+	base, having, args := searchFilter(params)
+	total, err := s.countAssets(ctx, base, having, args)
+	if err != nil {
+		return 0, queryErr(ctx, err)
+	}
+	return total, nil
+}
+
+// Facet fields accepted by FacetAssets.
+const (
+	FacetCreatedAt = "created_at"
+	FacetMime      = "mime"
+)
+
+// facetIntervals truncates created_at to the key of each date bucket.
+var facetIntervals = map[string]string{
+	"year":  "DATE_FORMAT(a.created_at, '%Y')",
+	"month": "DATE_FORMAT(a.created_at, '%Y-%m')",
+	"day":   "DATE_FORMAT(a.created_at, '%Y-%m-%d')",
+}
+
+// FacetBucket is one facet value and how many matching assets have it.
+type FacetBucket struct {
+	Key   string `db:"bucket"`
+	Count int    `db:"count"`
+}
+
+// FacetAssets groups the assets matching the search filters by field: created_at
+// truncated to interval (year, month, or day), oldest first, or mime, most common
+// first. Paging and sort fields of params are ignored.
+func (s *Store) FacetAssets(ctx context.Context, params SearchParams, field, interval string) (_ []FacetBucket, err error) {
+	var expr, order string
+	switch field {
+	case FacetCreatedAt:
+		expr = facetIntervals[interval]
+		if expr == "" {
+			return nil, fmt.Errorf("unknown facet interval %q", interval)
+		}
+		order = "bucket"
+	case FacetMime:
+		expr = "a.mime"
+		order = "count DESC, bucket"
+	default:
+		return nil, fmt.Errorf("unknown facet field %q", field)
+	}
+
+	if err := s.breaker.allow(); err != nil {
+		return nil, err
+	}
+	defer s.breaker.record(&err)
+
+	// Match first, then bucket: the tag filter groups by asset and may need HAVING.
+	base, having, args := searchFilter(params)
+	query := "SELECT " + expr + " AS bucket, COUNT(*) AS count FROM (SELECT a.id, a.created_at, a.mime " + base + " GROUP BY a.id " + having + ") a GROUP BY bucket ORDER BY " + order
+	buckets := []FacetBucket{}
+	if err := s.reader().SelectContext(ctx, &buckets, query, args...); err != nil {
+		return nil, queryErr(ctx, err)
+	}
+	return buckets, nil
+}
+
+// UnknownTags returns the normalized forms of tags that are not in the tag catalog,
+// sorted. A search filtering on any of them cannot match. Namespace wildcards are
+// never reported.
+func (s *Store) UnknownTags(ctx context.Context, tags []string) (_ []string, err error) {
+	if err := s.breaker.allow(); err != nil {
+		return nil, err
+	}
+	defer s.breaker.record(&err)
+
+	names, _ := splitNamespaceWildcards(NormalizeTags(tags))
+	if len(names) == 0 {
+		return nil, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(names)), ",")
+	var known []string
+	if err := s.reader().SelectContext(ctx, &known, "SELECT name FROM tag WHERE name IN ("+placeholders+")", toAny(names)...); err != nil {
+		return nil, queryErr(ctx, err)
+	}
+	found := make(map[string]bool, len(known))
+	for _, name := range known {
+		found[name] = true
+	}
+	var unknown []string
+	for _, name := range names {
+		if !found[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	return unknown, nil
+}
+
+// AssetFile is the storage-relevant slice of an asset row, used to cross-check
+// the database against the media tree.
+type AssetFile struct {
+	ID      int64  `db:"id"`
+	SHA256  string `db:"sha256"`
+	Deleted bool   `db:"deleted"`
+}
+
+// ListAssetFiles returns every asset row, soft-deleted ones included, ordered by id.
+// It reads from the primary so a lagging replica cannot make fresh files look orphaned.
+func (s *Store) ListAssetFiles(ctx context.Context) (_ []AssetFile, err error) {
+	if err := s.breaker.allow(); err != nil {
+		return nil, err
+	}
+	defer s.breaker.record(&err)
+
+	var files []AssetFile
+	if err := s.db.SelectContext(ctx, &files, "SELECT id, sha256, deleted_at IS NOT NULL AS deleted FROM asset ORDER BY id"); err != nil {
+		return nil, queryErr(ctx, err)
+	}
+	return files, nil
+}
+
+func (s *Store) countAssets(ctx context.Context, base, having string, args []any) (int, error) {
+	countQuery := "SELECT COUNT(DISTINCT a.id) " + base
+	if having != "" {
+		countQuery = "SELECT COUNT(*) FROM (SELECT a.id " + base + " GROUP BY a.id " + having + ") sub"
+	}
+	var total int
+	if err := s.reader().GetContext(ctx, &total, countQuery, args...); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// searchFilter builds the FROM/WHERE clause, optional HAVING clause, and bound arguments
+// shared by the search and count queries.
+func searchFilter(params SearchParams) (string, string, []any) {
+	where := []string{"1=1"}
+	args := []any{}
+	if !params.IncludeDeleted {
+		where = append(where, "a.deleted_at IS NULL")
+	}
+
+	// MATCH against whitespace behaves differently across MySQL and MariaDB
+	// versions, so a blank query applies no full-text filter.
+	if query, mode := params.fullText(); query != "" {
+		where = append(where, fullTextMatch(mode))
+		args = append(args, query)
+	}
+	if params.Mime != "" {
+		where = append(where, "a.mime = ?")
+		args = append(args, params.Mime)
+	}
+	if params.CreatedAfter != nil {
+		where = append(where, "a.created_at >= ?")
+		args = append(args, *params.CreatedAfter)
+	}
+	if params.CreatedBefore != nil {
+		where = append(where, "a.created_at < ?")
+		args = append(args, *params.CreatedBefore)
+	}
+	if params.Viewer != nil {
+		where = append(where, "(a.visibility = 'public' OR EXISTS (SELECT 1 FROM asset_acl acl WHERE acl.asset_id = a.id AND acl.principal_id = ?))")
+		args = append(args, *params.Viewer)
+	}
+
+	join := ""
+	having := ""
+	if len(params.Tags) > 0 {
+		tags, namespaces := splitNamespaceWildcards(NormalizeTags(params.Tags))
+		// A wildcard is satisfied by any one tag in its namespace, so it cannot share
+		// the join that requires every exact tag.
+		for _, ns := range namespaces {
+			where = append(where, "EXISTS (SELECT 1 FROM asset_tag nat JOIN tag nt ON nt.id = nat.tag_id WHERE nat.asset_id = a.id AND nt.name LIKE ?)")
+			args = append(args, likePrefix(ns+NamespaceSep))
+		}
+		if len(tags) > 0 {
+			placeholders := strings.Repeat("?,", len(tags))
+			placeholders = strings.TrimSuffix(placeholders, ",")
+			join = "JOIN asset_tag at ON at.asset_id = a.id JOIN tag t ON t.id = at.tag_id"
+			where = append(where, "t.name IN ("+placeholders+")")
+			for _, t := range tags {
+				args = append(args, t)
+			}
+			having = "HAVING COUNT(DISTINCT t.name) = ?"
+			args = append(args, len(tags))
+		}
+	}
+
+	return "FROM asset a " + join + " WHERE " + strings.Join(where, " AND "), having, args
+}
+
 func (s *Store) attachTags(ctx context.Context, tx *sqlx.Tx, assets []*Asset) error {
 	if len(assets) == 0 {
 		return nil
@@ -333,7 +1052,7 @@ func (s *Store) attachTags(ctx context.Context, tx *sqlx.Tx, assets []*Asset) er
 		if tx != nil {
 			return tx.QueryxContext(ctx, query, toAny(ids)...)
 		}
-		return s.db.QueryxContext(ctx, query, toAny(ids)...)
+		return s.reader().QueryxContext(ctx, query, toAny(ids)...)
 	})()
 	if err != nil {
 		return err
@@ -350,6 +1069,75 @@ func (s *Store) attachTags(ctx context.Context, tx *sqlx.Tx, assets []*Asset) er
 	return rows.Err()
 }
 
+// attachACL loads access lists for the private assets among assets.
+func (s *Store) attachACL(ctx context.Context, tx *sqlx.Tx, assets []*Asset) error {
+	index := make(map[int64]*Asset)
+	ids := []int64{}
+	for _, a := range assets {
+		if a.Visibility == VisibilityPrivate {
+			index[a.ID] = a
+			ids = append(ids, a.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	query := "SELECT asset_id, principal_id FROM asset_acl WHERE asset_id IN (" + placeholders + ") ORDER BY principal_id"
+	rows, err := (func() (*sqlx.Rows, error) {
+		if tx != nil {
+			return tx.QueryxContext(ctx, query, toAny(ids)...)
+		}
+		return s.reader().QueryxContext(ctx, query, toAny(ids)...)
+	})()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var assetID int64
+		var principal string
+		if err := rows.Scan(&assetID, &principal); err != nil {
+			return err
+		}
+		index[assetID].AllowedPrincipals = append(index[assetID].AllowedPrincipals, principal)
+	}
+	return rows.Err()
+}
+
+func replaceACLTx(ctx context.Context, tx *sqlx.Tx, assetID int64, principals []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM asset_acl WHERE asset_id = ?", assetID); err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(principals))
+	for _, p := range principals {
+		p = strings.TrimSpace(p)
+		if p == "" || seen[p] {
+			continue
+		}
+		seen[p] = true
+		if _, err := tx.ExecContext(ctx, "INSERT INTO asset_acl (asset_id, principal_id) VALUES (?, ?)", assetID, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CanView reports whether principalID may see the asset. Public assets are visible
+// to everyone; private ones only to the principals on their access list.
+func (a *Asset) CanView(principalID string) bool {
+	if a.Visibility != VisibilityPrivate {
+		return true
+	}
+	for _, p := range a.AllowedPrincipals {
+		if p == principalID {
+			return true
+		}
+	}
+	return false
+}
+
 func toAny[T comparable](vals []T) []any {
 	res := make([]any, len(vals))
 	for i, v := range vals {
@@ -358,6 +1146,50 @@ func toAny[T comparable](vals []T) []any {
 	return res
 }
 
+// nullString maps an empty string to SQL NULL.
+func nullString(v string) any {
+	if v == "" {
+		return nil
+	}
+	return v
+}
+
+// queryErr reports the context's error when a query failed because the context ended,
+// since drivers often surface that as an opaque connection error. A query the server
+// killed for exceeding max_statement_time is reported as a deadline too.
+func queryErr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+		return fmt.Errorf("%w: %v", ctxErr, err)
+	}
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) && myErr.Number == errStatementTimeout {
+		return fmt.Errorf("%w: %v", context.DeadlineExceeded, err)
+	}
+	return err
+}
+
+// errStatementTimeout is MariaDB's ER_STATEMENT_TIMEOUT.
+const errStatementTimeout = 1969
+
+// StatementTimeoutDSN returns dsn with the max_statement_time session variable set,
+// so the server itself aborts statements running longer than timeout even when a
+// cancelled context never reaches it. The driver sets it on every new connection.
+// A zero timeout returns dsn unchanged.
+func StatementTimeoutDSN(dsn string, timeout time.Duration) (string, error) {
+	if timeout <= 0 {
+		return dsn, nil
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", err
+	}
+	if cfg.Params == nil {
+		cfg.Params = map[string]string{}
+	}
+	cfg.Params["max_statement_time"] = strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64)
+	return cfg.FormatDSN(), nil
+}
+
 func isDuplicate(err error) bool {
 	if err == nil {
 		return false
@@ -365,7 +1197,21 @@ func isDuplicate(err error) bool {
 	return strings.Contains(strings.ToLower(err.Error()), "duplicate") || strings.Contains(strings.ToLower(err.Error()), "unique")
 }
 
-func (s *Store) ListTags(ctx context.Context, prefix string, page, pageSize int) ([]string, int, error) {
+// ListTags pages through tag names by name, optionally only those starting with
+// prefix. With a namespace, only tags in it are listed and prefix matches the part
+// after the colon.
+func (s *Store) ListTags(ctx context.Context, namespace, prefix string, page, pageSize int) (_ []string, _ int, err error) {
+	namespace = NormalizeTag(namespace)
+	key := tagCacheKey{namespace: namespace, prefix: prefix, page: page, pageSize: pageSize}
+	cached, cachedTotal, generation, ok := s.tags.get(key)
+	if ok {
+		return cached, cachedTotal, nil
+	}
+	if err := s.breaker.allow(); err != nil {
+		return nil, 0, err
+	}
+	defer s.breaker.record(&err)
+
 	if page <= 0 {
 		page = 1
 	}
@@ -376,22 +1222,90 @@ func (s *Store) ListTags(ctx context.Context, prefix string, page, pageSize int)
 
 	where := ""
 	args := []any{}
+	if namespace != "" {
+		// The prefix applies to what follows the namespace.
+		prefix = namespace + NamespaceSep + prefix
+	}
 	if prefix != "" {
 		where = "WHERE name LIKE ?"
-		args = append(args, prefix+"%")
+		args = append(args, likePrefix(prefix))
 	}
 
 	countQuery := "SELECT COUNT(*) FROM tag " + where
 	var total int
-	if err := s.db.GetContext(ctx, &total, countQuery, args...); err != nil {
+	if err := s.reader().GetContext(ctx, &total, countQuery, args...); err != nil {
 		return nil, 0, err
 	}
 
 	query := "SELECT name FROM tag " + where + " ORDER BY name LIMIT ? OFFSET ?"
 	argsWithPaging := append(append([]any{}, args...), pageSize, offset)
 	var tags []string
-	if err := s.db.SelectContext(ctx, &tags, query, argsWithPaging...); err != nil {
+	if err := s.reader().SelectContext(ctx, &tags, query, argsWithPaging...); err != nil {
 		return nil, 0, err
 	}
+	s.tags.put(generation, key, tags, total)
 	return tags, total, nil
 }
+
+// TagCount is a tag and the number of assets it was counted on.
+type TagCount struct {
+	Name  string `db:"name"`
+	Count int    `db:"count"`
+}
+
+// RelatedTags ranks the tags that appear on live assets carrying tag by how many of
+// those assets they share, most first and then by name. tag is normalized first.
+// viewer limits the assets counted as in SearchParams. It returns ErrNotFound when
+// no such tag exists.
+func (s *Store) RelatedTags(ctx context.Context, tag string, viewer *string, page, pageSize int) (_ []TagCount, _ int, err error) {
+	if err := s.breaker.allow(); err != nil {
+		return nil, 0, err
+	}
+	defer s.breaker.record(&err)
+
+	name := NormalizeTag(tag)
+	if name == "" {
+		return nil, 0, ErrNotFound
+	}
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 100
+	}
+	offset := (page - 1) * pageSize
+
+	base := `FROM asset_tag src
+	JOIN tag st ON st.id = src.tag_id
+	JOIN asset a ON a.id = src.asset_id
+	JOIN asset_tag co ON co.asset_id = src.asset_id AND co.tag_id <> src.tag_id
+	JOIN tag t ON t.id = co.tag_id
+	WHERE st.name = ? AND a.deleted_at IS NULL`
+	args := []any{name}
+	if viewer != nil {
+		base += " AND (a.visibility = 'public' OR EXISTS (SELECT 1 FROM asset_acl acl WHERE acl.asset_id = a.id AND acl.principal_id = ?))"
+		args = append(args, *viewer)
+	}
+
+	var total int
+	if err := s.reader().GetContext(ctx, &total, "SELECT COUNT(DISTINCT co.tag_id) "+base, args...); err != nil {
+		return nil, 0, queryErr(ctx, err)
+	}
+	if total == 0 {
+		var known int
+		if err := s.reader().GetContext(ctx, &known, "SELECT COUNT(*) FROM tag WHERE name = ?", name); err != nil {
+			return nil, 0, queryErr(ctx, err)
+		}
+		if known == 0 {
+			return nil, 0, ErrNotFound
+		}
+		return []TagCount{}, 0, nil
+	}
+
+	query := "SELECT t.name, COUNT(*) AS count " + base + " GROUP BY t.id, t.name ORDER BY count DESC, t.name LIMIT ? OFFSET ?"
+	related := []TagCount{}
+	if err := s.reader().SelectContext(ctx, &related, query, append(args, pageSize, offset)...); err != nil {
+		return nil, 0, queryErr(ctx, err)
+	}
+	return related, total, nil
+}
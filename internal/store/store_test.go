@@ -0,0 +1,228 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestSearchFilter(t *testing.T) {
+	after := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	base, having, args := searchFilter(SearchParams{
+		Query:        "cricket",
+		Tags:         []string{"Sport", "sport", "West Indies"},
+		Mime:         "image/jpeg",
+		CreatedAfter: &after,
+	})
+	for _, frag := range []string{"a.deleted_at IS NULL", "MATCH(", "a.mime = ?", "a.created_at >= ?", "t.name IN (?,?)"} {
+		if !strings.Contains(base, frag) {
+			t.Fatalf("expected %q in %q", frag, base)
+		}
+	}
+	if strings.Contains(base, "a.created_at < ?") {
+		t.Fatalf("unexpected createdBefore clause in %q", base)
+	}
+	if having != "HAVING COUNT(DISTINCT t.name) = ?" {
+		t.Fatalf("unexpected having clause %q", having)
+	}
+	if len(args) != 6 || args[5] != 2 {
+		t.Fatalf("unexpected args %v", args)
+	}
+}
+
+func TestSearchFilterNamespaceWildcard(t *testing.T) {
+	base, having, args := searchFilter(SearchParams{Tags: []string{"Location:*", "sport"}, IncludeDeleted: true})
+	if !strings.Contains(base, "nt.name LIKE ?") || !strings.Contains(base, "t.name IN (?)") {
+		t.Fatalf("expected a namespace clause next to the exact tag, got %q", base)
+	}
+	if having != "HAVING COUNT(DISTINCT t.name) = ?" || len(args) != 3 || args[0] != "location:%" || args[1] != "sport" || args[2] != 1 {
+		t.Fatalf("unexpected filter %q %v", having, args)
+	}
+
+	base, having, args = searchFilter(SearchParams{Tags: []string{"a_b:*"}, IncludeDeleted: true})
+	if strings.Contains(base, "JOIN asset_tag at") || having != "" || len(args) != 1 || args[0] != `a\_b:%` {
+		t.Fatalf("expected only an escaped namespace clause, got %q %q %v", base, having, args)
+	}
+	if _, namespaces := splitNamespaceWildcards([]string{":*", "a:b:*", "*"}); len(namespaces) != 0 {
+		t.Fatalf("expected malformed wildcards to be treated as tags, got %v", namespaces)
+	}
+}
+
+func TestSearchFilterTrimsQuery(t *testing.T) {
+	cases := []struct {
+		query string
+		want  []any
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"\t cricket  ", []any{"cricket"}},
+	}
+	for _, tc := range cases {
+		base, _, args := searchFilter(SearchParams{Query: tc.query, IncludeDeleted: true})
+		if hasMatch := strings.Contains(base, "MATCH("); hasMatch != (tc.want != nil) {
+			t.Fatalf("q=%q: unexpected full-text clause presence in %q", tc.query, base)
+		}
+		if len(args) != len(tc.want) || (len(args) == 1 && args[0] != tc.want[0]) {
+			t.Fatalf("q=%q: expected args %v, got %v", tc.query, tc.want, args)
+		}
+	}
+}
+
+func TestSearchFilterIncludeDeleted(t *testing.T) {
+	base, having, args := searchFilter(SearchParams{IncludeDeleted: true})
+	if strings.Contains(base, "deleted_at") || having != "" || len(args) != 0 {
+		t.Fatalf("unexpected filter %q %q %v", base, having, args)
+	}
+}
+
+func TestAllowedSortHasIDTiebreaker(t *testing.T) {
+	for name, clause := range allowedSort {
+		if !strings.HasSuffix(clause, "a.id DESC") && !strings.HasSuffix(clause, "a.id ASC") {
+			t.Fatalf("sort %q lacks an id tiebreaker: %q", name, clause)
+		}
+	}
+}
+
+func TestSearchFilterViewer(t *testing.T) {
+	viewer := "partner"
+	base, _, args := searchFilter(SearchParams{Viewer: &viewer})
+	if !strings.Contains(base, "a.visibility = 'public' OR EXISTS") {
+		t.Fatalf("expected visibility clause in %q", base)
+	}
+	if len(args) != 1 || args[0] != "partner" {
+		t.Fatalf("unexpected args %v", args)
+	}
+}
+
+func TestAssetCanView(t *testing.T) {
+	public := &Asset{Visibility: VisibilityPublic}
+	if !public.CanView("") {
+		t.Fatalf("public assets are visible to everyone")
+	}
+	private := &Asset{Visibility: VisibilityPrivate, AllowedPrincipals: []string{"partner"}}
+	if !private.CanView("partner") || private.CanView("other") || private.CanView("") {
+		t.Fatalf("private asset visibility not enforced")
+	}
+}
+
+func TestRelevanceExpr(t *testing.T) {
+	expr, n := relevanceExpr(RelevanceWeights{}, naturalLanguageMode)
+	if expr != fullTextMatch(naturalLanguageMode) || n != 1 {
+		t.Fatalf("zero weights should use the combined match, got %q (%d)", expr, n)
+	}
+
+	expr, n = relevanceExpr(RelevanceWeights{Title: 3, Tags: 2, Caption: 1}, naturalLanguageMode)
+	want := "(3 * MATCH(a.title) AGAINST (? IN NATURAL LANGUAGE MODE) + 2 * MATCH(a.tag_text) AGAINST (? IN NATURAL LANGUAGE MODE) + 1 * MATCH(a.caption) AGAINST (? IN NATURAL LANGUAGE MODE))"
+	if expr != want || n != 3 {
+		t.Fatalf("unexpected expression %q (%d)", expr, n)
+	}
+
+	expr, n = relevanceExpr(RelevanceWeights{Title: 1.5}, naturalLanguageMode)
+	if expr != "(1.5 * MATCH(a.title) AGAINST (? IN NATURAL LANGUAGE MODE))" || n != 1 {
+		t.Fatalf("zero-weight columns should be left out, got %q (%d)", expr, n)
+	}
+
+	expr, _ = relevanceExpr(RelevanceWeights{Title: 1}, booleanMode)
+	if expr != "(1 * MATCH(a.title) AGAINST (? IN BOOLEAN MODE))" {
+		t.Fatalf("expected the stemmed query's boolean mode, got %q", expr)
+	}
+}
+
+func TestScoresRelevance(t *testing.T) {
+	s := &Store{}
+	if !s.scoresRelevance("cricket", "newest") || !s.scoresRelevance("cricket", "relevance") || s.scoresRelevance("", "relevance") {
+		t.Fatalf("expected every search with a query to be scored by default")
+	}
+	s.relevanceSortedOnly = true
+	if s.scoresRelevance("cricket", "newest") || !s.scoresRelevance("cricket", "relevance") {
+		t.Fatalf("expected only relevance sorts to be scored")
+	}
+	// Counting only uses the filter, which matches the query either way.
+	if base, _, _ := searchFilter(SearchParams{Query: "cricket", Sort: "newest"}); !strings.Contains(base, "MATCH(") {
+		t.Fatalf("expected the full-text filter to stay in %q", base)
+	}
+}
+
+func TestFacetAssetsValidation(t *testing.T) {
+	s := &Store{}
+	if _, err := s.FacetAssets(context.Background(), SearchParams{}, "title", "month"); err == nil {
+		t.Fatalf("expected an unknown field to be rejected")
+	}
+	if _, err := s.FacetAssets(context.Background(), SearchParams{}, FacetCreatedAt, "week"); err == nil {
+		t.Fatalf("expected an unknown interval to be rejected")
+	}
+}
+
+func TestStatementTimeoutDSN(t *testing.T) {
+	const dsn = "ganache:secret@tcp(db:3306)/ganache?parseTime=true"
+	if got, err := StatementTimeoutDSN(dsn, 0); err != nil || got != dsn {
+		t.Fatalf("expected DSN unchanged without a timeout, got %q, %v", got, err)
+	}
+	got, err := StatementTimeoutDSN(dsn, 1500*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg, err := mysql.ParseDSN(got)
+	if err != nil {
+		t.Fatalf("parse %q: %v", got, err)
+	}
+	if cfg.Params["max_statement_time"] != "1.5" || !cfg.ParseTime || cfg.DBName != "ganache" {
+		t.Fatalf("unexpected config from %q: %+v", got, cfg)
+	}
+	if _, err := StatementTimeoutDSN("not a dsn", time.Second); err == nil {
+		t.Fatalf("expected invalid DSN to be rejected")
+	}
+}
+
+func TestQueryErrStatementTimeout(t *testing.T) {
+	err := queryErr(context.Background(), &mysql.MySQLError{Number: errStatementTimeout, Message: "Query execution was interrupted (max_statement_time exceeded)"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline, got %v", err)
+	}
+	if err := queryErr(context.Background(), &mysql.MySQLError{Number: 1064}); errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("other server errors must pass through, got %v", err)
+	}
+}
+
+func TestAssetVersionCanView(t *testing.T) {
+	v := &AssetVersion{Visibility: VisibilityPrivate, AllowedPrincipals: []string{"partner"}}
+	if !v.CanView("partner") || v.CanView("other") {
+		t.Fatalf("private version visibility not enforced")
+	}
+	v = &AssetVersion{Visibility: VisibilityPublic}
+	if !v.CanView("other") {
+		t.Fatalf("public versions are visible to everyone")
+	}
+}
+
+func TestSameVersion(t *testing.T) {
+	a := &Asset{Title: "t", Visibility: VisibilityPublic, Tags: []string{"x"}}
+	b := *a
+	b.Tags = []string{"x"}
+	b.Bytes = 42
+	if !sameVersion(a, &b) {
+		t.Fatalf("expected fields a version does not record to be ignored")
+	}
+	b.AllowedPrincipals = []string{"partner"}
+	if sameVersion(a, &b) {
+		t.Fatalf("expected a changed access list to count as a new version")
+	}
+	b.AllowedPrincipals = nil
+	b.Tags = []string{"x", "y"}
+	if sameVersion(a, &b) {
+		t.Fatalf("expected changed tags to count as a new version")
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	cases := map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 9: 256 * time.Second, 10: outboxMaxBackoff, 50: outboxMaxBackoff}
+	for attempt, want := range cases {
+		if got := outboxBackoff(attempt); got != want {
+			t.Fatalf("outboxBackoff(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// maxTagCacheEntries bounds the tag listing cache; type-ahead traffic produces one
+// entry per distinct prefix and page.
+const maxTagCacheEntries = 10000
+
+type tagCacheKey struct {
+	namespace string
+	prefix    string
+	page      int
+	pageSize  int
+}
+
+type tagCacheEntry struct {
+	tags    []string
+	total   int
+	expires time.Time
+}
+
+// tagCache holds recent ListTags results for ttl. Mutations bump the generation,
+// which drops every entry and stops queries that started before the mutation from
+// storing their now-stale result. A nil cache (ttl disabled) caches nothing.
+type tagCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu         sync.Mutex
+	generation uint64
+	entries    map[tagCacheKey]tagCacheEntry
+}
+
+func newTagCache(ttl time.Duration) *tagCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &tagCache{ttl: ttl, now: time.Now, entries: make(map[tagCacheKey]tagCacheEntry)}
+}
+
+// get returns a live entry for key, plus the generation to pass to put on a miss.
+func (c *tagCache) get(key tagCacheKey) ([]string, int, uint64, bool) {
+	if c == nil {
+		return nil, 0, 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || !c.now().Before(e.expires) {
+		return nil, 0, c.generation, false
+	}
+	return e.tags, e.total, c.generation, true
+}
+
+func (c *tagCache) put(generation uint64, key tagCacheKey, tags []string, total int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if len(c.entries) >= maxTagCacheEntries {
+		clear(c.entries)
+	}
+	c.entries[key] = tagCacheEntry{tags: tags, total: total, expires: c.now().Add(c.ttl)}
+}
+
+func (c *tagCache) invalidate() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	clear(c.entries)
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTagCache(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := newTagCache(time.Minute)
+	c.now = func() time.Time { return now }
+	key := tagCacheKey{prefix: "cr", page: 1, pageSize: 10}
+
+	_, _, gen, ok := c.get(key)
+	if ok {
+		t.Fatalf("empty cache should miss")
+	}
+	c.put(gen, key, []string{"cricket"}, 1)
+	if tags, total, _, ok := c.get(key); !ok || total != 1 || tags[0] != "cricket" {
+		t.Fatalf("expected hit, got %v %d %v", tags, total, ok)
+	}
+
+	now = now.Add(time.Minute)
+	if _, _, _, ok := c.get(key); ok {
+		t.Fatalf("entry should expire after ttl")
+	}
+
+	_, _, gen, _ = c.get(key)
+	c.invalidate()
+	c.put(gen, key, []string{"stale"}, 1)
+	if _, _, _, ok := c.get(key); ok {
+		t.Fatalf("result fetched before a mutation must not be cached")
+	}
+
+	disabled := newTagCache(0)
+	disabled.put(0, key, nil, 0)
+	disabled.invalidate()
+	if _, _, _, ok := disabled.get(key); ok {
+		t.Fatalf("disabled cache should never hit")
+	}
+}
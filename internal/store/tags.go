@@ -3,15 +3,61 @@ package store
 import (
 	"sort"
 	"strings"
+	"sync/atomic"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
 )
 
+var foldAccents atomic.Bool
+
+// SetAccentFolding controls whether NormalizeTag strips diacritics ("café" → "cafe").
+// It is meant to be set once at startup; it defaults to off.
+func SetAccentFolding(enabled bool) {
+	foldAccents.Store(enabled)
+}
+
+// NamespaceSep separates a tag's namespace from the rest of it, as in "location:paris".
+const NamespaceSep = ":"
+
+// namespaceWildcard, as "location:*", matches any tag in a namespace in a search.
+const namespaceWildcard = NamespaceSep + "*"
+
+// NormalizeTag trims, collapses whitespace, and lowercases a tag. Whitespace around
+// the namespace separator is dropped, so "Location : Paris" becomes "location:paris".
 func NormalizeTag(in string) string {
 	trimmed := strings.TrimSpace(in)
 	if trimmed == "" {
 		return ""
 	}
 	collapsed := strings.Join(strings.Fields(trimmed), " ")
-	return strings.ToLower(collapsed)
+	if ns, rest, ok := strings.Cut(collapsed, NamespaceSep); ok {
+		collapsed = strings.TrimSpace(ns) + NamespaceSep + strings.TrimSpace(rest)
+	}
+	lowered := cases.Lower(language.Und).String(norm.NFC.String(collapsed))
+	if foldAccents.Load() {
+		return stripAccents(lowered)
+	}
+	return lowered
+}
+
+// NormalizeText puts free text such as titles into Unicode NFC so that precomposed and
+// combining-accent spellings are stored identically.
+func NormalizeText(in string) string {
+	return norm.NFC.String(in)
+}
+
+func stripAccents(in string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	out, _, err := transform.String(t, in)
+	if err != nil {
+		return in
+	}
+	return out
 }
 
 func NormalizeTags(tags []string) []string {
@@ -31,6 +77,45 @@ func NormalizeTags(tags []string) []string {
 	return out
 }
 
+// EditTags applies incremental edits to an existing tag set: removals are matched after
+// normalization, and a tag both added and removed ends up removed.
+func EditTags(current, add, remove []string) []string {
+	set := make(map[string]struct{}, len(current)+len(add))
+	for _, t := range NormalizeTags(current) {
+		set[t] = struct{}{}
+	}
+	for _, t := range NormalizeTags(add) {
+		set[t] = struct{}{}
+	}
+	for _, t := range NormalizeTags(remove) {
+		delete(set, t)
+	}
+	out := make([]string, 0, len(set))
+	for t := range set {
+		out = append(out, t)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// splitNamespaceWildcards separates "namespace:*" filters from exact tag names,
+// returning the namespaces of the former. Tags must already be normalized.
+func splitNamespaceWildcards(tags []string) (names, namespaces []string) {
+	for _, t := range tags {
+		if ns, ok := strings.CutSuffix(t, namespaceWildcard); ok && ns != "" && !strings.Contains(ns, NamespaceSep) {
+			namespaces = append(namespaces, ns)
+			continue
+		}
+		names = append(names, t)
+	}
+	return names, namespaces
+}
+
+// likePrefix escapes LIKE wildcards in prefix and matches anything after it.
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix) + "%"
+}
+
 func TagText(tags []string) string {
 	norm := NormalizeTags(tags)
 	return strings.Join(norm, " ")
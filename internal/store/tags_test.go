@@ -4,12 +4,14 @@ import "testing"
 
 func TestNormalizeTag(t *testing.T) {
 	cases := map[string]string{
-		"  Foo  ":      "foo",
-		"Foo   Bar":    "foo bar",
-		"":             "",
-		"  ":           "",
-		"Mixed	Case":   "mixed case",
-		"Two  Words  ": "two words",
+		"  Foo  ":             "foo",
+		"Foo   Bar":           "foo bar",
+		"":                    "",
+		"  ":                  "",
+		"Mixed	Case":          "mixed case",
+		"Two  Words  ":        "two words",
+		"Location : Paris":    "location:paris",
+		"subject:Street  Art": "subject:street art",
 	}
 	for in, expect := range cases {
 		if got := NormalizeTag(in); got != expect {
@@ -34,3 +36,50 @@ func TestNormalizeTagsAndText(t *testing.T) {
 		t.Fatalf("tag text expected %q got %q", "tag three tag two tagone", text)
 	}
 }
+
+func TestNormalizeTagUnicode(t *testing.T) {
+	precomposed := "Caf\u00e9"
+	combining := "Cafe\u0301"
+	if NormalizeTag(precomposed) != NormalizeTag(combining) {
+		t.Fatalf("expected %q and %q to normalize identically", precomposed, combining)
+	}
+	if got := NormalizeTag(combining); got != "caf\u00e9" {
+		t.Fatalf("normalize %q => %q, expected %q", combining, got, "caf\u00e9")
+	}
+	if got := NormalizeTag("\u00c9COLE"); got != "\u00e9cole" {
+		t.Fatalf("normalize %q => %q, expected %q", "\u00c9COLE", got, "\u00e9cole")
+	}
+}
+
+func TestNormalizeTagAccentFolding(t *testing.T) {
+	SetAccentFolding(true)
+	t.Cleanup(func() { SetAccentFolding(false) })
+
+	cases := map[string]string{
+		"Caf\u00e9":        "cafe",
+		"Cafe\u0301":       "cafe",
+		"S\u00e3o Paulo":   "sao paulo",
+		"Zoe\u0308  Smith": "zoe smith",
+	}
+	for in, expect := range cases {
+		if got := NormalizeTag(in); got != expect {
+			t.Fatalf("normalize %q => %q, expected %q", in, got, expect)
+		}
+	}
+}
+
+func TestEditTags(t *testing.T) {
+	got := EditTags([]string{"cricket", "west indies"}, []string{" Bridgetown ", "cricket"}, []string{"WEST INDIES", "missing"})
+	expect := []string{"bridgetown", "cricket"}
+	if len(got) != len(expect) {
+		t.Fatalf("expected %v got %v", expect, got)
+	}
+	for i := range got {
+		if got[i] != expect[i] {
+			t.Fatalf("expected %v got %v", expect, got)
+		}
+	}
+	if got := EditTags([]string{"a"}, []string{"b"}, []string{"b"}); len(got) != 1 || got[0] != "a" {
+		t.Fatalf("remove should win over add, got %v", got)
+	}
+}
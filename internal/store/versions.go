@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// AssetVersion is an earlier state of an asset's metadata, kept when an edit
+// replaced it. Versions are numbered from 1 per asset in the order they were replaced.
+// Each version keeps the visibility and access list it had, so a version is only
+// shown to callers who could see the asset at the time.
+type AssetVersion struct {
+	AssetID           int64     `db:"asset_id"`
+	Version           int       `db:"version"`
+	Title             string    `db:"title"`
+	Caption           string    `db:"caption"`
+	Credit            string    `db:"credit"`
+	Source            string    `db:"source"`
+	UsageNotes        string    `db:"usage_notes"`
+	TagsJSON          string    `db:"tags"`
+	Visibility        string    `db:"visibility"`
+	PrincipalsJSON    string    `db:"allowed_principals"`
+	SHA256            string    `db:"sha256"`
+	ValidFrom         time.Time `db:"valid_from"`
+	ReplacedAt        time.Time `db:"replaced_at"`
+	ReplacedBy        *string   `db:"replaced_by"`
+	Tags              []string  `db:"-"`
+	AllowedPrincipals []string  `db:"-"`
+}
+
+const versionColumns = "asset_id, version, title, caption, credit, source, usage_notes, tags, visibility, allowed_principals, sha256, valid_from, replaced_at, replaced_by"
+
+// CanView reports whether principalID could see the asset in this version, by the
+// same rule as Asset.CanView.
+func (v *AssetVersion) CanView(principalID string) bool {
+	return (&Asset{Visibility: v.Visibility, AllowedPrincipals: v.AllowedPrincipals}).CanView(principalID)
+}
+
+// snapshotVersionTx records before, the asset's state ahead of an edit in tx, as its
+// next version. An edit that left every versioned field as it was records nothing.
+func (s *Store) snapshotVersionTx(ctx context.Context, tx *sqlx.Tx, before, after *Asset, by string) error {
+	if sameVersion(before, after) {
+		return nil
+	}
+	tagsJSON, err := jsonList(before.Tags)
+	if err != nil {
+		return err
+	}
+	principalsJSON, err := jsonList(before.AllowedPrincipals)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO asset_version (asset_id, version, title, caption, credit, source, usage_notes, tags, visibility, allowed_principals, sha256, valid_from, replaced_by)
+	SELECT ?, COALESCE(MAX(version), 0) + 1, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ? FROM asset_version WHERE asset_id = ?`,
+		before.ID, before.Title, before.Caption, before.Credit, before.Source, before.UsageNotes, tagsJSON,
+		before.Visibility, principalsJSON, before.SHA256, before.UpdatedAt, nullString(by), before.ID)
+	return err
+}
+
+// sameVersion reports whether a and b agree on every field a version records.
+func sameVersion(a, b *Asset) bool {
+	return a.Title == b.Title && a.Caption == b.Caption && a.Credit == b.Credit &&
+		a.Source == b.Source && a.UsageNotes == b.UsageNotes && a.Visibility == b.Visibility &&
+		a.SHA256 == b.SHA256 && slices.Equal(a.Tags, b.Tags) && slices.Equal(a.AllowedPrincipals, b.AllowedPrincipals)
+}
+
+// jsonList encodes vals as a JSON array, never null.
+func jsonList(vals []string) (string, error) {
+	if vals == nil {
+		vals = []string{}
+	}
+	b, err := json.Marshal(vals)
+	return string(b), err
+}
+
+// ListVersions returns the earlier versions of an asset, newest first. It does not
+// check that the asset exists.
+func (s *Store) ListVersions(ctx context.Context, assetID int64) (_ []AssetVersion, err error) {
+	if err := s.breaker.allow(); err != nil {
+		return nil, err
+	}
+	defer s.breaker.record(&err)
+
+	var versions []AssetVersion
+	if err := s.reader().SelectContext(ctx, &versions, "SELECT "+versionColumns+" FROM asset_version WHERE asset_id = ? ORDER BY version DESC", assetID); err != nil {
+		return nil, queryErr(ctx, err)
+	}
+	for i := range versions {
+		if err := versions[i].decodeLists(); err != nil {
+			return nil, err
+		}
+	}
+	return versions, nil
+}
+
+// GetVersion returns one earlier version of an asset, or ErrNotFound.
+func (s *Store) GetVersion(ctx context.Context, assetID int64, version int) (_ *AssetVersion, err error) {
+	if err := s.breaker.allow(); err != nil {
+		return nil, err
+	}
+	defer s.breaker.record(&err)
+
+	var v AssetVersion
+	err = s.reader().GetContext(ctx, &v, "SELECT "+versionColumns+" FROM asset_version WHERE asset_id = ? AND version = ?", assetID, version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, queryErr(ctx, err)
+	}
+	if err := v.decodeLists(); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (v *AssetVersion) decodeLists() error {
+	v.Tags = []string{}
+	if err := json.Unmarshal([]byte(v.TagsJSON), &v.Tags); err != nil {
+		return err
+	}
+	v.AllowedPrincipals = []string{}
+	return json.Unmarshal([]byte(v.PrincipalsJSON), &v.AllowedPrincipals)
+}
@@ -0,0 +1 @@
+ALTER TABLE asset ADD COLUMN immutable TINYINT(1) NOT NULL DEFAULT 0 AFTER tag_text;
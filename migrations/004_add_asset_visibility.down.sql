@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS asset_acl;
+ALTER TABLE asset DROP COLUMN visibility;
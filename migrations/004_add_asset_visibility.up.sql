@@ -0,0 +1,8 @@
+ALTER TABLE asset ADD COLUMN visibility ENUM('public', 'private') NOT NULL DEFAULT 'public' AFTER immutable;
+
+CREATE TABLE IF NOT EXISTS asset_acl (
+    asset_id BIGINT UNSIGNED NOT NULL,
+    principal_id VARCHAR(255) NOT NULL,
+    PRIMARY KEY (asset_id, principal_id),
+    CONSTRAINT fk_asset_acl_asset FOREIGN KEY (asset_id) REFERENCES asset(id) ON DELETE CASCADE
+);
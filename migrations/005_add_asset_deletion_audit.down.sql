@@ -0,0 +1 @@
+ALTER TABLE asset DROP COLUMN deletion_reason, DROP COLUMN deleted_by;
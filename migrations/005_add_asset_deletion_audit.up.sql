@@ -0,0 +1,3 @@
+ALTER TABLE asset
+    ADD COLUMN deleted_by VARCHAR(255) NULL AFTER deleted_at,
+    ADD COLUMN deletion_reason VARCHAR(1024) NULL AFTER deleted_by;
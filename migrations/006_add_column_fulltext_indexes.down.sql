@@ -0,0 +1 @@
+ALTER TABLE asset DROP INDEX ft_asset_tag_text, DROP INDEX ft_asset_caption, DROP INDEX ft_asset_title;
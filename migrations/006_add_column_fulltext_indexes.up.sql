@@ -0,0 +1,3 @@
+ALTER TABLE asset ADD FULLTEXT KEY ft_asset_title (title);
+ALTER TABLE asset ADD FULLTEXT KEY ft_asset_caption (caption);
+ALTER TABLE asset ADD FULLTEXT KEY ft_asset_tag_text (tag_text);
@@ -0,0 +1 @@
+ALTER TABLE asset ADD COLUMN preview TEXT NULL AFTER sha256;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS event_consumer;
+DROP TABLE IF EXISTS event_outbox_seq;
+DROP TABLE IF EXISTS event_outbox;
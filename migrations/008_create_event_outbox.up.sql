@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS event_outbox (
+    id BIGINT UNSIGNED NOT NULL PRIMARY KEY,
+    type VARCHAR(32) NOT NULL,
+    asset_id BIGINT UNSIGNED NOT NULL,
+    created_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    last_error VARCHAR(1024) NULL,
+    delivered_at TIMESTAMP(6) NULL,
+    KEY idx_event_outbox_pending (delivered_at, next_attempt_at)
+);
+CREATE TABLE IF NOT EXISTS event_outbox_seq (
+    id TINYINT UNSIGNED NOT NULL PRIMARY KEY,
+    last_id BIGINT UNSIGNED NOT NULL
+);
+INSERT IGNORE INTO event_outbox_seq (id, last_id) VALUES (1, 0);
+CREATE TABLE IF NOT EXISTS event_consumer (
+    name VARCHAR(255) NOT NULL PRIMARY KEY,
+    cursor_id BIGINT UNSIGNED NOT NULL,
+    updated_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+);
@@ -0,0 +1 @@
+ALTER TABLE asset DROP COLUMN display_filename;
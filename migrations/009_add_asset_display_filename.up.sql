@@ -0,0 +1 @@
+ALTER TABLE asset ADD COLUMN display_filename VARCHAR(255) NULL AFTER original_filename;
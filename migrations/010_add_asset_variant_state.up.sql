@@ -0,0 +1 @@
+ALTER TABLE asset ADD COLUMN variant_state ENUM('ready', 'pending', 'failed') NOT NULL DEFAULT 'ready' AFTER preview;
@@ -0,0 +1,9 @@
+BEGIN NOT ATOMIC
+    IF EXISTS (SELECT 1 FROM asset WHERE hash_algo <> 'sha256') THEN
+        SIGNAL SQLSTATE '45000'
+            SET MESSAGE_TEXT = 'assets addressed by SHA-512 do not fit CHAR(64); delete them or re-upload them under GANACHE_HASH_ALGO=sha256 before migrating down';
+    END IF;
+END;
+ALTER TABLE asset
+    DROP COLUMN hash_algo,
+    MODIFY sha256 CHAR(64) NOT NULL;
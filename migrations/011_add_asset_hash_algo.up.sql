@@ -0,0 +1,3 @@
+ALTER TABLE asset
+    MODIFY sha256 VARCHAR(128) NOT NULL,
+    ADD COLUMN hash_algo VARCHAR(16) NOT NULL DEFAULT 'sha256' AFTER sha256;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS asset_version (
+    asset_id BIGINT UNSIGNED NOT NULL,
+    version INT UNSIGNED NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    caption TEXT NOT NULL,
+    credit VARCHAR(255) NOT NULL,
+    source VARCHAR(255) NOT NULL,
+    usage_notes TEXT NOT NULL,
+    tags TEXT NOT NULL,
+    visibility ENUM('public', 'private') NOT NULL,
+    allowed_principals TEXT NOT NULL DEFAULT '[]',
+    sha256 VARCHAR(128) NOT NULL,
+    valid_from TIMESTAMP NOT NULL,
+    replaced_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    replaced_by VARCHAR(255) NULL,
+    PRIMARY KEY (asset_id, version),
+    CONSTRAINT fk_asset_version_asset FOREIGN KEY (asset_id) REFERENCES asset(id) ON DELETE CASCADE
+);
@@ -0,0 +1 @@
+ALTER TABLE asset ADD COLUMN has_alpha BOOLEAN NULL AFTER height;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS reprocess_sweep (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    state ENUM('running', 'done') NOT NULL DEFAULT 'running',
+    cursor_id BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    total INT UNSIGNED NOT NULL,
+    processed INT UNSIGNED NOT NULL DEFAULT 0,
+    errors INT UNSIGNED NOT NULL DEFAULT 0,
+    last_error VARCHAR(1024) NULL,
+    started_by VARCHAR(255) NULL,
+    started_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    updated_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    finished_at TIMESTAMP(6) NULL,
+    running_guard TINYINT AS (IF(state = 'running', 1, NULL)) PERSISTENT,
+    lease_token CHAR(32) NULL,
+    lease_until TIMESTAMP(6) NULL,
+    KEY idx_reprocess_sweep_state (state),
+    UNIQUE KEY uq_reprocess_sweep_running (running_guard)
+);
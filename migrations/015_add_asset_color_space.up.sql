@@ -0,0 +1 @@
+ALTER TABLE asset ADD COLUMN color_space VARCHAR(64) NULL AFTER has_alpha;